@@ -115,7 +115,7 @@ func (s *blockService) Exchange() exchange.Interface {
 func NewSession(ctx context.Context, bs BlockService) *Session {
 	exch := bs.Exchange()
 	if sessEx, ok := exch.(exchange.SessionExchange); ok {
-		ses := sessEx.NewSession(ctx)
+		ses := sessEx.NewSessionFetcher(ctx)
 		return &Session{
 			ses: ses,
 			bs:  bs.Blockstore(),
@@ -246,10 +246,13 @@ func getBlock(ctx context.Context, c *cid.Cid, bs blockstore.Blockstore, f excha
 // the returned channel.
 // NB: No guarantees are made about order.
 func (s *blockService) GetBlocks(ctx context.Context, ks []*cid.Cid) <-chan blocks.Block {
-	return getBlocks(ctx, ks, s.blockstore, s.exchange) // hash security
+	return getBlocks(ctx, ks, nil, s.blockstore, s.exchange) // hash security
 }
 
-func getBlocks(ctx context.Context, ks []*cid.Cid, bs blockstore.Blockstore, f exchange.Fetcher) <-chan blocks.Block {
+// getBlocks gets the blocks from the blockstore, or from the fetcher. The
+// priorities of the blocks are passed to the fetcher if it supports them,
+// prios may be nil.
+func getBlocks(ctx context.Context, ks []*cid.Cid, prios []int, bs blockstore.Blockstore, f exchange.Fetcher) <-chan blocks.Block {
 	out := make(chan blocks.Block)
 	for _, c := range ks {
 		// hash security
@@ -261,10 +264,14 @@ func getBlocks(ctx context.Context, ks []*cid.Cid, bs blockstore.Blockstore, f e
 	go func() {
 		defer close(out)
 		var misses []*cid.Cid
-		for _, c := range ks {
+		var missPrios []int
+		for i, c := range ks {
 			hit, err := bs.Get(c)
 			if err != nil {
 				misses = append(misses, c)
+				if prios != nil {
+					missPrios = append(missPrios, prios[i])
+				}
 				continue
 			}
 			select {
@@ -278,7 +285,13 @@ func getBlocks(ctx context.Context, ks []*cid.Cid, bs blockstore.Blockstore, f e
 			return
 		}
 
-		rblocks, err := f.GetBlocks(ctx, misses)
+		var rblocks <-chan blocks.Block
+		var err error
+		if pf, ok := f.(exchange.PriorityFetcher); ok && prios != nil {
+			rblocks, err = pf.GetBlocksWithPriority(ctx, misses, missPrios)
+		} else {
+			rblocks, err = f.GetBlocks(ctx, misses)
+		}
 		if err != nil {
 			log.Debugf("Error with GetBlocks: %s", err)
 			return
@@ -323,7 +336,21 @@ func (s *Session) GetBlock(ctx context.Context, c *cid.Cid) (blocks.Block, error
 
 // GetBlocks gets blocks in the context of a request session
 func (s *Session) GetBlocks(ctx context.Context, ks []*cid.Cid) <-chan blocks.Block {
-	return getBlocks(ctx, ks, s.bs, s.ses) // hash security
+	return getBlocks(ctx, ks, nil, s.bs, s.ses) // hash security
+}
+
+// GetBlocksWithPriority gets blocks in the context of a request session,
+// fetching the blocks of higher priority first if the exchange supports it.
+func (s *Session) GetBlocksWithPriority(ctx context.Context, ks []*cid.Cid, prios []int) <-chan blocks.Block {
+	return getBlocks(ctx, ks, prios, s.bs, s.ses) // hash security
+}
+
+// Reprioritize changes the priorities of blocks being fetched by the
+// session. It does nothing if the exchange does not support priorities.
+func (s *Session) Reprioritize(ks []*cid.Cid, prios []int) {
+	if pf, ok := s.ses.(exchange.PriorityFetcher); ok {
+		pf.Reprioritize(ks, prios)
+	}
 }
 
 var _ BlockGetter = (*Session)(nil)
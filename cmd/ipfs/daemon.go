@@ -452,7 +452,9 @@ func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error
 
 	var opts = []corehttp.ServeOption{
 		corehttp.MetricsCollectionOption("api"),
+		corehttp.RateLimitOption(cfg.API.RateLimit),
 		corehttp.CheckVersionOption(),
+		corehttp.APIAuthOption(),
 		corehttp.CommandsOption(*cctx),
 		corehttp.WebUIOption,
 		gatewayOpt,
@@ -467,6 +469,13 @@ func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error
 		opts = append(opts, corehttp.RedirectOption("", cfg.Gateway.RootRedirect))
 	}
 
+	if cfg.WebDAV.Enabled {
+		opts = append(opts, corehttp.WebDAVOption("/webdav", corehttp.WebDAVConfig{
+			ReadOnly:      cfg.WebDAV.ReadOnly,
+			IPFSNamespace: cfg.WebDAV.ExposeIPFS,
+		}))
+	}
+
 	node, err := cctx.ConstructNode()
 	if err != nil {
 		return nil, fmt.Errorf("serveHTTPApi: ConstructNode() failed: %s", err)
@@ -547,6 +556,7 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 
 	var opts = []corehttp.ServeOption{
 		corehttp.MetricsCollectionOption("gateway"),
+		corehttp.RateLimitOption(cfg.Gateway.RateLimit),
 		corehttp.CheckVersionOption(),
 		corehttp.CommandsROOption(*cctx),
 		corehttp.VersionOption(),
@@ -237,11 +237,16 @@ func setupNode(ctx context.Context, n *IpfsNode, cfg *BuildCfg) error {
 	}
 	n.Resolver = resolver.NewBasicResolver(n.DAG)
 
+	// the reprovider may announce the content of the files root
+	if err := n.loadFilesRoot(); err != nil {
+		return err
+	}
+
 	if cfg.Online {
 		if err := n.startLateOnlineServices(ctx); err != nil {
 			return err
 		}
 	}
 
-	return n.loadFilesRoot()
+	return nil
 }
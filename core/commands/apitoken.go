@@ -0,0 +1,238 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	coreauth "github.com/ipfs/go-ipfs/core/coreauth"
+
+	"gx/ipfs/QmceUdzxkimdYsgtX733uNgzf1DLHyBKN6ehGSp85ayppM/go-ipfs-cmdkit"
+)
+
+var ApiCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Manage access to the HTTP API.",
+	},
+	Subcommands: map[string]*cmds.Command{
+		"token": apiTokenCmd,
+	},
+}
+
+var apiTokenCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Create, list and revoke API tokens.",
+		ShortDescription: `
+API tokens authenticate requests to the HTTP API when
+'API.Authorization.Enabled' is set. Clients pass them in the
+'Authorization: Bearer <token>' header. The command line client sends no
+token, so it only works against such a daemon when
+'API.Authorization.AllowLoopback' is set as well.
+
+Each token is granted one or more scopes:
+
+  read    the read-only command set also served on the gateway
+  pin     pinning and adding content
+  files   the mutable file system ('ipfs files')
+  name    IPNS publishing and key management
+  admin   every command
+
+  > ipfs api token create --scopes=read,pin ci
+  4f2a9c...
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"create": apiTokenCreateCmd,
+		"ls":     apiTokenLsCmd,
+		"revoke": apiTokenRevokeCmd,
+	},
+}
+
+type ApiTokenOutput struct {
+	ID      string
+	Name    string
+	Scopes  []string
+	Created time.Time
+	Token   string `json:",omitempty"`
+}
+
+type ApiTokenList struct {
+	Tokens []ApiTokenOutput
+}
+
+func apiTokenOutput(t *coreauth.Token) ApiTokenOutput {
+	scopes := make([]string, len(t.Scopes))
+	for i, s := range t.Scopes {
+		scopes[i] = string(s)
+	}
+	return ApiTokenOutput{
+		ID:      t.ID,
+		Name:    t.Name,
+		Scopes:  scopes,
+		Created: t.Created,
+	}
+}
+
+var apiTokenCreateCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Create a new API token.",
+		ShortDescription: `
+Creates a token with the given scopes and prints it. The token is
+only shown once, store it somewhere safe.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("name", true, false, "Name describing the token holder."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("scopes", "s", "Comma separated list of scopes to grant.").WithDefault("read"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		scopestr, _, _ := req.Option("scopes").String()
+		scopes, err := coreauth.ParseScopes(scopestr)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		t, bearer, err := coreauth.NewStore(n.Repo.Datastore()).Create(req.Arguments()[0], scopes)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		out := apiTokenOutput(t)
+		out.Token = bearer
+		res.SetOutput(&out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+
+			out, ok := v.(*ApiTokenOutput)
+			if !ok {
+				return nil, e.TypeErr(out, v)
+			}
+
+			return strings.NewReader(out.Token + "\n"), nil
+		},
+	},
+	Type: ApiTokenOutput{},
+}
+
+var apiTokenLsCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "List API tokens.",
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		tokens, err := coreauth.NewStore(n.Repo.Datastore()).List()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		list := make([]ApiTokenOutput, len(tokens))
+		for i, t := range tokens {
+			list[i] = apiTokenOutput(t)
+		}
+
+		res.SetOutput(&ApiTokenList{list})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+
+			list, ok := v.(*ApiTokenList)
+			if !ok {
+				return nil, e.TypeErr(list, v)
+			}
+
+			buf := new(bytes.Buffer)
+			w := tabwriter.NewWriter(buf, 1, 2, 1, ' ', 0)
+			for _, t := range list.Tokens {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.ID, t.Name, strings.Join(t.Scopes, ","), t.Created.Format(time.RFC3339))
+			}
+			w.Flush()
+			return buf, nil
+		},
+	},
+	Type: ApiTokenList{},
+}
+
+var apiTokenRevokeCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Revoke API tokens.",
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("id", true, true, "IDs of the tokens to revoke.").EnableStdin(),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		store := coreauth.NewStore(n.Repo.Datastore())
+
+		list := make([]ApiTokenOutput, 0, len(req.Arguments()))
+		for _, id := range req.Arguments() {
+			t, err := store.Get(id)
+			if err != nil {
+				res.SetError(fmt.Errorf("no token with id %s was found", id), cmdkit.ErrNormal)
+				return
+			}
+
+			if err := store.Revoke(id); err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+			list = append(list, apiTokenOutput(t))
+		}
+
+		res.SetOutput(&ApiTokenList{list})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+
+			list, ok := v.(*ApiTokenList)
+			if !ok {
+				return nil, e.TypeErr(list, v)
+			}
+
+			buf := new(bytes.Buffer)
+			for _, t := range list.Tokens {
+				fmt.Fprintf(buf, "revoked %s (%s)\n", t.ID, t.Name)
+			}
+			return buf, nil
+		},
+	},
+	Type: ApiTokenList{},
+}
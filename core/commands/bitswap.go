@@ -2,14 +2,19 @@ package commands
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
+	"time"
 
 	oldcmds "github.com/ipfs/go-ipfs/commands"
 	lgc "github.com/ipfs/go-ipfs/commands/legacy"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
 	bitswap "github.com/ipfs/go-ipfs/exchange/bitswap"
 	decision "github.com/ipfs/go-ipfs/exchange/bitswap/decision"
+	rp "github.com/ipfs/go-ipfs/exchange/reprovide"
 
 	"gx/ipfs/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
 	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
@@ -30,6 +35,159 @@ var BitswapCmd = &cmds.Command{
 		"unwant":    lgc.NewCommand(unwantCmd),
 		"ledger":    lgc.NewCommand(ledgerCmd),
 		"reprovide": lgc.NewCommand(reprovideCmd),
+		"sessions":  bitswapSessionsCmd,
+		"trace":     bitswapTraceCmd,
+	},
+}
+
+var bitswapSessionsCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the running bitswap sessions.",
+		ShortDescription: `
+Prints the running bitswap sessions, with the peers each session fetches
+blocks from, fastest first.
+`,
+	},
+	Type: bitswap.SessionStat{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) {
+		nd, err := GetNode(env)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		if !nd.OnlineMode() {
+			res.SetError(errNotOnline, cmdkit.ErrClient)
+			return
+		}
+
+		bs, ok := nd.Exchange.(*bitswap.Bitswap)
+		if !ok {
+			res.SetError(e.TypeErr(bs, nd.Exchange), cmdkit.ErrNormal)
+			return
+		}
+
+		for _, st := range bs.SessionStats() {
+			if err := res.Emit(st); err != nil {
+				return
+			}
+		}
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			out, ok := v.(*bitswap.SessionStat)
+			if !ok {
+				return e.TypeErr(out, v)
+			}
+
+			fmt.Fprintf(w, "session %d\n", out.ID)
+			fmt.Fprintf(w, "\tlive wants: %d\n", out.LiveWants)
+			fmt.Fprintf(w, "\tqueued: %d\n", out.Queued)
+			fmt.Fprintf(w, "\tfetched: %d\n", out.Fetched)
+			fmt.Fprintf(w, "\taverage latency: %s\n", out.AvgLatency)
+			fmt.Fprintf(w, "\tpeers [%d]\n", len(out.Peers))
+			for _, p := range out.Peers {
+				fmt.Fprintf(w, "\t\t%s blocks: %d (%s) failures: %d latency: %s throughput: %s/s\n",
+					p.Peer, p.Received, humanize.Bytes(p.Bytes), p.Failures,
+					p.Latency, humanize.Bytes(uint64(p.Throughput)))
+			}
+			return nil
+		}),
+	},
+}
+
+var bitswapTraceCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show what bitswap did to fetch or send a block.",
+		ShortDescription: `
+Prints the recorded bitswap events of a block: the wants sent and to which
+peers, the blocks received and from whom, duplicates and cancels. Only the
+most recent events are kept in memory.
+
+With --stream, new events are printed as they happen until the command is
+interrupted.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("key", true, false, "Key of the block to trace."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("stream", "s", "Keep printing new events as they happen."),
+	},
+	Type: bitswap.TraceEvent{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) {
+		nd, err := GetNode(env)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		if !nd.OnlineMode() {
+			res.SetError(errNotOnline, cmdkit.ErrClient)
+			return
+		}
+
+		bs, ok := nd.Exchange.(*bitswap.Bitswap)
+		if !ok {
+			res.SetError(e.TypeErr(bs, nd.Exchange), cmdkit.ErrNormal)
+			return
+		}
+
+		c, err := cid.Decode(req.Arguments[0])
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		stream, _ := req.Options["stream"].(bool)
+
+		// subscribe before reading the past events so none is missed, and
+		// skip the live events that were already among the past ones
+		var events <-chan bitswap.TraceEvent
+		if stream {
+			events = bs.TraceEvents(req.Context, c)
+		}
+
+		var last uint64
+		for _, ev := range bs.Trace(c) {
+			last = ev.Seq
+			if err := res.Emit(&ev); err != nil {
+				return
+			}
+		}
+
+		for ev := range events {
+			if ev.Seq <= last {
+				continue
+			}
+			if err := res.Emit(&ev); err != nil {
+				return
+			}
+		}
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeEncoder(func(req *cmds.Request, w io.Writer, v interface{}) error {
+			ev, ok := v.(*bitswap.TraceEvent)
+			if !ok {
+				return e.TypeErr(ev, v)
+			}
+
+			fmt.Fprintf(w, "%s %-10s", ev.Time.Format(time.RFC3339Nano), ev.Type)
+			if ev.Session != 0 {
+				fmt.Fprintf(w, " session %d", ev.Session)
+			}
+			if ev.Size != 0 {
+				fmt.Fprintf(w, " %s", humanize.Bytes(uint64(ev.Size)))
+			}
+			switch {
+			case len(ev.Peers) > 0:
+				fmt.Fprintf(w, " %s", strings.Join(ev.Peers, " "))
+			case ev.Type == bitswap.TraceWant || ev.Type == bitswap.TraceCancel:
+				fmt.Fprint(w, " (broadcast)")
+			}
+			fmt.Fprintln(w)
+			return nil
+		}),
 	},
 }
 
@@ -180,6 +338,10 @@ var bitswapStatCmd = &cmds.Command{
 			fmt.Fprintf(w, "\tdata sent: %d\n", out.DataSent)
 			fmt.Fprintf(w, "\tdup blocks received: %d\n", out.DupBlksReceived)
 			fmt.Fprintf(w, "\tdup data received: %s\n", humanize.Bytes(out.DupDataReceived))
+			fmt.Fprintf(w, "\thaves received: %d\n", out.HavesReceived)
+			fmt.Fprintf(w, "\tdont-haves received: %d\n", out.DontHavesReceived)
+			fmt.Fprintf(w, "\twants rejected: %d\n", out.WantsRejected)
+			fmt.Fprintf(w, "\tblocks rejected: %d\n", out.BlocksRejected)
 			fmt.Fprintf(w, "\twantlist [%d keys]\n", len(out.Wantlist))
 			for _, k := range out.Wantlist {
 				fmt.Fprintf(w, "\t\t%s\n", k.String())
@@ -201,10 +363,16 @@ var ledgerCmd = &oldcmds.Command{
 The Bitswap decision engine tracks the number of bytes exchanged between IPFS
 nodes, and stores this information as a collection of ledgers. This command
 prints the ledger associated with a given peer.
+
+Ledgers are saved in the repo and kept across restarts. With --all, the ledgers
+of all peers are printed, including the ones we are no longer connected to.
 `,
 	},
 	Arguments: []cmdkit.Argument{
-		cmdkit.StringArg("peer", true, false, "The PeerID (B58) of the ledger to inspect."),
+		cmdkit.StringArg("peer", false, false, "The PeerID (B58) of the ledger to inspect."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("all", "a", "Show the ledgers of all peers, past and present."),
 	},
 	Type: decision.Receipt{},
 	Run: func(req oldcmds.Request, res oldcmds.Response) {
@@ -225,12 +393,35 @@ prints the ledger associated with a given peer.
 			return
 		}
 
-		partner, err := peer.IDB58Decode(req.Arguments()[0])
-		if err != nil {
-			res.SetError(err, cmdkit.ErrClient)
+		all, _, _ := req.Option("all").Bool()
+		var receipts []*decision.Receipt
+		switch {
+		case all && len(req.Arguments()) > 0:
+			res.SetError(errors.New("cannot pass a peer with --all"), cmdkit.ErrClient)
+			return
+		case all:
+			receipts = bs.AllLedgers()
+			sort.Slice(receipts, func(i, j int) bool {
+				return receipts[i].LastSeen.After(receipts[j].LastSeen)
+			})
+		case len(req.Arguments()) == 0:
+			res.SetError(errors.New("a peer is required unless --all is passed"), cmdkit.ErrClient)
 			return
+		default:
+			partner, err := peer.IDB58Decode(req.Arguments()[0])
+			if err != nil {
+				res.SetError(err, cmdkit.ErrClient)
+				return
+			}
+			receipts = []*decision.Receipt{bs.LedgerForPeer(partner)}
+		}
+
+		out := make(chan interface{}, len(receipts))
+		for _, r := range receipts {
+			out <- r
 		}
-		res.SetOutput(bs.LedgerForPeer(partner))
+		close(out)
+		res.SetOutput((<-chan interface{})(out))
 	},
 	Marshalers: oldcmds.MarshalerMap{
 		oldcmds.Text: func(res oldcmds.Response) (io.Reader, error) {
@@ -249,9 +440,21 @@ prints the ledger associated with a given peer.
 				"Debt ratio:\t%f\n"+
 				"Exchanges:\t%d\n"+
 				"Bytes sent:\t%d\n"+
-				"Bytes received:\t%d\n\n",
+				"Bytes received:\t%d\n",
 				out.Peer, out.Value, out.Exchanged,
 				out.Sent, out.Recv)
+			if !out.FirstSeen.IsZero() {
+				fmt.Fprintf(buf, "Connected:\t%t\n"+
+					"First seen:\t%s\n"+
+					"Last seen:\t%s\n",
+					out.Connected,
+					out.FirstSeen.Format(time.RFC3339),
+					out.LastSeen.Format(time.RFC3339))
+			}
+			if !out.LastExchange.IsZero() {
+				fmt.Fprintf(buf, "Last exchange:\t%s\n", out.LastExchange.Format(time.RFC3339))
+			}
+			buf.WriteString("\n")
 			return buf, nil
 		},
 	},
@@ -262,8 +465,19 @@ var reprovideCmd = &oldcmds.Command{
 		Tagline: "Trigger reprovider.",
 		ShortDescription: `
 Trigger reprovider to announce our data to network.
+`,
+		LongDescription: `
+Trigger reprovider to announce our data to network.
+
+With --status, the progress of the reprovider is shown instead: the keys
+announced in the current or last round, the rate at which they are announced,
+and an estimate of the keys left in the round.
 `,
 	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("status", "Show the progress of the reprovider."),
+	},
+	Type: rp.Status{},
 	Run: func(req oldcmds.Request, res oldcmds.Response) {
 		nd, err := req.InvocContext().GetNode()
 		if err != nil {
@@ -276,6 +490,12 @@ Trigger reprovider to announce our data to network.
 			return
 		}
 
+		if status, _, _ := req.Option("status").Bool(); status {
+			st := nd.Reprovider.Status()
+			res.SetOutput(&st)
+			return
+		}
+
 		err = nd.Reprovider.Trigger(req.Context())
 		if err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
@@ -284,4 +504,56 @@ Trigger reprovider to announce our data to network.
 
 		res.SetOutput(nil)
 	},
+	Marshalers: oldcmds.MarshalerMap{
+		oldcmds.Text: func(res oldcmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				return new(bytes.Buffer), nil
+			}
+
+			st, ok := v.(*rp.Status)
+			if !ok {
+				return nil, e.TypeErr(st, v)
+			}
+
+			buf := new(bytes.Buffer)
+			mode := "one by one"
+			if st.Batched {
+				mode = "batched"
+			}
+			fmt.Fprintf(buf, "Reprovider (%s)\n", mode)
+			switch {
+			case st.Running:
+				fmt.Fprintf(buf, "Round started:\t%s\n", st.RoundStart.Format(time.RFC3339))
+			case !st.RoundStart.IsZero():
+				fmt.Fprintf(buf, "Last round:\t%s\n", st.RoundStart.Format(time.RFC3339))
+			default:
+				fmt.Fprintf(buf, "No round yet\n")
+				return buf, nil
+			}
+			fmt.Fprintf(buf, "Provided:\t%d\n", st.Provided)
+			if st.Batched {
+				fmt.Fprintf(buf, "Skipped:\t%d\n"+
+					"Lookups:\t%d\n",
+					st.Skipped, st.Lookups)
+			}
+			fmt.Fprintf(buf, "Failed:\t%d\n"+
+				"Rate:\t%.1f keys/s\n",
+				st.Failed, st.Rate)
+			if st.Running {
+				fmt.Fprintf(buf, "Backlog:\t%d\n", st.Backlog)
+			}
+			if !st.LastRoundEnd.IsZero() {
+				fmt.Fprintf(buf, "Last complete round:\t%s, %d keys in %s\n",
+					st.LastRoundEnd.Format(time.RFC3339), st.LastRoundKeys, st.LastRoundDuration)
+			}
+			if st.LastError != "" {
+				fmt.Fprintf(buf, "Last error:\t%s\n", st.LastError)
+			}
+			return buf, nil
+		},
+	},
 }
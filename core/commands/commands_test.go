@@ -69,9 +69,16 @@ func TestROCommands(t *testing.T) {
 func TestCommands(t *testing.T) {
 	list := []string{
 		"/add",
+		"/api",
+		"/api/token",
+		"/api/token/create",
+		"/api/token/ls",
+		"/api/token/revoke",
 		"/bitswap",
 		"/bitswap/ledger",
 		"/bitswap/reprovide",
+		"/bitswap/sessions",
+		"/bitswap/trace",
 		"/bitswap/stat",
 		"/bitswap/unwant",
 		"/bitswap/wantlist",
@@ -142,11 +149,24 @@ func TestCommands(t *testing.T) {
 		"/ls",
 		"/mount",
 		"/name",
+		"/name/cache",
+		"/name/cache/flush",
+		"/name/cache/ls",
+		"/name/delegate",
+		"/name/delegate/add",
+		"/name/delegate/ls",
+		"/name/delegate/publish",
+		"/name/delegate/rm",
+		"/name/get",
+		"/name/inspect",
 		"/name/publish",
 		"/name/pubsub",
 		"/name/pubsub/state",
 		"/name/pubsub/subs",
 		"/name/pubsub/cancel",
+		"/name/put",
+		"/name/republish",
+		"/name/republish/status",
 		"/name/resolve",
 		"/object",
 		"/object/data",
@@ -54,9 +54,20 @@ The resolver can recursively resolve:
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
 
+		cfg, err := req.InvocContext().GetConfig()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		transports, err := namesys.ParseDNSTransports(cfg.DNS.Resolvers)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
 		recursive, _, _ := req.Option("recursive").Bool()
 		name := req.Arguments()[0]
-		resolver := namesys.NewDNSResolver()
+		resolver := namesys.NewDNSResolverWithTransports(transports)
 
 		var ropts []nsopts.ResolveOpt
 		if !recursive {
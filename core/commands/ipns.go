@@ -50,6 +50,13 @@ Resolve the value of a dnslink:
   > ipfs name resolve ipfs.io
   /ipfs/QmaBvfZooxWkrv7D3r8LS9moNjzD2o525XMZze69hhoxf5
 
+Print the values of a name as they are found, from the cached value to the
+best of the records of the DHT:
+
+  > ipfs name resolve --stream QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ
+  /ipfs/QmSiTko9JZyabH56y2fussEt1A5oDqsFXB3CkvAqraFryz
+  /ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
+
 `,
 	},
 
@@ -61,6 +68,7 @@ Resolve the value of a dnslink:
 		cmdkit.BoolOption("nocache", "n", "Do not use cached entries."),
 		cmdkit.UintOption("dht-record-count", "dhtrc", "Number of records to request for DHT resolution."),
 		cmdkit.StringOption("dht-timeout", "dhtt", "Max time to collect values during DHT resolution eg \"30s\". Pass 0 for no timeout."),
+		cmdkit.BoolOption("stream", "s", "Print the values as better records are found instead of only the best one."),
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		n, err := req.InvocContext().GetNode()
@@ -136,6 +144,49 @@ Resolve the value of a dnslink:
 			name = "/ipns/" + name
 		}
 
+		stream, _, _ := req.Option("stream").Bool()
+		if stream {
+			results := resolver.ResolveAsync(req.Context(), name, ropts...)
+
+			// fail when there is no answer at all
+			var first *ResolvedPath
+			err = namesys.ErrResolveFailed
+			for r := range results {
+				if r.Err == nil {
+					first = &ResolvedPath{r.Path}
+					break
+				}
+				err = r.Err
+			}
+			if first == nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+
+			outChan := make(chan interface{})
+			res.SetOutput((<-chan interface{})(outChan))
+
+			go func() {
+				defer close(outChan)
+				select {
+				case outChan <- first:
+				case <-req.Context().Done():
+					return
+				}
+				for r := range results {
+					if r.Err != nil {
+						continue
+					}
+					select {
+					case outChan <- &ResolvedPath{r.Path}:
+					case <-req.Context().Done():
+						return
+					}
+				}
+			}()
+			return
+		}
+
 		output, err := resolver.Resolve(req.Context(), name, ropts...)
 		if err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
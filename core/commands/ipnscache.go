@@ -0,0 +1,148 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	namesys "github.com/ipfs/go-ipfs/namesys"
+
+	"gx/ipfs/QmceUdzxkimdYsgtX733uNgzf1DLHyBKN6ehGSp85ayppM/go-ipfs-cmdkit"
+)
+
+// IpnsCacheEntry is an entry of the IPNS resolve cache.
+type IpnsCacheEntry struct {
+	Name        string
+	Value       string
+	Expires     time.Time
+	EOL         time.Time
+	Revalidated bool
+}
+
+// IpnsCacheList lists the entries of the IPNS resolve cache.
+type IpnsCacheList struct {
+	Entries []IpnsCacheEntry
+}
+
+var nameCacheCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Manage the cache of resolved IPNS names.",
+		ShortDescription: `
+Resolved IPNS names are cached for the TTL of their record, and the cache is
+kept across restarts. The names listed in the Ipns.ResolveCacheRevalidate
+config are served from the cache after their TTL, until the end of validity
+of their record, while they are resolved again in the background.
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"ls":    nameCacheLsCmd,
+		"flush": nameCacheFlushCmd,
+	},
+}
+
+var nameCacheLsCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "List the cached IPNS names.",
+	},
+
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		if !n.OnlineMode() {
+			err := n.SetupOfflineRouting()
+			if err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+		}
+
+		entries, err := namesys.CacheEntries(n.Namesys)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		out := &IpnsCacheList{Entries: make([]IpnsCacheEntry, 0, len(entries))}
+		for _, entry := range entries {
+			out.Entries = append(out.Entries, IpnsCacheEntry{
+				Name:        entry.Name,
+				Value:       entry.Value.String(),
+				Expires:     entry.Expires,
+				EOL:         entry.EOL,
+				Revalidated: entry.Revalidated,
+			})
+		}
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+			list, ok := v.(*IpnsCacheList)
+			if !ok {
+				return nil, e.TypeErr(list, v)
+			}
+
+			now := time.Now()
+			buf := new(bytes.Buffer)
+			for _, entry := range list.Entries {
+				state := fmt.Sprintf("expires in %s", entry.Expires.Sub(now).Round(time.Second))
+				if !now.Before(entry.Expires) {
+					state = "stale"
+					if entry.Revalidated {
+						state = "stale, revalidating"
+					}
+				}
+				fmt.Fprintf(buf, "%s: %s (%s)\n", entry.Name, entry.Value, state)
+			}
+			return buf, nil
+		},
+	},
+	Type: IpnsCacheList{},
+}
+
+var nameCacheFlushCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Remove IPNS names from the cache.",
+		ShortDescription: `
+'ipfs name cache flush' removes the given names from the cache of resolved
+IPNS names, or all the names when none is given.
+`,
+	},
+
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("name", false, true, "The IPNS names to remove."),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		if !n.OnlineMode() {
+			err := n.SetupOfflineRouting()
+			if err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+		}
+
+		err = namesys.FlushCache(n.Namesys, req.Arguments()...)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		res.SetOutput(nil)
+	},
+}
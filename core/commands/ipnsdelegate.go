@@ -0,0 +1,434 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	namesys "github.com/ipfs/go-ipfs/namesys"
+	republisher "github.com/ipfs/go-ipfs/namesys/republisher"
+	path "github.com/ipfs/go-ipfs/path"
+
+	routing "gx/ipfs/QmTiWLZ6Fo5j4KcTVutZJ5KWRRJrbxzmxA4td8NfEdrPh7/go-libp2p-routing"
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+	crypto "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+	"gx/ipfs/QmceUdzxkimdYsgtX733uNgzf1DLHyBKN6ehGSp85ayppM/go-ipfs-cmdkit"
+)
+
+// IpnsDelegation lists the delegated writers of an IPNS name.
+type IpnsDelegation struct {
+	Name     string
+	Writers  []string
+	Sequence uint64
+	EOL      time.Time
+}
+
+var nameDelegateCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Manage the delegated writers of IPNS names.",
+		ShortDescription: `
+The owner of an IPNS name may delegate the publishing of the name to other
+keys, its writers. The list of writers is signed by the owner and carried by
+the records of the name, which may then be signed by any writer.
+`,
+		LongDescription: `
+The owner of an IPNS name may delegate the publishing of the name to other
+keys, its writers. The list of writers is signed by the owner and carried by
+the records of the name, which may then be signed by any writer.
+
+Records carrying the latest list of writers win over the others, so writers
+removed from the list cannot override the name anymore. The list is renewed
+whenever the owner publishes the name, and expires after 30 days otherwise.
+
+Delegated records are only accepted by nodes knowing them.
+
+Examples:
+
+Let the key of a team member publish the name of the 'site' key:
+
+  > ipfs name delegate add --key=site QmbCMUZw6JFeZ7Wp9jkzbye3Fzp2GGcPgC3nmeUjfVF87n
+
+Publish the name as the team member:
+
+  > ipfs name delegate publish QmSrPmbaUKA3ZodhzPWZnpFgcPMFWF4QsxXbkWfEptTBJd /ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
+  Published to QmSrPmbaUKA3ZodhzPWZnpFgcPMFWF4QsxXbkWfEptTBJd: /ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"add":     nameDelegateAddCmd,
+		"rm":      nameDelegateRmCmd,
+		"ls":      nameDelegateLsCmd,
+		"publish": nameDelegatePublishCmd,
+	},
+}
+
+var nameDelegateAddCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Add delegated writers to an IPNS name.",
+		ShortDescription: `
+'ipfs name delegate add' adds the given keys, as key names or peer IDs, to the
+writers of the name of --key, and publishes the last record of the name again
+with the new list of writers. The name must have been published before.
+`,
+	},
+
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("writer", true, true, "Key names or peer IDs of the writers to add."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("key", "k", "Name of the key of the IPNS name, or a valid PeerID. Default: <<default>>.").WithDefault("self"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		updateDelegation(req, res, true)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: marshalIpnsDelegation,
+	},
+	Type: IpnsDelegation{},
+}
+
+var nameDelegateRmCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Remove delegated writers from an IPNS name.",
+		ShortDescription: `
+'ipfs name delegate rm' removes the given keys, as key names or peer IDs, from
+the writers of the name of --key, and publishes the last record of the name
+again with the new list of writers.
+`,
+	},
+
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("writer", true, true, "Key names or peer IDs of the writers to remove."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("key", "k", "Name of the key of the IPNS name, or a valid PeerID. Default: <<default>>.").WithDefault("self"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		updateDelegation(req, res, false)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: marshalIpnsDelegation,
+	},
+	Type: IpnsDelegation{},
+}
+
+var nameDelegateLsCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "List the delegated writers of an IPNS name.",
+		ShortDescription: `
+'ipfs name delegate ls' lists the writers of the name, as carried by its
+record in the routing system. The name defaults to the node's own PeerID.
+`,
+	},
+
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("name", false, false, "The IPNS name."),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		if !n.OnlineMode() {
+			err := n.SetupOfflineRouting()
+			if err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+		}
+
+		pid := n.Identity
+		if len(req.Arguments()) > 0 {
+			pid, err = peer.IDB58Decode(strings.TrimPrefix(req.Arguments()[0], "/ipns/"))
+			if err != nil {
+				res.SetError(fmt.Errorf("invalid IPNS name: %s", err), cmdkit.ErrNormal)
+				return
+			}
+		}
+
+		d, err := namesys.LookupDelegation(req.Context(), n.Routing, pid)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		out, err := delegationOutput(pid, d)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: marshalIpnsDelegation,
+	},
+	Type: IpnsDelegation{},
+}
+
+var nameDelegatePublishCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Publish an IPNS name as a delegated writer.",
+		ShortDescription: `
+'ipfs name delegate publish' publishes a record of the given name signed by
+--key, which must be a writer of the name. The sequence number of the record
+follows the one of the record of the name in the routing system.
+`,
+	},
+
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("name", true, false, "The IPNS name to publish."),
+		cmdkit.StringArg("ipfs-path", true, false, "ipfs path of the object to be published.").EnableStdin(),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("key", "k", "Name of the writer key, or a valid PeerID. Default: <<default>>.").WithDefault("self"),
+		cmdkit.BoolOption("resolve", "Resolve given path before publishing.").WithDefault(true),
+		cmdkit.StringOption("lifetime", "t",
+			`Time duration that the record will be valid for. <<default>>
+    This accepts durations such as "300s", "1.5h" or "2h45m". Valid time units are
+    "ns", "us" (or "µs"), "ms", "s", "m", "h".`).WithDefault("24h"),
+		cmdkit.StringOption("ttl", "Time duration this record should be cached for (caution: experimental)."),
+		cmdkit.BoolOption("republish", "Republish the record until it expires.").WithDefault(true),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		if !n.OnlineMode() {
+			err := n.SetupOfflineRouting()
+			if err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+		}
+
+		pid, err := peer.IDB58Decode(strings.TrimPrefix(req.Arguments()[0], "/ipns/"))
+		if err != nil {
+			res.SetError(fmt.Errorf("invalid IPNS name: %s", err), cmdkit.ErrNormal)
+			return
+		}
+
+		pth, err := path.ParsePath(req.Arguments()[1])
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		validtime, _, _ := req.Option("lifetime").String()
+		d, err := time.ParseDuration(validtime)
+		if err != nil {
+			res.SetError(fmt.Errorf("error parsing lifetime option: %s", err), cmdkit.ErrNormal)
+			return
+		}
+
+		ctx := req.Context()
+		if ttl, found, _ := req.Option("ttl").String(); found {
+			d, err := time.ParseDuration(ttl)
+			if err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+
+			ctx = context.WithValue(ctx, "ipns-publish-ttl", d)
+		}
+
+		kname, _, _ := req.Option("key").String()
+		k, err := keylookup(n, kname)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		if verify, _, _ := req.Option("resolve").Bool(); verify {
+			_, err := core.Resolve(ctx, n.Namesys, n.Resolver, pth)
+			if err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+		}
+
+		err = namesys.PublishDelegated(ctx, k, pid, pth, time.Now().Add(d), n.Routing)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		// the cached value of the name is outdated
+		err = namesys.FlushCache(n.Namesys, pid.Pretty())
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		republish, _, _ := req.Option("republish").Bool()
+		if republish {
+			err = republisher.Track(n.Repo.Datastore(), pid)
+		} else {
+			err = republisher.Untrack(n.Repo.Datastore(), pid)
+		}
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		res.SetOutput(&IpnsEntry{
+			Name:  pid.Pretty(),
+			Value: pth.String(),
+		})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+			entry, ok := v.(*IpnsEntry)
+			if !ok {
+				return nil, e.TypeErr(entry, v)
+			}
+
+			s := fmt.Sprintf("Published to %s: %s\n", entry.Name, entry.Value)
+			return strings.NewReader(s), nil
+		},
+	},
+	Type: IpnsEntry{},
+}
+
+func updateDelegation(req cmds.Request, res cmds.Response, add bool) {
+	n, err := req.InvocContext().GetNode()
+	if err != nil {
+		res.SetError(err, cmdkit.ErrNormal)
+		return
+	}
+
+	if !n.OnlineMode() {
+		err := n.SetupOfflineRouting()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+	}
+
+	if n.Mounts.Ipns != nil && n.Mounts.Ipns.IsActive() {
+		res.SetError(errors.New("cannot manually publish while IPNS is mounted"), cmdkit.ErrNormal)
+		return
+	}
+
+	kname, _, _ := req.Option("key").String()
+	k, err := keylookup(n, kname)
+	if err != nil {
+		res.SetError(err, cmdkit.ErrNormal)
+		return
+	}
+
+	writers := make([]crypto.PubKey, 0, len(req.Arguments()))
+	for _, arg := range req.Arguments() {
+		w, err := writerKey(req.Context(), n, arg)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		writers = append(writers, w)
+	}
+
+	var d *namesys.Delegation
+	if add {
+		d, err = namesys.UpdateDelegation(req.Context(), k, writers, nil, n.Routing, n.Repo.Datastore())
+	} else {
+		d, err = namesys.UpdateDelegation(req.Context(), k, nil, writers, n.Routing, n.Repo.Datastore())
+	}
+	if err != nil {
+		res.SetError(err, cmdkit.ErrNormal)
+		return
+	}
+
+	pid, err := peer.IDFromPrivateKey(k)
+	if err != nil {
+		res.SetError(err, cmdkit.ErrNormal)
+		return
+	}
+
+	// the cached value of the name is outdated
+	err = namesys.FlushCache(n.Namesys, pid.Pretty())
+	if err != nil {
+		res.SetError(err, cmdkit.ErrNormal)
+		return
+	}
+
+	out, err := delegationOutput(pid, d)
+	if err != nil {
+		res.SetError(err, cmdkit.ErrNormal)
+		return
+	}
+	res.SetOutput(out)
+}
+
+// writerKey returns the public key of a writer, given as the name of a key
+// of the node or as a peer ID.
+func writerKey(ctx context.Context, n *core.IpfsNode, s string) (crypto.PubKey, error) {
+	if k, err := keylookup(n, s); err == nil {
+		return k.GetPublic(), nil
+	}
+
+	pid, err := peer.IDB58Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("no key named %s, and not a valid peer ID", s)
+	}
+	if pk := pid.ExtractPublicKey(); pk != nil {
+		return pk, nil
+	}
+	if pk := n.Peerstore.PubKey(pid); pk != nil {
+		return pk, nil
+	}
+	pk, err := routing.GetPublicKey(n.Routing, ctx, []byte(pid))
+	if err != nil {
+		return nil, fmt.Errorf("could not find the public key of %s: %s", s, err)
+	}
+	return pk, nil
+}
+
+func delegationOutput(pid peer.ID, d *namesys.Delegation) (*IpnsDelegation, error) {
+	out := &IpnsDelegation{
+		Name:     pid.Pretty(),
+		Writers:  make([]string, 0, len(d.Writers)),
+		Sequence: d.Sequence,
+		EOL:      d.EOL,
+	}
+	for _, w := range d.Writers {
+		id, err := peer.IDFromPublicKey(w)
+		if err != nil {
+			return nil, err
+		}
+		out.Writers = append(out.Writers, id.Pretty())
+	}
+	return out, nil
+}
+
+func marshalIpnsDelegation(res cmds.Response) (io.Reader, error) {
+	v, err := unwrapOutput(res.Output())
+	if err != nil {
+		return nil, err
+	}
+	d, ok := v.(*IpnsDelegation)
+	if !ok {
+		return nil, e.TypeErr(d, v)
+	}
+
+	buf := new(bytes.Buffer)
+	for _, w := range d.Writers {
+		fmt.Fprintln(buf, w)
+	}
+	return buf, nil
+}
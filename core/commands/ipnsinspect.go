@@ -0,0 +1,200 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	namesys "github.com/ipfs/go-ipfs/namesys"
+	nsopts "github.com/ipfs/go-ipfs/namesys/opts"
+
+	"gx/ipfs/QmceUdzxkimdYsgtX733uNgzf1DLHyBKN6ehGSp85ayppM/go-ipfs-cmdkit"
+)
+
+// IpnsInspection describes how a name resolves.
+type IpnsInspection struct {
+	Name      string
+	KeyType   string
+	Resolvers []IpnsResolverAnswer
+	Records   []IpnsCandidate
+}
+
+// IpnsResolverAnswer is the answer of a resolver to an inspected name.
+type IpnsResolverAnswer struct {
+	Resolver string
+	Value    string `json:",omitempty"`
+	Error    string `json:",omitempty"`
+}
+
+// IpnsCandidate is a record of an inspected name received from the routing
+// system.
+type IpnsCandidate struct {
+	From     string
+	Record   *IpnsRecord `json:",omitempty"`
+	Error    string      `json:",omitempty"`
+	Selected bool
+	Reason   string
+}
+
+var nameInspectCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Inspect the records of an IPNS name.",
+		ShortDescription: `
+'ipfs name inspect' shows how the node resolves an IPNS name: the answers of
+the cache, pubsub and DHT resolvers, and all the records received from the
+routing system with their decoded fields and the validity of their signature
+against the public key of the name. The record selected by the DHT resolver
+among the valid ones is marked, along with why it was preferred over the
+other records.
+`,
+	},
+
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("name", false, false, "The IPNS name to inspect. Defaults to your node's peerID."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.UintOption("dht-record-count", "dhtrc", "Number of records to request for DHT resolution."),
+		cmdkit.StringOption("dht-timeout", "dhtt", "Max time to collect values during DHT resolution eg \"30s\". Pass 0 for no timeout."),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		if !n.OnlineMode() {
+			err := n.SetupOfflineRouting()
+			if err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+		}
+
+		var name string
+		if len(req.Arguments()) == 0 {
+			if n.Identity == "" {
+				res.SetError(errors.New("identity not loaded"), cmdkit.ErrNormal)
+				return
+			}
+			name = n.Identity.Pretty()
+		} else {
+			name = req.Arguments()[0]
+		}
+
+		var ropts []nsopts.ResolveOpt
+		if rc, rcok, _ := req.Option("dht-record-count").Int(); rcok {
+			ropts = append(ropts, nsopts.DhtRecordCount(uint(rc)))
+		}
+		if dhtt, dhttok, _ := req.Option("dht-timeout").String(); dhttok {
+			d, err := time.ParseDuration(dhtt)
+			if err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+			if d < 0 {
+				res.SetError(errors.New("DHT timeout value must be >= 0"), cmdkit.ErrNormal)
+				return
+			}
+			ropts = append(ropts, nsopts.DhtTimeout(d))
+		}
+
+		ins, err := namesys.Inspect(req.Context(), n.Namesys, n.Peerstore, name, ropts...)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		out := &IpnsInspection{
+			Name:    ins.Name.Pretty(),
+			KeyType: ins.KeyType,
+		}
+		for _, a := range ins.Answers {
+			answer := IpnsResolverAnswer{Resolver: a.Resolver}
+			if a.Err != nil {
+				answer.Error = a.Err.Error()
+			} else {
+				answer.Value = a.Value.String()
+			}
+			out.Resolvers = append(out.Resolvers, answer)
+		}
+		for i, r := range ins.Records {
+			c := IpnsCandidate{
+				From:     r.From.Pretty(),
+				Selected: i == ins.Selected,
+				Reason:   r.Reason,
+			}
+			if r.From == "" {
+				// records of the local datastore
+				c.From = "local"
+			}
+			if r.Err != nil {
+				c.Error = r.Err.Error()
+			}
+			if r.Entry != nil {
+				c.Record, err = decodeIpnsRecord(ins.Name, r.Entry, nil)
+				if err != nil && c.Error == "" {
+					c.Error = err.Error()
+				}
+			}
+			out.Records = append(out.Records, c)
+		}
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+			ins, ok := v.(*IpnsInspection)
+			if !ok {
+				return nil, e.TypeErr(ins, v)
+			}
+
+			buf := new(bytes.Buffer)
+			fmt.Fprintf(buf, "Name: %s\n", ins.Name)
+			if ins.KeyType != "" {
+				fmt.Fprintf(buf, "Key Type: %s\n", ins.KeyType)
+			} else {
+				fmt.Fprintf(buf, "Key Type: unknown (public key not found)\n")
+			}
+
+			fmt.Fprintf(buf, "Resolvers:\n")
+			for _, a := range ins.Resolvers {
+				if a.Error != "" {
+					fmt.Fprintf(buf, "  %s: error: %s\n", a.Resolver, a.Error)
+				} else {
+					fmt.Fprintf(buf, "  %s: %s\n", a.Resolver, a.Value)
+				}
+			}
+
+			fmt.Fprintf(buf, "Records: %d\n", len(ins.Records))
+			for i, c := range ins.Records {
+				mark := ""
+				if c.Selected {
+					mark = " (selected)"
+				}
+				fmt.Fprintf(buf, "  #%d from %s%s: %s\n", i, c.From, mark, c.Reason)
+				if c.Record != nil {
+					fmt.Fprintf(buf, "    Value: %s\n", c.Record.Value)
+					fmt.Fprintf(buf, "    Sequence: %d\n", c.Record.Sequence)
+					fmt.Fprintf(buf, "    %s: %s\n", c.Record.ValidityType, c.Record.Validity)
+					fmt.Fprintf(buf, "    TTL: %s\n", c.Record.TTL)
+					fmt.Fprintf(buf, "    Version: %d\n", c.Record.Version)
+				}
+				if c.Error != "" {
+					fmt.Fprintf(buf, "    Validation: failed: %s\n", c.Error)
+				} else {
+					fmt.Fprintf(buf, "    Validation: ok\n")
+				}
+			}
+			return buf, nil
+		},
+	},
+	Type: IpnsInspection{},
+}
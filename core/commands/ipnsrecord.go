@@ -0,0 +1,315 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	namesys "github.com/ipfs/go-ipfs/namesys"
+	pb "github.com/ipfs/go-ipfs/namesys/pb"
+	republisher "github.com/ipfs/go-ipfs/namesys/republisher"
+
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+	"gx/ipfs/QmceUdzxkimdYsgtX733uNgzf1DLHyBKN6ehGSp85ayppM/go-ipfs-cmdkit"
+)
+
+// maxIpnsRecordSize is the size limit of the records read by 'ipfs name put'.
+const maxIpnsRecordSize = 10 << 10
+
+// IpnsRecord is an ipns record along with its decoded fields.
+type IpnsRecord struct {
+	Name         string
+	Value        string
+	ValidityType string
+	Validity     string
+	Sequence     uint64
+	TTL          time.Duration
+	Version      int
+	PubKey       bool
+	Extensions   map[string]string `json:",omitempty"`
+	Record       []byte            `json:",omitempty"`
+}
+
+var namePutCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Publish an IPNS record signed elsewhere.",
+		ShortDescription: `
+'ipfs name put' validates an IPNS record of the given name, as created by
+'ipfs name publish --offline' on another node, and publishes it to the
+routing system. The private key of the name is not needed.
+`,
+		LongDescription: `
+'ipfs name put' validates an IPNS record of the given name, as created by
+'ipfs name publish --offline' on another node, and publishes it to the
+routing system. The private key of the name is not needed.
+
+The record is republished by the node until it expires, unless
+--republish=false is given.
+
+The public key of the name is taken from the record, or else fetched from the
+routing system, and is published along with the record when it cannot be
+extracted from the name.
+
+Examples:
+
+  > ipfs name put QmbCMUZw6JFeZ7Wp9jkzbye3Fzp2GGcPgC3nmeUjfVF87n record.bin
+  Published to QmbCMUZw6JFeZ7Wp9jkzbye3Fzp2GGcPgC3nmeUjfVF87n: /ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
+`,
+	},
+
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("name", true, false, "The IPNS name of the record."),
+		cmdkit.FileArg("record", true, false, "The file holding the signed record.").EnableStdin(),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("republish", "Republish the record until it expires.").WithDefault(true),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		if !n.OnlineMode() {
+			err := n.SetupOfflineRouting()
+			if err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+		}
+
+		pid, err := parseIpnsName(req.Arguments()[0])
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		fi, err := req.Files().NextFile()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		defer fi.Close()
+
+		data, err := ioutil.ReadAll(io.LimitReader(fi, maxIpnsRecordSize+1))
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		if len(data) > maxIpnsRecordSize {
+			res.SetError(fmt.Errorf("record is larger than %d bytes", maxIpnsRecordSize), cmdkit.ErrNormal)
+			return
+		}
+
+		entry := new(pb.IpnsEntry)
+		if err := proto.Unmarshal(data, entry); err != nil {
+			res.SetError(namesys.ErrBadRecord, cmdkit.ErrNormal)
+			return
+		}
+
+		err = namesys.PutRecord(req.Context(), n.Routing, n.Peerstore, pid, entry)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		// the cached value of the name is outdated
+		err = namesys.FlushCache(n.Namesys, pid.Pretty())
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		republish, _, _ := req.Option("republish").Bool()
+		if republish {
+			err = republisher.Track(n.Repo.Datastore(), pid)
+		} else {
+			err = republisher.Untrack(n.Repo.Datastore(), pid)
+		}
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		res.SetOutput(&IpnsEntry{
+			Name:  pid.Pretty(),
+			Value: string(entry.GetValue()),
+		})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+			entry, ok := v.(*IpnsEntry)
+			if !ok {
+				return nil, e.TypeErr(entry, v)
+			}
+
+			s := fmt.Sprintf("Published to %s: %s\n", entry.Name, entry.Value)
+			return strings.NewReader(s), nil
+		},
+	},
+	Type: IpnsEntry{},
+}
+
+var nameGetCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Get the IPNS record of a name.",
+		ShortDescription: `
+'ipfs name get' fetches the IPNS record of the given name from the routing
+system and prints its fields along with the raw record, encoded in base64.
+With --raw, only the raw record is written, as read by 'ipfs name put'.
+The default name is the node's own PeerID.
+`,
+	},
+
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("name", false, false, "The IPNS name of the record. Defaults to your node's peerID."),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("raw", "Only write the raw record.").WithDefault(false),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		if !n.OnlineMode() {
+			err := n.SetupOfflineRouting()
+			if err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+		}
+
+		var pid peer.ID
+		if len(req.Arguments()) == 0 {
+			if n.Identity == "" {
+				res.SetError(errors.New("identity not loaded"), cmdkit.ErrNormal)
+				return
+			}
+			pid = n.Identity
+		} else {
+			pid, err = parseIpnsName(req.Arguments()[0])
+			if err != nil {
+				res.SetError(err, cmdkit.ErrNormal)
+				return
+			}
+		}
+
+		rec, err := getIpnsRecord(req, n, pid)
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+		res.SetOutput(rec)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+			rec, ok := v.(*IpnsRecord)
+			if !ok {
+				return nil, e.TypeErr(rec, v)
+			}
+
+			raw, _, _ := res.Request().Option("raw").Bool()
+			if raw {
+				return bytes.NewReader(rec.Record), nil
+			}
+
+			buf := new(bytes.Buffer)
+			fmt.Fprintf(buf, "Name: %s\n", rec.Name)
+			fmt.Fprintf(buf, "Value: %s\n", rec.Value)
+			fmt.Fprintf(buf, "Validity Type: %s\n", rec.ValidityType)
+			fmt.Fprintf(buf, "Validity: %s\n", rec.Validity)
+			fmt.Fprintf(buf, "Sequence: %d\n", rec.Sequence)
+			fmt.Fprintf(buf, "TTL: %s\n", rec.TTL)
+			fmt.Fprintf(buf, "Version: %d\n", rec.Version)
+			fmt.Fprintf(buf, "Public Key Embedded: %t\n", rec.PubKey)
+
+			exts := make([]string, 0, len(rec.Extensions))
+			for k := range rec.Extensions {
+				exts = append(exts, k)
+			}
+			sort.Strings(exts)
+			for _, k := range exts {
+				fmt.Fprintf(buf, "Extension %s: %s\n", k, rec.Extensions[k])
+			}
+
+			fmt.Fprintf(buf, "Record: %s\n", base64.StdEncoding.EncodeToString(rec.Record))
+			return buf, nil
+		},
+	},
+	Type: IpnsRecord{},
+}
+
+// parseIpnsName returns the peer ID of a name given with or without the
+// /ipns/ prefix.
+func parseIpnsName(name string) (peer.ID, error) {
+	pid, err := peer.IDB58Decode(strings.TrimPrefix(name, "/ipns/"))
+	if err != nil {
+		return "", fmt.Errorf("invalid IPNS name %s: %s", name, err)
+	}
+	return pid, nil
+}
+
+func getIpnsRecord(req cmds.Request, n *core.IpfsNode, pid peer.ID) (*IpnsRecord, error) {
+	_, ipnskey := namesys.IpnsKeysForID(pid)
+	data, err := n.Routing.GetValue(req.Context(), ipnskey)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := new(pb.IpnsEntry)
+	if err := proto.Unmarshal(data, entry); err != nil {
+		return nil, namesys.ErrBadRecord
+	}
+	return decodeIpnsRecord(pid, entry, data)
+}
+
+func decodeIpnsRecord(pid peer.ID, entry *pb.IpnsEntry, data []byte) (*IpnsRecord, error) {
+	rec := &IpnsRecord{
+		Name:         pid.Pretty(),
+		Value:        string(entry.GetValue()),
+		ValidityType: entry.GetValidityType().String(),
+		Validity:     string(entry.GetValidity()),
+		Sequence:     entry.GetSequence(),
+		TTL:          time.Duration(entry.GetTtl()),
+		Version:      1,
+		PubKey:       len(entry.GetPubKey()) > 0,
+		Record:       data,
+	}
+	if len(entry.GetSignatureV2()) > 0 {
+		rec.Version = 2
+	}
+
+	exts, err := namesys.RecordExtensions(entry)
+	if err != nil {
+		return nil, err
+	}
+	if len(exts) > 0 {
+		rec.Extensions = make(map[string]string, len(exts))
+		for k, v := range exts {
+			rec.Extensions[k] = fmt.Sprint(v)
+		}
+	}
+	return rec, nil
+}
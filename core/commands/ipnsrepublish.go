@@ -0,0 +1,135 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	e "github.com/ipfs/go-ipfs/core/commands/e"
+	republisher "github.com/ipfs/go-ipfs/namesys/republisher"
+
+	"gx/ipfs/QmceUdzxkimdYsgtX733uNgzf1DLHyBKN6ehGSp85ayppM/go-ipfs-cmdkit"
+)
+
+// IpnsRepublishStatus is the state of the republishing of an IPNS name.
+type IpnsRepublishStatus struct {
+	Name          string
+	Key           string `json:",omitempty"`
+	Value         string `json:",omitempty"`
+	LastRepublish time.Time
+	Error         string `json:",omitempty"`
+}
+
+// IpnsRepublishList lists the state of the republishing of IPNS names.
+type IpnsRepublishList struct {
+	Names    []IpnsRepublishStatus
+	Tracked  []string
+	Interval time.Duration
+}
+
+var nameRepublishCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Inspect the republishing of IPNS names.",
+		ShortDescription: `
+The node republishes the records of its keys, selected by the
+Ipns.RepublishKeys config, and the records signed elsewhere and imported with
+'ipfs name put', until they expire.
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"status": nameRepublishStatusCmd,
+	},
+}
+
+var nameRepublishStatusCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the last republishing of IPNS names.",
+		ShortDescription: `
+'ipfs name republish status' shows, for every name republished since the
+daemon started, the last time it was republished and the error if it failed.
+It also lists the names whose imported record is republished.
+`,
+	},
+
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.InvocContext().GetNode()
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		if n.IpnsRepub == nil {
+			res.SetError(errNotOnline, cmdkit.ErrClient)
+			return
+		}
+
+		tracked, err := republisher.TrackedNames(n.Repo.Datastore())
+		if err != nil {
+			res.SetError(err, cmdkit.ErrNormal)
+			return
+		}
+
+		out := &IpnsRepublishList{
+			Names:    []IpnsRepublishStatus{},
+			Tracked:  make([]string, 0, len(tracked)),
+			Interval: n.IpnsRepub.Interval,
+		}
+		for _, st := range n.IpnsRepub.Status() {
+			s := IpnsRepublishStatus{
+				Name:          st.Name.Pretty(),
+				Key:           st.Key,
+				Value:         st.Value.String(),
+				LastRepublish: st.LastRepublish,
+			}
+			if st.Err != nil {
+				s.Error = st.Err.Error()
+			}
+			out.Names = append(out.Names, s)
+		}
+		for _, id := range tracked {
+			out.Tracked = append(out.Tracked, id.Pretty())
+		}
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			v, err := unwrapOutput(res.Output())
+			if err != nil {
+				return nil, err
+			}
+			list, ok := v.(*IpnsRepublishList)
+			if !ok {
+				return nil, e.TypeErr(list, v)
+			}
+
+			buf := new(bytes.Buffer)
+			fmt.Fprintf(buf, "Interval: %s\n", list.Interval)
+			if len(list.Names) == 0 {
+				fmt.Fprintf(buf, "No name republished yet.\n")
+			}
+			for _, s := range list.Names {
+				key := s.Key
+				if key == "" {
+					key = "imported"
+				}
+				fmt.Fprintf(buf, "%s (%s): %s\n", s.Name, key, s.Value)
+				if s.Error != "" {
+					fmt.Fprintf(buf, "  failed at %s: %s\n", s.LastRepublish.Format(time.RFC3339), s.Error)
+				} else {
+					fmt.Fprintf(buf, "  republished at %s\n", s.LastRepublish.Format(time.RFC3339))
+				}
+			}
+			if len(list.Tracked) > 0 {
+				fmt.Fprintf(buf, "Imported records:\n")
+				for _, name := range list.Tracked {
+					fmt.Fprintf(buf, "  %s\n", name)
+				}
+			}
+			return buf, nil
+		},
+	},
+	Type: IpnsRepublishList{},
+}
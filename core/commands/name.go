@@ -9,6 +9,9 @@ import (
 type IpnsEntry struct {
 	Name  string
 	Value string
+
+	// Record is the signed record, set when it was not published.
+	Record []byte `json:",omitempty"`
 }
 
 var NameCmd = &cmds.Command{
@@ -61,8 +64,14 @@ Resolve the value of a dnslink:
 	},
 
 	Subcommands: map[string]*cmds.Command{
-		"publish": PublishCmd,
-		"resolve": IpnsCmd,
-		"pubsub":  IpnsPubsubCmd,
+		"publish":   PublishCmd,
+		"resolve":   IpnsCmd,
+		"pubsub":    IpnsPubsubCmd,
+		"put":       namePutCmd,
+		"get":       nameGetCmd,
+		"inspect":   nameInspectCmd,
+		"cache":     nameCacheCmd,
+		"republish": nameRepublishCmd,
+		"delegate":  nameDelegateCmd,
 	},
 }
@@ -1,10 +1,12 @@
 package commands
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"strings"
 	"time"
 
@@ -12,8 +14,11 @@ import (
 	core "github.com/ipfs/go-ipfs/core"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
 	keystore "github.com/ipfs/go-ipfs/keystore"
+	namesys "github.com/ipfs/go-ipfs/namesys"
 	path "github.com/ipfs/go-ipfs/path"
 
+	offline "gx/ipfs/QmXtoXbu9ReyV6Q4kDQ5CF9wXQNDY1PdHc4HhfxRR5AHB3/go-ipfs-routing/offline"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
 	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
 	crypto "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
 	"gx/ipfs/QmceUdzxkimdYsgtX733uNgzf1DLHyBKN6ehGSp85ayppM/go-ipfs-cmdkit"
@@ -58,6 +63,15 @@ Alternatively, publish an <ipfs-path> using a valid PeerID (as listed by
  > ipfs name publish --key=QmbCMUZw6JFeZ7Wp9jkzbye3Fzp2GGcPgC3nmeUjfVF87n /ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
   Published to QmbCMUZw6JFeZ7Wp9jkzbye3Fzp2GGcPgC3nmeUjfVF87n: /ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
 
+Sign a record without publishing it, for example on a machine without network
+access, and publish it later from another node with 'ipfs name put':
+
+  > ipfs name publish --offline --resolve=false --output=record.bin /ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
+  Signed record of QmbCMUZw6JFeZ7Wp9jkzbye3Fzp2GGcPgC3nmeUjfVF87n: /ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy, written to record.bin
+
+The sequence number of the record follows the one of the last record of the
+key known to the node. Without --output, the record is written to stdout.
+
 `,
 	},
 
@@ -72,6 +86,8 @@ Alternatively, publish an <ipfs-path> using a valid PeerID (as listed by
     "ns", "us" (or "µs"), "ms", "s", "m", "h".`).WithDefault("24h"),
 		cmdkit.StringOption("ttl", "Time duration this record should be cached for (caution: experimental)."),
 		cmdkit.StringOption("key", "k", "Name of the key to be used or a valid PeerID, as listed by 'ipfs key list -l'. Default: <<default>>.").WithDefault("self"),
+		cmdkit.BoolOption("offline", "Sign the record without publishing it.").WithDefault(false),
+		cmdkit.StringOption("output", "o", "Write the record signed with --offline to the given file."),
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		n, err := req.InvocContext().GetNode()
@@ -137,7 +153,18 @@ Alternatively, publish an <ipfs-path> using a valid PeerID (as listed by
 			return
 		}
 
-		output, err := publish(ctx, n, k, pth, popts)
+		offlineMode, _, _ := req.Option("offline").Bool()
+		if out, found, _ := req.Option("output").String(); found && out != "" && !offlineMode {
+			res.SetError(errors.New("--output can only be used with --offline"), cmdkit.ErrNormal)
+			return
+		}
+
+		var output *IpnsEntry
+		if offlineMode {
+			output, err = signOffline(ctx, n, k, pth, popts)
+		} else {
+			output, err = publish(ctx, n, k, pth, popts)
+		}
 		if err != nil {
 			res.SetError(err, cmdkit.ErrNormal)
 			return
@@ -155,6 +182,18 @@ Alternatively, publish an <ipfs-path> using a valid PeerID (as listed by
 				return nil, e.TypeErr(entry, v)
 			}
 
+			if len(entry.Record) > 0 {
+				out, _, _ := res.Request().Option("output").String()
+				if out == "" {
+					return bytes.NewReader(entry.Record), nil
+				}
+				if err := ioutil.WriteFile(out, entry.Record, 0644); err != nil {
+					return nil, err
+				}
+				s := fmt.Sprintf("Signed record of %s: %s, written to %s\n", entry.Name, entry.Value, out)
+				return strings.NewReader(s), nil
+			}
+
 			s := fmt.Sprintf("Published to %s: %s\n", entry.Name, entry.Value)
 			return strings.NewReader(s), nil
 		},
@@ -194,6 +233,46 @@ func publish(ctx context.Context, n *core.IpfsNode, k crypto.PrivKey, ref path.P
 	}, nil
 }
 
+// signOffline creates the next record of the key without publishing it. The
+// record is only stored in the local datastore, so that the sequence numbers
+// of the records signed by the node keep increasing.
+func signOffline(ctx context.Context, n *core.IpfsNode, k crypto.PrivKey, ref path.Path, opts *publishOpts) (*IpnsEntry, error) {
+	if opts.verifyExists {
+		_, err := core.Resolve(ctx, n.Namesys, n.Resolver, ref)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pid, err := peer.IDFromPrivateKey(k)
+	if err != nil {
+		return nil, err
+	}
+
+	offroute := offline.NewOfflineRouter(n.Repo.Datastore(), n.PrivateKey)
+	eol := time.Now().Add(opts.pubValidTime)
+	entry, err := namesys.SignRecord(ctx, k, ref, eol, offroute, n.Repo.Datastore())
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := proto.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	_, ipnskey := namesys.IpnsKeysForID(pid)
+	if err := offroute.PutValue(ctx, ipnskey, data); err != nil {
+		return nil, err
+	}
+
+	return &IpnsEntry{
+		Name:   pid.Pretty(),
+		Value:  ref.String(),
+		Record: data,
+	}, nil
+}
+
 func keylookup(n *core.IpfsNode, k string) (crypto.PrivKey, error) {
 
 	res, err := n.GetKey(k)
@@ -2,6 +2,7 @@ package commands
 
 import (
 	"io"
+	"sort"
 	"strings"
 
 	oldcmds "github.com/ipfs/go-ipfs/commands"
@@ -53,6 +54,7 @@ ADVANCED COMMANDS
   stats         Various operational stats
   p2p           Libp2p stream mounting
   filestore     Manage the filestore (experimental)
+  api           Manage access to the HTTP API
 
 NETWORK COMMANDS
   id            Show info about IPFS peers
@@ -104,6 +106,7 @@ var CommandsDaemonCmd = CommandsCmd(Root)
 
 var rootSubcommands = map[string]*cmds.Command{
 	"add":       AddCmd,
+	"api":       lgc.NewCommand(ApiCmd),
 	"bitswap":   BitswapCmd,
 	"block":     BlockCmd,
 	"cat":       CatCmd,
@@ -200,6 +203,29 @@ func init() {
 	RootRO.Subcommands = rootROSubcommands
 }
 
+// ReadOnlyPaths returns the paths of all commands in RootRO, with
+// subcommand names joined by '/'. It is used to express the read-only
+// command set as an API token scope.
+func ReadOnlyPaths() []string {
+	var paths []string
+	var walk func(prefix string, cmd *cmds.Command)
+	walk = func(prefix string, cmd *cmds.Command) {
+		for name, sub := range cmd.Subcommands {
+			p := name
+			if prefix != "" {
+				p = prefix + "/" + name
+			}
+			if sub.Run != nil {
+				paths = append(paths, p)
+			}
+			walk(p, sub)
+		}
+	}
+	walk("", RootRO)
+	sort.Strings(paths)
+	return paths
+}
+
 type MessageOutput struct {
 	Message string
 }
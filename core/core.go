@@ -24,6 +24,7 @@ import (
 	bserv "github.com/ipfs/go-ipfs/blockservice"
 	exchange "github.com/ipfs/go-ipfs/exchange"
 	bitswap "github.com/ipfs/go-ipfs/exchange/bitswap"
+	decision "github.com/ipfs/go-ipfs/exchange/bitswap/decision"
 	bsnet "github.com/ipfs/go-ipfs/exchange/bitswap/network"
 	rp "github.com/ipfs/go-ipfs/exchange/reprovide"
 	filestore "github.com/ipfs/go-ipfs/filestore"
@@ -299,27 +300,77 @@ func constructConnMgr(cfg config.ConnMgr) (ifconnmgr.ConnManager, error) {
 	}
 }
 
+func constructBitswapOptions(cfg config.Bitswap) ([]bitswap.Option, error) {
+	priority := make(map[peer.ID]bool, len(cfg.PriorityPeers))
+	var priorityList []peer.ID
+	for _, s := range cfg.PriorityPeers {
+		p, err := peer.IDB58Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("parsing Bitswap.PriorityPeers: %s", err)
+		}
+		priority[p] = true
+		priorityList = append(priorityList, p)
+	}
+
+	strategy, err := decision.NewStrategy(cfg.Strategy, priorityList)
+	if err != nil {
+		return nil, err
+	}
+	opts := []bitswap.Option{bitswap.WithStrategy(strategy)}
+	if cfg.MaxMessageSize > 0 {
+		opts = append(opts, bitswap.WithMaxMessageSize(cfg.MaxMessageSize))
+	}
+
+	if len(cfg.AllowedPeers) > 0 {
+		allowed := make([]peer.ID, 0, len(cfg.AllowedPeers))
+		for _, s := range cfg.AllowedPeers {
+			p, err := peer.IDB58Decode(s)
+			if err != nil {
+				return nil, fmt.Errorf("parsing Bitswap.AllowedPeers: %s", err)
+			}
+			allowed = append(allowed, p)
+		}
+		opts = append(opts, bitswap.WithPeerFilter(decision.NewAllowlistFilter(allowed)))
+	}
+
+	if cfg.PeerSendRate > 0 || cfg.PrioritySendRate > 0 {
+		opts = append(opts, bitswap.WithSendRate(func(p peer.ID) (int64, int64) {
+			if priority[p] {
+				return cfg.PrioritySendRate, cfg.PrioritySendRate
+			}
+			return cfg.PeerSendRate, cfg.PeerSendBurst
+		}))
+	}
+	return opts, nil
+}
+
 func (n *IpfsNode) startLateOnlineServices(ctx context.Context) error {
 	cfg, err := n.Repo.Config()
 	if err != nil {
 		return err
 	}
 
-	var keyProvider rp.KeyChanFunc
+	keyProvider, err := rp.DefaultStrategies.KeyProvider(cfg.Reprovider.Strategy, rp.StrategyEnv{
+		Blockstore: n.Blockstore,
+		Pinning:    n.Pinning,
+		DAG:        n.DAG,
+		FilesRoot:  n.FilesRoot,
+	})
+	if err != nil {
+		return err
+	}
 
-	switch cfg.Reprovider.Strategy {
-	case "all":
-		fallthrough
-	case "":
-		keyProvider = rp.NewBlockstoreProvider(n.Blockstore)
-	case "roots":
-		keyProvider = rp.NewPinnedProvider(n.Pinning, n.DAG, true)
-	case "pinned":
-		keyProvider = rp.NewPinnedProvider(n.Pinning, n.DAG, false)
-	default:
-		return fmt.Errorf("unknown reprovider strategy '%s'", cfg.Reprovider.Strategy)
+	if d, ok := n.Routing.(*dht.IpfsDHT); ok && cfg.Reprovider.Batched {
+		opts := rp.BatchOptions{
+			BatchSize: cfg.Reprovider.BatchSize,
+		}
+		n.Reprovider = rp.NewBatchReprovider(ctx, rp.NewDHTProvider(n.PeerHost, d), keyProvider, n.Repo.Datastore(), opts)
+	} else {
+		if cfg.Reprovider.Batched {
+			log.Warning("batched reproviding needs the DHT, providing keys one by one")
+		}
+		n.Reprovider = rp.NewReprovider(ctx, n.Routing, keyProvider)
 	}
-	n.Reprovider = rp.NewReprovider(ctx, n.Routing, keyProvider)
 
 	reproviderInterval := kReprovideFrequency
 	if cfg.Reprovider.Interval != "" {
@@ -452,20 +503,54 @@ func (n *IpfsNode) startOnlineServicesWithHost(ctx context.Context, host p2phost
 	n.PeerHost = rhost.Wrap(host, n.Routing)
 
 	// setup exchange service
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return err
+	}
+	bsOpts, err := constructBitswapOptions(cfg.Bitswap)
+	if err != nil {
+		return err
+	}
+	bsOpts = append(bsOpts, bitswap.WithLedgerStore(n.Repo.Datastore()))
+	var netOpts []bsnet.Option
+	if cfg.Bitswap.MaxBlockSize > 0 {
+		netOpts = append(netOpts, bsnet.MaxBlockSize(cfg.Bitswap.MaxBlockSize))
+	}
 	const alwaysSendToPeer = true // use YesManStrategy
-	bitswapNetwork := bsnet.NewFromIpfsHost(n.PeerHost, n.Routing)
-	n.Exchange = bitswap.New(ctx, n.Identity, bitswapNetwork, n.Blockstore, alwaysSendToPeer)
+	bitswapNetwork := bsnet.NewFromIpfsHost(n.PeerHost, n.Routing, netOpts...)
+	n.Exchange = bitswap.New(ctx, n.Identity, bitswapNetwork, n.Blockstore, alwaysSendToPeer, bsOpts...)
+
+	// setup name system
+	if err := n.setupNamesys(); err != nil {
+		return err
+	}
+
+	// setup ipns republishing
+	return n.setupIpnsRepublisher()
+}
+
+// setupNamesys sets up the name system on the routing of the node.
+func (n *IpfsNode) setupNamesys() error {
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return err
+	}
 
 	size, err := n.getCacheSize()
 	if err != nil {
 		return err
 	}
 
-	// setup name system
 	n.Namesys = namesys.NewNameSystem(n.Routing, n.Repo.Datastore(), size)
+	if err := namesys.SetRevalidatedNames(n.Namesys, cfg.Ipns.ResolveCacheRevalidate); err != nil {
+		return err
+	}
 
-	// setup ipns republishing
-	return n.setupIpnsRepublisher()
+	transports, err := namesys.ParseDNSTransports(cfg.DNS.Resolvers)
+	if err != nil {
+		return fmt.Errorf("failure to parse config setting DNS.Resolvers: %s", err)
+	}
+	return namesys.SetDNSTransports(n.Namesys, transports)
 }
 
 // getCacheSize returns cache life and cache size
@@ -492,6 +577,7 @@ func (n *IpfsNode) setupIpnsRepublisher() error {
 	}
 
 	n.IpnsRepub = ipnsrp.NewRepublisher(n.Routing, n.Repo.Datastore(), n.PrivateKey, n.Repo.Keystore())
+	n.IpnsRepub.Keys = cfg.Ipns.RepublishKeys
 
 	if cfg.Ipns.RepublishPeriod != "" {
 		d, err := time.ParseDuration(cfg.Ipns.RepublishPeriod)
@@ -793,14 +879,7 @@ func (n *IpfsNode) SetupOfflineRouting() error {
 
 	n.Routing = offroute.NewOfflineRouter(n.Repo.Datastore(), n.PrivateKey)
 
-	size, err := n.getCacheSize()
-	if err != nil {
-		return err
-	}
-
-	n.Namesys = namesys.NewNameSystem(n.Routing, n.Repo.Datastore(), size)
-
-	return nil
+	return n.setupNamesys()
 }
 
 func loadPrivateKey(cfg *config.Identity, id peer.ID) (ic.PrivKey, error) {
@@ -0,0 +1,90 @@
+package coreauth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Scope names a set of API commands a token may call.
+type Scope string
+
+const (
+	// ScopeRead grants access to the read-only command set, the same set
+	// exposed on the gateway port.
+	ScopeRead Scope = "read"
+	// ScopePin grants access to pinning and adding content.
+	ScopePin Scope = "pin"
+	// ScopeFiles grants access to the mutable file system.
+	ScopeFiles Scope = "files"
+	// ScopeName grants access to IPNS publishing and key management.
+	ScopeName Scope = "name"
+	// ScopeAdmin grants access to every command.
+	ScopeAdmin Scope = "admin"
+)
+
+// AllScopes lists the known scopes.
+var AllScopes = []Scope{ScopeRead, ScopePin, ScopeFiles, ScopeName, ScopeAdmin}
+
+// Valid returns whether s is a known scope.
+func (s Scope) Valid() bool {
+	for _, k := range AllScopes {
+		if s == k {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseScopes parses a comma separated list of scopes.
+func ParseScopes(s string) ([]Scope, error) {
+	var out []Scope
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		sc := Scope(f)
+		if !sc.Valid() {
+			return nil, fmt.Errorf("unknown scope %q, must be one of %v", f, AllScopes)
+		}
+		out = append(out, sc)
+	}
+	return out, nil
+}
+
+// Policy maps scopes onto the command paths they grant access to. Command
+// paths are the '/' separated subcommand names below the API root, e.g.
+// "pin/add". A path in the policy grants access to itself and everything
+// below it.
+type Policy struct {
+	paths map[Scope][]string
+}
+
+// NewPolicy returns the default policy. readOnly is the list of commands
+// granted by ScopeRead.
+func NewPolicy(readOnly []string) *Policy {
+	return &Policy{
+		paths: map[Scope][]string{
+			ScopeRead:  readOnly,
+			ScopePin:   {"pin", "add", "refs/local"},
+			ScopeFiles: {"files", "add"},
+			ScopeName:  {"name", "key"},
+		},
+	}
+}
+
+// Allowed returns whether t may call the command at the given path.
+func (p *Policy) Allowed(t *Token, cmdPath string) bool {
+	cmdPath = strings.Trim(cmdPath, "/")
+	for _, s := range t.Scopes {
+		if s == ScopeAdmin {
+			return true
+		}
+		for _, allowed := range p.paths[s] {
+			if cmdPath == allowed || strings.HasPrefix(cmdPath, allowed+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
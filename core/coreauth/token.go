@@ -0,0 +1,199 @@
+// Package coreauth implements the scoped bearer tokens used to authenticate
+// requests to the HTTP API.
+package coreauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
+	dsq "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore/query"
+)
+
+// tokenPrefix is the datastore namespace tokens are stored under.
+var tokenPrefix = ds.NewKey("/local/apitokens")
+
+var (
+	// ErrInvalidToken is returned when a presented token is malformed, unknown
+	// or does not match the stored secret.
+	ErrInvalidToken = errors.New("invalid API token")
+	// ErrNoSuchToken is returned when revoking a token that doesn't exist.
+	ErrNoSuchToken = errors.New("no API token with the given id")
+)
+
+// Token describes an API token. The secret part of the token is only known
+// to its holder, the store keeps a hash of it.
+type Token struct {
+	ID         string
+	Name       string
+	Scopes     []Scope
+	Created    time.Time
+	SecretHash string
+}
+
+// HasScope returns whether the token was granted the given scope.
+func (t *Token) HasScope(s Scope) bool {
+	for _, ts := range t.Scopes {
+		if ts == s || ts == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Store persists API tokens in a datastore.
+type Store struct {
+	ds ds.Datastore
+}
+
+// NewStore returns a token store backed by the given datastore.
+func NewStore(d ds.Datastore) *Store {
+	return &Store{ds: d}
+}
+
+// Create generates a new token with the given name and scopes. It returns the
+// stored token and the bearer string to hand out to the client, which cannot
+// be recovered later.
+func (s *Store) Create(name string, scopes []Scope) (*Token, string, error) {
+	if len(scopes) == 0 {
+		return nil, "", errors.New("a token needs at least one scope")
+	}
+	for _, sc := range scopes {
+		if !sc.Valid() {
+			return nil, "", fmt.Errorf("unknown scope: %s", sc)
+		}
+	}
+
+	idb := make([]byte, 8)
+	if _, err := rand.Read(idb); err != nil {
+		return nil, "", err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+
+	t := &Token{
+		ID:         hex.EncodeToString(idb),
+		Name:       name,
+		Scopes:     scopes,
+		Created:    time.Now().UTC(),
+		SecretHash: hashSecret(secret),
+	}
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.ds.Put(tokenKey(t.ID), data); err != nil {
+		return nil, "", err
+	}
+
+	return t, t.ID + "." + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// Get returns the token with the given id.
+func (s *Store) Get(id string) (*Token, error) {
+	val, err := s.ds.Get(tokenKey(id))
+	if err != nil {
+		return nil, err
+	}
+	data, ok := val.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("token %s is not stored as bytes", id)
+	}
+
+	t := new(Token)
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// List returns all tokens, ordered by creation time.
+func (s *Store) List() ([]*Token, error) {
+	res, err := s.ds.Query(dsq.Query{Prefix: tokenPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*Token, 0, len(entries))
+	for _, e := range entries {
+		data, ok := e.Value.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("token %s is not stored as bytes", e.Key)
+		}
+		t := new(Token)
+		if err := json.Unmarshal(data, t); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Created.Before(out[j].Created)
+	})
+	return out, nil
+}
+
+// Revoke deletes the token with the given id.
+func (s *Store) Revoke(id string) error {
+	k := tokenKey(id)
+	has, err := s.ds.Has(k)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return ErrNoSuchToken
+	}
+	return s.ds.Delete(k)
+}
+
+// Authenticate checks a bearer string as returned by Create and returns the
+// matching token.
+func (s *Store) Authenticate(bearer string) (*Token, error) {
+	parts := strings.SplitN(bearer, ".", 2)
+	if len(parts) != 2 || parts[0] == "" || strings.Contains(parts[0], "/") {
+		return nil, ErrInvalidToken
+	}
+	secret, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	t, err := s.Get(parts[0])
+	switch err {
+	case nil:
+	case ds.ErrNotFound:
+		return nil, ErrInvalidToken
+	default:
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(t.SecretHash)) != 1 {
+		return nil, ErrInvalidToken
+	}
+	return t, nil
+}
+
+func tokenKey(id string) ds.Key {
+	return tokenPrefix.ChildString(id)
+}
+
+func hashSecret(secret []byte) string {
+	h := sha256.Sum256(secret)
+	return hex.EncodeToString(h[:])
+}
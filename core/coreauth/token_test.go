@@ -0,0 +1,102 @@
+package coreauth
+
+import (
+	"testing"
+
+	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
+)
+
+func TestCreateAuthenticateRevoke(t *testing.T) {
+	s := NewStore(ds.NewMapDatastore())
+
+	tok, bearer, err := s.Create("ci", []Scope{ScopeRead, ScopePin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Authenticate(bearer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != tok.ID || got.Name != "ci" || len(got.Scopes) != 2 {
+		t.Fatalf("authenticated wrong token: %#v", got)
+	}
+
+	for _, bad := range []string{"", "nodot", tok.ID + ".", tok.ID + ".AAAA", "unknown." + bearer[len(tok.ID)+1:]} {
+		if _, err := s.Authenticate(bad); err != ErrInvalidToken {
+			t.Errorf("expected ErrInvalidToken for %q, got %v", bad, err)
+		}
+	}
+
+	list, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != tok.ID {
+		t.Fatalf("unexpected token list: %v", list)
+	}
+
+	if err := s.Revoke(tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authenticate(bearer); err != ErrInvalidToken {
+		t.Fatalf("expected revoked token to be rejected, got %v", err)
+	}
+	if err := s.Revoke(tok.ID); err != ErrNoSuchToken {
+		t.Fatalf("expected ErrNoSuchToken, got %v", err)
+	}
+}
+
+func TestCreateRejectsUnknownScope(t *testing.T) {
+	s := NewStore(ds.NewMapDatastore())
+	if _, _, err := s.Create("x", []Scope{"root"}); err == nil {
+		t.Fatal("expected error for unknown scope")
+	}
+	if _, _, err := s.Create("x", nil); err == nil {
+		t.Fatal("expected error for token without scopes")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	p := NewPolicy([]string{"cat", "name/resolve"})
+
+	cases := []struct {
+		scopes  []Scope
+		path    string
+		allowed bool
+	}{
+		{[]Scope{ScopeRead}, "/cat", true},
+		{[]Scope{ScopeRead}, "/name/resolve", true},
+		{[]Scope{ScopeRead}, "/name/publish", false},
+		{[]Scope{ScopeRead}, "/catalog", false},
+		{[]Scope{ScopePin}, "/pin/add", true},
+		{[]Scope{ScopePin}, "/files/write", false},
+		{[]Scope{ScopeFiles}, "/files/write", true},
+		{[]Scope{ScopeName}, "/name/publish", true},
+		{[]Scope{ScopeName}, "/key/gen", true},
+		{[]Scope{ScopeRead, ScopeName}, "/cat", true},
+		{[]Scope{ScopeName}, "/config/replace", false},
+		{[]Scope{ScopeAdmin}, "/config/replace", true},
+	}
+
+	for _, c := range cases {
+		tok := &Token{Scopes: c.scopes}
+		if got := p.Allowed(tok, c.path); got != c.allowed {
+			t.Errorf("%v on %s: expected %t, got %t", c.scopes, c.path, c.allowed, got)
+		}
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	s, err := ParseScopes("read, pin,,files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s) != 3 || s[0] != ScopeRead || s[1] != ScopePin || s[2] != ScopeFiles {
+		t.Fatalf("unexpected scopes: %v", s)
+	}
+
+	if _, err := ParseScopes("read,sudo"); err == nil {
+		t.Fatal("expected error for unknown scope")
+	}
+}
@@ -0,0 +1,130 @@
+package corehttp
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	core "github.com/ipfs/go-ipfs/core"
+	corecommands "github.com/ipfs/go-ipfs/core/commands"
+	coreauth "github.com/ipfs/go-ipfs/core/coreauth"
+)
+
+// APIAuthOption requires requests to the API to carry a bearer token granting
+// access to the requested command, if API.Authorization is enabled in the
+// config. Tokens are managed with 'ipfs api token'.
+func APIAuthOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, parent *http.ServeMux) (*http.ServeMux, error) {
+		auth, err := newTokenAuthorizer(n)
+		if err != nil {
+			return nil, err
+		}
+		if auth == nil {
+			return parent, nil
+		}
+
+		mux := http.NewServeMux()
+		parent.Handle("/", &apiAuthHandler{
+			auth:   auth,
+			policy: coreauth.NewPolicy(corecommands.ReadOnlyPaths()),
+			next:   mux,
+		})
+		return mux, nil
+	}
+}
+
+// tokenAuthorizer authenticates HTTP requests using API tokens.
+type tokenAuthorizer struct {
+	store         *coreauth.Store
+	allowLoopback bool
+}
+
+// newTokenAuthorizer returns an authorizer for the node, or nil if API
+// authorization is disabled in the config.
+func newTokenAuthorizer(n *core.IpfsNode) (*tokenAuthorizer, error) {
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.API.Authorization.Enabled {
+		return nil, nil
+	}
+	return &tokenAuthorizer{
+		store:         coreauth.NewStore(n.Repo.Datastore()),
+		allowLoopback: cfg.API.Authorization.AllowLoopback,
+	}, nil
+}
+
+// authenticate returns the token presented with the request. It returns a
+// nil token and no error for requests exempt from authentication.
+func (a *tokenAuthorizer) authenticate(r *http.Request) (*coreauth.Token, error) {
+	if a.allowLoopback {
+		if ip := net.ParseIP(clientIP(r)); ip != nil && ip.IsLoopback() {
+			return nil, nil
+		}
+	}
+	return tokenFromRequest(a.store, r)
+}
+
+// unauthorized writes a 401 response asking the client for a token.
+func unauthorized(w http.ResponseWriter, err error) {
+	w.Header().Add("WWW-Authenticate", `Bearer realm="ipfs"`)
+	w.Header().Add("WWW-Authenticate", `Basic realm="ipfs"`)
+	http.Error(w, err.Error(), http.StatusUnauthorized)
+}
+
+type apiAuthHandler struct {
+	auth   *tokenAuthorizer
+	policy *coreauth.Policy
+	next   http.Handler
+}
+
+func (h *apiAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, APIPath+"/") {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	t, err := h.auth.authenticate(r)
+	if err != nil {
+		unauthorized(w, err)
+		return
+	}
+
+	cmdPath := r.URL.Path[len(APIPath):]
+	if t != nil && !h.policy.Allowed(t, cmdPath) {
+		log.Infof("API token %s (%s) denied access to %s", t.ID, t.Name, cmdPath)
+		http.Error(w, "token is not allowed to call "+strings.Trim(cmdPath, "/"), http.StatusForbidden)
+		return
+	}
+
+	h.next.ServeHTTP(w, r)
+}
+
+// tokenFromRequest authenticates the token in the Authorization header of r.
+// Besides bearer tokens, basic authentication with the token as password is
+// accepted for clients such as WebDAV file managers which only support that.
+func tokenFromRequest(store *coreauth.Store, r *http.Request) (*coreauth.Token, error) {
+	const prefix = "Bearer "
+
+	var bearer string
+	hdr := r.Header.Get("Authorization")
+	if _, password, ok := r.BasicAuth(); ok {
+		bearer = password
+	} else if hdr == "" {
+		return nil, errMissingToken
+	} else if len(hdr) >= len(prefix) && strings.EqualFold(hdr[:len(prefix)], prefix) {
+		bearer = strings.TrimSpace(hdr[len(prefix):])
+	} else {
+		return nil, coreauth.ErrInvalidToken
+	}
+
+	t, err := store.Authenticate(bearer)
+	if err != nil {
+		if err != coreauth.ErrInvalidToken {
+			log.Errorf("failed to authenticate API token: %s", err)
+		}
+		return nil, coreauth.ErrInvalidToken
+	}
+	return t, nil
+}
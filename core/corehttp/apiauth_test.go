@@ -0,0 +1,68 @@
+package corehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	coreauth "github.com/ipfs/go-ipfs/core/coreauth"
+
+	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
+)
+
+func TestAPIAuthHandler(t *testing.T) {
+	store := coreauth.NewStore(ds.NewMapDatastore())
+	_, readToken, err := store.Create("reader", []coreauth.Scope{coreauth.ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pinToken, err := store.Create("pinner", []coreauth.Scope{coreauth.ScopePin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &apiAuthHandler{
+		auth:   &tokenAuthorizer{store: store},
+		policy: coreauth.NewPolicy([]string{"cat"}),
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}),
+	}
+
+	cases := []struct {
+		remote string
+		uri    string
+		token  string
+		code   int
+	}{
+		{"10.0.0.1:1234", "/webui", "", http.StatusOK},
+		{"10.0.0.1:1234", APIPath + "/cat", "", http.StatusUnauthorized},
+		{"10.0.0.1:1234", APIPath + "/cat", "garbage", http.StatusUnauthorized},
+		{"10.0.0.1:1234", APIPath + "/cat", readToken, http.StatusOK},
+		{"10.0.0.1:1234", APIPath + "/pin/add", readToken, http.StatusForbidden},
+		{"10.0.0.1:1234", APIPath + "/pin/add", pinToken, http.StatusOK},
+		{"127.0.0.1:1234", APIPath + "/pin/add", "", http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("POST", c.uri, nil)
+		r.RemoteAddr = c.remote
+		if c.token != "" {
+			r.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != c.code {
+			t.Errorf("%s with token %q: expected %d, got %d", c.uri, c.token, c.code, w.Code)
+		}
+	}
+
+	h.auth.allowLoopback = true
+	r := httptest.NewRequest("POST", APIPath+"/pin/add", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected loopback request to be allowed, got %d", w.Code)
+	}
+}
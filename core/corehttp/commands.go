@@ -21,6 +21,7 @@ import (
 
 var (
 	errAPIVersionMismatch = errors.New("api version mismatch")
+	errMissingToken       = errors.New("missing API token")
 )
 
 const originEnvKey = "API_ORIGIN"
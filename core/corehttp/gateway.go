@@ -34,6 +34,9 @@ func GatewayOption(writable bool, paths ...string) ServeOption {
 		for _, p := range paths {
 			mux.Handle(p+"/", gateway)
 		}
+		if writable {
+			mux.Handle(mfsPathPrefix, gateway)
+		}
 		return mux, nil
 	}
 }
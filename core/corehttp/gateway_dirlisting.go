@@ -0,0 +1,312 @@
+package corehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	gopath "path"
+	"sort"
+	"strconv"
+	"strings"
+
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
+
+	humanize "gx/ipfs/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
+	ipld "gx/ipfs/Qme5bWv7wtjUNGsK2BNGVUFPKiuxWrsqrtvYwCLRw8YFES/go-ipld-format"
+)
+
+// dirListingMaxSort is the largest directory the gateway sorts. Sorting needs
+// all entries in memory, unsorted listings are streamed.
+const dirListingMaxSort = 10000
+
+var errTooManyToSort = fmt.Errorf("directory has more than %d entries and cannot be sorted", dirListingMaxSort)
+
+var errStopListing = errors.New("stop listing")
+
+// dirListingOptions are the query parameters of a directory listing.
+type dirListingOptions struct {
+	format   string // "html" or "json"
+	cursor   string // name of the entry to continue after
+	limit    int    // maximum number of entries, 0 for all
+	sortBy   string // "", "name" or "size"
+	desc     bool
+	showCid  bool
+	showSize bool
+}
+
+func parseDirListingOptions(q url.Values) (*dirListingOptions, error) {
+	opts := &dirListingOptions{
+		format:   "html",
+		cursor:   q.Get("cursor"),
+		showSize: true,
+	}
+
+	switch f := q.Get("format"); f {
+	case "", "html":
+	case "json":
+		opts.format = f
+	default:
+		return nil, fmt.Errorf("unknown format %q", f)
+	}
+
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid limit %q", l)
+		}
+		opts.limit = n
+	}
+
+	if s := q.Get("sort"); s != "" {
+		if strings.HasPrefix(s, "-") {
+			opts.desc = true
+			s = s[1:]
+		}
+		switch s {
+		case "name", "size":
+			opts.sortBy = s
+		default:
+			return nil, fmt.Errorf("cannot sort by %q", s)
+		}
+	}
+
+	if cols, ok := q["columns"]; ok {
+		opts.showSize = false
+		for _, col := range strings.Split(cols[0], ",") {
+			switch col {
+			case "cid":
+				opts.showCid = true
+			case "size":
+				opts.showSize = true
+			case "":
+			default:
+				return nil, fmt.Errorf("unknown column %q", col)
+			}
+		}
+	}
+	return opts, nil
+}
+
+// query returns the query string of the listing with the given cursor and
+// sort order, keeping the other options.
+func (o *dirListingOptions) query(cursor, sortBy string, desc bool) string {
+	q := url.Values{}
+	if o.format != "html" {
+		q.Set("format", o.format)
+	}
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	if o.limit > 0 {
+		q.Set("limit", strconv.Itoa(o.limit))
+	}
+	if sortBy != "" {
+		if desc {
+			sortBy = "-" + sortBy
+		}
+		q.Set("sort", sortBy)
+	}
+	if o.showCid || !o.showSize {
+		var cols []string
+		if o.showCid {
+			cols = append(cols, "cid")
+		}
+		if o.showSize {
+			cols = append(cols, "size")
+		}
+		q.Set("columns", strings.Join(cols, ","))
+	}
+	return "?" + q.Encode()
+}
+
+// listDirectory calls f for the entries of dir selected by opts. It returns
+// the cursor of the next page, or "" if there are no more entries.
+func listDirectory(ctx context.Context, dir *uio.Directory, opts *dirListingOptions, f func(*ipld.Link) error) (string, error) {
+	if opts.sortBy == "" {
+		var last, next string
+		n := 0
+		err := dir.ForEachLinkAfter(ctx, opts.cursor, func(l *ipld.Link) error {
+			// we look one entry past the limit to know if there is a next page
+			if opts.limit > 0 && n == opts.limit {
+				next = last
+				return errStopListing
+			}
+			if err := f(l); err != nil {
+				return err
+			}
+			last = l.Name
+			n++
+			return nil
+		})
+		if err != nil && err != errStopListing {
+			return "", err
+		}
+		return next, nil
+	}
+
+	var links []*ipld.Link
+	err := dir.ForEachLink(ctx, func(l *ipld.Link) error {
+		if len(links) == dirListingMaxSort {
+			return errTooManyToSort
+		}
+		links = append(links, l)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		a, b := links[i], links[j]
+		if opts.desc {
+			a, b = b, a
+		}
+		if opts.sortBy == "size" && a.Size != b.Size {
+			return a.Size < b.Size
+		}
+		return a.Name < b.Name
+	})
+
+	start := 0
+	if opts.cursor != "" {
+		start = len(links)
+		for i, l := range links {
+			if l.Name == opts.cursor {
+				start = i + 1
+				break
+			}
+		}
+	}
+	end := len(links)
+	if opts.limit > 0 && start+opts.limit < end {
+		end = start + opts.limit
+	}
+
+	for _, l := range links[start:end] {
+		if err := f(l); err != nil {
+			return "", err
+		}
+	}
+	if end < len(links) {
+		return links[end-1].Name, nil
+	}
+	return "", nil
+}
+
+// dirListingWriter writes a directory listing entry by entry. Nothing is
+// written before the first entry or the end of the listing, so errors
+// occurring before that can still be reported to the client.
+type dirListingWriter interface {
+	item(l *ipld.Link) error
+	finish(next string) error
+	started() bool
+}
+
+type htmlListingWriter struct {
+	w       io.Writer
+	opts    *dirListingOptions
+	data    listingTemplateData
+	header  bool
+	headErr error
+}
+
+func (lw *htmlListingWriter) start() error {
+	if !lw.header {
+		lw.header = true
+		lw.headErr = listingTemplate.ExecuteTemplate(lw.w, "header", lw.data)
+	}
+	return lw.headErr
+}
+
+func (lw *htmlListingWriter) started() bool {
+	return lw.header
+}
+
+func (lw *htmlListingWriter) item(l *ipld.Link) error {
+	if err := lw.start(); err != nil {
+		return err
+	}
+	return listingTemplate.ExecuteTemplate(lw.w, "item", directoryItem{
+		Size:     humanize.Bytes(l.Size),
+		Name:     l.Name,
+		Path:     gopath.Join(lw.data.Path, l.Name),
+		Cid:      l.Cid.String(),
+		ShowCid:  lw.opts.showCid,
+		ShowSize: lw.opts.showSize,
+	})
+}
+
+func (lw *htmlListingWriter) finish(next string) error {
+	if err := lw.start(); err != nil {
+		return err
+	}
+	var footer listingFooterData
+	if next != "" {
+		footer.Next = lw.opts.query(next, lw.opts.sortBy, lw.opts.desc)
+	}
+	return listingTemplate.ExecuteTemplate(lw.w, "footer", footer)
+}
+
+// dirListingEntry is an entry of a JSON directory listing.
+type dirListingEntry struct {
+	Name string
+	Cid  string
+	Size uint64
+}
+
+// jsonListingWriter streams a listing as
+// {"Path": ..., "Entries": [...], "Next": ...}.
+type jsonListingWriter struct {
+	w       io.Writer
+	path    string
+	entries int
+	header  bool
+}
+
+func (lw *jsonListingWriter) start() error {
+	if lw.header {
+		return nil
+	}
+	lw.header = true
+	p, err := json.Marshal(lw.path)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(lw.w, `{"Path":%s,"Entries":[`, p)
+	return err
+}
+
+func (lw *jsonListingWriter) started() bool {
+	return lw.header
+}
+
+func (lw *jsonListingWriter) item(l *ipld.Link) error {
+	if err := lw.start(); err != nil {
+		return err
+	}
+	b, err := json.Marshal(dirListingEntry{Name: l.Name, Cid: l.Cid.String(), Size: l.Size})
+	if err != nil {
+		return err
+	}
+	if lw.entries > 0 {
+		b = append([]byte{','}, b...)
+	}
+	lw.entries++
+	_, err = lw.w.Write(b)
+	return err
+}
+
+func (lw *jsonListingWriter) finish(next string) error {
+	if err := lw.start(); err != nil {
+		return err
+	}
+	n, err := json.Marshal(next)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(lw.w, "],\"Next\":%s}\n", n)
+	return err
+}
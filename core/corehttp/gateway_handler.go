@@ -2,7 +2,6 @@ package corehttp
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -15,6 +14,7 @@ import (
 	core "github.com/ipfs/go-ipfs/core"
 	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
 	coreiface "github.com/ipfs/go-ipfs/core/coreapi/interface"
+	coreauth "github.com/ipfs/go-ipfs/core/coreauth"
 	"github.com/ipfs/go-ipfs/importer"
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	dagutils "github.com/ipfs/go-ipfs/merkledag/utils"
@@ -23,7 +23,6 @@ import (
 	ft "github.com/ipfs/go-ipfs/unixfs"
 	uio "github.com/ipfs/go-ipfs/unixfs/io"
 
-	humanize "gx/ipfs/QmPSBJL4momYnE7DcUyk2DVhD6rH488ZmHBGLbxNdhU44K/go-humanize"
 	routing "gx/ipfs/QmTiWLZ6Fo5j4KcTVutZJ5KWRRJrbxzmxA4td8NfEdrPh7/go-libp2p-routing"
 	chunker "gx/ipfs/QmWo8jYc19ppG7YoTsrr2kEtLRbARTJho5oNXFTR6B7Peq/go-ipfs-chunker"
 	cid "gx/ipfs/QmcZfnkapfECQGcLZaf9B79NRg7cRa9EnZh4LSbkCzwNvY/go-cid"
@@ -42,6 +41,7 @@ type gatewayHandler struct {
 	node   *core.IpfsNode
 	config GatewayConfig
 	api    coreiface.CoreAPI
+	tokens *coreauth.Store // authenticates writes
 }
 
 func newGatewayHandler(n *core.IpfsNode, c GatewayConfig, api coreiface.CoreAPI) *gatewayHandler {
@@ -49,6 +49,7 @@ func newGatewayHandler(n *core.IpfsNode, c GatewayConfig, api coreiface.CoreAPI)
 		node:   n,
 		config: c,
 		api:    api,
+		tokens: coreauth.NewStore(n.Repo.Datastore()),
 	}
 	return i
 }
@@ -89,18 +90,18 @@ func (i *gatewayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	if i.config.Writable {
 		switch r.Method {
-		case "POST":
-			i.postHandler(ctx, w, r)
-			return
-		case "PUT":
-			i.putHandler(w, r)
-			return
-		case "DELETE":
-			i.deleteHandler(w, r)
+		case "POST", "PUT", "DELETE":
+			i.writeHandler(ctx, w, r)
 			return
 		}
 	}
 
+	if strings.HasPrefix(r.URL.Path, mfsPathPrefix) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, "Method "+r.Method+" not allowed: "+mfsPathPrefix+" only accepts writes")
+		return
+	}
+
 	if r.Method == "GET" || r.Method == "HEAD" {
 		i.getOrHeadHandler(ctx, w, r)
 		return
@@ -168,6 +169,25 @@ func (i *gatewayHandler) getOrHeadHandler(ctx context.Context, w http.ResponseWr
 		return
 	}
 
+	// Serve IPNS names with the first valid answer of the name system
+	// rather than waiting for the best record of the DHT
+	if strings.HasPrefix(urlPath, ipnsPathPrefix) {
+		p, err := core.ResolveIpnsFirst(ctx, i.node.Namesys, path.Path(parsedPath.String()))
+		switch err {
+		case nil:
+			parsedPath, err = coreapi.ParsePath(p.String())
+			if err != nil {
+				webError(w, "ipfs resolve -r "+escapedURLPath, err, http.StatusNotFound)
+				return
+			}
+		case core.ErrNoNamesys:
+			// reported by ResolvePath
+		default:
+			webError(w, "ipfs resolve -r "+escapedURLPath, err, http.StatusNotFound)
+			return
+		}
+	}
+
 	// Resolve path to the final DAG node for the ETag
 	resolvedPath, err := i.api.ResolvePath(ctx, parsedPath)
 	switch err {
@@ -311,18 +331,17 @@ func (i *gatewayHandler) getOrHeadHandler(ctx context.Context, w http.ResponseWr
 	case os.IsNotExist(err):
 	}
 
-	if r.Method == "HEAD" {
+	// the query of websites with an index.html is theirs, so the listing
+	// options are only parsed when a listing is rendered
+	listOpts, err := parseDirListingOptions(r.URL.Query())
+	if err != nil {
+		webError(w, "invalid directory listing options", err, http.StatusBadRequest)
 		return
 	}
 
-	// storage for directory listing
-	var dirListing []directoryItem
-	dirr.ForEachLink(ctx, func(link *ipld.Link) error {
-		// See comment above where originalUrlPath is declared.
-		di := directoryItem{humanize.Bytes(link.Size), link.Name, gopath.Join(originalUrlPath, link.Name)}
-		dirListing = append(dirListing, di)
-		return nil
-	})
+	if r.Method == "HEAD" {
+		return
+	}
 
 	// construct the correct back link
 	// https://github.com/ipfs/go-ipfs/issues/1365
@@ -357,16 +376,43 @@ func (i *gatewayHandler) getOrHeadHandler(ctx context.Context, w http.ResponseWr
 	}
 
 	// See comment above where originalUrlPath is declared.
-	tplData := listingTemplateData{
-		Listing:  dirListing,
-		Path:     originalUrlPath,
-		BackLink: backLink,
+	var lw dirListingWriter
+	if listOpts.format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		lw = &jsonListingWriter{w: w, path: originalUrlPath}
+	} else {
+		lw = &htmlListingWriter{
+			w:    w,
+			opts: listOpts,
+			data: listingTemplateData{
+				Path:       originalUrlPath,
+				BackLink:   backLink,
+				ShowCid:    listOpts.showCid,
+				ShowSize:   listOpts.showSize,
+				SortByName: listOpts.query("", "name", listOpts.sortBy == "name" && !listOpts.desc),
+				SortBySize: listOpts.query("", "size", listOpts.sortBy == "size" && !listOpts.desc),
+			},
+		}
 	}
-	err = listingTemplate.Execute(w, tplData)
+
+	next, err := listDirectory(ctx, dirr, listOpts, lw.item)
 	if err != nil {
-		internalWebError(w, err)
+		if !lw.started() {
+			code := http.StatusInternalServerError
+			if err == errTooManyToSort {
+				code = http.StatusBadRequest
+			}
+			webError(w, "ipfs ls "+escapedURLPath, err, code)
+			return
+		}
+		// too late to report the error to the client
+		log.Errorf("failed to list %s: %s", urlPath, err)
 		return
 	}
+
+	if err := lw.finish(next); err != nil {
+		log.Debugf("failed to write directory listing: %s", err)
+	}
 }
 
 type sizeReadSeeker interface {
@@ -421,10 +467,6 @@ func (i *gatewayHandler) putHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	rsegs := rootPath.Segments()
-	if rsegs[0] == ipnsPathPrefix {
-		webError(w, "putHandler: updating named entries not supported", errors.New("WritableGateway: ipns put not supported"), http.StatusBadRequest)
-		return
-	}
 
 	var newnode ipld.Node
 	if rsegs[len(rsegs)-1] == "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn" {
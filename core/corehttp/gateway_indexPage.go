@@ -11,19 +11,92 @@ import (
 
 // structs for directory listing
 type listingTemplateData struct {
-	Listing  []directoryItem
 	Path     string
 	BackLink string
+
+	ShowCid  bool
+	ShowSize bool
+
+	// links to the listing sorted by the respective column
+	SortByName string
+	SortBySize string
 }
 
 type directoryItem struct {
 	Size string
 	Name string
 	Path string
+	Cid  string
+
+	ShowCid  bool
+	ShowSize bool
 }
 
+type listingFooterData struct {
+	Next string
+}
+
+// listingTemplate renders directory listings in three parts, "header", "item"
+// for each entry and "footer", so that listings can be streamed.
 var listingTemplate *template.Template
 
+const listingTemplateText = `
+{{ define "header" }}<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8" />
+  {{ styles }}
+  <title>{{ .Path }}</title>
+</head>
+<body>
+  <div id="header" class="row">
+    <div class="col-xs-2">
+      <div id="logo" class="ipfs-logo">&nbsp;</div>
+    </div>
+  </div>
+  <br/>
+  <div class="col-xs-12">
+    <div class="panel panel-default">
+      <div class="panel-heading">
+        <strong>Index of {{ .Path }}</strong>
+      </div>
+      <table class="table table-striped">
+        <tr>
+          <th class="narrow"></th>
+          <th><a href="{{ .SortByName }}">Name</a></th>
+          {{ if .ShowCid }}<th>CID</th>{{ end }}
+          {{ if .ShowSize }}<th><a href="{{ .SortBySize }}">Size</a></th>{{ end }}
+        </tr>
+        <tr>
+          <td class="narrow">
+            <div class="ipfs-icon ipfs-_blank">&nbsp;</div>
+          </td>
+          <td class="padding">
+            <a href="{{ .BackLink | urlEscape }}">..</a>
+          </td>
+          {{ if .ShowCid }}<td></td>{{ end }}
+          {{ if .ShowSize }}<td></td>{{ end }}
+        </tr>
+{{ end }}
+{{ define "item" }}        <tr>
+          <td>
+            <div class="ipfs-icon {{ iconFromExt .Name }}">&nbsp;</div>
+          </td>
+          <td>
+            <a href="{{ .Path | urlEscape }}">{{ .Name }}</a>
+          </td>
+          {{ if .ShowCid }}<td><code>{{ .Cid }}</code></td>{{ end }}
+          {{ if .ShowSize }}<td>{{ .Size }}</td>{{ end }}
+        </tr>
+{{ end }}
+{{ define "footer" }}      </table>
+      {{ if .Next }}<div class="panel-footer"><a href="{{ .Next }}">Next page</a></div>{{ end }}
+    </div>
+  </div>
+</body>
+</html>
+{{ end }}`
+
 func init() {
 	knownIconsBytes, err := assets.Asset("dir-index-html/knownIcons.txt")
 	if err != nil {
@@ -51,14 +124,23 @@ func init() {
 		return pathUrl.String()
 	}
 
-	// Directory listing template
+	// Directory listing template. We only take the styles (icons and layout)
+	// from the upstream template, the markup is ours so it can be streamed.
 	dirIndexBytes, err := assets.Asset("dir-index-html/dir-index.html")
 	if err != nil {
 		panic(err)
 	}
+	dirIndex := string(dirIndexBytes)
+	start := strings.Index(dirIndex, "<style>")
+	end := strings.Index(dirIndex, "</style>")
+	if start < 0 || end < start {
+		panic("dir-index.html contains no styles")
+	}
+	styles := template.HTML(dirIndex[start : end+len("</style>")])
 
 	listingTemplate = template.Must(template.New("dir").Funcs(template.FuncMap{
 		"iconFromExt": iconFromExt,
 		"urlEscape":   urlEscape,
-	}).Parse(string(dirIndexBytes)))
+		"styles":      func() template.HTML { return styles },
+	}).Parse(listingTemplateText))
 }
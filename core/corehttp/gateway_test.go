@@ -1,9 +1,13 @@
 package corehttp
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -11,13 +15,16 @@ import (
 	"time"
 
 	core "github.com/ipfs/go-ipfs/core"
+	coreauth "github.com/ipfs/go-ipfs/core/coreauth"
 	coreunix "github.com/ipfs/go-ipfs/core/coreunix"
 	dag "github.com/ipfs/go-ipfs/merkledag"
+	mfs "github.com/ipfs/go-ipfs/mfs"
 	namesys "github.com/ipfs/go-ipfs/namesys"
 	nsopts "github.com/ipfs/go-ipfs/namesys/opts"
 	path "github.com/ipfs/go-ipfs/path"
 	repo "github.com/ipfs/go-ipfs/repo"
 	config "github.com/ipfs/go-ipfs/repo/config"
+	ft "github.com/ipfs/go-ipfs/unixfs"
 
 	id "gx/ipfs/QmNh1kGFFdsPu79KNSaL4NUKUPb4Eiz4KHdMtFY6664RDp/go-libp2p/p2p/protocol/identify"
 	datastore "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
@@ -38,6 +45,14 @@ func (m mockNamesys) Resolve(ctx context.Context, name string, opts ...nsopts.Re
 	return p, nil
 }
 
+func (m mockNamesys) ResolveAsync(ctx context.Context, name string, opts ...nsopts.ResolveOpt) <-chan namesys.Result {
+	out := make(chan namesys.Result, 1)
+	v, err := m.Resolve(ctx, name, opts...)
+	out <- namesys.Result{Path: v, Err: err}
+	close(out)
+	return out
+}
+
 func (m mockNamesys) Publish(ctx context.Context, name ci.PrivKey, value path.Path) error {
 	return errors.New("not implemented for mockNamesys")
 }
@@ -550,3 +565,194 @@ func TestVersion(t *testing.T) {
 		t.Fatalf("response doesn't contain protocol version:\n%s", s)
 	}
 }
+
+func TestDirectoryListingJSON(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ts, n := newTestServerAndNode(t, mockNamesys{})
+	defer ts.Close()
+
+	dir := dag.NodeWithData(ft.FolderPBData())
+	for _, name := range []string{"a", "b", "c"} {
+		_, f, err := coreunix.AddWrapped(n, strings.NewReader(name), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dir.AddNodeLink(name, f); err != nil {
+			t.Fatal(err)
+		}
+		if err := n.DAG.Add(ctx, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.DAG.Add(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	base := ts.URL + "/ipfs/" + dir.Cid().String() + "/"
+
+	for _, test := range []struct {
+		query string
+		names []string
+		next  string
+	}{
+		{"format=json", []string{"a", "b", "c"}, ""},
+		{"format=json&limit=2", []string{"a", "b"}, "b"},
+		{"format=json&limit=2&cursor=b", []string{"c"}, ""},
+		{"format=json&sort=-name&limit=1", []string{"c"}, "c"},
+		{"format=json&sort=-name&cursor=c", []string{"b", "a"}, ""},
+	} {
+		res, err := http.Get(base + "?" + test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var listing struct {
+			Entries []dirListingEntry
+			Next    string
+		}
+		err = json.NewDecoder(res.Body).Decode(&listing)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", test.query, err)
+		}
+
+		var names []string
+		for _, e := range listing.Entries {
+			names = append(names, e.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(test.names, ",") || listing.Next != test.next {
+			t.Errorf("%s: expected %v (next %q), got %v (next %q)", test.query, test.names, test.next, names, listing.Next)
+		}
+	}
+
+	res, err := http.Get(base + "?sort=owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected invalid sort to fail with 400, got %d", res.StatusCode)
+	}
+
+	// the query of directories with an index.html is left to the website
+	index := dag.NodeWithData(ft.FilePBData([]byte("<p>site</p>"), 11))
+	if err := n.DAG.Add(ctx, index); err != nil {
+		t.Fatal(err)
+	}
+	if err := dir.AddNodeLink("index.html", index); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.DAG.Add(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	site := ts.URL + "/ipfs/" + dir.Cid().String() + "/"
+	for _, query := range []string{"sort=owner", "limit=-1", "format=json"} {
+		res, err := http.Get(site + "?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK || string(body) != "<p>site</p>" {
+			t.Errorf("%s: expected the index.html, got %d: %s", query, res.StatusCode, body)
+		}
+	}
+}
+
+func TestWritableGateway(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := coreauth.NewStore(n.Repo.Datastore())
+	_, readToken, err := store.Create("reader", []coreauth.Scope{coreauth.ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, filesToken, err := store.Create("writer", []coreauth.Scope{coreauth.ScopeFiles})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	defer ts.Close()
+	dh.Handler, err = makeHandler(n, ts.Listener, GatewayOption(true, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(method, url, token, contentType string, body io.Reader) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+url, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	if res := write("POST", "/ipfs/", "", "", strings.NewReader("fnord")); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected anonymous write to fail with 401, got %d", res.StatusCode)
+	}
+	if res := write("POST", "/ipfs/", readToken, "", strings.NewReader("fnord")); res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected write with read token to fail with 403, got %d", res.StatusCode)
+	}
+
+	res := write("PUT", "/mfs/docs/a.txt", filesToken, "", strings.NewReader("fnord"))
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected MFS write to succeed, got %d", res.StatusCode)
+	}
+	fsn, err := mfs.Lookup(n.FilesRoot, "/docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := fsn.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash := res.Header.Get("IPFS-Hash"); hash != nd.Cid().String() {
+		t.Fatalf("expected IPFS-Hash %s, got %s", nd.Cid(), hash)
+	}
+
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		fw, err := mw.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(name))
+	}
+	mw.Close()
+
+	res = write("POST", "/ipfs/", filesToken, mw.FormDataContentType(), body)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected multipart upload to succeed, got %d", res.StatusCode)
+	}
+	res, err = http.Get(ts.URL + "/ipfs/" + res.Header.Get("IPFS-Hash") + "/sub/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "sub/b.txt" {
+		t.Fatalf("unexpected content of uploaded file: %q", b)
+	}
+}
@@ -0,0 +1,401 @@
+package corehttp
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	gopath "path"
+	"strings"
+
+	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
+	caopts "github.com/ipfs/go-ipfs/core/coreapi/interface/options"
+	coreauth "github.com/ipfs/go-ipfs/core/coreauth"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	mfs "github.com/ipfs/go-ipfs/mfs"
+	namesys "github.com/ipfs/go-ipfs/namesys"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+
+	dshelp "gx/ipfs/QmTmqJGRQfuH8eKWD1FjThwPRipt1QhqJQNZ8MpzmfAAxo/go-ipfs-ds-help"
+	dhtpb "gx/ipfs/QmUpttFinNDmNPgFwKN8sZK6BUtBmA68Y4KdSBDXa8t9sJ/go-libp2p-record/pb"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+	ipld "gx/ipfs/Qme5bWv7wtjUNGsK2BNGVUFPKiuxWrsqrtvYwCLRw8YFES/go-ipld-format"
+)
+
+const mfsPathPrefix = "/mfs/"
+
+// statusError is an error caused by the request, reported to the client
+// with the given status code.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e statusError) Error() string {
+	return e.msg
+}
+
+func statusErrorf(code int, format string, args ...interface{}) error {
+	return statusError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+func writeWebError(w http.ResponseWriter, message string, err error) {
+	if se, ok := err.(statusError); ok {
+		webErrorWithCode(w, message, err, se.code)
+		return
+	}
+	webError(w, message, err, http.StatusInternalServerError)
+}
+
+// writeHandler handles POST, PUT and DELETE requests to the writable gateway.
+// Requests must carry an API token: writes to /ipfs need the files or pin
+// scope, writes to /mfs the files scope and writes to /ipns the name scope.
+func (i *gatewayHandler) writeHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, mfsPathPrefix):
+		if i.authorizeWrite(w, r, coreauth.ScopeFiles) {
+			i.mfsWriteHandler(ctx, w, r)
+		}
+	case strings.HasPrefix(r.URL.Path, ipnsPathPrefix):
+		if i.authorizeWrite(w, r, coreauth.ScopeName) {
+			i.ipnsWriteHandler(ctx, w, r)
+		}
+	default:
+		if !i.authorizeWrite(w, r, coreauth.ScopeFiles, coreauth.ScopePin) {
+			return
+		}
+		switch r.Method {
+		case "POST":
+			if isMultipartUpload(r) {
+				i.postDirectoryHandler(ctx, w, r)
+			} else {
+				i.postHandler(ctx, w, r)
+			}
+		case "PUT":
+			i.putHandler(w, r)
+		case "DELETE":
+			i.deleteHandler(w, r)
+		}
+	}
+}
+
+// authorizeWrite checks that the request carries a token granting one of
+// scopes. It writes an error response and returns false otherwise.
+func (i *gatewayHandler) authorizeWrite(w http.ResponseWriter, r *http.Request, scopes ...coreauth.Scope) bool {
+	t, err := tokenFromRequest(i.tokens, r)
+	if err != nil {
+		unauthorized(w, err)
+		return false
+	}
+	for _, s := range scopes {
+		if t.HasScope(s) {
+			return true
+		}
+	}
+	log.Infof("API token %s (%s) denied %s %s", t.ID, t.Name, r.Method, r.URL.Path)
+	http.Error(w, "token is not allowed to write to "+r.URL.Path, http.StatusForbidden)
+	return false
+}
+
+func isMultipartUpload(r *http.Request) bool {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && strings.HasPrefix(mt, "multipart/")
+}
+
+// postDirectoryHandler adds the files of a multipart upload as a new
+// directory.
+func (i *gatewayHandler) postDirectoryHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	root, err := mfs.NewRoot(ctx, i.node.DAG, ft.EmptyDirNode(), nil)
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+
+	nd, err := i.writeMFS(root, r, "/")
+	if err != nil {
+		writeWebError(w, "postHandler: could not add directory", err)
+		return
+	}
+	if err := i.node.DAG.Add(ctx, nd); err != nil {
+		internalWebError(w, err)
+		return
+	}
+
+	i.addUserHeaders(w) // ok, _now_ write user's headers.
+	w.Header().Set("IPFS-Hash", nd.Cid().String())
+	http.Redirect(w, r, ipfsPathPrefix+nd.Cid().String(), http.StatusCreated)
+}
+
+// mfsWriteHandler writes to the node's MFS: /mfs/<path>.
+func (i *gatewayHandler) mfsWriteHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	p := gopath.Clean("/" + strings.TrimPrefix(r.URL.Path, mfsPathPrefix))
+
+	nd, err := i.writeMFS(i.node.FilesRoot, r, p)
+	if err != nil {
+		writeWebError(w, "mfs: could not write "+p, err)
+		return
+	}
+
+	i.addUserHeaders(w) // ok, _now_ write user's headers.
+	w.Header().Set("IPFS-Hash", nd.Cid().String())
+	http.Redirect(w, r, ipfsPathPrefix+nd.Cid().String(), http.StatusCreated)
+}
+
+// ipnsWriteHandler modifies the directory an IPNS name owned by this node
+// points to, /ipns/<name>/<path>, and republishes the name.
+func (i *gatewayHandler) ipnsWriteHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	name, p := strings.TrimPrefix(r.URL.Path, ipnsPathPrefix), "/"
+	if idx := strings.IndexByte(name, '/'); idx >= 0 {
+		name, p = name[:idx], gopath.Clean(name[idx:])
+	}
+
+	keyName, err := i.ownedKey(ctx, name)
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+	if keyName == "" {
+		webErrorWithCode(w, "ipns: cannot publish "+name, fmt.Errorf("name is not owned by this node"), http.StatusForbidden)
+		return
+	}
+
+	// an unpublished name starts out as an empty directory
+	var rootnd ipld.Node = ft.EmptyDirNode()
+	cur, err := i.api.Name().Resolve(ctx, name)
+	switch err {
+	case nil:
+		rootnd, err = i.api.ResolveNode(ctx, cur)
+		if err != nil {
+			webError(w, "ipns: could not resolve "+cur.String(), err, http.StatusInternalServerError)
+			return
+		}
+	case namesys.ErrResolveFailed:
+	default:
+		webError(w, "ipns: could not resolve "+name, err, http.StatusInternalServerError)
+		return
+	}
+
+	pbnd, ok := rootnd.(*dag.ProtoNode)
+	if !ok {
+		webError(w, "Cannot read non protobuf nodes through gateway", dag.ErrNotProtobuf, http.StatusBadRequest)
+		return
+	}
+	root, err := mfs.NewRoot(ctx, i.node.DAG, pbnd, nil)
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+	if _, ok := root.GetValue().(*mfs.Directory); !ok {
+		webErrorWithCode(w, "ipns: cannot write to "+name, fmt.Errorf("name does not point to a directory"), http.StatusConflict)
+		return
+	}
+
+	nd, err := i.writeMFS(root, r, p)
+	if err != nil {
+		writeWebError(w, "ipns: could not write "+p, err)
+		return
+	}
+
+	rootnd, err = root.GetValue().GetNode()
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+	if err := i.node.DAG.Add(ctx, rootnd); err != nil {
+		internalWebError(w, err)
+		return
+	}
+
+	entry, err := i.api.Name().Publish(ctx, coreapi.ParseCid(rootnd.Cid()), caopts.Name.Key(keyName))
+	if err != nil {
+		webError(w, "ipns: could not publish "+name, err, http.StatusInternalServerError)
+		return
+	}
+
+	i.addUserHeaders(w) // ok, _now_ write user's headers.
+	w.Header().Set("IPFS-Hash", nd.Cid().String())
+	w.Header().Set("IPNS-Name", entry.Name())
+	w.Header().Set("IPNS-Value", entry.Value().String())
+	if rec, err := i.ipnsRecord(entry.Name()); err != nil {
+		log.Warningf("could not load the IPNS record of %s: %s", entry.Name(), err)
+	} else {
+		w.Header().Set("IPNS-Record", base64.StdEncoding.EncodeToString(rec))
+	}
+	http.Redirect(w, r, ipfsPathPrefix+nd.Cid().String(), http.StatusCreated)
+}
+
+// ownedKey returns the name of the key that name is the peer ID of, or "" if
+// no such key is in the keystore.
+func (i *gatewayHandler) ownedKey(ctx context.Context, name string) (string, error) {
+	keys, err := i.api.Key().List(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, k := range keys {
+		if k.Path().String() == ipnsPathPrefix+name {
+			return k.Name(), nil
+		}
+	}
+	return "", nil
+}
+
+// ipnsRecord returns the serialized IPNS record of name stored by the last
+// publish.
+func (i *gatewayHandler) ipnsRecord(name string) ([]byte, error) {
+	pid, err := peer.IDB58Decode(name)
+	if err != nil {
+		return nil, err
+	}
+	_, ipnskey := namesys.IpnsKeysForID(pid)
+
+	val, err := i.node.Repo.Datastore().Get(dshelp.NewKeyFromBinary([]byte(ipnskey)))
+	if err != nil {
+		return nil, err
+	}
+	b, ok := val.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type returned from datastore: %#v", val)
+	}
+	rec := new(dhtpb.Record)
+	if err := proto.Unmarshal(b, rec); err != nil {
+		return nil, err
+	}
+	return rec.GetValue(), nil
+}
+
+// writeMFS applies a write request to p in root and returns the written
+// node. PUT and plain POST requests store the body as a file, multipart POST
+// requests add each file part below the directory p, and DELETE requests
+// remove p, returning its parent directory.
+func (i *gatewayHandler) writeMFS(root *mfs.Root, r *http.Request, p string) (ipld.Node, error) {
+	switch {
+	case r.Method == "DELETE":
+		if p == "/" {
+			return nil, statusErrorf(http.StatusBadRequest, "cannot delete the root directory")
+		}
+		dirname, name := gopath.Split(p)
+		pdir, err := lookupMFSDir(root, dirname)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := pdir.Child(name); err != nil {
+			return nil, statusErrorf(http.StatusNotFound, "%s does not exist", p)
+		}
+		if err := pdir.Unlink(name); err != nil {
+			return nil, err
+		}
+		if err := pdir.Flush(); err != nil {
+			return nil, err
+		}
+		return pdir.GetNode()
+
+	case r.Method == "POST" && isMultipartUpload(r):
+		if err := mfs.Mkdir(root, p, mfs.MkdirOpts{Mkparents: true}); err != nil {
+			return nil, statusErrorf(http.StatusConflict, "%s", err)
+		}
+		dir, err := lookupMFSDir(root, p)
+		if err != nil {
+			return nil, err
+		}
+
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, statusErrorf(http.StatusBadRequest, "%s", err)
+		}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, statusErrorf(http.StatusBadRequest, "%s", err)
+			}
+
+			rel := partFileName(part.Header.Get("Content-Disposition"))
+			if rel == "" {
+				// not a file, e.g. a regular form field
+				continue
+			}
+			target := gopath.Join(p, gopath.Clean("/"+rel))
+
+			if part.Header.Get("Content-Type") == "application/x-directory" {
+				err = mfs.Mkdir(root, target, mfs.MkdirOpts{Mkparents: true})
+			} else {
+				var nd ipld.Node
+				nd, err = i.newDagFromReader(part)
+				if err == nil {
+					err = putMFSNode(root, target, nd)
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if err := dir.Flush(); err != nil {
+			return nil, err
+		}
+		return dir.GetNode()
+
+	default:
+		if p == "/" {
+			return nil, statusErrorf(http.StatusBadRequest, "a file name is required")
+		}
+		nd, err := i.newDagFromReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		if err := putMFSNode(root, p, nd); err != nil {
+			return nil, err
+		}
+		return nd, nil
+	}
+}
+
+// partFileName returns the file name, which may contain slashes for files
+// in subdirectories, of a multipart part.
+func partFileName(disposition string) string {
+	_, params, err := mime.ParseMediaType(disposition)
+	if err != nil {
+		return ""
+	}
+	return params["filename"]
+}
+
+func lookupMFSDir(root *mfs.Root, p string) (*mfs.Directory, error) {
+	fsn, err := mfs.Lookup(root, p)
+	if err != nil {
+		return nil, statusErrorf(http.StatusNotFound, "%s does not exist", p)
+	}
+	dir, ok := fsn.(*mfs.Directory)
+	if !ok {
+		return nil, statusErrorf(http.StatusConflict, "%s is not a directory", p)
+	}
+	return dir, nil
+}
+
+// putMFSNode stores nd at p, creating missing parent directories and
+// replacing an existing entry.
+func putMFSNode(root *mfs.Root, p string, nd ipld.Node) error {
+	dirname, name := gopath.Split(p)
+	if err := mfs.Mkdir(root, dirname, mfs.MkdirOpts{Mkparents: true}); err != nil {
+		return statusErrorf(http.StatusConflict, "%s", err)
+	}
+	dir, err := lookupMFSDir(root, dirname)
+	if err != nil {
+		return err
+	}
+
+	if _, err := dir.Child(name); err == nil {
+		if err := dir.Unlink(name); err != nil {
+			return err
+		}
+	}
+	if err := dir.AddChild(name, nd); err != nil {
+		return err
+	}
+	return dir.Flush()
+}
@@ -0,0 +1,272 @@
+package corehttp
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	core "github.com/ipfs/go-ipfs/core"
+	config "github.com/ipfs/go-ipfs/repo/config"
+)
+
+// idleClientTimeout is how long a client's limiter state is kept around after
+// its last request.
+const idleClientTimeout = 5 * time.Minute
+
+// RateLimitOption enforces the given per-client limits on every request
+// handled by the options that follow it. Clients are identified by the IP
+// address of the remote end of the connection.
+//
+// Requests over the rate or concurrency limits are answered with
+// 429 Too Many Requests and a Retry-After header. Responses are throttled to
+// the configured bandwidth instead of being rejected.
+func RateLimitOption(rl config.RateLimit) ServeOption {
+	return func(_ *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		childMux := http.NewServeMux()
+		if !rl.Enabled() {
+			mux.Handle("/", childMux)
+			return childMux, nil
+		}
+
+		limiter := newRateLimiter(rl, time.Now)
+		mux.Handle("/", limiter.wrap(childMux))
+		return childMux, nil
+	}
+}
+
+// tokenBucket is a classic token bucket. It is not safe for concurrent use.
+type tokenBucket struct {
+	rate   float64 // tokens added per second
+	burst  float64 // maximum number of tokens held
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// take removes n tokens if they are available. Otherwise it leaves the bucket
+// untouched and returns how long the caller should wait before retrying.
+func (b *tokenBucket) take(now time.Time, n float64) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= n {
+		b.tokens -= n
+		return true, 0
+	}
+	return false, b.delay(n - b.tokens)
+}
+
+// reserve unconditionally removes n tokens, possibly going into debt, and
+// returns how long the caller has to wait until the debt is paid off.
+func (b *tokenBucket) reserve(now time.Time, n float64) time.Duration {
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return b.delay(-b.tokens)
+}
+
+func (b *tokenBucket) delay(missing float64) time.Duration {
+	return time.Duration(missing / b.rate * float64(time.Second))
+}
+
+// clientLimiter holds the limiter state of a single client.
+type clientLimiter struct {
+	requests *tokenBucket
+	bytes    *tokenBucket
+	inflight int
+	lastSeen time.Time
+}
+
+type rateLimiter struct {
+	cfg config.RateLimit
+	now func() time.Time
+
+	lk        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+func newRateLimiter(cfg config.RateLimit, now func() time.Time) *rateLimiter {
+	return &rateLimiter{
+		cfg:       cfg,
+		now:       now,
+		clients:   make(map[string]*clientLimiter),
+		lastSweep: now(),
+	}
+}
+
+// client returns the limiter state for the given IP. Must be called with the
+// lock held.
+func (rl *rateLimiter) client(ip string, now time.Time) *clientLimiter {
+	if now.Sub(rl.lastSweep) > idleClientTimeout {
+		for k, c := range rl.clients {
+			if c.inflight == 0 && now.Sub(c.lastSeen) > idleClientTimeout {
+				delete(rl.clients, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	c, ok := rl.clients[ip]
+	if !ok {
+		c = &clientLimiter{}
+		if rl.cfg.RequestsPerSecond > 0 {
+			burst := float64(rl.cfg.RequestBurst)
+			if burst < 1 {
+				burst = math.Max(1, rl.cfg.RequestsPerSecond)
+			}
+			c.requests = newTokenBucket(rl.cfg.RequestsPerSecond, burst, now)
+		}
+		if rl.cfg.BytesPerSecond > 0 {
+			rate := float64(rl.cfg.BytesPerSecond)
+			c.bytes = newTokenBucket(rate, rate, now)
+		}
+		rl.clients[ip] = c
+	}
+	c.lastSeen = now
+	return c
+}
+
+// acquire registers a new request from ip. It returns false and the time the
+// client should wait if the request has to be rejected.
+func (rl *rateLimiter) acquire(ip string) (*clientLimiter, bool, time.Duration) {
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+
+	now := rl.now()
+	c := rl.client(ip, now)
+
+	if rl.cfg.MaxConcurrent > 0 && c.inflight >= rl.cfg.MaxConcurrent {
+		return nil, false, time.Second
+	}
+	if c.requests != nil {
+		if ok, wait := c.requests.take(now, 1); !ok {
+			return nil, false, wait
+		}
+	}
+	c.inflight++
+	return c, true, 0
+}
+
+func (rl *rateLimiter) release(c *clientLimiter) {
+	rl.lk.Lock()
+	c.inflight--
+	c.lastSeen = rl.now()
+	rl.lk.Unlock()
+}
+
+// throttle accounts n bytes sent to the client and returns how long the
+// writer has to pause to stay within the bandwidth limit.
+func (rl *rateLimiter) throttle(c *clientLimiter, n int) time.Duration {
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+	return c.bytes.reserve(rl.now(), float64(n))
+}
+
+func (rl *rateLimiter) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		c, ok, wait := rl.acquire(ip)
+		if !ok {
+			retry := int(math.Ceil(wait.Seconds()))
+			if retry < 1 {
+				retry = 1
+			}
+			log.Debugf("rate limiting request from %s to %s", ip, r.URL.Path)
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		defer rl.release(c)
+
+		if c.bytes != nil {
+			w = &throttledResponseWriter{
+				ResponseWriter: w,
+				ctx:            r.Context(),
+				limiter:        rl,
+				client:         c,
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// maxThrottledWrite bounds the size of a single write so that bandwidth is
+// shared fairly between concurrent responses to the same client.
+const maxThrottledWrite = 32 * 1024
+
+// throttledResponseWriter delays writes so that the body is sent no faster
+// than the client's bandwidth allowance. Delayed writes fail once the request
+// is canceled.
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	limiter *rateLimiter
+	client  *clientLimiter
+}
+
+func (w *throttledResponseWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxThrottledWrite {
+			chunk = chunk[:maxThrottledWrite]
+		}
+		if d := w.limiter.throttle(w.client, len(chunk)); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-w.ctx.Done():
+				t.Stop()
+				return written, w.ctx.Err()
+			}
+		}
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *throttledResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *throttledResponseWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
@@ -0,0 +1,133 @@
+package corehttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	config "github.com/ipfs/go-ipfs/repo/config"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func TestRateLimitRequests(t *testing.T) {
+	clk := &fakeClock{t: time.Now()}
+	rl := newRateLimiter(config.RateLimit{RequestsPerSecond: 1, RequestBurst: 2}, clk.now)
+	h := rl.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(addr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/ipfs/", nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := do("10.0.0.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, w.Code)
+		}
+	}
+
+	w := do("10.0.0.1:1235")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected Retry-After of 1, got %q", w.Header().Get("Retry-After"))
+	}
+
+	// other clients have their own allowance
+	if w := do("10.0.0.2:1234"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for other client, got %d", w.Code)
+	}
+
+	clk.t = clk.t.Add(time.Second)
+	if w := do("10.0.0.1:1234"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 after refill, got %d", w.Code)
+	}
+}
+
+func TestRateLimitConcurrency(t *testing.T) {
+	rl := newRateLimiter(config.RateLimit{MaxConcurrent: 1}, time.Now)
+
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+	h := rl.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(entered)
+			<-unblock
+		}
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+		close(done)
+	}()
+	<-entered
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/fast", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 while at concurrency limit, got %d", w.Code)
+	}
+
+	close(unblock)
+	<-done
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/fast", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 after slow request finished, got %d", w.Code)
+	}
+}
+
+func TestThrottledWriteCanceled(t *testing.T) {
+	rl := newRateLimiter(config.RateLimit{BytesPerSecond: 10}, time.Now)
+
+	var werr error
+	h := rl.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, werr = w.Write(make([]byte, 1000))
+	}))
+
+	// the write would take 99 seconds, but the request is gone
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("GET", "/ipfs/", nil).WithContext(ctx)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if werr != context.Canceled {
+		t.Fatalf("expected the write to be canceled, got %v", werr)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("write returned after %s", d)
+	}
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(100, 100, now)
+
+	if d := b.reserve(now, 100); d != 0 {
+		t.Fatalf("expected no delay within burst, got %s", d)
+	}
+	if d := b.reserve(now, 50); d != 500*time.Millisecond {
+		t.Fatalf("expected 500ms delay, got %s", d)
+	}
+	if d := b.reserve(now.Add(time.Second), 0); d != 0 {
+		t.Fatalf("expected debt to be paid off, got %s", d)
+	}
+}
@@ -0,0 +1,819 @@
+package corehttp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	gopath "path"
+	"strconv"
+	"strings"
+	"time"
+
+	core "github.com/ipfs/go-ipfs/core"
+	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
+	coreiface "github.com/ipfs/go-ipfs/core/coreapi/interface"
+	coreauth "github.com/ipfs/go-ipfs/core/coreauth"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	mfs "github.com/ipfs/go-ipfs/mfs"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+
+	ipld "gx/ipfs/Qme5bWv7wtjUNGsK2BNGVUFPKiuxWrsqrtvYwCLRw8YFES/go-ipld-format"
+)
+
+// WebDAVConfig configures the WebDAV share.
+type WebDAVConfig struct {
+	// ReadOnly refuses all requests modifying the share.
+	ReadOnly bool
+	// IPFSNamespace exposes an 'ipfs' collection at the root of the share
+	// which allows browsing any /ipfs/ path. It is always read-only and
+	// shadows an MFS entry of the same name.
+	IPFSNamespace bool
+}
+
+// WebDAVOption exposes the node's files (MFS) as a WebDAV share mounted at
+// prefix. If API authorization is enabled, clients have to present a token
+// with the files scope, or the read scope for read-only access, as password
+// using basic authentication.
+func WebDAVOption(prefix string, cfg WebDAVConfig) ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		auth, err := newTokenAuthorizer(n)
+		if err != nil {
+			return nil, err
+		}
+
+		h := &webdavHandler{
+			node:   n,
+			api:    coreapi.NewCoreAPI(n),
+			prefix: strings.TrimRight(prefix, "/"),
+			config: cfg,
+			auth:   auth,
+		}
+		mux.Handle(h.prefix, h)
+		mux.Handle(h.prefix+"/", h)
+		return mux, nil
+	}
+}
+
+const (
+	davIPFSRoot = "/ipfs"
+
+	// davLockTimeout is the timeout reported for the advisory locks handed
+	// out to clients.
+	davLockTimeout = time.Hour
+)
+
+// davReadMethods are the methods that never modify the share.
+var davReadMethods = map[string]bool{
+	"OPTIONS":  true,
+	"GET":      true,
+	"HEAD":     true,
+	"PROPFIND": true,
+}
+
+type webdavHandler struct {
+	node   *core.IpfsNode
+	api    coreiface.CoreAPI
+	prefix string
+	config WebDAVConfig
+	auth   *tokenAuthorizer
+}
+
+// davResource describes a file or collection in the share.
+type davResource struct {
+	name string
+	dir  bool
+	size int64
+	cid  string
+
+	// immutable is set for resources in the /ipfs namespace.
+	immutable bool
+}
+
+func (h *webdavHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(h.node.Context())
+	defer cancel()
+
+	if h.auth != nil {
+		t, err := h.auth.authenticate(r)
+		if err != nil {
+			unauthorized(w, err)
+			return
+		}
+		if t != nil && !t.HasScope(coreauth.ScopeFiles) && !(davReadMethods[r.Method] && t.HasScope(coreauth.ScopeRead)) {
+			http.Error(w, "token does not grant access to files", http.StatusForbidden)
+			return
+		}
+	}
+
+	p, ok := h.sharePath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !davReadMethods[r.Method] {
+		if h.config.ReadOnly {
+			http.Error(w, "share is read-only", http.StatusForbidden)
+			return
+		}
+		// COPY only writes its destination, checked by moveOrCopy, so
+		// /ipfs content can be copied into the share
+		if h.isIPFS(p) && r.Method != "COPY" {
+			http.Error(w, "the /ipfs namespace is read-only", http.StatusForbidden)
+			return
+		}
+	}
+
+	var err error
+	switch r.Method {
+	case "OPTIONS":
+		h.options(w)
+	case "GET", "HEAD":
+		err = h.get(ctx, w, r, p)
+	case "PROPFIND":
+		err = h.propfind(ctx, w, r, p)
+	case "PUT":
+		err = h.put(w, r, p)
+	case "MKCOL":
+		err = h.mkcol(w, r, p)
+	case "DELETE":
+		err = h.delete(w, p)
+	case "MOVE", "COPY":
+		err = h.moveOrCopy(ctx, w, r, p, r.Method == "MOVE")
+	case "LOCK":
+		h.lock(w, r)
+	case "UNLOCK":
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", h.allowed())
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+
+	if err != nil {
+		davError(w, err)
+	}
+}
+
+// sharePath returns the path inside the share for the given URL path.
+func (h *webdavHandler) sharePath(urlPath string) (string, bool) {
+	if urlPath != h.prefix && !strings.HasPrefix(urlPath, h.prefix+"/") {
+		return "", false
+	}
+	return gopath.Clean("/" + urlPath[len(h.prefix):]), true
+}
+
+func (h *webdavHandler) href(p string, dir bool) string {
+	u := &url.URL{Path: gopath.Join(h.prefix, p)}
+	if dir && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u.EscapedPath()
+}
+
+func (h *webdavHandler) isIPFS(p string) bool {
+	return h.config.IPFSNamespace && (p == davIPFSRoot || strings.HasPrefix(p, davIPFSRoot+"/"))
+}
+
+func (h *webdavHandler) allowed() string {
+	if h.config.ReadOnly {
+		return "OPTIONS, GET, HEAD, PROPFIND"
+	}
+	return "OPTIONS, GET, HEAD, PROPFIND, PUT, MKCOL, DELETE, MOVE, COPY, LOCK, UNLOCK"
+}
+
+func (h *webdavHandler) options(w http.ResponseWriter) {
+	w.Header().Set("DAV", "1, 2")
+	w.Header().Set("Allow", h.allowed())
+	w.Header().Set("MS-Author-Via", "DAV")
+	w.WriteHeader(http.StatusOK)
+}
+
+// davStatusError carries the HTTP status to answer a failed request with.
+type davStatusError struct {
+	code int
+	msg  string
+}
+
+func (e davStatusError) Error() string {
+	return e.msg
+}
+
+func davErrorf(code int, format string, args ...interface{}) error {
+	return davStatusError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+func davError(w http.ResponseWriter, err error) {
+	switch err := err.(type) {
+	case davStatusError:
+		http.Error(w, err.msg, err.code)
+	default:
+		if err == os.ErrNotExist {
+			http.Error(w, "no such file or directory", http.StatusNotFound)
+			return
+		}
+		log.Warningf("webdav: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// stat describes the resource at p.
+func (h *webdavHandler) stat(ctx context.Context, p string) (*davResource, error) {
+	if h.isIPFS(p) {
+		return h.statIPFS(ctx, p)
+	}
+
+	fsn, err := mfs.Lookup(h.node.FilesRoot, p)
+	if err != nil {
+		return nil, err
+	}
+	return statMFS(gopath.Base(p), fsn)
+}
+
+func statMFS(name string, fsn mfs.FSNode) (*davResource, error) {
+	nd, err := fsn.GetNode()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &davResource{name: name, cid: nd.Cid().String()}
+	switch fsn := fsn.(type) {
+	case *mfs.Directory:
+		res.dir = true
+	case *mfs.File:
+		res.size, err = fsn.Size()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unexpected mfs node type %T", fsn)
+	}
+	return res, nil
+}
+
+func (h *webdavHandler) statIPFS(ctx context.Context, p string) (*davResource, error) {
+	if p == davIPFSRoot {
+		return &davResource{name: "ipfs", dir: true, immutable: true}, nil
+	}
+
+	pth, err := coreapi.ParsePath(p)
+	if err != nil {
+		return nil, davErrorf(http.StatusNotFound, "invalid ipfs path: %s", err)
+	}
+	nd, err := h.api.ResolveNode(ctx, pth)
+	if err != nil {
+		return nil, davErrorf(http.StatusNotFound, "%s", err)
+	}
+	return statIPLD(gopath.Base(p), nd)
+}
+
+func statIPLD(name string, nd ipld.Node) (*davResource, error) {
+	res := &davResource{name: name, cid: nd.Cid().String(), immutable: true}
+	switch nd := nd.(type) {
+	case *dag.ProtoNode:
+		fsn, err := ft.FSNodeFromBytes(nd.Data())
+		if err != nil {
+			return nil, err
+		}
+		switch fsn.Type {
+		case ft.TDirectory, ft.THAMTShard:
+			res.dir = true
+		default:
+			res.size = int64(fsn.FileSize())
+		}
+	case *dag.RawNode:
+		res.size = int64(len(nd.RawData()))
+	default:
+		return nil, davErrorf(http.StatusUnsupportedMediaType, "%s is not a unixfs node", nd.Cid())
+	}
+	return res, nil
+}
+
+// list returns the members of the collection at p.
+func (h *webdavHandler) list(ctx context.Context, p string) ([]*davResource, error) {
+	if h.isIPFS(p) {
+		if p == davIPFSRoot {
+			// the namespace can't be enumerated
+			return nil, nil
+		}
+		pth, err := coreapi.ParsePath(p)
+		if err != nil {
+			return nil, davErrorf(http.StatusNotFound, "invalid ipfs path: %s", err)
+		}
+		links, err := h.api.Unixfs().Ls(ctx, pth)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]*davResource, 0, len(links))
+		for _, l := range links {
+			nd, err := l.GetNode(ctx, h.node.DAG)
+			if err != nil {
+				return nil, err
+			}
+			res, err := statIPLD(l.Name, nd)
+			if err != nil {
+				continue
+			}
+			out = append(out, res)
+		}
+		return out, nil
+	}
+
+	fsn, err := mfs.Lookup(h.node.FilesRoot, p)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := fsn.(*mfs.Directory)
+	if !ok {
+		return nil, nil
+	}
+
+	var out []*davResource
+	if p == "/" && h.config.IPFSNamespace {
+		out = append(out, &davResource{name: "ipfs", dir: true, immutable: true})
+	}
+	err = dir.ForEachEntry(ctx, func(nl mfs.NodeListing) error {
+		if p == "/" && h.config.IPFSNamespace && nl.Name == "ipfs" {
+			return nil
+		}
+		out = append(out, &davResource{
+			name: nl.Name,
+			dir:  nl.Type == int(mfs.TDir),
+			size: nl.Size,
+			cid:  nl.Hash,
+		})
+		return nil
+	})
+	return out, err
+}
+
+func (h *webdavHandler) get(ctx context.Context, w http.ResponseWriter, r *http.Request, p string) error {
+	res, err := h.stat(ctx, p)
+	if err != nil {
+		return err
+	}
+	if res.dir {
+		return davErrorf(http.StatusMethodNotAllowed, "%s is a collection", p)
+	}
+
+	var content io.ReadSeeker
+	if h.isIPFS(p) {
+		pth, err := coreapi.ParsePath(p)
+		if err != nil {
+			return davErrorf(http.StatusNotFound, "invalid ipfs path: %s", err)
+		}
+		dr, err := h.api.Unixfs().Cat(ctx, pth)
+		if err != nil {
+			return err
+		}
+		defer dr.Close()
+		content = dr
+	} else {
+		fsn, err := mfs.Lookup(h.node.FilesRoot, p)
+		if err != nil {
+			return err
+		}
+		fi, ok := fsn.(*mfs.File)
+		if !ok {
+			return davErrorf(http.StatusMethodNotAllowed, "%s is a collection", p)
+		}
+		fd, err := fi.Open(mfs.OpenReadOnly, false)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+		content = fd
+	}
+
+	modtime := time.Time{}
+	if res.immutable {
+		modtime = time.Unix(1, 0)
+		w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
+	}
+	w.Header().Set("Etag", `"`+res.cid+`"`)
+	http.ServeContent(w, r, res.name, modtime, content)
+	return nil
+}
+
+func (h *webdavHandler) propfind(ctx context.Context, w http.ResponseWriter, r *http.Request, p string) error {
+	depth := r.Header.Get("Depth")
+	switch depth {
+	case "0", "1":
+	case "":
+		// clients omitting the header expect a directory listing
+		depth = "1"
+	case "infinity":
+		// RFC 4918 allows refusing infinite depth, which would have us walk
+		// the whole DAG.
+		return davErrorf(http.StatusForbidden, "propfind with infinite depth is not supported")
+	default:
+		return davErrorf(http.StatusBadRequest, "invalid depth: %q", depth)
+	}
+
+	res, err := h.stat(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	ms := &davMultistatus{XmlnsD: "DAV:"}
+	ms.add(h.href(p, res.dir), res)
+
+	if depth == "1" && res.dir {
+		children, err := h.list(ctx, p)
+		if err != nil {
+			return err
+		}
+		for _, c := range children {
+			ms.add(h.href(gopath.Join(p, c.name), c.dir), c)
+		}
+	}
+
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	return xml.NewEncoder(w).Encode(ms)
+}
+
+// parentDir returns the MFS directory containing p and the base name of p.
+func (h *webdavHandler) parentDir(p string) (*mfs.Directory, string, error) {
+	if p == "/" {
+		return nil, "", davErrorf(http.StatusForbidden, "cannot modify the root collection")
+	}
+	dirname, name := gopath.Split(p)
+	fsn, err := mfs.Lookup(h.node.FilesRoot, dirname)
+	if err != nil {
+		// RFC 4918 asks for 409 Conflict if intermediate collections are missing
+		return nil, "", davErrorf(http.StatusConflict, "parent collection %s does not exist", dirname)
+	}
+	dir, ok := fsn.(*mfs.Directory)
+	if !ok {
+		return nil, "", davErrorf(http.StatusConflict, "%s is not a collection", dirname)
+	}
+	return dir, name, nil
+}
+
+func (h *webdavHandler) put(w http.ResponseWriter, r *http.Request, p string) error {
+	pdir, name, err := h.parentDir(p)
+	if err != nil {
+		return err
+	}
+
+	created := false
+	fsn, err := pdir.Child(name)
+	switch err {
+	case nil:
+	case os.ErrNotExist:
+		nd := dag.NodeWithData(ft.FilePBData(nil, 0))
+		nd.SetPrefix(pdir.GetPrefix())
+		if err := pdir.AddChild(name, nd); err != nil {
+			return err
+		}
+		fsn, err = pdir.Child(name)
+		if err != nil {
+			return err
+		}
+		created = true
+	default:
+		return err
+	}
+
+	fi, ok := fsn.(*mfs.File)
+	if !ok {
+		return davErrorf(http.StatusMethodNotAllowed, "%s is a collection", p)
+	}
+
+	offset, partial, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		return davErrorf(http.StatusBadRequest, "%s", err)
+	}
+
+	fd, err := fi.Open(mfs.OpenWriteOnly, true)
+	if err != nil {
+		return err
+	}
+
+	if !partial {
+		err = fd.Truncate(0)
+	}
+	if err == nil {
+		_, err = fd.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		_, err = io.Copy(fd, r.Body)
+	}
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := pdir.Flush(); err != nil {
+		return err
+	}
+
+	if nd, err := fi.GetNode(); err == nil {
+		w.Header().Set("Etag", `"`+nd.Cid().String()+`"`)
+	}
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+	return nil
+}
+
+// parseContentRange parses the start offset of a partial PUT, as sent by
+// clients resuming uploads: "bytes <start>-<end>/<total>".
+func parseContentRange(hdr string) (int64, bool, error) {
+	if hdr == "" {
+		return 0, false, nil
+	}
+	if !strings.HasPrefix(hdr, "bytes ") {
+		return 0, false, fmt.Errorf("invalid Content-Range: %q", hdr)
+	}
+	spec := strings.TrimPrefix(hdr, "bytes ")
+	dash := strings.IndexByte(spec, '-')
+	if dash <= 0 {
+		return 0, false, fmt.Errorf("invalid Content-Range: %q", hdr)
+	}
+	start, err := strconv.ParseInt(spec[:dash], 10, 64)
+	if err != nil || start < 0 {
+		return 0, false, fmt.Errorf("invalid Content-Range: %q", hdr)
+	}
+	return start, true, nil
+}
+
+func (h *webdavHandler) mkcol(w http.ResponseWriter, r *http.Request, p string) error {
+	if r.ContentLength > 0 {
+		return davErrorf(http.StatusUnsupportedMediaType, "MKCOL with a body is not supported")
+	}
+
+	pdir, name, err := h.parentDir(p)
+	if err != nil {
+		return err
+	}
+	if _, err := pdir.Child(name); err == nil {
+		return davErrorf(http.StatusMethodNotAllowed, "%s already exists", p)
+	}
+
+	if _, err := pdir.Mkdir(name); err != nil {
+		return err
+	}
+	if err := pdir.Flush(); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func (h *webdavHandler) delete(w http.ResponseWriter, p string) error {
+	pdir, name, err := h.parentDir(p)
+	if err != nil {
+		return err
+	}
+	if _, err := pdir.Child(name); err != nil {
+		return err
+	}
+
+	if err := pdir.Unlink(name); err != nil {
+		return err
+	}
+	if err := pdir.Flush(); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *webdavHandler) moveOrCopy(ctx context.Context, w http.ResponseWriter, r *http.Request, src string, move bool) error {
+	dsturl, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || dsturl.Path == "" {
+		return davErrorf(http.StatusBadRequest, "invalid Destination header")
+	}
+	// RFC 4918 section 9.8.4 and 9.9.4: a destination on another server
+	// gets 502 Bad Gateway
+	if dsturl.Host != "" && !strings.EqualFold(dsturl.Host, r.Host) {
+		return davErrorf(http.StatusBadGateway, "destination is on another server")
+	}
+	if dsturl.Scheme != "" && !strings.EqualFold(dsturl.Scheme, requestScheme(r)) {
+		return davErrorf(http.StatusBadGateway, "destination is on another server")
+	}
+	dst, ok := h.sharePath(dsturl.Path)
+	if !ok {
+		return davErrorf(http.StatusBadGateway, "destination is outside of the share")
+	}
+	if h.isIPFS(dst) {
+		return davErrorf(http.StatusForbidden, "the /ipfs namespace is read-only")
+	}
+	if dst == src || strings.HasPrefix(dst, strings.TrimRight(src, "/")+"/") {
+		return davErrorf(http.StatusForbidden, "cannot %s a resource into itself", strings.ToLower(r.Method))
+	}
+	overwrite := r.Header.Get("Overwrite") != "F"
+
+	var nd ipld.Node
+	if h.isIPFS(src) {
+		pth, err := coreapi.ParsePath(src)
+		if err != nil {
+			return davErrorf(http.StatusNotFound, "invalid ipfs path: %s", err)
+		}
+		nd, err = h.api.ResolveNode(ctx, pth)
+		if err != nil {
+			return davErrorf(http.StatusNotFound, "%s", err)
+		}
+	} else {
+		fsn, err := mfs.Lookup(h.node.FilesRoot, src)
+		if err != nil {
+			return err
+		}
+		nd, err = fsn.GetNode()
+		if err != nil {
+			return err
+		}
+	}
+
+	dstDir, dstName, err := h.parentDir(dst)
+	if err != nil {
+		return err
+	}
+
+	var srcDir *mfs.Directory
+	var srcName string
+	if move {
+		srcDir, srcName, err = h.parentDir(src)
+		if err != nil {
+			return err
+		}
+	}
+
+	var prev ipld.Node
+	if fsn, err := dstDir.Child(dstName); err == nil {
+		if !overwrite {
+			return davErrorf(http.StatusPreconditionFailed, "%s already exists", dst)
+		}
+		prev, err = fsn.GetNode()
+		if err != nil {
+			return err
+		}
+	}
+	existed := prev != nil
+
+	// the destination entry is only given up once its replacement is in
+	// place; any later failure puts the previous node back
+	restore, err := replaceChild(dstDir, dstName, nd, prev)
+	if err != nil {
+		return err
+	}
+
+	if move {
+		if err := srcDir.Unlink(srcName); err != nil {
+			if rerr := restore(); rerr != nil {
+				log.Errorf("webdav: restoring %s: %s", dst, rerr)
+			}
+			return err
+		}
+		if err := srcDir.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if err := dstDir.Flush(); err != nil {
+		return err
+	}
+
+	if existed {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	return nil
+}
+
+// lock hands out advisory locks. They are not enforced; MFS serializes
+// concurrent writers itself. Supporting them keeps clients like Finder and
+// Windows Explorer from mounting the share read-only.
+func (h *webdavHandler) lock(w http.ResponseWriter, r *http.Request) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		davError(w, err)
+		return
+	}
+	token := "opaquelocktoken:" + hex.EncodeToString(buf)
+
+	w.Header().Set("Lock-Token", "<"+token+">")
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `%s<D:prop xmlns:D="DAV:"><D:lockdiscovery><D:activelock>`+
+		`<D:locktype><D:write/></D:locktype><D:lockscope><D:exclusive/></D:lockscope>`+
+		`<D:depth>infinity</D:depth><D:timeout>Second-%d</D:timeout>`+
+		`<D:locktoken><D:href>%s</D:href></D:locktoken>`+
+		`<D:lockroot><D:href>%s</D:href></D:lockroot>`+
+		`</D:activelock></D:lockdiscovery></D:prop>`,
+		xml.Header, int(davLockTimeout.Seconds()), token, (&url.URL{Path: r.URL.Path}).EscapedPath())
+}
+
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	XmlnsD    string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+type davResponse struct {
+	Href     string      `xml:"D:href"`
+	Propstat davPropstat `xml:"D:propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+type davProp struct {
+	DisplayName   string          `xml:"D:displayname"`
+	ResourceType  davResourceType `xml:"D:resourcetype"`
+	ContentLength *int64          `xml:"D:getcontentlength,omitempty"`
+	ContentType   string          `xml:"D:getcontenttype,omitempty"`
+	ETag          string          `xml:"D:getetag,omitempty"`
+	LastModified  string          `xml:"D:getlastmodified,omitempty"`
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection,omitempty"`
+}
+
+func (ms *davMultistatus) add(href string, res *davResource) {
+	prop := davProp{DisplayName: res.name}
+	if res.cid != "" {
+		prop.ETag = `"` + res.cid + `"`
+	}
+	if res.dir {
+		prop.ResourceType.Collection = &struct{}{}
+	} else {
+		size := res.size
+		prop.ContentLength = &size
+		prop.ContentType = mime.TypeByExtension(gopath.Ext(res.name))
+		if prop.ContentType == "" {
+			prop.ContentType = "application/octet-stream"
+		}
+	}
+	if res.immutable {
+		prop.LastModified = time.Unix(1, 0).UTC().Format(http.TimeFormat)
+	}
+
+	ms.Responses = append(ms.Responses, davResponse{
+		Href: href,
+		Propstat: davPropstat{
+			Prop:   prop,
+			Status: "HTTP/1.1 200 OK",
+		},
+	})
+}
+
+// replaceChild links nd as name in dir in place of prev, which may be nil. If
+// linking nd fails, prev is linked again. The returned function undoes the
+// replacement.
+func replaceChild(dir *mfs.Directory, name string, nd, prev ipld.Node) (func() error, error) {
+	relink := func() error {
+		if prev == nil {
+			return nil
+		}
+		return dir.AddChild(name, prev)
+	}
+	if prev != nil {
+		if err := dir.Unlink(name); err != nil {
+			return nil, err
+		}
+	}
+	if err := dir.AddChild(name, nd); err != nil {
+		if rerr := relink(); rerr != nil {
+			log.Errorf("webdav: restoring %s: %s", name, rerr)
+		}
+		return nil, err
+	}
+	return func() error {
+		if err := dir.Unlink(name); err != nil {
+			return err
+		}
+		return relink()
+	}, nil
+}
+
+// requestScheme returns the scheme the client used to reach the gateway.
+func requestScheme(r *http.Request) string {
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		return proto
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
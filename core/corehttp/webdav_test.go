@@ -0,0 +1,160 @@
+package corehttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	coreunix "github.com/ipfs/go-ipfs/core/coreunix"
+)
+
+func newWebDAVTestServer(t *testing.T, cfg WebDAVConfig) *httptest.Server {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+
+	dh.Handler, err = makeHandler(n, ts.Listener, WebDAVOption("/webdav", cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+func davDo(t *testing.T, method, url string, body string, hdrs map[string]string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range hdrs {
+		req.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func davExpect(t *testing.T, res *http.Response, code int) string {
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != code {
+		t.Fatalf("%s %s: expected status %d, got %d: %s", res.Request.Method, res.Request.URL, code, res.StatusCode, body)
+	}
+	return string(body)
+}
+
+func TestWebDAV(t *testing.T) {
+	ts := newWebDAVTestServer(t, WebDAVConfig{})
+	defer ts.Close()
+	base := ts.URL + "/webdav"
+
+	davExpect(t, davDo(t, "MKCOL", base+"/docs", "", nil), http.StatusCreated)
+	davExpect(t, davDo(t, "MKCOL", base+"/docs", "", nil), http.StatusMethodNotAllowed)
+	davExpect(t, davDo(t, "MKCOL", base+"/missing/child", "", nil), http.StatusConflict)
+
+	davExpect(t, davDo(t, "PUT", base+"/docs/a.txt", "hello world", nil), http.StatusCreated)
+	davExpect(t, davDo(t, "PUT", base+"/docs/a.txt", "hello", nil), http.StatusNoContent)
+	davExpect(t, davDo(t, "PUT", base+"/docs/a.txt", " there", map[string]string{"Content-Range": "bytes 5-10/11"}), http.StatusNoContent)
+
+	if body := davExpect(t, davDo(t, "GET", base+"/docs/a.txt", "", nil), http.StatusOK); body != "hello there" {
+		t.Fatalf("unexpected content: %q", body)
+	}
+	if body := davExpect(t, davDo(t, "GET", base+"/docs/a.txt", "", map[string]string{"Range": "bytes=6-"}), http.StatusPartialContent); body != "there" {
+		t.Fatalf("unexpected range content: %q", body)
+	}
+
+	body := davExpect(t, davDo(t, "PROPFIND", base+"/docs/", "", map[string]string{"Depth": "1"}), http.StatusMultiStatus)
+	if !strings.Contains(body, "<D:href>/webdav/docs/</D:href>") || !strings.Contains(body, "<D:collection></D:collection>") {
+		t.Fatalf("collection missing from propfind response: %s", body)
+	}
+	if !strings.Contains(body, "<D:href>/webdav/docs/a.txt</D:href>") || !strings.Contains(body, "<D:getcontentlength>11</D:getcontentlength>") {
+		t.Fatalf("file missing from propfind response: %s", body)
+	}
+	davExpect(t, davDo(t, "PROPFIND", base+"/docs/", "", map[string]string{"Depth": "infinity"}), http.StatusForbidden)
+
+	davExpect(t, davDo(t, "COPY", base+"/docs/a.txt", "", map[string]string{"Destination": "http://example.com/webdav/b.txt"}), http.StatusBadGateway)
+	davExpect(t, davDo(t, "MOVE", base+"/docs/a.txt", "", map[string]string{"Destination": strings.Replace(base, "http://", "https://", 1) + "/b.txt"}), http.StatusBadGateway)
+	davExpect(t, davDo(t, "GET", base+"/b.txt", "", nil), http.StatusNotFound)
+
+	davExpect(t, davDo(t, "COPY", base+"/docs/a.txt", "", map[string]string{"Destination": base + "/b.txt"}), http.StatusCreated)
+	davExpect(t, davDo(t, "MOVE", base+"/docs/a.txt", "", map[string]string{"Destination": base + "/b.txt", "Overwrite": "F"}), http.StatusPreconditionFailed)
+	davExpect(t, davDo(t, "MOVE", base+"/docs/a.txt", "", map[string]string{"Destination": base + "/b.txt"}), http.StatusNoContent)
+	davExpect(t, davDo(t, "GET", base+"/docs/a.txt", "", nil), http.StatusNotFound)
+	if body := davExpect(t, davDo(t, "GET", base+"/b.txt", "", nil), http.StatusOK); body != "hello there" {
+		t.Fatalf("unexpected content after move: %q", body)
+	}
+
+	davExpect(t, davDo(t, "DELETE", base+"/docs", "", nil), http.StatusNoContent)
+	davExpect(t, davDo(t, "PROPFIND", base+"/docs", "", map[string]string{"Depth": "0"}), http.StatusNotFound)
+	davExpect(t, davDo(t, "DELETE", base+"/", "", nil), http.StatusForbidden)
+}
+
+func TestWebDAVReadOnlyAndIPFSNamespace(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := coreunix.Add(n, strings.NewReader("fnord"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	defer ts.Close()
+	dh.Handler, err = makeHandler(n, ts.Listener, WebDAVOption("/webdav", WebDAVConfig{ReadOnly: true, IPFSNamespace: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := ts.URL + "/webdav"
+
+	davExpect(t, davDo(t, "MKCOL", base+"/docs", "", nil), http.StatusForbidden)
+	davExpect(t, davDo(t, "PUT", base+"/ipfs/"+k, "x", nil), http.StatusForbidden)
+
+	if body := davExpect(t, davDo(t, "GET", base+"/ipfs/"+k, "", nil), http.StatusOK); body != "fnord" {
+		t.Fatalf("unexpected content: %q", body)
+	}
+
+	body := davExpect(t, davDo(t, "PROPFIND", base+"/", "", map[string]string{"Depth": "1"}), http.StatusMultiStatus)
+	if !strings.Contains(body, "<D:href>/webdav/ipfs/</D:href>") {
+		t.Fatalf("ipfs namespace missing from root listing: %s", body)
+	}
+}
+
+func TestWebDAVCopyFromIPFS(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := coreunix.Add(n, strings.NewReader("fnord"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	defer ts.Close()
+	dh.Handler, err = makeHandler(n, ts.Listener, WebDAVOption("/webdav", WebDAVConfig{IPFSNamespace: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := ts.URL + "/webdav"
+
+	// /ipfs content can be copied into the share, but not moved or written
+	davExpect(t, davDo(t, "COPY", base+"/ipfs/"+k, "", map[string]string{"Destination": base + "/copied.txt"}), http.StatusCreated)
+	if body := davExpect(t, davDo(t, "GET", base+"/copied.txt", "", nil), http.StatusOK); body != "fnord" {
+		t.Fatalf("unexpected content after copy: %q", body)
+	}
+	davExpect(t, davDo(t, "MOVE", base+"/ipfs/"+k, "", map[string]string{"Destination": base + "/moved.txt"}), http.StatusForbidden)
+	davExpect(t, davDo(t, "COPY", base+"/copied.txt", "", map[string]string{"Destination": base + "/ipfs/" + k}), http.StatusForbidden)
+}
@@ -23,47 +23,84 @@ var ErrNoNamesys = errors.New(
 // entries (e.g. /ipns/<node-key>) and then going through the /ipfs/
 // entries and returning the final node.
 func Resolve(ctx context.Context, nsys namesys.NameSystem, r *resolver.Resolver, p path.Path) (ipld.Node, error) {
-	if strings.HasPrefix(p.String(), "/ipns/") {
-		evt := log.EventBegin(ctx, "resolveIpnsPath")
-		defer evt.Done()
-		// resolve ipns paths
-
-		// TODO(cryptix): we sould be able to query the local cache for the path
-		if nsys == nil {
-			evt.Append(logging.LoggableMap{"error": ErrNoNamesys.Error()})
-			return nil, ErrNoNamesys
-		}
+	p, err := resolveIpnsPath(ctx, nsys, p, false)
+	if err != nil {
+		return nil, err
+	}
 
-		seg := p.Segments()
+	// ok, we have an IPFS path now (or what we'll treat as one)
+	return r.ResolvePath(ctx, p)
+}
 
-		if len(seg) < 2 || seg[1] == "" { // just "/<protocol/>" without further segments
-			evt.Append(logging.LoggableMap{"error": path.ErrNoComponents.Error()})
-			return nil, path.ErrNoComponents
-		}
+// ResolveIpnsFirst resolves the name of an /ipns/ path with the first valid
+// answer of the name system, instead of waiting for the best one like
+// Resolve does. Other paths are returned as is.
+func ResolveIpnsFirst(ctx context.Context, nsys namesys.NameSystem, p path.Path) (path.Path, error) {
+	return resolveIpnsPath(ctx, nsys, p, true)
+}
 
-		extensions := seg[2:]
-		resolvable, err := path.FromSegments("/", seg[0], seg[1])
-		if err != nil {
-			evt.Append(logging.LoggableMap{"error": err.Error()})
-			return nil, err
-		}
+func resolveIpnsPath(ctx context.Context, nsys namesys.NameSystem, p path.Path, first bool) (path.Path, error) {
+	if !strings.HasPrefix(p.String(), "/ipns/") {
+		return p, nil
+	}
 
-		respath, err := nsys.Resolve(ctx, resolvable.String())
-		if err != nil {
-			evt.Append(logging.LoggableMap{"error": err.Error()})
-			return nil, err
-		}
+	evt := log.EventBegin(ctx, "resolveIpnsPath")
+	defer evt.Done()
+	// resolve ipns paths
 
-		segments := append(respath.Segments(), extensions...)
-		p, err = path.FromSegments("/", segments...)
-		if err != nil {
-			evt.Append(logging.LoggableMap{"error": err.Error()})
-			return nil, err
-		}
+	// TODO(cryptix): we sould be able to query the local cache for the path
+	if nsys == nil {
+		evt.Append(logging.LoggableMap{"error": ErrNoNamesys.Error()})
+		return "", ErrNoNamesys
 	}
 
-	// ok, we have an IPFS path now (or what we'll treat as one)
-	return r.ResolvePath(ctx, p)
+	seg := p.Segments()
+
+	if len(seg) < 2 || seg[1] == "" { // just "/<protocol/>" without further segments
+		evt.Append(logging.LoggableMap{"error": path.ErrNoComponents.Error()})
+		return "", path.ErrNoComponents
+	}
+
+	extensions := seg[2:]
+	resolvable, err := path.FromSegments("/", seg[0], seg[1])
+	if err != nil {
+		evt.Append(logging.LoggableMap{"error": err.Error()})
+		return "", err
+	}
+
+	var respath path.Path
+	if first {
+		respath, err = firstResult(ctx, nsys, resolvable.String())
+	} else {
+		respath, err = nsys.Resolve(ctx, resolvable.String())
+	}
+	if err != nil {
+		evt.Append(logging.LoggableMap{"error": err.Error()})
+		return "", err
+	}
+
+	segments := append(respath.Segments(), extensions...)
+	p, err = path.FromSegments("/", segments...)
+	if err != nil {
+		evt.Append(logging.LoggableMap{"error": err.Error()})
+		return "", err
+	}
+	return p, nil
+}
+
+// firstResult returns the first valid answer of the name system.
+func firstResult(ctx context.Context, nsys namesys.NameSystem, name string) (path.Path, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	err := namesys.ErrResolveFailed
+	for res := range nsys.ResolveAsync(ctx, name) {
+		if res.Err == nil {
+			return res.Path, nil
+		}
+		err = res.Err
+	}
+	return "", err
 }
 
 // ResolveToCid resolves a path to a cid.
@@ -22,6 +22,7 @@ import (
 	metrics "gx/ipfs/QmRg1gKTHzc3CZXSKzem8aR4E3TubFhbgXwfVuWnSK5CC5/go-metrics-interface"
 	process "gx/ipfs/QmSF8fPo3jgVBAy8fpdjjYqgG87dkJgUprRBHRd2tmfgpP/goprocess"
 	procctx "gx/ipfs/QmSF8fPo3jgVBAy8fpdjjYqgG87dkJgUprRBHRd2tmfgpP/goprocess/context"
+	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
 	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
 	blockstore "gx/ipfs/QmaG4DZ4JaqEfvPWt5nPPgoTzhc1tr1T3f4Nu9Jpdm8ymY/go-ipfs-blockstore"
 	cid "gx/ipfs/QmcZfnkapfECQGcLZaf9B79NRg7cRa9EnZh4LSbkCzwNvY/go-cid"
@@ -62,12 +63,62 @@ func init() {
 
 var rebroadcastDelay = delay.Fixed(time.Minute)
 
+type options struct {
+	strategy       decision.Strategy
+	sendRate       decision.SendRateFunc
+	ledgers        ds.Datastore
+	maxMessageSize int
+	filter         decision.PeerFilter
+}
+
+// Option configures a Bitswap instance.
+type Option func(*options)
+
+// WithStrategy sets the strategy deciding which peer is sent blocks next.
+func WithStrategy(s decision.Strategy) Option {
+	return func(o *options) {
+		o.strategy = s
+	}
+}
+
+// WithSendRate caps the rate at which blocks are sent to each peer.
+func WithSendRate(f decision.SendRateFunc) Option {
+	return func(o *options) {
+		o.sendRate = f
+	}
+}
+
+// WithMaxMessageSize sets the budget of the blocks packed into one message.
+// A block larger than the budget is sent alone.
+func WithMaxMessageSize(n int) Option {
+	return func(o *options) {
+		o.maxMessageSize = n
+	}
+}
+
+// WithPeerFilter restricts the peers blocks are exchanged with. Wants of
+// other peers are rejected and their blocks ignored, and our wants are not
+// sent to them.
+func WithPeerFilter(f decision.PeerFilter) Option {
+	return func(o *options) {
+		o.filter = f
+	}
+}
+
+// WithLedgerStore keeps the ledgers of peers in the datastore across
+// restarts.
+func WithLedgerStore(d ds.Datastore) Option {
+	return func(o *options) {
+		o.ledgers = d
+	}
+}
+
 // New initializes a BitSwap instance that communicates over the provided
 // BitSwapNetwork. This function registers the returned instance as the network
 // delegate.
 // Runs until context is cancelled.
 func New(parent context.Context, p peer.ID, network bsnet.BitSwapNetwork,
-	bstore blockstore.Blockstore, nice bool) exchange.Interface {
+	bstore blockstore.Blockstore, nice bool, opts ...Option) exchange.Interface {
 
 	// important to use provided parent context (since it may include important
 	// loggable data). It's probably not a good idea to allow bitswap to be
@@ -82,17 +133,36 @@ func New(parent context.Context, p peer.ID, network bsnet.BitSwapNetwork,
 		" data blocks recived").Histogram(metricsBuckets)
 	allHist := metrics.NewCtx(ctx, "recv_all_blocks_bytes", "Summary of all"+
 		" data blocks recived").Histogram(metricsBuckets)
+	rejWants := metrics.NewCtx(ctx, "rejected_wants_total", "Number of wants"+
+		" rejected from peers not allowed").Counter()
+	rejBlocks := metrics.NewCtx(ctx, "rejected_blocks_total", "Number of blocks"+
+		" ignored from peers not allowed").Counter()
+
+	o := options{maxMessageSize: decision.DefaultMaxMessageSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	engine := decision.NewEngine(ctx, bstore, o.ledgers) // TODO close the engine with Close() method
+	if o.strategy != nil {
+		engine.SetStrategy(o.strategy)
+	}
+	if o.sendRate != nil {
+		engine.SetSendRate(o.sendRate)
+	}
+	engine.SetMaxMessageSize(o.maxMessageSize)
+	engine.SetPeerFilter(o.filter)
 
 	notif := notifications.New()
 	px := process.WithTeardown(func() error {
 		notif.Shutdown()
-		return nil
+		return engine.SaveLedgers()
 	})
 
 	bs := &Bitswap{
 		blockstore:    bstore,
 		notifications: notif,
-		engine:        decision.NewEngine(ctx, bstore), // TODO close the engine with Close() method
+		engine:        engine,
 		network:       network,
 		findKeys:      make(chan *blockRequest, sizeBatchRequestChan),
 		process:       px,
@@ -100,10 +170,15 @@ func New(parent context.Context, p peer.ID, network bsnet.BitSwapNetwork,
 		provideKeys:   make(chan *cid.Cid, provideKeysBufferSize),
 		wm:            NewWantManager(ctx, network),
 		counters:      new(counters),
+		tracer:        newTracer(traceBufferSize),
 
-		dupMetric: dupHist,
-		allMetric: allHist,
+		dupMetric:       dupHist,
+		allMetric:       allHist,
+		rejWantsMetric:  rejWants,
+		rejBlocksMetric: rejBlocks,
 	}
+	bs.wm.maxMessageSize = o.maxMessageSize
+	bs.wm.trace = bs.tracer
 	go bs.wm.Run()
 	network.SetDelegate(bs)
 
@@ -157,13 +232,18 @@ type Bitswap struct {
 	counters  *counters
 
 	// Metrics interface metrics
-	dupMetric metrics.Histogram
-	allMetric metrics.Histogram
+	dupMetric       metrics.Histogram
+	allMetric       metrics.Histogram
+	rejWantsMetric  metrics.Counter
+	rejBlocksMetric metrics.Counter
 
 	// Sessions
 	sessions []*Session
 	sessLk   sync.Mutex
 
+	// tracer records what happens to blocks, for debugging
+	tracer *tracer
+
 	sessID   uint64
 	sessIDLk sync.Mutex
 }
@@ -176,6 +256,10 @@ type counters struct {
 	dataSent       uint64
 	dataRecvd      uint64
 	messagesRecvd  uint64
+	havesRecvd     uint64
+	dontHavesRecvd uint64
+	wantsRejected  uint64
+	blocksRejected uint64
 }
 
 type blockRequest struct {
@@ -201,6 +285,12 @@ func (bs *Bitswap) LedgerForPeer(p peer.ID) *decision.Receipt {
 	return bs.engine.LedgerForPeer(p)
 }
 
+// AllLedgers returns the ledgers of all peers, including the saved ledgers of
+// peers no longer connected.
+func (bs *Bitswap) AllLedgers() []*decision.Receipt {
+	return bs.engine.AllLedgers()
+}
+
 // GetBlocks returns a channel where the caller may receive blocks that
 // correspond to the provided |keys|. Returns an error if BitSwap is unable to
 // begin this request within the deadline enforced by the context.
@@ -362,10 +452,16 @@ func (bs *Bitswap) ReceiveMessage(ctx context.Context, p peer.ID, incoming bsmsg
 
 	// This call records changes to wantlists, blocks received,
 	// and number of bytes transfered.
-	bs.engine.MessageReceived(p, incoming)
+	if err := bs.engine.MessageReceived(p, incoming); err == decision.ErrPeerNotAllowed {
+		bs.rejectMessage(p, incoming)
+		return
+	}
 	// TODO: this is bad, and could be easily abused.
 	// Should only track *useful* messages in ledger
 
+	bs.sendPresences(ctx, p, incoming)
+	bs.receivePresences(p, incoming)
+
 	iblocks := incoming.Blocks()
 
 	if len(iblocks) == 0 {
@@ -378,7 +474,11 @@ func (bs *Bitswap) ReceiveMessage(ctx context.Context, p peer.ID, incoming bsmsg
 		go func(b blocks.Block) { // TODO: this probably doesnt need to be a goroutine...
 			defer wg.Done()
 
-			bs.updateReceiveCounters(b)
+			if bs.updateReceiveCounters(b) {
+				bs.tracer.record(TraceDuplicate, []*cid.Cid{b.Cid()}, []peer.ID{p}, 0, len(b.RawData()))
+			} else {
+				bs.tracer.record(TraceBlock, []*cid.Cid{b.Cid()}, []peer.ID{p}, 0, len(b.RawData()))
+			}
 
 			log.Debugf("got block %s from %s", b, p)
 
@@ -391,14 +491,91 @@ func (bs *Bitswap) ReceiveMessage(ctx context.Context, p peer.ID, incoming bsmsg
 	wg.Wait()
 }
 
+// rejectMessage accounts for a message of a peer we don't exchange blocks
+// with.
+func (bs *Bitswap) rejectMessage(p peer.ID, incoming bsmsg.BitSwapMessage) {
+	wants := 0
+	for _, e := range incoming.Wantlist() {
+		if !e.Cancel {
+			wants++
+		}
+	}
+	blks := len(incoming.Blocks())
+	if wants == 0 && blks == 0 {
+		return
+	}
+	log.Debugf("rejected %d wants and %d blocks from %s", wants, blks, p)
+
+	bs.rejWantsMetric.Add(float64(wants))
+	bs.rejBlocksMetric.Add(float64(blks))
+
+	bs.counterLk.Lock()
+	bs.counters.wantsRejected += uint64(wants)
+	bs.counters.blocksRejected += uint64(blks)
+	bs.counterLk.Unlock()
+}
+
+// sendPresences answers the want-have entries of a message, and tells the
+// peer which of the blocks it asked to be told about we don't have.
+func (bs *Bitswap) sendPresences(ctx context.Context, p peer.ID, incoming bsmsg.BitSwapMessage) {
+	var haves, dontHaves []*cid.Cid
+	for _, e := range incoming.Wantlist() {
+		if e.Cancel || (e.WantType != bsmsg.WantHave && !e.SendDontHave) {
+			continue
+		}
+
+		has, err := bs.blockstore.Has(e.Cid)
+		if err != nil {
+			log.Infof("blockstore.Has error: %s", err)
+			continue
+		}
+		switch {
+		case has && e.WantType == bsmsg.WantHave:
+			haves = append(haves, e.Cid)
+		case !has && e.SendDontHave:
+			dontHaves = append(dontHaves, e.Cid)
+		}
+	}
+
+	if len(haves) > 0 || len(dontHaves) > 0 {
+		bs.wm.SendPresences(ctx, p, haves, dontHaves)
+	}
+}
+
+// receivePresences passes the HAVE and DONT_HAVE answers of a peer to the
+// sessions waiting for the blocks.
+func (bs *Bitswap) receivePresences(from peer.ID, incoming bsmsg.BitSwapMessage) {
+	haves, dontHaves := incoming.Haves(), incoming.DontHaves()
+	bs.tracer.record(TraceHave, haves, []peer.ID{from}, 0, 0)
+	bs.tracer.record(TraceDontHave, dontHaves, []peer.ID{from}, 0, 0)
+
+	bs.counterLk.Lock()
+	bs.counters.havesRecvd += uint64(len(haves))
+	bs.counters.dontHavesRecvd += uint64(len(dontHaves))
+	bs.counterLk.Unlock()
+
+	for _, c := range haves {
+		for _, s := range bs.SessionsForBlock(c) {
+			s.receivePresence(from, c, true)
+		}
+	}
+	for _, c := range dontHaves {
+		for _, s := range bs.SessionsForBlock(c) {
+			s.receivePresence(from, c, false)
+		}
+	}
+}
+
 var ErrAlreadyHaveBlock = errors.New("already have block")
 
-func (bs *Bitswap) updateReceiveCounters(b blocks.Block) {
+// updateReceiveCounters accounts for a received block, and returns whether
+// it is a duplicate.
+func (bs *Bitswap) updateReceiveCounters(b blocks.Block) bool {
 	blkLen := len(b.RawData())
 	has, err := bs.blockstore.Has(b.Cid())
 	if err != nil {
 		log.Infof("blockstore.Has error: %s", err)
-		return
+		return false
 	}
 
 	bs.allMetric.Observe(float64(blkLen))
@@ -416,16 +593,23 @@ func (bs *Bitswap) updateReceiveCounters(b blocks.Block) {
 		c.dupBlocksRecvd++
 		c.dupDataRecvd += uint64(blkLen)
 	}
+	return has
 }
 
 // Connected/Disconnected warns bitswap about peer connections
 func (bs *Bitswap) PeerConnected(p peer.ID) {
+	if !bs.engine.Allowed(p) {
+		return
+	}
 	bs.wm.Connected(p)
 	bs.engine.PeerConnected(p)
 }
 
 // Connected/Disconnected warns bitswap about peer connections
 func (bs *Bitswap) PeerDisconnected(p peer.ID) {
+	if !bs.engine.Allowed(p) {
+		return
+	}
 	bs.wm.Disconnected(p)
 	bs.engine.PeerDisconnected(p)
 }
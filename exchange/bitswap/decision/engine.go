@@ -10,6 +10,7 @@ import (
 	wl "github.com/ipfs/go-ipfs/exchange/bitswap/wantlist"
 
 	logging "gx/ipfs/QmRb5jh8z2E8hMGN2tkvs1yHynUanqnZ3UeKwgN1i9P1F8/go-log"
+	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
 	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
 	bstore "gx/ipfs/QmaG4DZ4JaqEfvPWt5nPPgoTzhc1tr1T3f4Nu9Jpdm8ymY/go-ipfs-blockstore"
 	blocks "gx/ipfs/Qmej7nf81hi2x2tvjRBF3mcp74sQyuDH4VMYDGd1YtXjb2/go-block-format"
@@ -54,13 +55,17 @@ const (
 	outboxChanBuffer = 0
 )
 
+// DefaultMaxMessageSize is the default budget of the blocks packed into one
+// envelope. A block larger than the budget is sent alone.
+const DefaultMaxMessageSize = 512 << 10
+
 // Envelope contains a message for a Peer
 type Envelope struct {
 	// Peer is the intended recipient
 	Peer peer.ID
 
-	// Block is the payload
-	Block blocks.Block
+	// Blocks is the payload, in the order they were requested
+	Blocks []blocks.Block
 
 	// A callback to notify the decision queue that the task is complete
 	Sent func()
@@ -85,26 +90,90 @@ type Engine struct {
 
 	bs bstore.Blockstore
 
+	// maxMessageSize is the budget of the blocks packed into an envelope
+	maxMessageSize int
+
+	// unsent is a task which did not fit in its envelope and was put back
+	// in the queue, and unsentBlock its block. They are owned by the
+	// taskWorker goroutine.
+	unsent      *peerRequestTask
+	unsentBlock blocks.Block
+
+	// ledgerStore keeps the ledgers across restarts, may be nil
+	ledgerStore ds.Datastore
+
+	// filter rejects the peers blocks may not be exchanged with, nil allows
+	// all peers
+	filter PeerFilter
+
 	lock sync.Mutex // protects the fields immediatly below
 	// ledgerMap lists Ledgers by their Partner key.
 	ledgerMap map[peer.ID]*ledger
+	// savedLedgers lists the last saved state of the ledgers
+	savedLedgers map[peer.ID]ledgerRecord
 
 	ticker *time.Ticker
 }
 
-func NewEngine(ctx context.Context, bs bstore.Blockstore) *Engine {
+// NewEngine creates an engine serving blocks from bs. If lstore is not nil,
+// the ledgers saved in it are reloaded, and the ledgers of the peers blocks
+// were exchanged with are saved to it periodically and when their peer
+// disconnects. Saved ledgers expire after ledgerMaxAge, and at most
+// maxSavedLedgers are kept.
+func NewEngine(ctx context.Context, bs bstore.Blockstore, lstore ds.Datastore) *Engine {
 	e := &Engine{
 		ledgerMap:        make(map[peer.ID]*ledger),
+		savedLedgers:     make(map[peer.ID]ledgerRecord),
+		ledgerStore:      lstore,
 		bs:               bs,
+		maxMessageSize:   DefaultMaxMessageSize,
 		peerRequestQueue: newPRQ(),
 		outbox:           make(chan (<-chan *Envelope), outboxChanBuffer),
 		workSignal:       make(chan struct{}, 1),
 		ticker:           time.NewTicker(time.Millisecond * 100),
 	}
+	if lstore != nil {
+		saved, err := loadLedgers(lstore)
+		if err != nil {
+			log.Errorf("loading ledgers: %s", err)
+		} else {
+			e.savedLedgers = saved
+		}
+		go e.ledgerSaver(ctx)
+	}
 	go e.taskWorker(ctx)
 	return e
 }
 
+// SetStrategy sets the strategy deciding which partner is served next. The
+// engine uses FairStrategy by default.
+func (e *Engine) SetStrategy(s Strategy) {
+	e.peerRequestQueue.setStrategy(s)
+}
+
+// SetSendRate caps the rate at which blocks are sent to each partner. By
+// default the rate is not limited.
+func (e *Engine) SetSendRate(f SendRateFunc) {
+	e.peerRequestQueue.setSendRate(f)
+}
+
+// SetMaxMessageSize sets the budget of the blocks packed into one envelope.
+// It must be called before the engine is used.
+func (e *Engine) SetMaxMessageSize(n int) {
+	e.maxMessageSize = n
+}
+
+// SetPeerFilter restricts the peers the engine exchanges blocks with. It must
+// be called before the engine is used.
+func (e *Engine) SetPeerFilter(f PeerFilter) {
+	e.filter = f
+}
+
+// Allowed returns whether blocks may be exchanged with the peer.
+func (e *Engine) Allowed(p peer.ID) bool {
+	return e.filter == nil || e.filter(p)
+}
+
 func (e *Engine) WantlistForPeer(p peer.ID) (out []*wl.Entry) {
 	partner := e.findOrCreate(p)
 	partner.lk.Lock()
@@ -119,11 +188,15 @@ func (e *Engine) LedgerForPeer(p peer.ID) *Receipt {
 	defer ledger.lk.Unlock()
 
 	return &Receipt{
-		Peer:      ledger.Partner.String(),
-		Value:     ledger.Accounting.Value(),
-		Sent:      ledger.Accounting.BytesSent,
-		Recv:      ledger.Accounting.BytesRecv,
-		Exchanged: ledger.ExchangeCount(),
+		Peer:         ledger.Partner.String(),
+		Value:        ledger.Accounting.Value(),
+		Sent:         ledger.Accounting.BytesSent,
+		Recv:         ledger.Accounting.BytesRecv,
+		Exchanged:    ledger.ExchangeCount(),
+		LastExchange: ledger.lastExchange,
+		FirstSeen:    ledger.firstSeen,
+		LastSeen:     ledger.lastSeen,
+		Connected:    ledger.ref > 0,
 	}
 }
 
@@ -167,7 +240,7 @@ func (e *Engine) nextEnvelope(ctx context.Context) (*Envelope, error) {
 
 		// with a task in hand, we're ready to prepare the envelope...
 
-		block, err := e.bs.Get(nextTask.Entry.Cid)
+		block, err := e.taskBlock(nextTask)
 		if err != nil {
 			log.Errorf("tried to execute a task and errored fetching block: %s", err)
 			// If we don't have the block, don't hold that against the peer
@@ -176,11 +249,14 @@ func (e *Engine) nextEnvelope(ctx context.Context) (*Envelope, error) {
 			continue
 		}
 
+		tasks, blks := e.packTasks(nextTask, block)
 		return &Envelope{
-			Peer:  nextTask.Target,
-			Block: block,
+			Peer:   nextTask.Target,
+			Blocks: blks,
 			Sent: func() {
-				nextTask.Done()
+				for _, t := range tasks {
+					t.Done()
+				}
 				select {
 				case e.workSignal <- struct{}{}:
 					// work completing may mean that our queue will provide new
@@ -192,6 +268,54 @@ func (e *Engine) nextEnvelope(ctx context.Context) (*Envelope, error) {
 	}
 }
 
+// packTasks adds the following tasks of the same peer to the first one, as
+// long as their blocks fit in the message budget.
+func (e *Engine) packTasks(first *peerRequestTask, block blocks.Block) ([]*peerRequestTask, []blocks.Block) {
+	tasks := []*peerRequestTask{first}
+	blks := []blocks.Block{block}
+	size := len(block.RawData())
+	// blocks are charged to the send rate of the peer as they are packed,
+	// so that no more blocks are packed once the peer is throttled
+	e.peerRequestQueue.blockSent(first.Target, size)
+
+	for size < e.maxMessageSize {
+		task := e.peerRequestQueue.popFor(first.Target)
+		if task == nil {
+			break
+		}
+
+		blk, err := e.taskBlock(task)
+		if err != nil {
+			log.Errorf("tried to execute a task and errored fetching block: %s", err)
+			task.Done()
+			continue
+		}
+		if size+len(blk.RawData()) > e.maxMessageSize {
+			// keep it with its block for the next envelope
+			e.peerRequestQueue.requeue(task)
+			e.unsent, e.unsentBlock = task, blk
+			break
+		}
+
+		tasks = append(tasks, task)
+		blks = append(blks, blk)
+		size += len(blk.RawData())
+		e.peerRequestQueue.blockSent(first.Target, len(blk.RawData()))
+	}
+	return tasks, blks
+}
+
+// taskBlock returns the block of the task, reusing the one fetched when the
+// task did not fit in the previous envelope.
+func (e *Engine) taskBlock(task *peerRequestTask) (blocks.Block, error) {
+	if task == e.unsent {
+		blk := e.unsentBlock
+		e.unsent, e.unsentBlock = nil, nil
+		return blk, nil
+	}
+	return e.bs.Get(task.Entry.Cid)
+}
+
 // Outbox returns a channel of one-time use Envelope channels.
 func (e *Engine) Outbox() <-chan (<-chan *Envelope) {
 	return e.outbox
@@ -217,6 +341,10 @@ func (e *Engine) MessageReceived(p peer.ID, m bsmsg.BitSwapMessage) error {
 		log.Debugf("received empty message from %s", p)
 	}
 
+	if !e.Allowed(p) {
+		return ErrPeerNotAllowed
+	}
+
 	newWorkExists := false
 	defer func() {
 		if newWorkExists {
@@ -236,6 +364,9 @@ func (e *Engine) MessageReceived(p peer.ID, m bsmsg.BitSwapMessage) error {
 			log.Debugf("%s cancel %s", p, entry.Cid)
 			l.CancelWant(entry.Cid)
 			e.peerRequestQueue.Remove(entry.Cid, p)
+		} else if entry.WantType == bsmsg.WantHave {
+			// answered with a block presence by the bitswap instance
+			continue
 		} else {
 			log.Debugf("wants %s - %d", entry.Cid, entry.Priority)
 			l.Wants(entry.Cid, entry.Priority)
@@ -250,6 +381,7 @@ func (e *Engine) MessageReceived(p peer.ID, m bsmsg.BitSwapMessage) error {
 		log.Debugf("got block %s %d bytes", block, len(block.RawData()))
 		l.ReceivedBytes(len(block.RawData()))
 	}
+	e.peerRequestQueue.updateLedger(p, l.Accounting.BytesSent, l.Accounting.BytesRecv)
 	return nil
 }
 
@@ -293,6 +425,7 @@ func (e *Engine) MessageSent(p peer.ID, m bsmsg.BitSwapMessage) error {
 		l.wantList.Remove(block.Cid())
 		e.peerRequestQueue.Remove(block.Cid(), p)
 	}
+	e.peerRequestQueue.updateLedger(p, l.Accounting.BytesSent, l.Accounting.BytesRecv)
 
 	return nil
 }
@@ -302,27 +435,44 @@ func (e *Engine) PeerConnected(p peer.ID) {
 	defer e.lock.Unlock()
 	l, ok := e.ledgerMap[p]
 	if !ok {
-		l = newLedger(p)
+		l = e.newLedger(p)
 		e.ledgerMap[p] = l
 	}
 	l.lk.Lock()
 	defer l.lk.Unlock()
 	l.ref++
+	l.seen()
 }
 
 func (e *Engine) PeerDisconnected(p peer.ID) {
 	e.lock.Lock()
-	defer e.lock.Unlock()
 	l, ok := e.ledgerMap[p]
 	if !ok {
+		e.lock.Unlock()
 		return
 	}
 	l.lk.Lock()
-	defer l.lk.Unlock()
 	l.ref--
-	if l.ref <= 0 {
+	l.seen()
+	gone := l.ref <= 0
+	r := l.record()
+	l.lk.Unlock()
+
+	// only the ledgers of the peers we exchanged blocks with are kept
+	save := gone && r.exchanged()
+	if gone {
 		delete(e.ledgerMap, p)
 	}
+	if save {
+		e.savedLedgers[p] = r
+	}
+	e.lock.Unlock()
+
+	if save && e.ledgerStore != nil {
+		if err := saveLedger(e.ledgerStore, p, r); err != nil {
+			log.Errorf("saving ledger of %s: %s", p, err)
+		}
+	}
 }
 
 func (e *Engine) numBytesSentTo(p peer.ID) uint64 {
@@ -341,12 +491,25 @@ func (e *Engine) findOrCreate(p peer.ID) *ledger {
 	defer e.lock.Unlock()
 	l, ok := e.ledgerMap[p]
 	if !ok {
-		l = newLedger(p)
+		l = e.newLedger(p)
 		e.ledgerMap[p] = l
 	}
 	return l
 }
 
+// newLedger creates a ledger for the peer, restoring its saved state.
+// e.lock must be held.
+func (e *Engine) newLedger(p peer.ID) *ledger {
+	l := newLedger(p)
+	if r, ok := e.savedLedgers[p]; ok {
+		l.restore(r)
+	}
+	if l.firstSeen.IsZero() {
+		l.firstSeen = time.Now()
+	}
+	return l
+}
+
 func (e *Engine) signalNewWork() {
 	// Signal task generation to restart (if stopped!)
 	select {
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	message "github.com/ipfs/go-ipfs/exchange/bitswap/message"
 
@@ -29,7 +30,7 @@ func newEngine(ctx context.Context, idStr string) peerAndEngine {
 		Peer: peer.ID(idStr),
 		//Strategy: New(true),
 		Engine: NewEngine(ctx,
-			blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))),
+			blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())), nil),
 	}
 }
 
@@ -106,9 +107,174 @@ func peerIsPartner(p peer.ID, e *Engine) bool {
 	return false
 }
 
+func TestLedgersPersist(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	p := testutil.RandPeerIDFatal(t)
+
+	e := NewEngine(ctx, bs, lstore)
+	e.PeerConnected(p)
+	m := message.New(false)
+	m.AddBlock(blocks.NewBlock([]byte("Hello, Bert")))
+	e.MessageReceived(p, m)
+	if err := e.SaveLedgers(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new engine on the same datastore knows the peer
+	e2 := NewEngine(ctx, bs, lstore)
+	r := e2.LedgerForPeer(p)
+	if r.Recv != 11 || r.Exchanged != 1 {
+		t.Fatalf("ledger not restored: %+v", r)
+	}
+
+	e.PeerDisconnected(p)
+	e3 := NewEngine(ctx, bs, lstore)
+	all := e3.AllLedgers()
+	if len(all) != 1 || all[0].Recv != 11 || all[0].Connected {
+		t.Fatalf("unexpected ledgers: %+v", all)
+	}
+	if all[0].LastSeen.IsZero() {
+		t.Fatal("last seen time not saved")
+	}
+}
+
+// failingStore fails to put values while fail is set.
+type failingStore struct {
+	ds.Datastore
+	fail bool
+}
+
+func (s *failingStore) Put(k ds.Key, v interface{}) error {
+	if s.fail {
+		return errors.New("put failed")
+	}
+	return s.Datastore.Put(k, v)
+}
+
+func TestLedgersSaveRetried(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lstore := &failingStore{Datastore: dssync.MutexWrap(ds.NewMapDatastore())}
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	p := testutil.RandPeerIDFatal(t)
+
+	// invalid values do not stop the engine from starting
+	if err := lstore.Put(ledgerKey(testutil.RandPeerIDFatal(t)), "invalid"); err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewEngine(ctx, bs, lstore)
+	e.PeerConnected(p)
+	m := message.New(false)
+	m.AddBlock(blocks.NewBlock([]byte("Hello, Bert")))
+	e.MessageReceived(p, m)
+
+	lstore.fail = true
+	if err := e.SaveLedgers(); err == nil {
+		t.Fatal("expected saving to fail")
+	}
+
+	// the ledger is still dirty and saved on the next attempt
+	lstore.fail = false
+	if err := e.SaveLedgers(); err != nil {
+		t.Fatal(err)
+	}
+	r := NewEngine(ctx, bs, lstore).LedgerForPeer(p)
+	if r.Recv != 11 {
+		t.Fatalf("ledger not saved: %+v", r)
+	}
+}
+
+func TestLedgersPruned(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+
+	// peers we did not exchange blocks with are not saved
+	e := NewEngine(ctx, bs, lstore)
+	idle := testutil.RandPeerIDFatal(t)
+	e.PeerConnected(idle)
+	e.PeerDisconnected(idle)
+	if err := e.SaveLedgers(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lstore.Get(ledgerKey(idle)); err != ds.ErrNotFound {
+		t.Fatalf("expected the idle peer not to be saved, got %v", err)
+	}
+
+	// expired ledgers and the ones over the cap are dropped
+	old := testutil.RandPeerIDFatal(t)
+	recent := testutil.RandPeerIDFatal(t)
+	recent2 := testutil.RandPeerIDFatal(t)
+	now := time.Now()
+	for p, seen := range map[peer.ID]time.Time{
+		old:     now.Add(-ledgerMaxAge - time.Hour),
+		recent:  now.Add(-time.Hour),
+		recent2: now.Add(-time.Minute),
+	} {
+		if err := saveLedger(lstore, p, ledgerRecord{BytesRecv: 1, LastSeen: seen}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func(n int) { maxSavedLedgers = n }(maxSavedLedgers)
+	maxSavedLedgers = 1
+
+	e = NewEngine(ctx, bs, lstore)
+	all := e.AllLedgers()
+	if len(all) != 1 || all[0].Peer != recent2.Pretty() {
+		t.Fatalf("unexpected ledgers: %+v", all)
+	}
+	for _, p := range []peer.ID{old, recent} {
+		if _, err := lstore.Get(ledgerKey(p)); err != ds.ErrNotFound {
+			t.Fatalf("expected the ledger of %s to be deleted, got %v", p, err)
+		}
+	}
+}
+
+func TestPeerFilter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	blk := blocks.NewBlock([]byte("private"))
+	if err := bs.Put(blk); err != nil {
+		t.Fatal(err)
+	}
+	allowed := testutil.RandPeerIDFatal(t)
+	other := testutil.RandPeerIDFatal(t)
+
+	e := NewEngine(ctx, bs, nil)
+	e.SetPeerFilter(NewAllowlistFilter([]peer.ID{allowed}))
+
+	m := message.New(false)
+	m.AddEntry(blk.Cid(), 1)
+	if err := e.MessageReceived(other, m); err != ErrPeerNotAllowed {
+		t.Fatal("expected the message to be rejected, got", err)
+	}
+	if len(e.WantlistForPeer(other)) != 0 {
+		t.Fatal("wants of a rejected peer were recorded")
+	}
+	if err := e.MessageReceived(allowed, m); err != nil {
+		t.Fatal(err)
+	}
+
+	env := <-<-e.Outbox()
+	if env.Peer != allowed {
+		t.Fatal("block sent to", env.Peer)
+	}
+}
+
 func TestOutboxClosedWhenEngineClosed(t *testing.T) {
 	t.SkipNow() // TODO implement *Engine.Close
-	e := NewEngine(context.Background(), blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())))
+	e := NewEngine(context.Background(), blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())), nil)
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
@@ -156,7 +322,7 @@ func TestPartnerWantsThenCancels(t *testing.T) {
 			cancels := testcase[1]
 			keeps := stringsComplement(set, cancels)
 
-			e := NewEngine(context.Background(), bs)
+			e := NewEngine(context.Background(), bs, nil)
 			partner := testutil.RandPeerIDFatal(t)
 
 			partnerWants(e, set, partner)
@@ -169,6 +335,75 @@ func TestPartnerWantsThenCancels(t *testing.T) {
 	}
 }
 
+func TestEnvelopesRespectMessageSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	keys := strings.Split("abcde", "")
+	for _, k := range keys {
+		if err := bs.Put(blocks.NewBlock([]byte(k + k))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := NewEngine(ctx, bs, nil)
+	e.SetMaxMessageSize(5)
+	partner := testutil.RandPeerIDFatal(t)
+
+	add := message.New(false)
+	for i, k := range keys {
+		add.AddEntry(blocks.NewBlock([]byte(k+k)).Cid(), math.MaxInt32-i)
+	}
+	e.MessageReceived(partner, add)
+
+	// 2 byte blocks, so at most two of them fit in each envelope
+	var got []string
+	for len(got) < len(keys) {
+		envelope := <-<-e.Outbox()
+		if len(envelope.Blocks) > 2 {
+			t.Fatalf("envelope has %d blocks", len(envelope.Blocks))
+		}
+		for _, b := range envelope.Blocks {
+			got = append(got, string(b.RawData()[:1]))
+		}
+		envelope.Sent()
+	}
+	if strings.Join(got, "") != "abcde" {
+		t.Fatal("blocks sent out of order:", got)
+	}
+}
+
+func TestEnvelopesRespectSendRate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	keys := strings.Split("abcde", "")
+	for _, k := range keys {
+		if err := bs.Put(blocks.NewBlock([]byte(k + k))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := NewEngine(ctx, bs, nil)
+	e.SetSendRate(func(peer.ID) (int64, int64) { return 3, 3 })
+	partner := testutil.RandPeerIDFatal(t)
+
+	add := message.New(false)
+	for i, k := range keys {
+		add.AddEntry(blocks.NewBlock([]byte(k+k)).Cid(), math.MaxInt32-i)
+	}
+	e.MessageReceived(partner, add)
+
+	// the second 2 byte block exhausts the burst of 3 bytes, and nothing
+	// more is packed
+	envelope := <-<-e.Outbox()
+	if len(envelope.Blocks) != 2 {
+		t.Fatalf("expected 2 blocks before throttling, got %d", len(envelope.Blocks))
+	}
+}
+
 func partnerWants(e *Engine, keys []string, partner peer.ID) {
 	add := message.New(false)
 	for i, letter := range keys {
@@ -188,10 +423,14 @@ func partnerCancels(e *Engine, keys []string, partner peer.ID) {
 }
 
 func checkHandledInOrder(t *testing.T, e *Engine, keys []string) error {
-	for _, k := range keys {
+	var blks []blocks.Block
+	for len(blks) < len(keys) {
 		next := <-e.Outbox()
 		envelope := <-next
-		received := envelope.Block
+		blks = append(blks, envelope.Blocks...)
+	}
+	for i, k := range keys {
+		received := blks[i]
 		expected := blocks.NewBlock([]byte(k))
 		if !received.Cid().Equals(expected.Cid()) {
 			return errors.New(fmt.Sprintln("received", string(received.RawData()), "expected", string(expected.RawData())))
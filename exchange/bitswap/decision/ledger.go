@@ -33,6 +33,14 @@ type ledger struct {
 	// exchangeCount is the number of exchanges with this peer
 	exchangeCount uint64
 
+	// firstSeen and lastSeen are the times we first and last were connected
+	// to the peer.
+	firstSeen time.Time
+	lastSeen  time.Time
+
+	// dirty is set when the ledger changed since it was last saved.
+	dirty bool
+
 	// wantList is a (bounded, small) set of keys that Partner desires.
 	wantList *wl.Wantlist
 
@@ -53,6 +61,11 @@ type Receipt struct {
 	Sent      uint64
 	Recv      uint64
 	Exchanged uint64
+
+	LastExchange time.Time
+	FirstSeen    time.Time
+	LastSeen     time.Time
+	Connected    bool
 }
 
 type debtRatio struct {
@@ -68,12 +81,19 @@ func (l *ledger) SentBytes(n int) {
 	l.exchangeCount++
 	l.lastExchange = time.Now()
 	l.Accounting.BytesSent += uint64(n)
+	l.dirty = true
 }
 
 func (l *ledger) ReceivedBytes(n int) {
 	l.exchangeCount++
 	l.lastExchange = time.Now()
 	l.Accounting.BytesRecv += uint64(n)
+	l.dirty = true
+}
+
+func (l *ledger) seen() {
+	l.lastSeen = time.Now()
+	l.dirty = true
 }
 
 func (l *ledger) Wants(k *cid.Cid, priority int) {
@@ -0,0 +1,268 @@
+package decision
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
+	dsq "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore/query"
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+)
+
+// ledgerSaveInterval is how often the ledgers of connected peers are saved.
+var ledgerSaveInterval = time.Minute
+
+// ledgerMaxAge is how long the ledger of a peer is kept after we last were
+// connected to it.
+var ledgerMaxAge = 90 * 24 * time.Hour
+
+// maxSavedLedgers caps the number of saved ledgers; the ledgers of the peers
+// last seen the longest ago are dropped first.
+var maxSavedLedgers = 10000
+
+var ledgerPrefix = ds.NewKey("/bitswap/ledgers")
+
+func ledgerKey(p peer.ID) ds.Key {
+	return ledgerPrefix.ChildString(p.Pretty())
+}
+
+// ledgerRecord is the part of a ledger kept across restarts.
+type ledgerRecord struct {
+	BytesSent    uint64
+	BytesRecv    uint64
+	Exchanged    uint64
+	LastExchange time.Time
+	FirstSeen    time.Time
+	LastSeen     time.Time
+}
+
+// record returns the persisted part of the ledger. l.lk must be held.
+func (l *ledger) record() ledgerRecord {
+	return ledgerRecord{
+		BytesSent:    l.Accounting.BytesSent,
+		BytesRecv:    l.Accounting.BytesRecv,
+		Exchanged:    l.exchangeCount,
+		LastExchange: l.lastExchange,
+		FirstSeen:    l.firstSeen,
+		LastSeen:     l.lastSeen,
+	}
+}
+
+// restore sets the ledger to the saved record.
+func (l *ledger) restore(r ledgerRecord) {
+	l.Accounting.BytesSent = r.BytesSent
+	l.Accounting.BytesRecv = r.BytesRecv
+	l.exchangeCount = r.Exchanged
+	l.lastExchange = r.LastExchange
+	l.firstSeen = r.FirstSeen
+	l.lastSeen = r.LastSeen
+}
+
+// exchanged returns whether blocks were exchanged with the peer. Only the
+// ledgers of such peers are saved.
+func (r *ledgerRecord) exchanged() bool {
+	return r.BytesSent > 0 || r.BytesRecv > 0
+}
+
+func (r *ledgerRecord) receipt(p peer.ID) *Receipt {
+	dr := debtRatio{BytesSent: r.BytesSent, BytesRecv: r.BytesRecv}
+	return &Receipt{
+		Peer:         p.Pretty(),
+		Value:        dr.Value(),
+		Sent:         r.BytesSent,
+		Recv:         r.BytesRecv,
+		Exchanged:    r.Exchanged,
+		LastExchange: r.LastExchange,
+		FirstSeen:    r.FirstSeen,
+		LastSeen:     r.LastSeen,
+	}
+}
+
+// loadLedgers reads the saved ledgers, dropping the expired ones and the
+// ones over the cap.
+func loadLedgers(d ds.Datastore) (map[peer.ID]ledgerRecord, error) {
+	out, err := readLedgers(d)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range prunable(out, nil) {
+		delete(out, p)
+		if err := d.Delete(ledgerKey(p)); err != nil && err != ds.ErrNotFound {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// prunable returns the saved ledgers to drop, because they expired or are
+// over the cap, except those of the connected peers.
+func prunable(saved map[peer.ID]ledgerRecord, connected map[peer.ID]*ledger) []peer.ID {
+	var drop, keep []peer.ID
+	now := time.Now()
+	for p, r := range saved {
+		if _, ok := connected[p]; ok {
+			continue
+		}
+		if now.Sub(r.LastSeen) > ledgerMaxAge {
+			drop = append(drop, p)
+		} else {
+			keep = append(keep, p)
+		}
+	}
+
+	if over := len(keep) - maxSavedLedgers; over > 0 {
+		sort.Slice(keep, func(i, j int) bool {
+			return saved[keep[i]].LastSeen.Before(saved[keep[j]].LastSeen)
+		})
+		drop = append(drop, keep[:over]...)
+	}
+	return drop
+}
+
+func readLedgers(d ds.Datastore) (map[peer.ID]ledgerRecord, error) {
+	res, err := d.Query(dsq.Query{Prefix: ledgerPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	out := make(map[peer.ID]ledgerRecord)
+	for e := range res.Next() {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		p, err := peer.IDB58Decode(ds.RawKey(e.Key).BaseNamespace())
+		if err != nil {
+			log.Warningf("skipping ledger with invalid key %s: %s", e.Key, err)
+			continue
+		}
+		b, ok := e.Value.([]byte)
+		if !ok {
+			log.Warningf("skipping ledger for %s stored as a %T", p, e.Value)
+			continue
+		}
+		var r ledgerRecord
+		if err := json.Unmarshal(b, &r); err != nil {
+			log.Warningf("skipping invalid ledger for %s: %s", p, err)
+			continue
+		}
+		out[p] = r
+	}
+	return out, nil
+}
+
+func saveLedger(d ds.Datastore, p peer.ID, r ledgerRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return d.Put(ledgerKey(p), b)
+}
+
+func (e *Engine) ledgerSaver(ctx context.Context) {
+	t := time.NewTicker(ledgerSaveInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := e.SaveLedgers(); err != nil {
+				log.Errorf("saving ledgers: %s", err)
+			}
+			if err := e.pruneLedgers(); err != nil {
+				log.Errorf("pruning ledgers: %s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// SaveLedgers writes the ledgers changed since they were last saved to the
+// datastore. It does nothing if the engine has no datastore.
+func (e *Engine) SaveLedgers() error {
+	if e.ledgerStore == nil {
+		return nil
+	}
+
+	e.lock.Lock()
+	ledgers := make([]*ledger, 0, len(e.ledgerMap))
+	for _, l := range e.ledgerMap {
+		ledgers = append(ledgers, l)
+	}
+	e.lock.Unlock()
+
+	for _, l := range ledgers {
+		l.lk.Lock()
+		dirty := l.dirty
+		r := l.record()
+		l.lk.Unlock()
+
+		if !dirty || !r.exchanged() {
+			continue
+		}
+
+		if err := saveLedger(e.ledgerStore, l.Partner, r); err != nil {
+			return err
+		}
+
+		// the ledger stays dirty if it changed while being saved
+		l.lk.Lock()
+		if l.record() == r {
+			l.dirty = false
+		}
+		l.lk.Unlock()
+
+		e.lock.Lock()
+		e.savedLedgers[l.Partner] = r
+		e.lock.Unlock()
+	}
+	return nil
+}
+
+// pruneLedgers drops the saved ledgers which expired or are over the cap.
+func (e *Engine) pruneLedgers() error {
+	e.lock.Lock()
+	drop := prunable(e.savedLedgers, e.ledgerMap)
+	for _, p := range drop {
+		delete(e.savedLedgers, p)
+	}
+	e.lock.Unlock()
+
+	for _, p := range drop {
+		if err := e.ledgerStore.Delete(ledgerKey(p)); err != nil && err != ds.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// AllLedgers returns the ledgers of the connected peers followed by the
+// saved ledgers of the peers we exchanged with in the past.
+func (e *Engine) AllLedgers() []*Receipt {
+	e.lock.Lock()
+	ledgers := make([]*ledger, 0, len(e.ledgerMap))
+	for _, l := range e.ledgerMap {
+		ledgers = append(ledgers, l)
+	}
+	var past []*Receipt
+	for p, r := range e.savedLedgers {
+		if _, ok := e.ledgerMap[p]; !ok {
+			past = append(past, r.receipt(p))
+		}
+	}
+	e.lock.Unlock()
+
+	out := make([]*Receipt, 0, len(ledgers)+len(past))
+	for _, l := range ledgers {
+		l.lk.Lock()
+		r := l.record()
+		l.lk.Unlock()
+
+		rc := r.receipt(l.Partner)
+		rc.Connected = true
+		out = append(out, rc)
+	}
+	return append(out, past...)
+}
@@ -22,39 +22,110 @@ type peerRequestQueue interface {
 }
 
 func newPRQ() *prq {
-	return &prq{
-		taskMap:  make(map[string]*peerRequestTask),
-		partners: make(map[peer.ID]*activePartner),
-		frozen:   make(map[peer.ID]*activePartner),
-		pQueue:   pq.New(partnerCompare),
+	tl := &prq{
+		taskMap:   make(map[string]*peerRequestTask),
+		partners:  make(map[peer.ID]*activePartner),
+		frozen:    make(map[peer.ID]*activePartner),
+		throttled: make(map[peer.ID]*activePartner),
+		strategy:  FairStrategy{},
 	}
+	tl.pQueue = pq.New(tl.partnerCompare)
+	return tl
 }
 
 // verify interface implementation
 var _ peerRequestQueue = &prq{}
 
-// TODO: at some point, the strategy needs to plug in here
-// to help decide how to sort tasks (on add) and how to select
-// tasks (on getnext). For now, we are assuming a dumb/nice strategy.
+// prq orders partners with the strategy, and tasks of a partner by the
+// priority of their wantlist entries.
 type prq struct {
 	lock     sync.Mutex
 	pQueue   pq.PQ
 	taskMap  map[string]*peerRequestTask
 	partners map[peer.ID]*activePartner
 
-	frozen map[peer.ID]*activePartner
+	frozen    map[peer.ID]*activePartner
+	throttled map[peer.ID]*activePartner
+
+	strategy Strategy
+	sendRate SendRateFunc
 }
 
-// Push currently adds a new peerRequestTask to the end of the list
-func (tl *prq) Push(entry *wantlist.Entry, to peer.ID) {
+// setStrategy sets the strategy ordering partners.
+func (tl *prq) setStrategy(s Strategy) {
+	tl.lock.Lock()
+	defer tl.lock.Unlock()
+	tl.strategy = s
+	tl.reorder()
+}
+
+// setSendRate sets the function returning the send rate cap of partners.
+func (tl *prq) setSendRate(f SendRateFunc) {
 	tl.lock.Lock()
 	defer tl.lock.Unlock()
-	partner, ok := tl.partners[to]
+	tl.sendRate = f
+	for id, partner := range tl.partners {
+		partner.setRate(tl.rateFor(id))
+	}
+}
+
+func (tl *prq) rateFor(p peer.ID) (int64, int64) {
+	if tl.sendRate == nil {
+		return 0, 0
+	}
+	return tl.sendRate(p)
+}
+
+// reorder fixes the order of all partners, after the ordering changed.
+func (tl *prq) reorder() {
+	for _, partner := range tl.partners {
+		tl.pQueue.Update(partner.index)
+	}
+}
+
+// partner returns the partner for the peer, creating it if needed.
+// tl.lock must be held.
+func (tl *prq) partner(p peer.ID) *activePartner {
+	partner, ok := tl.partners[p]
 	if !ok {
-		partner = newActivePartner()
+		partner = newActivePartner(p)
+		partner.setRate(tl.rateFor(p))
 		tl.pQueue.Push(partner)
-		tl.partners[to] = partner
+		tl.partners[p] = partner
+	}
+	return partner
+}
+
+// updateLedger records the bytes exchanged with the peer, for the strategy.
+func (tl *prq) updateLedger(p peer.ID, sent, recv uint64) {
+	tl.lock.Lock()
+	defer tl.lock.Unlock()
+	partner := tl.partner(p)
+	partner.bytesSent = sent
+	partner.bytesRecv = recv
+	tl.pQueue.Update(partner.index)
+}
+
+// blockSent charges the size of a block packed for the peer to its send
+// rate.
+func (tl *prq) blockSent(p peer.ID, n int) {
+	tl.lock.Lock()
+	defer tl.lock.Unlock()
+	partner, ok := tl.partners[p]
+	if !ok || partner.rate == 0 {
+		return
 	}
+	if partner.spend(n, time.Now()) {
+		tl.throttled[p] = partner
+	}
+	tl.pQueue.Update(partner.index)
+}
+
+// Push currently adds a new peerRequestTask to the end of the list
+func (tl *prq) Push(entry *wantlist.Entry, to peer.ID) {
+	tl.lock.Lock()
+	defer tl.lock.Unlock()
+	partner := tl.partner(to)
 
 	partner.activelk.Lock()
 	defer partner.activelk.Unlock()
@@ -94,23 +165,53 @@ func (tl *prq) Pop() *peerRequestTask {
 		return nil
 	}
 	partner := tl.pQueue.Pop().(*activePartner)
+	out := tl.popTask(partner)
+	tl.pQueue.Push(partner)
+	return out
+}
 
-	var out *peerRequestTask
-	for partner.taskQueue.Len() > 0 && partner.freezeVal == 0 {
-		out = partner.taskQueue.Pop().(*peerRequestTask)
+// popFor pops the next task of the given peer. Returns nil if the peer has no
+// task or must not be sent blocks right now.
+func (tl *prq) popFor(p peer.ID) *peerRequestTask {
+	tl.lock.Lock()
+	defer tl.lock.Unlock()
+	partner, ok := tl.partners[p]
+	if !ok {
+		return nil
+	}
+	out := tl.popTask(partner)
+	tl.pQueue.Update(partner.index)
+	return out
+}
+
+// popTask pops the next task of the partner. tl.lock must be held.
+func (tl *prq) popTask(partner *activePartner) *peerRequestTask {
+	for partner.taskQueue.Len() > 0 && partner.freezeVal == 0 && !partner.throttled {
+		out := partner.taskQueue.Pop().(*peerRequestTask)
 		delete(tl.taskMap, out.Key())
 		if out.trash {
-			out = nil
 			continue // discarding tasks that have been removed
 		}
 
 		partner.StartTask(out.Entry.Cid)
 		partner.requests--
-		break // and return |out|
+		return out
 	}
+	return nil
+}
 
-	tl.pQueue.Push(partner)
-	return out
+// requeue puts back a popped task which was not performed, keeping its
+// place in the queue of the partner.
+func (tl *prq) requeue(task *peerRequestTask) {
+	tl.lock.Lock()
+	defer tl.lock.Unlock()
+	partner := tl.partner(task.Target)
+	partner.TaskDone(task.Entry.Cid)
+
+	partner.taskQueue.Push(task)
+	tl.taskMap[task.Key()] = task
+	partner.requests++
+	tl.pQueue.Update(partner.index)
 }
 
 // Remove removes a task from the queue
@@ -163,6 +264,15 @@ func (tl *prq) thawRound() {
 		}
 		tl.pQueue.Update(partner.index)
 	}
+
+	now := time.Now()
+	for id, partner := range tl.throttled {
+		partner.refill(now)
+		if !partner.throttled {
+			delete(tl.throttled, id)
+			tl.pQueue.Update(partner.index)
+		}
+	}
 }
 
 type peerRequestTask struct {
@@ -220,6 +330,19 @@ func wrapCmp(f func(a, b *peerRequestTask) bool) func(a, b pq.Elem) bool {
 }
 
 type activePartner struct {
+	id peer.ID
+
+	// bytes exchanged with the peer, from its ledger
+	bytesSent uint64
+	bytesRecv uint64
+
+	// token bucket limiting the rate blocks are sent at, unused if rate is
+	// zero. The partner is throttled while tokens are negative.
+	rate      float64
+	burst     float64
+	tokens    float64
+	filled    time.Time
+	throttled bool
 
 	// Active is the number of blocks this peer is currently being sent
 	// active must be locked around as it will be updated externally
@@ -242,8 +365,9 @@ type activePartner struct {
 	taskQueue pq.PQ
 }
 
-func newActivePartner() *activePartner {
+func newActivePartner(id peer.ID) *activePartner {
 	return &activePartner{
+		id:           id,
 		taskQueue:    pq.New(wrapCmp(V1)),
 		activeBlocks: cid.NewSet(),
 	}
@@ -251,7 +375,7 @@ func newActivePartner() *activePartner {
 
 // partnerCompare implements pq.ElemComparator
 // returns true if peer 'a' has higher priority than peer 'b'
-func partnerCompare(a, b pq.Elem) bool {
+func (tl *prq) partnerCompare(a, b pq.Elem) bool {
 	pa := a.(*activePartner)
 	pb := b.(*activePartner)
 
@@ -271,13 +395,60 @@ func partnerCompare(a, b pq.Elem) bool {
 		return true
 	}
 
-	if pa.active == pb.active {
-		// sorting by taskQueue.Len() aids in cleaning out trash entries faster
-		// if we sorted instead by requests, one peer could potentially build up
-		// a huge number of cancelled entries in the queue resulting in a memory leak
-		return pa.taskQueue.Len() > pb.taskQueue.Len()
+	if pa.throttled != pb.throttled {
+		return pb.throttled
+	}
+
+	sa, sb := pa.stats(), pb.stats()
+	if tl.strategy.Less(&sa, &sb) {
+		return true
+	}
+	if tl.strategy.Less(&sb, &sa) {
+		return false
+	}
+	// sorting by taskQueue.Len() aids in cleaning out trash entries faster
+	// if we sorted instead by requests, one peer could potentially build up
+	// a huge number of cancelled entries in the queue resulting in a memory leak
+	return pa.taskQueue.Len() > pb.taskQueue.Len()
+}
+
+func (p *activePartner) stats() PeerStats {
+	return PeerStats{
+		Peer:      p.id,
+		BytesSent: p.bytesSent,
+		BytesRecv: p.bytesRecv,
+		Active:    p.active,
+		Pending:   p.requests,
 	}
-	return pa.active < pb.active
+}
+
+func (p *activePartner) setRate(rate, burst int64) {
+	if burst <= 0 {
+		burst = rate
+	}
+	p.rate = float64(rate)
+	p.burst = float64(burst)
+	p.tokens = p.burst
+	p.filled = time.Now()
+	p.throttled = false
+}
+
+func (p *activePartner) refill(now time.Time) {
+	p.tokens += p.rate * now.Sub(p.filled).Seconds()
+	if p.tokens > p.burst {
+		p.tokens = p.burst
+	}
+	p.filled = now
+	p.throttled = p.tokens < 0
+}
+
+// spend takes n bytes from the bucket and returns whether the partner is
+// now throttled.
+func (p *activePartner) spend(n int, now time.Time) bool {
+	p.refill(now)
+	p.tokens -= float64(n)
+	p.throttled = p.tokens < 0
+	return p.throttled
 }
 
 // StartTask signals that a task was started for this partner
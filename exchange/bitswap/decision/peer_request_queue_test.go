@@ -7,10 +7,12 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ipfs/go-ipfs/exchange/bitswap/wantlist"
 	u "gx/ipfs/QmNiJuT8Ja3hMVpBHXv3Q6dwmperaQ6JjLtpMQgMCD7xvx/go-ipfs-util"
 	"gx/ipfs/QmVvkK7s5imCiq3JVbL3pGfnhcCnf3LrFJPF4GE2sAoGZf/go-testutil"
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
 	cid "gx/ipfs/QmcZfnkapfECQGcLZaf9B79NRg7cRa9EnZh4LSbkCzwNvY/go-cid"
 )
 
@@ -126,3 +128,71 @@ func TestPeerRepeats(t *testing.T) {
 		}
 	}
 }
+
+func TestAllowlistStrategy(t *testing.T) {
+	prq := newPRQ()
+	a := testutil.RandPeerIDFatal(t)
+	b := testutil.RandPeerIDFatal(t)
+	prq.setStrategy(NewAllowlistStrategy([]peer.ID{b}, FairStrategy{}))
+
+	for i := 0; i < 3; i++ {
+		elcid := cid.NewCidV0(u.Hash([]byte(fmt.Sprint(i))))
+		prq.Push(&wantlist.Entry{Cid: elcid}, a)
+		prq.Push(&wantlist.Entry{Cid: elcid}, b)
+	}
+
+	// all of b's tasks are served before any of a's
+	for i := 0; i < 3; i++ {
+		if task := prq.Pop(); task.Target != b {
+			t.Fatal("expected task for allowed peer, got", task.Target)
+		}
+	}
+	if task := prq.Pop(); task.Target != a {
+		t.Fatal("expected task for other peer, got", task.Target)
+	}
+}
+
+func TestReciprocityStrategy(t *testing.T) {
+	prq := newPRQ()
+	leecher := testutil.RandPeerIDFatal(t)
+	seeder := testutil.RandPeerIDFatal(t)
+	prq.setStrategy(ReciprocityStrategy{})
+	prq.updateLedger(leecher, 100<<20, 0)
+	prq.updateLedger(seeder, 0, 100<<20)
+
+	elcid := cid.NewCidV0(u.Hash([]byte("a")))
+	prq.Push(&wantlist.Entry{Cid: elcid}, leecher)
+	prq.Push(&wantlist.Entry{Cid: elcid}, seeder)
+
+	if task := prq.Pop(); task.Target != seeder {
+		t.Fatal("expected the peer that sent us data first")
+	}
+}
+
+func TestSendRate(t *testing.T) {
+	prq := newPRQ()
+	a := testutil.RandPeerIDFatal(t)
+	prq.setSendRate(func(peer.ID) (int64, int64) { return 1000, 1000 })
+
+	for i := 0; i < 2; i++ {
+		elcid := cid.NewCidV0(u.Hash([]byte(fmt.Sprint(i))))
+		prq.Push(&wantlist.Entry{Cid: elcid}, a)
+	}
+
+	task := prq.Pop()
+	if task == nil {
+		t.Fatal("expected a task")
+	}
+	task.Done()
+	prq.blockSent(a, 1500)
+
+	if task := prq.Pop(); task != nil {
+		t.Fatal("throttled peer should not be served")
+	}
+
+	time.Sleep(time.Millisecond * 600)
+	prq.thawRound()
+	if task := prq.Pop(); task == nil {
+		t.Fatal("expected a task once the send rate allows it")
+	}
+}
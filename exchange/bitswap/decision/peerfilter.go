@@ -0,0 +1,26 @@
+package decision
+
+import (
+	"errors"
+
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+)
+
+// ErrPeerNotAllowed is returned by MessageReceived for messages of peers
+// rejected by the peer filter.
+var ErrPeerNotAllowed = errors.New("peer not allowed to exchange blocks")
+
+// PeerFilter reports whether blocks may be exchanged with a peer.
+type PeerFilter func(p peer.ID) bool
+
+// NewAllowlistFilter returns a filter only allowing the given peers.
+func NewAllowlistFilter(peers []peer.ID) PeerFilter {
+	allowed := make(map[peer.ID]struct{}, len(peers))
+	for _, p := range peers {
+		allowed[p] = struct{}{}
+	}
+	return func(p peer.ID) bool {
+		_, ok := allowed[p]
+		return ok
+	}
+}
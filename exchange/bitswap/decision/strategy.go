@@ -0,0 +1,120 @@
+package decision
+
+import (
+	"fmt"
+
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+)
+
+// PeerStats is what a Strategy knows about a partner.
+type PeerStats struct {
+	Peer peer.ID
+
+	// BytesSent and BytesRecv are the bytes exchanged with the partner, as
+	// recorded in its ledger.
+	BytesSent uint64
+	BytesRecv uint64
+
+	// Active is the number of blocks being sent to the partner.
+	Active int
+
+	// Pending is the number of blocks the partner requested and we have.
+	Pending int
+}
+
+// Strategy decides in which order the engine serves its partners. It is only
+// asked about partners with pending requests which are neither frozen nor
+// over their send rate.
+type Strategy interface {
+	// Less reports whether partner a should be served before partner b.
+	Less(a, b *PeerStats) bool
+}
+
+// Names of the built-in strategies.
+const (
+	StrategyFair        = "fair"
+	StrategyReciprocity = "reciprocity"
+	StrategyAllowlist   = "allowlist"
+)
+
+// NewStrategy returns the built-in strategy with the given name. priority is
+// the list of peers the allowlist strategy serves first, and is ignored by the
+// other strategies.
+func NewStrategy(name string, priority []peer.ID) (Strategy, error) {
+	switch name {
+	case "", StrategyFair:
+		return FairStrategy{}, nil
+	case StrategyReciprocity:
+		return ReciprocityStrategy{}, nil
+	case StrategyAllowlist:
+		return NewAllowlistStrategy(priority, FairStrategy{}), nil
+	default:
+		return nil, fmt.Errorf("unknown bitswap strategy %q", name)
+	}
+}
+
+// FairStrategy serves partners round robin, regardless of what they sent us.
+type FairStrategy struct{}
+
+// Less implements Strategy.
+func (FairStrategy) Less(a, b *PeerStats) bool {
+	return a.Active < b.Active
+}
+
+// reciprocitySmoothing keeps the weight of peers we barely exchanged with
+// close to one.
+const reciprocitySmoothing = 1 << 20
+
+// ReciprocityStrategy shares the upload between partners in proportion to how
+// much data they sent us compared to what we sent them, so peers that only
+// download are served after those that contribute.
+type ReciprocityStrategy struct{}
+
+// Less implements Strategy.
+func (ReciprocityStrategy) Less(a, b *PeerStats) bool {
+	return reciprocityShare(a) > reciprocityShare(b)
+}
+
+func reciprocityShare(s *PeerStats) float64 {
+	w := float64(s.BytesRecv+reciprocitySmoothing) / float64(s.BytesSent+reciprocitySmoothing)
+	switch {
+	case w < 0.1:
+		w = 0.1
+	case w > 10:
+		w = 10
+	}
+	return w / float64(s.Active+1)
+}
+
+type allowlistStrategy struct {
+	peers    map[peer.ID]struct{}
+	fallback Strategy
+}
+
+// NewAllowlistStrategy returns a strategy serving the given peers before any
+// other. Peers within each group are ordered by fallback.
+func NewAllowlistStrategy(peers []peer.ID, fallback Strategy) Strategy {
+	s := &allowlistStrategy{
+		peers:    make(map[peer.ID]struct{}, len(peers)),
+		fallback: fallback,
+	}
+	for _, p := range peers {
+		s.peers[p] = struct{}{}
+	}
+	return s
+}
+
+// Less implements Strategy.
+func (s *allowlistStrategy) Less(a, b *PeerStats) bool {
+	_, pa := s.peers[a.Peer]
+	_, pb := s.peers[b.Peer]
+	if pa != pb {
+		return pa
+	}
+	return s.fallback.Less(a, b)
+}
+
+// SendRateFunc returns the rate in bytes per second at which blocks may be sent
+// to a peer, and the number of bytes that may be sent in a burst. A rate of
+// zero means no limit.
+type SendRateFunc func(p peer.ID) (rate, burst int64)
@@ -28,8 +28,25 @@ type BitSwapMessage interface {
 	// AddEntry adds an entry to the Wantlist.
 	AddEntry(key *cid.Cid, priority int)
 
+	// AddEntryType adds an entry of the given want type to the Wantlist.
+	// If sendDontHave is set, the receiver replies with DONT_HAVE if it
+	// doesn't have the block. Both are only sent to bitswap 1.2.0 peers.
+	AddEntryType(key *cid.Cid, priority int, wantType WantType, sendDontHave bool)
+
 	Cancel(key *cid.Cid)
 
+	// Haves returns the cids the sender announced to have.
+	Haves() []*cid.Cid
+
+	// DontHaves returns the cids the sender announced not to have.
+	DontHaves() []*cid.Cid
+
+	// AddHave announces that the sender has the block.
+	AddHave(key *cid.Cid)
+
+	// AddDontHave announces that the sender doesn't have the block.
+	AddDontHave(key *cid.Cid)
+
 	Empty() bool
 
 	// A full wantlist is an authoritative copy, a 'non-full' wantlist is a patch-set
@@ -44,14 +61,27 @@ type BitSwapMessage interface {
 type Exportable interface {
 	ToProtoV0() *pb.Message
 	ToProtoV1() *pb.Message
+	ToProtoV2() *pb.Message
 	ToNetV0(w io.Writer) error
 	ToNetV1(w io.Writer) error
+	ToNetV2(w io.Writer) error
 }
 
+// WantType is the type of a wantlist entry: either the block itself or only
+// the information whether the receiver has it is wanted.
+type WantType = pb.Message_Wantlist_WantType
+
+const (
+	WantBlock = pb.Message_Wantlist_Block
+	WantHave  = pb.Message_Wantlist_Have
+)
+
 type impl struct {
-	full     bool
-	wantlist map[string]*Entry
-	blocks   map[string]blocks.Block
+	full      bool
+	wantlist  map[string]*Entry
+	blocks    map[string]blocks.Block
+	haves     map[string]*cid.Cid
+	dontHaves map[string]*cid.Cid
 }
 
 func New(full bool) BitSwapMessage {
@@ -60,15 +90,19 @@ func New(full bool) BitSwapMessage {
 
 func newMsg(full bool) *impl {
 	return &impl{
-		blocks:   make(map[string]blocks.Block),
-		wantlist: make(map[string]*Entry),
-		full:     full,
+		blocks:    make(map[string]blocks.Block),
+		wantlist:  make(map[string]*Entry),
+		haves:     make(map[string]*cid.Cid),
+		dontHaves: make(map[string]*cid.Cid),
+		full:      full,
 	}
 }
 
 type Entry struct {
 	*wantlist.Entry
-	Cancel bool
+	Cancel       bool
+	WantType     WantType
+	SendDontHave bool
 }
 
 func newMessageFromProto(pbm pb.Message) (BitSwapMessage, error) {
@@ -78,7 +112,7 @@ func newMessageFromProto(pbm pb.Message) (BitSwapMessage, error) {
 		if err != nil {
 			return nil, fmt.Errorf("incorrectly formatted cid in wantlist: %s", err)
 		}
-		m.addEntry(c, int(e.GetPriority()), e.GetCancel())
+		m.addEntry(c, int(e.GetPriority()), e.GetCancel(), e.GetWantType(), e.GetSendDontHave())
 	}
 
 	// deprecated
@@ -108,6 +142,20 @@ func newMessageFromProto(pbm pb.Message) (BitSwapMessage, error) {
 		m.AddBlock(blk)
 	}
 
+	for _, bp := range pbm.GetBlockPresences() {
+		c, err := cid.Cast(bp.GetCid())
+		if err != nil {
+			return nil, fmt.Errorf("incorrectly formatted cid in block presence: %s", err)
+		}
+
+		switch bp.GetType() {
+		case pb.Message_Have:
+			m.AddHave(c)
+		case pb.Message_DontHave:
+			m.AddDontHave(c)
+		}
+	}
+
 	return m, nil
 }
 
@@ -116,7 +164,7 @@ func (m *impl) Full() bool {
 }
 
 func (m *impl) Empty() bool {
-	return len(m.blocks) == 0 && len(m.wantlist) == 0
+	return len(m.blocks) == 0 && len(m.wantlist) == 0 && len(m.haves) == 0 && len(m.dontHaves) == 0
 }
 
 func (m *impl) Wantlist() []Entry {
@@ -135,32 +183,71 @@ func (m *impl) Blocks() []blocks.Block {
 	return bs
 }
 
+func (m *impl) Haves() []*cid.Cid {
+	return cidValues(m.haves)
+}
+
+func (m *impl) DontHaves() []*cid.Cid {
+	return cidValues(m.dontHaves)
+}
+
+func cidValues(set map[string]*cid.Cid) []*cid.Cid {
+	out := make([]*cid.Cid, 0, len(set))
+	for _, c := range set {
+		out = append(out, c)
+	}
+	return out
+}
+
 func (m *impl) Cancel(k *cid.Cid) {
 	delete(m.wantlist, k.KeyString())
-	m.addEntry(k, 0, true)
+	m.addEntry(k, 0, true, WantBlock, false)
 }
 
 func (m *impl) AddEntry(k *cid.Cid, priority int) {
-	m.addEntry(k, priority, false)
+	m.addEntry(k, priority, false, WantBlock, false)
+}
+
+func (m *impl) AddEntryType(k *cid.Cid, priority int, wantType WantType, sendDontHave bool) {
+	m.addEntry(k, priority, false, wantType, sendDontHave)
 }
 
-func (m *impl) addEntry(c *cid.Cid, priority int, cancel bool) {
+func (m *impl) addEntry(c *cid.Cid, priority int, cancel bool, wantType WantType, sendDontHave bool) {
 	k := c.KeyString()
 	e, exists := m.wantlist[k]
 	if exists {
 		e.Priority = priority
 		e.Cancel = cancel
+		// a block want also answers the question whether the peer has it
+		if e.WantType != WantBlock {
+			e.WantType = wantType
+		}
+		e.SendDontHave = e.SendDontHave || sendDontHave
 	} else {
 		m.wantlist[k] = &Entry{
 			Entry: &wantlist.Entry{
 				Cid:      c,
 				Priority: priority,
 			},
-			Cancel: cancel,
+			Cancel:       cancel,
+			WantType:     wantType,
+			SendDontHave: sendDontHave,
 		}
 	}
 }
 
+func (m *impl) AddHave(c *cid.Cid) {
+	k := c.KeyString()
+	delete(m.dontHaves, k)
+	m.haves[k] = c
+}
+
+func (m *impl) AddDontHave(c *cid.Cid) {
+	k := c.KeyString()
+	delete(m.haves, k)
+	m.dontHaves[k] = c
+}
+
 func (m *impl) AddBlock(b blocks.Block) {
 	m.blocks[b.Cid().KeyString()] = b
 }
@@ -200,6 +287,9 @@ func (m *impl) ToProtoV0() *pb.Message {
 	return pbm
 }
 
+// ToProtoV1 encodes the message for bitswap 1.1.0 peers. They don't know
+// want-have entries, which are sent as regular wants, and presences are
+// dropped.
 func (m *impl) ToProtoV1() *pb.Message {
 	pbm := new(pb.Message)
 	pbm.Wantlist = new(pb.Message_Wantlist)
@@ -225,6 +315,36 @@ func (m *impl) ToProtoV1() *pb.Message {
 	return pbm
 }
 
+// ToProtoV2 encodes the message for bitswap 1.2.0 peers, including want
+// types and block presences.
+func (m *impl) ToProtoV2() *pb.Message {
+	pbm := m.ToProtoV1()
+	for _, e := range pbm.Wantlist.Entries {
+		me := m.wantlist[e.GetBlock()]
+		if me.WantType != WantBlock {
+			e.WantType = me.WantType.Enum()
+		}
+		if me.SendDontHave {
+			e.SendDontHave = proto.Bool(true)
+		}
+	}
+
+	pbm.BlockPresences = make([]*pb.Message_BlockPresence, 0, len(m.haves)+len(m.dontHaves))
+	for _, c := range m.haves {
+		pbm.BlockPresences = append(pbm.BlockPresences, &pb.Message_BlockPresence{
+			Cid:  c.Bytes(),
+			Type: pb.Message_Have.Enum(),
+		})
+	}
+	for _, c := range m.dontHaves {
+		pbm.BlockPresences = append(pbm.BlockPresences, &pb.Message_BlockPresence{
+			Cid:  c.Bytes(),
+			Type: pb.Message_DontHave.Enum(),
+		})
+	}
+	return pbm
+}
+
 func (m *impl) ToNetV0(w io.Writer) error {
 	pbw := ggio.NewDelimitedWriter(w)
 
@@ -237,13 +357,21 @@ func (m *impl) ToNetV1(w io.Writer) error {
 	return pbw.WriteMsg(m.ToProtoV1())
 }
 
+func (m *impl) ToNetV2(w io.Writer) error {
+	pbw := ggio.NewDelimitedWriter(w)
+
+	return pbw.WriteMsg(m.ToProtoV2())
+}
+
 func (m *impl) Loggable() map[string]interface{} {
 	blocks := make([]string, 0, len(m.blocks))
 	for _, v := range m.blocks {
 		blocks = append(blocks, v.Cid().String())
 	}
 	return map[string]interface{}{
-		"blocks": blocks,
-		"wants":  m.Wantlist(),
+		"blocks":    blocks,
+		"wants":     m.Wantlist(),
+		"haves":     m.Haves(),
+		"donthaves": m.DontHaves(),
 	}
 }
@@ -198,3 +198,56 @@ func TestDuplicates(t *testing.T) {
 		t.Fatal("Duplicate in BitSwapMessage")
 	}
 }
+
+func TestWantTypesAndPresences(t *testing.T) {
+	have := mkFakeCid("have")
+	block := mkFakeCid("block")
+	present := mkFakeCid("present")
+	missing := mkFakeCid("missing")
+
+	m := New(false)
+	m.AddEntryType(have, 1, WantHave, true)
+	m.AddEntryType(block, 1, WantHave, false)
+	m.AddEntry(block, 1)
+	m.AddHave(present)
+	m.AddDontHave(missing)
+
+	buf := new(bytes.Buffer)
+	if err := m.ToNetV2(buf); err != nil {
+		t.Fatal(err)
+	}
+	m2, err := FromNet(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range m2.Wantlist() {
+		switch {
+		case e.Cid.Equals(have):
+			if e.WantType != WantHave || !e.SendDontHave {
+				t.Errorf("want-have entry not preserved: %v %v", e.WantType, e.SendDontHave)
+			}
+		case e.Cid.Equals(block):
+			if e.WantType != WantBlock {
+				t.Error("a block want must not be downgraded to a want-have")
+			}
+		}
+	}
+	if hs := m2.Haves(); len(hs) != 1 || !hs[0].Equals(present) {
+		t.Errorf("unexpected haves: %v", hs)
+	}
+	if dhs := m2.DontHaves(); len(dhs) != 1 || !dhs[0].Equals(missing) {
+		t.Errorf("unexpected dont-haves: %v", dhs)
+	}
+
+	// bitswap 1.1.0 peers only see regular wants
+	pbm := m.ToProtoV1()
+	if len(pbm.GetBlockPresences()) != 0 {
+		t.Error("presences must not be sent to bitswap 1.1.0 peers")
+	}
+	for _, e := range pbm.GetWantlist().GetEntries() {
+		if e.WantType != nil || e.SendDontHave != nil {
+			t.Error("want types must not be sent to bitswap 1.1.0 peers")
+		}
+	}
+}
@@ -17,6 +17,10 @@ var (
 	ProtocolBitswapNoVers protocol.ID = "/ipfs/bitswap"
 
 	ProtocolBitswap protocol.ID = "/ipfs/bitswap/1.1.0"
+
+	// ProtocolBitswapHave adds want-have entries and HAVE/DONT_HAVE
+	// responses to ProtocolBitswap.
+	ProtocolBitswapHave protocol.ID = "/ipfs/bitswap/1.2.0"
 )
 
 // BitSwapNetwork provides network connectivity for BitSwap sessions
@@ -38,6 +42,10 @@ type BitSwapNetwork interface {
 
 	ConnectionManager() ifconnmgr.ConnManager
 
+	// SupportsHave returns whether the peer is known to speak
+	// ProtocolBitswapHave and answers want-have entries.
+	SupportsHave(peer.ID) bool
+
 	Routing
 }
 
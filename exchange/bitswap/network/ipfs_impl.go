@@ -24,12 +24,31 @@ var log = logging.Logger("bitswap_network")
 
 var sendMessageTimeout = time.Minute * 10
 
+// DefaultMaxBlockSize is the size of the largest block accepted from peers.
+const DefaultMaxBlockSize = 2 << 20
+
+// Option configures the network.
+type Option func(*impl)
+
+// MaxBlockSize sets the size of the largest block accepted from peers. Peers
+// sending larger blocks have their stream reset.
+func MaxBlockSize(n int) Option {
+	return func(bsnet *impl) {
+		bsnet.maxBlockSize = n
+	}
+}
+
 // NewFromIpfsHost returns a BitSwapNetwork supported by underlying IPFS host
-func NewFromIpfsHost(host host.Host, r routing.ContentRouting) BitSwapNetwork {
+func NewFromIpfsHost(host host.Host, r routing.ContentRouting, opts ...Option) BitSwapNetwork {
 	bitswapNetwork := impl{
-		host:    host,
-		routing: r,
+		host:         host,
+		routing:      r,
+		maxBlockSize: DefaultMaxBlockSize,
 	}
+	for _, opt := range opts {
+		opt(&bitswapNetwork)
+	}
+	host.SetStreamHandler(ProtocolBitswapHave, bitswapNetwork.handleNewStream)
 	host.SetStreamHandler(ProtocolBitswap, bitswapNetwork.handleNewStream)
 	host.SetStreamHandler(ProtocolBitswapOne, bitswapNetwork.handleNewStream)
 	host.SetStreamHandler(ProtocolBitswapNoVers, bitswapNetwork.handleNewStream)
@@ -45,6 +64,8 @@ type impl struct {
 	host    host.Host
 	routing routing.ContentRouting
 
+	maxBlockSize int
+
 	// inbound messages from the network are forwarded to the receiver
 	receiver Receiver
 }
@@ -76,6 +97,11 @@ func msgToStream(ctx context.Context, s inet.Stream, msg bsmsg.BitSwapMessage) e
 	}
 
 	switch s.Protocol() {
+	case ProtocolBitswapHave:
+		if err := msg.ToNetV2(s); err != nil {
+			log.Debugf("error: %s", err)
+			return err
+		}
 	case ProtocolBitswap:
 		if err := msg.ToNetV1(s); err != nil {
 			log.Debugf("error: %s", err)
@@ -106,7 +132,13 @@ func (bsnet *impl) NewMessageSender(ctx context.Context, p peer.ID) (MessageSend
 }
 
 func (bsnet *impl) newStreamToPeer(ctx context.Context, p peer.ID) (inet.Stream, error) {
-	return bsnet.host.NewStream(ctx, p, ProtocolBitswap, ProtocolBitswapOne, ProtocolBitswapNoVers)
+	return bsnet.host.NewStream(ctx, p, ProtocolBitswapHave, ProtocolBitswap, ProtocolBitswapOne, ProtocolBitswapNoVers)
+}
+
+// SupportsHave uses the protocols the peer announced during identify.
+func (bsnet *impl) SupportsHave(p peer.ID) bool {
+	protos, err := bsnet.host.Peerstore().SupportsProtocols(p, string(ProtocolBitswapHave))
+	return err == nil && len(protos) > 0
 }
 
 func (bsnet *impl) SendMessage(
@@ -199,12 +231,31 @@ func (bsnet *impl) handleNewStream(s inet.Stream) {
 		}
 
 		p := s.Conn().RemotePeer()
+		if err := bsnet.checkBlockSizes(received); err != nil {
+			s.Reset()
+			go bsnet.receiver.ReceiveError(err)
+			log.Warningf("bitswap net handleNewStream from %s: %s", p, err)
+			return
+		}
+
 		ctx := context.Background()
 		log.Debugf("bitswap net handleNewStream from %s", s.Conn().RemotePeer())
 		bsnet.receiver.ReceiveMessage(ctx, p, received)
 	}
 }
 
+// checkBlockSizes returns an error if the message has a block larger than
+// the maximum block size.
+func (bsnet *impl) checkBlockSizes(m bsmsg.BitSwapMessage) error {
+	for _, b := range m.Blocks() {
+		if len(b.RawData()) > bsnet.maxBlockSize {
+			return fmt.Errorf("block %s of %d bytes is larger than the %d bytes limit",
+				b.Cid(), len(b.RawData()), bsnet.maxBlockSize)
+		}
+	}
+	return nil
+}
+
 func (bsnet *impl) ConnectionManager() ifconnmgr.ConnManager {
 	return bsnet.host.ConnManager()
 }
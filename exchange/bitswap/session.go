@@ -3,8 +3,10 @@ package bitswap
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
+	exchange "github.com/ipfs/go-ipfs/exchange"
 	notifications "github.com/ipfs/go-ipfs/exchange/bitswap/notifications"
 
 	logging "gx/ipfs/QmRb5jh8z2E8hMGN2tkvs1yHynUanqnZ3UeKwgN1i9P1F8/go-log"
@@ -23,17 +25,22 @@ const activeWantsLimit = 16
 type Session struct {
 	ctx            context.Context
 	tofetch        *cidQueue
-	activePeers    map[peer.ID]struct{}
+	activePeers    map[peer.ID]*sessionPeer
 	activePeersArr []peer.ID
 
 	bs           *Bitswap
 	incoming     chan blkRecv
-	newReqs      chan []*cid.Cid
+	presences    chan presenceRecv
+	newReqs      chan wantReq
+	reprioReqs   chan wantReq
 	cancelKeys   chan []*cid.Cid
 	interestReqs chan interestReq
+	statReqs     chan chan *SessionStat
 
 	interest  *lru.Cache
 	liveWants map[string]time.Time
+	wants     map[string]*wantInfo
+	prios     map[string]int // priorities given to live and queued wants
 
 	tick          *time.Timer
 	baseTickDelay time.Duration
@@ -53,15 +60,20 @@ type Session struct {
 // given context
 func (bs *Bitswap) NewSession(ctx context.Context) *Session {
 	s := &Session{
-		activePeers:   make(map[peer.ID]struct{}),
+		activePeers:   make(map[peer.ID]*sessionPeer),
 		liveWants:     make(map[string]time.Time),
-		newReqs:       make(chan []*cid.Cid),
+		wants:         make(map[string]*wantInfo),
+		prios:         make(map[string]int),
+		newReqs:       make(chan wantReq),
+		reprioReqs:    make(chan wantReq),
 		cancelKeys:    make(chan []*cid.Cid),
 		tofetch:       newCidQueue(),
 		interestReqs:  make(chan interestReq),
+		statReqs:      make(chan chan *SessionStat),
 		ctx:           ctx,
 		bs:            bs,
 		incoming:      make(chan blkRecv),
+		presences:     make(chan presenceRecv),
 		notif:         notifications.New(),
 		uuid:          loggables.Uuid("GetBlockRequest"),
 		baseTickDelay: time.Millisecond * 500,
@@ -82,6 +94,12 @@ func (bs *Bitswap) NewSession(ctx context.Context) *Session {
 	return s
 }
 
+// NewSessionFetcher implements exchange.SessionExchange, returning a new
+// session.
+func (bs *Bitswap) NewSessionFetcher(ctx context.Context) exchange.Fetcher {
+	return bs.NewSession(ctx)
+}
+
 func (bs *Bitswap) removeSession(s *Session) {
 	s.notif.Shutdown()
 
@@ -115,6 +133,26 @@ func (s *Session) receiveBlockFrom(from peer.ID, blk blocks.Block) {
 	}
 }
 
+type presenceRecv struct {
+	from peer.ID
+	c    *cid.Cid
+	have bool
+}
+
+// receivePresence tells the session whether the peer has the block.
+func (s *Session) receivePresence(from peer.ID, c *cid.Cid, have bool) {
+	select {
+	case s.presences <- presenceRecv{from: from, c: c, have: have}:
+	case <-s.ctx.Done():
+	}
+}
+
+// wantReq asks for blocks, or changes their priorities.
+type wantReq struct {
+	keys  []*cid.Cid
+	prios []int // nil when no priorities are given
+}
+
 type interestReq struct {
 	c    *cid.Cid
 	resp chan bool
@@ -154,7 +192,7 @@ const provSearchDelay = time.Second * 10
 
 func (s *Session) addActivePeer(p peer.ID) {
 	if _, ok := s.activePeers[p]; !ok {
-		s.activePeers[p] = struct{}{}
+		s.activePeers[p] = new(sessionPeer)
 		s.activePeersArr = append(s.activePeersArr, p)
 
 		cmgr := s.bs.network.ConnectionManager()
@@ -183,59 +221,33 @@ func (s *Session) run(ctx context.Context) {
 				s.addActivePeer(blk.from)
 			}
 
-			s.receiveBlock(ctx, blk.blk)
+			s.receiveBlock(ctx, blk.from, blk.blk)
 
 			s.resetTick()
-		case keys := <-s.newReqs:
-			for _, k := range keys {
-				s.interest.Add(k.KeyString(), nil)
-			}
-			if len(s.liveWants) < activeWantsLimit {
-				toadd := activeWantsLimit - len(s.liveWants)
-				if toadd > len(keys) {
-					toadd = len(keys)
-				}
-
-				now := keys[:toadd]
-				keys = keys[toadd:]
-
-				s.wantBlocks(ctx, now)
-			}
-			for _, k := range keys {
-				s.tofetch.Push(k)
-			}
+		case pr := <-s.presences:
+			s.receivePresenceInternal(ctx, pr)
+		case req := <-s.newReqs:
+			s.addWants(ctx, req)
+		case req := <-s.reprioReqs:
+			s.reprioritize(ctx, req)
 		case keys := <-s.cancelKeys:
 			s.cancel(keys)
 
 		case <-s.tick.C:
 			live := make([]*cid.Cid, 0, len(s.liveWants))
-			now := time.Now()
 			for c := range s.liveWants {
 				cs, _ := cid.Cast([]byte(c))
 				live = append(live, cs)
-				s.liveWants[c] = now
 			}
 
-			// Broadcast these keys to everyone we're connected to
-			s.bs.wm.WantBlocks(ctx, live, nil, s.id)
-
-			if len(live) > 0 {
-				go func(k *cid.Cid) {
-					// TODO: have a task queue setup for this to:
-					// - rate limit
-					// - manage timeouts
-					// - ensure two 'findprovs' calls for the same block don't run concurrently
-					// - share peers between sessions based on interest set
-					for p := range s.bs.network.FindProvidersAsync(ctx, k, 10) {
-						newpeers <- p
-					}
-				}(live[0])
-			}
+			s.widen(ctx, live, newpeers)
 			s.resetTick()
 		case p := <-newpeers:
 			s.addActivePeer(p)
 		case lwchk := <-s.interestReqs:
 			lwchk.resp <- s.cidIsWanted(lwchk.c)
+		case resp := <-s.statReqs:
+			resp <- s.stat()
 		case <-ctx.Done():
 			s.tick.Stop()
 			s.bs.removeSession(s)
@@ -258,37 +270,294 @@ func (s *Session) cidIsWanted(c *cid.Cid) bool {
 	return ok
 }
 
-func (s *Session) receiveBlock(ctx context.Context, blk blocks.Block) {
+func (s *Session) receiveBlock(ctx context.Context, from peer.ID, blk blocks.Block) {
 	c := blk.Cid()
 	if s.cidIsWanted(c) {
 		ks := c.KeyString()
 		tval, ok := s.liveWants[ks]
 		if ok {
-			s.latTotal += time.Since(tval)
+			lat := time.Since(tval)
+			s.latTotal += lat
+			if sp, ok := s.activePeers[from]; ok {
+				sp.blockReceived(len(blk.RawData()), lat)
+			}
 			delete(s.liveWants, ks)
+			delete(s.wants, ks)
 		} else {
 			s.tofetch.Remove(c)
 		}
+		delete(s.prios, ks)
 		s.fetchcnt++
 		s.notif.Publish(blk)
 
-		if next := s.tofetch.Pop(); next != nil {
+		if next := s.tofetch.PopBest(s.priority); next != nil {
 			s.wantBlocks(ctx, []*cid.Cid{next})
 		}
 	}
 }
 
+// addWants starts fetching the requested blocks, up to activeWantsLimit live
+// wants, and queues the others.
+func (s *Session) addWants(ctx context.Context, req wantReq) {
+	keys := req.keys
+	for i, k := range keys {
+		s.interest.Add(k.KeyString(), nil)
+		if req.prios != nil {
+			s.prios[k.KeyString()] = req.prios[i]
+		}
+	}
+	if req.prios != nil {
+		keys = make([]*cid.Cid, len(req.keys))
+		copy(keys, req.keys)
+		sort.SliceStable(keys, func(i, j int) bool {
+			return s.prios[keys[i].KeyString()] > s.prios[keys[j].KeyString()]
+		})
+	}
+
+	if len(s.liveWants) < activeWantsLimit {
+		toadd := activeWantsLimit - len(s.liveWants)
+		if toadd > len(keys) {
+			toadd = len(keys)
+		}
+
+		now := keys[:toadd]
+		keys = keys[toadd:]
+
+		s.wantBlocks(ctx, now)
+	}
+	for _, k := range keys {
+		s.tofetch.Push(k)
+	}
+	s.promote(ctx)
+}
+
+// reprioritize changes the priorities of live and queued wants, and tells
+// the peers the live ones were sent to.
+func (s *Session) reprioritize(ctx context.Context, req wantReq) {
+	var live []*cid.Cid
+	var prios []int
+	for i, k := range req.keys {
+		if !s.cidIsWanted(k) {
+			continue
+		}
+		ks := k.KeyString()
+		s.prios[ks] = req.prios[i]
+		if _, ok := s.liveWants[ks]; ok {
+			live = append(live, k)
+			prios = append(prios, req.prios[i])
+		}
+	}
+
+	if len(live) > 0 {
+		s.bs.wm.Reprioritize(ctx, live, prios, s.id)
+	}
+	s.promote(ctx)
+}
+
+// promote starts fetching the queued want of highest priority if it is more
+// urgent than all live wants, even above activeWantsLimit, so the block a
+// reader waits on does not wait for prefetched ones.
+func (s *Session) promote(ctx context.Context) {
+	c := s.tofetch.Best(s.priority)
+	if c == nil {
+		return
+	}
+	prio, ok := s.priority(c)
+	if !ok {
+		return
+	}
+	for k := range s.liveWants {
+		if lp, ok := s.prios[k]; !ok || lp >= prio {
+			return
+		}
+	}
+	s.tofetch.Remove(c)
+	s.wantBlocks(ctx, []*cid.Cid{c})
+}
+
+// priority returns the priority given to the want of the cid, if any.
+func (s *Session) priority(c *cid.Cid) (int, bool) {
+	p, ok := s.prios[c.KeyString()]
+	return p, ok
+}
+
+// priorities returns the priorities to send for the wants of the cids, or
+// nil if none was given a priority. Wants without priority get decreasing
+// priorities in order.
+func (s *Session) priorities(ks []*cid.Cid) []int {
+	var prios []int
+	for i, c := range ks {
+		p, ok := s.prios[c.KeyString()]
+		if !ok {
+			continue
+		}
+		if prios == nil {
+			prios = make([]int, len(ks))
+			for j := range prios {
+				prios[j] = kMaxPriority - j
+			}
+		}
+		prios[i] = p
+	}
+	return prios
+}
+
 func (s *Session) wantBlocks(ctx context.Context, ks []*cid.Cid) {
 	now := time.Now()
 	for _, c := range ks {
 		s.liveWants[c.KeyString()] = now
+		s.wants[c.KeyString()] = newWantInfo()
 	}
-	s.bs.wm.WantBlocks(ctx, ks, s.activePeersArr, s.id)
+
+	if len(s.activePeersArr) == 0 {
+		// nobody to ask yet, broadcast
+		s.broadcast(ctx, ks, nil)
+		return
+	}
+	s.sendWants(ctx, ks, s.bestPeers(sessionTargetPeers))
+}
+
+// sendWants sends the wants to the given peers. Peers that support it are
+// asked whether they have the blocks, so we only request each block from one
+// of them (see receivePresenceInternal). Older peers are sent regular wants.
+func (s *Session) sendWants(ctx context.Context, ks []*cid.Cid, peers []peer.ID) {
+	var askHave, askBlock []peer.ID
+	for _, p := range peers {
+		if s.bs.network.SupportsHave(p) {
+			askHave = append(askHave, p)
+		} else {
+			askBlock = append(askBlock, p)
+		}
+	}
+	for _, c := range ks {
+		w := s.wants[c.KeyString()]
+		for _, p := range askHave {
+			w.asked[p] = true
+		}
+	}
+
+	prios := s.priorities(ks)
+	if len(askHave) > 0 {
+		s.bs.wm.WantHavesWithPriority(ctx, ks, prios, askHave, s.id)
+	}
+	if len(askBlock) > 0 {
+		s.bs.wm.WantBlocksWithPriority(ctx, ks, prios, askBlock, s.id)
+	}
+}
+
+// broadcast sends the wants to everyone we're connected to, and looks for
+// providers of the first one if newpeers is not nil.
+func (s *Session) broadcast(ctx context.Context, ks []*cid.Cid, newpeers chan<- peer.ID) {
+	for _, c := range ks {
+		s.wants[c.KeyString()].level = wantBroadcast
+	}
+	s.bs.wm.WantBlocksWithPriority(ctx, ks, s.priorities(ks), nil, s.id)
+
+	if len(ks) > 0 && newpeers != nil {
+		go func(k *cid.Cid) {
+			// TODO: have a task queue setup for this to:
+			// - rate limit
+			// - manage timeouts
+			// - ensure two 'findprovs' calls for the same block don't run concurrently
+			// - share peers between sessions based on interest set
+			for p := range s.bs.network.FindProvidersAsync(ctx, k, 10) {
+				if s.bs.engine.Allowed(p) {
+					newpeers <- p
+				}
+			}
+		}(ks[0])
+	}
+}
+
+// widen sends the wants to the next wider set of peers: from the best peers
+// to all the peers of the session, and then to everyone. Peers the blocks
+// were requested from and did not deliver are counted as failing.
+func (s *Session) widen(ctx context.Context, ks []*cid.Cid, newpeers chan<- peer.ID) {
+	now := time.Now()
+	var toActive, toAll []*cid.Cid
+	for _, c := range ks {
+		k := c.KeyString()
+		w, ok := s.wants[k]
+		if !ok {
+			continue
+		}
+		s.liveWants[k] = now
+
+		if w.requested != "" {
+			if sp, ok := s.activePeers[w.requested]; ok {
+				sp.failed()
+			}
+			w.requested = ""
+		}
+
+		if w.level == wantTargeted && len(s.activePeersArr) > sessionTargetPeers {
+			w.level = wantActive
+			toActive = append(toActive, c)
+		} else {
+			toAll = append(toAll, c)
+		}
+	}
+
+	if len(toActive) > 0 {
+		s.sendWants(ctx, toActive, s.activePeersArr)
+	}
+	if len(toAll) > 0 {
+		s.broadcast(ctx, toAll, newpeers)
+	}
+}
+
+func (s *Session) receivePresenceInternal(ctx context.Context, pr presenceRecv) {
+	w, ok := s.wants[pr.c.KeyString()]
+	if !ok {
+		return
+	}
+
+	if pr.have {
+		w.havers = append(w.havers, pr.from)
+		if w.requested == "" {
+			s.requestFrom(ctx, pr.c, w, pr.from)
+		}
+		return
+	}
+
+	if w.asked[pr.from] {
+		w.dontHaves++
+	}
+	for i, p := range w.havers {
+		if p == pr.from {
+			w.havers = append(w.havers[:i], w.havers[i+1:]...)
+			break
+		}
+	}
+	// the peer we requested the block from lost it, try the next one
+	if w.requested == pr.from {
+		if sp, ok := s.activePeers[pr.from]; ok {
+			sp.failed()
+		}
+		w.requested = ""
+		if len(w.havers) > 0 {
+			s.requestFrom(ctx, pr.c, w, w.havers[0])
+		}
+	}
+
+	// nobody we asked has the block, no need to wait for the timeout
+	if w.level != wantBroadcast && w.exhausted() {
+		s.widen(ctx, []*cid.Cid{pr.c}, nil)
+	}
+}
+
+func (s *Session) requestFrom(ctx context.Context, c *cid.Cid, w *wantInfo, p peer.ID) {
+	w.requested = p
+	ks := []*cid.Cid{c}
+	s.bs.wm.WantBlocksWithPriority(ctx, ks, s.priorities(ks), []peer.ID{p}, s.id)
 }
 
 func (s *Session) cancel(keys []*cid.Cid) {
 	for _, c := range keys {
 		s.tofetch.Remove(c)
+		if _, ok := s.liveWants[c.KeyString()]; !ok {
+			delete(s.prios, c.KeyString())
+		}
 	}
 }
 
@@ -300,8 +569,12 @@ func (s *Session) cancelWants(keys []*cid.Cid) {
 }
 
 func (s *Session) fetch(ctx context.Context, keys []*cid.Cid) {
+	s.fetchWithPriority(ctx, keys, nil)
+}
+
+func (s *Session) fetchWithPriority(ctx context.Context, keys []*cid.Cid, prios []int) {
 	select {
-	case s.newReqs <- keys:
+	case s.newReqs <- wantReq{keys: keys, prios: prios}:
 	case <-ctx.Done():
 	case <-s.ctx.Done():
 	}
@@ -315,11 +588,42 @@ func (s *Session) GetBlocks(ctx context.Context, keys []*cid.Cid) (<-chan blocks
 	return getBlocksImpl(ctx, keys, s.notif, s.fetch, s.cancelWants)
 }
 
+// GetBlocksWithPriority is like GetBlocks, with the priority of each key.
+// Blocks of higher priority are fetched first, and a block of higher
+// priority than all blocks being fetched is asked for right away.
+func (s *Session) GetBlocksWithPriority(ctx context.Context, keys []*cid.Cid, prios []int) (<-chan blocks.Block, error) {
+	if len(prios) != len(keys) {
+		return nil, fmt.Errorf("got %d priorities for %d keys", len(prios), len(keys))
+	}
+	ctx = logging.ContextWithLoggable(ctx, s.uuid)
+	fetch := func(ctx context.Context, ks []*cid.Cid) {
+		s.fetchWithPriority(ctx, ks, prios)
+	}
+	return getBlocksImpl(ctx, keys, s.notif, fetch, s.cancelWants)
+}
+
+// Reprioritize changes the priorities of blocks being fetched by the
+// session, for example when a reader seeks. Keys the session is not fetching
+// are ignored.
+func (s *Session) Reprioritize(keys []*cid.Cid, prios []int) {
+	if len(prios) != len(keys) {
+		log.Warningf("got %d priorities for %d keys", len(prios), len(keys))
+		return
+	}
+	select {
+	case s.reprioReqs <- wantReq{keys: keys, prios: prios}:
+	case <-s.ctx.Done():
+	}
+}
+
 // GetBlock fetches a single block
 func (s *Session) GetBlock(parent context.Context, k *cid.Cid) (blocks.Block, error) {
 	return getBlock(parent, k, s.GetBlocks)
 }
 
+var _ exchange.PriorityFetcher = (*Session)(nil)
+var _ exchange.SessionExchange = (*Bitswap)(nil)
+
 type cidQueue struct {
 	elems []*cid.Cid
 	eset  *cid.Set
@@ -345,6 +649,56 @@ func (cq *cidQueue) Pop() *cid.Cid {
 	}
 }
 
+// Best returns the element of highest priority, the oldest first among
+// equals, or the oldest element if none has a priority. Elements without
+// priority come after the others.
+func (cq *cidQueue) Best(prio func(*cid.Cid) (int, bool)) *cid.Cid {
+	if i := cq.best(prio); i >= 0 {
+		return cq.elems[i]
+	}
+	return nil
+}
+
+// PopBest removes and returns the element Best returns.
+func (cq *cidQueue) PopBest(prio func(*cid.Cid) (int, bool)) *cid.Cid {
+	i := cq.best(prio)
+	if i < 0 {
+		return nil
+	}
+	out := cq.elems[i]
+	cq.elems = append(cq.elems[:i], cq.elems[i+1:]...)
+	cq.eset.Remove(out)
+	return out
+}
+
+func (cq *cidQueue) best(prio func(*cid.Cid) (int, bool)) int {
+	// drop the removed elements first
+	live := cq.elems[:0]
+	for _, c := range cq.elems {
+		if cq.eset.Has(c) {
+			live = append(live, c)
+		}
+	}
+	for i := len(live); i < len(cq.elems); i++ {
+		cq.elems[i] = nil
+	}
+	cq.elems = live
+
+	if len(cq.elems) == 0 {
+		return -1
+	}
+	best, bestPrio := -1, 0
+	for i, c := range cq.elems {
+		if p, ok := prio(c); ok && (best < 0 || p > bestPrio) {
+			best, bestPrio = i, p
+		}
+	}
+	if best < 0 {
+		return 0
+	}
+	return best
+}
+
 func (cq *cidQueue) Push(c *cid.Cid) {
 	if cq.eset.Visit(c) {
 		cq.elems = append(cq.elems, c)
@@ -9,6 +9,7 @@ import (
 	blocksutil "github.com/ipfs/go-ipfs/blocks/blocksutil"
 
 	tu "gx/ipfs/QmVvkK7s5imCiq3JVbL3pGfnhcCnf3LrFJPF4GE2sAoGZf/go-testutil"
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
 	cid "gx/ipfs/QmcZfnkapfECQGcLZaf9B79NRg7cRa9EnZh4LSbkCzwNvY/go-cid"
 	blocks "gx/ipfs/Qmej7nf81hi2x2tvjRBF3mcp74sQyuDH4VMYDGd1YtXjb2/go-block-format"
 )
@@ -152,6 +153,66 @@ func TestSessionSplitFetch(t *testing.T) {
 	}
 }
 
+func TestSessionAsksHaveBeforeBlock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vnet := getVirtualNetwork()
+	sesgen := NewTestSessionGenerator(vnet)
+	defer sesgen.Close()
+	bgen := blocksutil.NewBlockGenerator()
+
+	inst := sesgen.Instances(4)
+
+	blks := bgen.Blocks(11)
+	for _, is := range inst[:3] {
+		if err := is.Blockstore().PutMany(blks); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var cids []*cid.Cid
+	for _, blk := range blks {
+		cids = append(cids, blk.Cid())
+	}
+
+	// the first block is broadcast, and all peers having it become active
+	// peers of the session
+	ses := inst[3].Exchange.NewSession(ctx)
+	if _, err := ses.GetBlock(ctx, cids[0]); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 50)
+
+	bs := inst[3].Exchange
+	bs.counterLk.Lock()
+	dups := bs.counters.dupBlocksRecvd
+	bs.counterLk.Unlock()
+
+	ch, err := ses.GetBlocks(ctx, cids[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []blocks.Block
+	for b := range ch {
+		got = append(got, b)
+	}
+	if err := assertBlockLists(got, blks[1:]); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := bs.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.HavesReceived == 0 {
+		t.Fatal("expected to receive HAVE answers")
+	}
+	if st.DupBlksReceived != dups {
+		t.Fatalf("received %d duplicate blocks", st.DupBlksReceived-dups)
+	}
+}
+
 func TestInterestCacheOverflow(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -324,3 +385,107 @@ func TestWantlistClearsOnCancel(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestSessionBestPeers(t *testing.T) {
+	fast := tu.RandPeerIDFatal(t)
+	slow := tu.RandPeerIDFatal(t)
+	failing := tu.RandPeerIDFatal(t)
+	unknown := tu.RandPeerIDFatal(t)
+
+	s := &Session{
+		activePeers:    make(map[peer.ID]*sessionPeer),
+		activePeersArr: []peer.ID{failing, slow, unknown, fast},
+		latTotal:       time.Millisecond * 200,
+		fetchcnt:       4,
+	}
+	for _, p := range s.activePeersArr {
+		s.activePeers[p] = new(sessionPeer)
+	}
+	s.activePeers[fast].blockReceived(100, time.Millisecond*10)
+	s.activePeers[slow].blockReceived(100, time.Millisecond*100)
+	s.activePeers[failing].blockReceived(100, time.Millisecond*20)
+	for i := 0; i < 5; i++ {
+		s.activePeers[failing].failed()
+	}
+
+	best := s.bestPeers(2)
+	if len(best) != 2 || best[0] != fast || best[1] != unknown {
+		t.Fatal("unexpected best peers", best)
+	}
+	if all := s.bestPeers(10); len(all) != 4 {
+		t.Fatal("expected all peers", all)
+	}
+}
+
+func TestSessionPriorities(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vnet := getVirtualNetwork()
+	sesgen := NewTestSessionGenerator(vnet)
+	defer sesgen.Close()
+	bgen := blocksutil.NewBlockGenerator()
+
+	inst := sesgen.Instances(2)
+	a := inst[0]
+	blks := bgen.Blocks(2)
+	cids := []*cid.Cid{blks[0].Cid(), blks[1].Cid()}
+
+	ses := a.Exchange.NewSession(ctx)
+	if _, err := ses.GetBlocksWithPriority(ctx, cids, []int{5, 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	waitPriority := func(c *cid.Cid, prio int) {
+		for i := 0; i < 100; i++ {
+			if e, ok := a.Exchange.wm.wl.Contains(c); ok && e.Priority == prio {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("%s never got priority %d", c, prio)
+	}
+	waitPriority(cids[0], 5)
+	waitPriority(cids[1], 10)
+
+	ses.Reprioritize(cids[:1], []int{20})
+	waitPriority(cids[0], 20)
+
+	if _, err := ses.GetBlocksWithPriority(ctx, cids, []int{1}); err == nil {
+		t.Fatal("expected an error for missing priorities")
+	}
+}
+
+func TestCidQueueBest(t *testing.T) {
+	cids := make([]*cid.Cid, 5)
+	for i, b := range blocksutil.NewBlockGenerator().Blocks(5) {
+		cids[i] = b.Cid()
+	}
+
+	prios := map[string]int{
+		cids[1].KeyString(): 3,
+		cids[2].KeyString(): 7,
+		cids[3].KeyString(): 7,
+		cids[4].KeyString(): 9,
+	}
+	prio := func(c *cid.Cid) (int, bool) {
+		p, ok := prios[c.KeyString()]
+		return p, ok
+	}
+
+	cq := newCidQueue()
+	for _, c := range cids {
+		cq.Push(c)
+	}
+	cq.Remove(cids[4])
+
+	// highest priority first, oldest first among equals, then the others
+	for _, exp := range []*cid.Cid{cids[2], cids[3], cids[1], cids[0]} {
+		if c := cq.PopBest(prio); !c.Equals(exp) {
+			t.Fatalf("expected %s, got %s", exp, c)
+		}
+	}
+	if cq.PopBest(prio) != nil || cq.Len() != 0 {
+		t.Fatal("queue should be empty")
+	}
+}
@@ -0,0 +1,185 @@
+package bitswap
+
+import (
+	"sort"
+	"time"
+
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+)
+
+// sessionTargetPeers is the number of best peers new wants are first sent to.
+const sessionTargetPeers = 3
+
+// Levels a want is widened through when it times out.
+const (
+	wantTargeted  = iota // sent to the best peers of the session
+	wantActive           // sent to all the peers of the session
+	wantBroadcast        // sent to everyone we are connected to
+)
+
+// wantInfo tracks a live want of a session.
+type wantInfo struct {
+	level int
+
+	// peers asked whether they have the block, and the ones that answered
+	// they don't
+	asked     map[peer.ID]bool
+	dontHaves int
+
+	// peers that announced to have the block, and the one the block was
+	// requested from
+	havers    []peer.ID
+	requested peer.ID
+}
+
+func newWantInfo() *wantInfo {
+	return &wantInfo{asked: make(map[peer.ID]bool)}
+}
+
+// exhausted returns whether all the peers asked for the block answered they
+// don't have it.
+func (w *wantInfo) exhausted() bool {
+	return len(w.asked) > 0 && w.dontHaves >= len(w.asked) && len(w.havers) == 0
+}
+
+// sessionPeer tracks how well a peer served a session.
+type sessionPeer struct {
+	received int
+	bytes    uint64
+	failures int
+
+	// latency is a moving average of the time between wanting and receiving
+	// a block, busy is the total of those times.
+	latency time.Duration
+	busy    time.Duration
+}
+
+func (sp *sessionPeer) blockReceived(size int, lat time.Duration) {
+	if sp.received == 0 {
+		sp.latency = lat
+	} else {
+		sp.latency = (sp.latency*7 + lat) / 8
+	}
+	sp.received++
+	sp.bytes += uint64(size)
+	sp.busy += lat
+}
+
+func (sp *sessionPeer) failed() {
+	sp.failures++
+}
+
+func (sp *sessionPeer) throughput() float64 {
+	if sp.busy <= 0 {
+		return 0
+	}
+	return float64(sp.bytes) / sp.busy.Seconds()
+}
+
+// cost estimates the time the peer takes to deliver a block, penalised by its
+// failure rate. def is used for peers that delivered nothing yet.
+func (sp *sessionPeer) cost(def time.Duration) float64 {
+	lat := sp.latency
+	if sp.received == 0 {
+		lat = def
+	}
+	failRate := float64(sp.failures) / float64(sp.failures+sp.received+1)
+	return float64(lat) * (1 + 4*failRate)
+}
+
+// bestPeers returns up to n active peers, fastest first.
+func (s *Session) bestPeers(n int) []peer.ID {
+	if n >= len(s.activePeersArr) {
+		return s.activePeersArr
+	}
+
+	var def time.Duration
+	if s.fetchcnt > 0 {
+		def = s.latTotal / time.Duration(s.fetchcnt)
+	}
+
+	peers := make([]peer.ID, len(s.activePeersArr))
+	copy(peers, s.activePeersArr)
+	sort.SliceStable(peers, func(i, j int) bool {
+		return s.activePeers[peers[i]].cost(def) < s.activePeers[peers[j]].cost(def)
+	})
+	return peers[:n]
+}
+
+// SessionStat describes a bitswap session.
+type SessionStat struct {
+	ID         uint64
+	LiveWants  int
+	Queued     int
+	Fetched    int
+	AvgLatency time.Duration
+	Peers      []SessionPeerStat
+}
+
+// SessionPeerStat describes how well a peer served a session.
+type SessionPeerStat struct {
+	Peer       string
+	Received   int
+	Bytes      uint64
+	Failures   int
+	Latency    time.Duration
+	Throughput float64 // bytes per second
+}
+
+func (s *Session) stat() *SessionStat {
+	st := &SessionStat{
+		ID:        s.id,
+		LiveWants: len(s.liveWants),
+		Queued:    s.tofetch.Len(),
+		Fetched:   s.fetchcnt,
+		Peers:     make([]SessionPeerStat, 0, len(s.activePeersArr)),
+	}
+	if s.fetchcnt > 0 {
+		st.AvgLatency = s.latTotal / time.Duration(s.fetchcnt)
+	}
+	for _, p := range s.bestPeers(len(s.activePeersArr)) {
+		sp := s.activePeers[p]
+		st.Peers = append(st.Peers, SessionPeerStat{
+			Peer:       p.Pretty(),
+			Received:   sp.received,
+			Bytes:      sp.bytes,
+			Failures:   sp.failures,
+			Latency:    sp.latency,
+			Throughput: sp.throughput(),
+		})
+	}
+	return st
+}
+
+// Stat returns the statistics of the session.
+func (s *Session) Stat() *SessionStat {
+	resp := make(chan *SessionStat, 1)
+	select {
+	case s.statReqs <- resp:
+	case <-s.ctx.Done():
+		return nil
+	}
+
+	select {
+	case st := <-resp:
+		return st
+	case <-s.ctx.Done():
+		return nil
+	}
+}
+
+// SessionStats returns the statistics of all running sessions.
+func (bs *Bitswap) SessionStats() []*SessionStat {
+	bs.sessLk.Lock()
+	sessions := make([]*Session, len(bs.sessions))
+	copy(sessions, bs.sessions)
+	bs.sessLk.Unlock()
+
+	out := make([]*SessionStat, 0, len(sessions))
+	for _, s := range sessions {
+		if st := s.Stat(); st != nil {
+			out = append(out, st)
+		}
+	}
+	return out
+}
@@ -0,0 +1,135 @@
+package sim
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+)
+
+// PeerReport reports on a peer of a simulation.
+type PeerReport struct {
+	Peer peer.ID
+	Role string
+
+	BlocksReceived uint64
+	DupBlocks      uint64
+	DataReceived   uint64
+	DataSent       uint64
+
+	// FetchTime is the time the leecher took to fetch its blocks.
+	FetchTime time.Duration
+
+	// Left is true if the leecher left before fetching all the blocks.
+	Left bool
+
+	// Failed is true if the leecher did not get its blocks in time.
+	Failed bool
+}
+
+// Report reports on a simulation.
+type Report struct {
+	Duration time.Duration
+	Peers    []PeerReport
+
+	// DupRatio is the ratio of duplicate blocks to the blocks received by
+	// all peers.
+	DupRatio float64
+
+	// Fetch times of the leechers which fetched all the blocks.
+	MeanFetch time.Duration
+	P50Fetch  time.Duration
+	P90Fetch  time.Duration
+	MaxFetch  time.Duration
+
+	// Failed is the number of leechers which did not get their blocks.
+	Failed int
+}
+
+func (s *simulation) report(d time.Duration) *Report {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	rep := &Report{Duration: d}
+	var recvd, dups uint64
+	var times []time.Duration
+	for _, sp := range s.peers {
+		st, err := sp.inst.Exchange.Stat()
+		if err != nil {
+			log.Errorf("getting stats of %s: %s", sp.inst.Peer, err)
+			continue
+		}
+
+		pr := PeerReport{
+			Peer:           sp.inst.Peer,
+			Role:           sp.role,
+			BlocksReceived: st.BlocksReceived,
+			DupBlocks:      st.DupBlksReceived,
+			DataReceived:   st.DataReceived,
+			DataSent:       st.DataSent,
+			FetchTime:      sp.fetchTime,
+			Left:           sp.left,
+			Failed:         sp.failed,
+		}
+		rep.Peers = append(rep.Peers, pr)
+
+		recvd += st.BlocksReceived
+		dups += st.DupBlksReceived
+		switch {
+		case sp.failed:
+			rep.Failed++
+		case sp.role == RoleLeecher && !sp.left:
+			times = append(times, sp.fetchTime)
+		}
+	}
+
+	if recvd > 0 {
+		rep.DupRatio = float64(dups) / float64(recvd)
+	}
+	if len(times) > 0 {
+		sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+		var sum time.Duration
+		for _, t := range times {
+			sum += t
+		}
+		rep.MeanFetch = sum / time.Duration(len(times))
+		rep.P50Fetch = times[len(times)*50/100]
+		rep.P90Fetch = times[len(times)*90/100]
+		rep.MaxFetch = times[len(times)-1]
+	}
+	return rep
+}
+
+// String formats the report as a table of the peers followed by a summary.
+func (r *Report) String() string {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "%-10s %-8s %8s %8s %12s %12s %10s\n",
+		"peer", "role", "blocks", "dups", "recv", "sent", "fetch")
+	for _, p := range r.Peers {
+		fetch := ""
+		switch {
+		case p.Left:
+			fetch = "left"
+		case p.Failed:
+			fetch = "failed"
+		case p.Role == RoleLeecher:
+			fetch = p.FetchTime.String()
+		}
+		fmt.Fprintf(buf, "%-10s %-8s %8d %8d %12d %12d %10s\n",
+			shortID(p.Peer), p.Role, p.BlocksReceived, p.DupBlocks,
+			p.DataReceived, p.DataSent, fetch)
+	}
+	fmt.Fprintf(buf, "duration %s, dup ratio %.3f, failed %d\n", r.Duration, r.DupRatio, r.Failed)
+	fmt.Fprintf(buf, "fetch mean %s, p50 %s, p90 %s, max %s\n", r.MeanFetch, r.P50Fetch, r.P90Fetch, r.MaxFetch)
+	return buf.String()
+}
+
+func shortID(p peer.ID) string {
+	s := p.Pretty()
+	if len(s) > 8 {
+		return s[len(s)-8:]
+	}
+	return s
+}
@@ -0,0 +1,297 @@
+// Package sim simulates bitswap swarms. It runs in-process bitswap instances
+// over a virtual network whose links have their own latency, bandwidth and
+// loss, runs a workload of seeders, leechers and churn, and reports how well
+// the blocks spread.
+package sim
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	exchange "github.com/ipfs/go-ipfs/exchange"
+	bitswap "github.com/ipfs/go-ipfs/exchange/bitswap"
+	tn "github.com/ipfs/go-ipfs/exchange/bitswap/testnet"
+
+	delay "gx/ipfs/QmRJVNatYJwTAHgdSM1Xef9QVQ1Ch3XHdmcrykjP5Y4soL/go-ipfs-delay"
+	logging "gx/ipfs/QmRb5jh8z2E8hMGN2tkvs1yHynUanqnZ3UeKwgN1i9P1F8/go-log"
+	mockrouting "gx/ipfs/QmXtoXbu9ReyV6Q4kDQ5CF9wXQNDY1PdHc4HhfxRR5AHB3/go-ipfs-routing/mock"
+	p2ptestutil "gx/ipfs/QmYVR3C8DWPHdHxvLtNFYfjsXgaRAdh6hPMNH3KiwCgu4o/go-libp2p-netutil"
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+	cid "gx/ipfs/QmcZfnkapfECQGcLZaf9B79NRg7cRa9EnZh4LSbkCzwNvY/go-cid"
+	blocks "gx/ipfs/Qmej7nf81hi2x2tvjRBF3mcp74sQyuDH4VMYDGd1YtXjb2/go-block-format"
+)
+
+var log = logging.Logger("bitswap/sim")
+
+// LinkDist describes the distributions the models of the links between
+// peers are drawn from. Each direction of a link is drawn separately.
+type LinkDist struct {
+	// Latency of messages follows a normal distribution.
+	Latency    time.Duration
+	LatencyStd time.Duration
+
+	// Bandwidth of links is drawn uniformly within BandwidthSpread of
+	// Bandwidth, in bytes per second. 0 means no limit.
+	Bandwidth       float64
+	BandwidthSpread float64
+
+	// Loss is the probability of a message being lost.
+	Loss float64
+}
+
+// Config describes a simulation.
+type Config struct {
+	Seeders  int // peers having all the blocks from the start
+	Leechers int // peers fetching all the blocks
+
+	Blocks    int // number of blocks fetched
+	BlockSize int // size of the blocks in bytes
+
+	Links LinkDist
+
+	// Stagger is the time over which leechers join, uniformly. They all
+	// start at once if zero.
+	Stagger time.Duration
+
+	// Churn is the probability of a leecher leaving after fetching half of
+	// the blocks. A new leecher joins in its place.
+	Churn float64
+
+	// Sessions makes leechers fetch the blocks in a bitswap session.
+	Sessions bool
+
+	// Timeout bounds the fetch of each leecher, 0 for a minute.
+	Timeout time.Duration
+
+	// Seed seeds the random choices of the simulation, for repeatable runs.
+	Seed int64
+}
+
+// Roles of the peers of a simulation.
+const (
+	RoleSeeder  = "seeder"
+	RoleLeecher = "leecher"
+)
+
+type simPeer struct {
+	inst   bitswap.Instance
+	role   string
+	cancel context.CancelFunc
+
+	fetchTime time.Duration
+	fetched   int
+	left      bool
+	failed    bool
+}
+
+type simulation struct {
+	cfg Config
+	net tn.ChurnNetwork
+
+	lk    sync.Mutex
+	rng   *rand.Rand
+	links map[[2]peer.ID]tn.LinkModel
+	peers []*simPeer
+}
+
+// Run runs the simulation until all leechers fetched the blocks, left or
+// timed out, and reports on it.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if cfg.Seeders <= 0 || cfg.Leechers <= 0 || cfg.Blocks <= 0 || cfg.BlockSize <= 0 {
+		return nil, errors.New("simulation needs seeders, leechers and blocks")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Minute
+	}
+
+	s := &simulation{
+		cfg:   cfg,
+		rng:   rand.New(rand.NewSource(cfg.Seed)),
+		links: make(map[[2]peer.ID]tn.LinkModel),
+	}
+	s.net = tn.VirtualNetworkWithLinks(mockrouting.NewServer(), s.link, cfg.Seed)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	blks := make([]blocks.Block, cfg.Blocks)
+	keys := make([]*cid.Cid, cfg.Blocks)
+	for i := range blks {
+		data := make([]byte, cfg.BlockSize)
+		s.rng.Read(data)
+		blks[i] = blocks.NewBlock(data)
+		keys[i] = blks[i].Cid()
+	}
+
+	// the leechers are drawn before any peer joins: links are drawn, with
+	// s.lk held, while the peers exchange messages
+	waits := make([]time.Duration, cfg.Leechers)
+	leaves := make([]bool, cfg.Leechers)
+	for i := range waits {
+		if cfg.Stagger > 0 {
+			waits[i] = time.Duration(s.rng.Int63n(int64(cfg.Stagger)))
+		}
+		leaves[i] = s.rng.Float64() < cfg.Churn
+	}
+
+	for i := 0; i < cfg.Seeders; i++ {
+		sp, err := s.join(ctx, RoleSeeder)
+		if err != nil {
+			return nil, err
+		}
+		if err := sp.inst.Blockstore().PutMany(blks); err != nil {
+			return nil, err
+		}
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range waits {
+		wg.Add(1)
+		go func(wait time.Duration, leave bool) {
+			defer wg.Done()
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+			s.leech(ctx, keys, leave)
+		}(waits[i], leaves[i])
+	}
+	wg.Wait()
+
+	rep := s.report(time.Since(start))
+	for _, sp := range s.peers {
+		if !sp.left {
+			sp.cancel()
+			sp.inst.Exchange.Close()
+		}
+	}
+	return rep, nil
+}
+
+// link returns the model of the link, drawing it the first time. The
+// latency of each link draws from its own source, as it is drawn by the
+// network without s.lk held.
+func (s *simulation) link(from, to peer.ID) tn.LinkModel {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	k := [2]peer.ID{from, to}
+	if l, ok := s.links[k]; ok {
+		return l
+	}
+
+	d := s.cfg.Links
+	l := tn.LinkModel{Loss: d.Loss}
+	if d.Latency > 0 || d.LatencyStd > 0 {
+		rng := rand.New(rand.NewSource(s.rng.Int63()))
+		l.Latency = delay.VariableNormal(d.Latency, d.LatencyStd, rng)
+	}
+	if d.Bandwidth > 0 {
+		l.Bandwidth = d.Bandwidth + d.BandwidthSpread*(2*s.rng.Float64()-1)
+		if l.Bandwidth <= 0 {
+			l.Bandwidth = d.Bandwidth
+		}
+	}
+	s.links[k] = l
+	return l
+}
+
+// join adds a peer to the network, connected to all the peers present.
+func (s *simulation) join(ctx context.Context, role string) (*simPeer, error) {
+	ident, err := p2ptestutil.RandTestBogusIdentity()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	sp := &simPeer{
+		inst:   bitswap.MkSession(ctx, s.net, ident),
+		role:   role,
+		cancel: cancel,
+	}
+
+	s.lk.Lock()
+	others := make([]*simPeer, 0, len(s.peers))
+	for _, o := range s.peers {
+		if !o.left {
+			others = append(others, o)
+		}
+	}
+	s.peers = append(s.peers, sp)
+	s.lk.Unlock()
+
+	for _, o := range others {
+		if err := s.net.ConnectPeers(sp.inst.Peer, o.inst.Peer); err != nil {
+			return nil, err
+		}
+	}
+	return sp, nil
+}
+
+// leave removes the peer from the network.
+func (s *simulation) leave(sp *simPeer) {
+	s.lk.Lock()
+	sp.left = true
+	s.lk.Unlock()
+
+	sp.cancel()
+	sp.inst.Exchange.Close()
+	s.net.RemovePeer(sp.inst.Peer)
+}
+
+// leech joins a leecher fetching the blocks. A leecher that leaves is
+// replaced by a new one.
+func (s *simulation) leech(ctx context.Context, keys []*cid.Cid, leave bool) {
+	sp, err := s.join(ctx, RoleLeecher)
+	if err != nil {
+		log.Errorf("joining leecher: %s", err)
+		return
+	}
+
+	want := len(keys)
+	if leave {
+		want = len(keys) / 2
+	}
+
+	fctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+
+	var f exchange.Fetcher = sp.inst.Exchange
+	if s.cfg.Sessions {
+		f = sp.inst.Exchange.NewSession(fctx)
+	}
+
+	start := time.Now()
+	out, err := f.GetBlocks(fctx, keys)
+	if err != nil {
+		log.Errorf("fetching blocks: %s", err)
+		s.lk.Lock()
+		sp.failed = true
+		s.lk.Unlock()
+		return
+	}
+
+	fetched := 0
+	for range out {
+		fetched++
+		if fetched == want {
+			break
+		}
+	}
+	cancel()
+
+	s.lk.Lock()
+	sp.fetched = fetched
+	sp.fetchTime = time.Since(start)
+	sp.failed = fetched < want
+	s.lk.Unlock()
+
+	if leave {
+		s.leave(sp)
+		s.leech(ctx, keys, false)
+	}
+}
@@ -0,0 +1,152 @@
+package sim
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSim(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	rep, err := Run(context.Background(), Config{
+		Seeders:   1,
+		Leechers:  3,
+		Blocks:    20,
+		BlockSize: 1024,
+		Links: LinkDist{
+			Latency:    5 * time.Millisecond,
+			LatencyStd: time.Millisecond,
+			Bandwidth:  1 << 20,
+		},
+		Sessions: true,
+		Timeout:  20 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log("\n" + rep.String())
+
+	if rep.Failed > 0 {
+		t.Fatalf("%d leechers failed to fetch the blocks", rep.Failed)
+	}
+	if len(rep.Peers) != 4 {
+		t.Fatalf("expected 4 peers, got %d", len(rep.Peers))
+	}
+	for _, p := range rep.Peers {
+		if p.Role == RoleLeecher && p.BlocksReceived < 20 {
+			t.Fatalf("leecher %s received %d blocks", p.Peer, p.BlocksReceived)
+		}
+	}
+}
+
+func TestSimChurn(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	rep, err := Run(context.Background(), Config{
+		Seeders:   2,
+		Leechers:  4,
+		Blocks:    20,
+		BlockSize: 512,
+		Churn:     1,
+		Timeout:   20 * time.Second,
+		Seed:      1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	left := 0
+	for _, p := range rep.Peers {
+		if p.Left {
+			left++
+		}
+	}
+	if left != 4 || len(rep.Peers) != 10 {
+		t.Fatalf("expected 4 leechers to leave and be replaced, got %d left of %d peers", left, len(rep.Peers))
+	}
+	if rep.Failed > 0 {
+		t.Fatalf("%d leechers failed to fetch the blocks", rep.Failed)
+	}
+}
+
+func benchmarkSim(b *testing.B, cfg Config) {
+	var rep *Report
+	for i := 0; i < b.N; i++ {
+		cfg.Seed = int64(i)
+		var err error
+		rep, err = Run(context.Background(), cfg)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.Log("\n" + rep.String())
+}
+
+func BenchmarkSimLAN(b *testing.B) {
+	benchmarkSim(b, Config{
+		Seeders:   1,
+		Leechers:  8,
+		Blocks:    100,
+		BlockSize: 16 * 1024,
+		Links: LinkDist{
+			Latency:    time.Millisecond,
+			LatencyStd: 200 * time.Microsecond,
+			Bandwidth:  100 << 20,
+		},
+		Sessions: true,
+	})
+}
+
+func BenchmarkSimWAN(b *testing.B) {
+	benchmarkSim(b, Config{
+		Seeders:   2,
+		Leechers:  8,
+		Blocks:    100,
+		BlockSize: 16 * 1024,
+		Links: LinkDist{
+			Latency:         50 * time.Millisecond,
+			LatencyStd:      20 * time.Millisecond,
+			Bandwidth:       1 << 20,
+			BandwidthSpread: 512 << 10,
+			Loss:            0.01,
+		},
+		Stagger:  time.Second,
+		Sessions: true,
+	})
+}
+
+func BenchmarkSimChurn(b *testing.B) {
+	benchmarkSim(b, Config{
+		Seeders:   2,
+		Leechers:  8,
+		Blocks:    100,
+		BlockSize: 16 * 1024,
+		Links: LinkDist{
+			Latency:    20 * time.Millisecond,
+			LatencyStd: 5 * time.Millisecond,
+			Bandwidth:  4 << 20,
+		},
+		Churn:    0.5,
+		Stagger:  500 * time.Millisecond,
+		Sessions: true,
+	})
+}
+
+func BenchmarkSimNoSessions(b *testing.B) {
+	benchmarkSim(b, Config{
+		Seeders:   2,
+		Leechers:  8,
+		Blocks:    100,
+		BlockSize: 16 * 1024,
+		Links: LinkDist{
+			Latency:    20 * time.Millisecond,
+			LatencyStd: 5 * time.Millisecond,
+			Bandwidth:  4 << 20,
+		},
+	})
+}
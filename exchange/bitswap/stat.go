@@ -7,15 +7,19 @@ import (
 )
 
 type Stat struct {
-	ProvideBufLen   int
-	Wantlist        []*cid.Cid
-	Peers           []string
-	BlocksReceived  uint64
-	DataReceived    uint64
-	BlocksSent      uint64
-	DataSent        uint64
-	DupBlksReceived uint64
-	DupDataReceived uint64
+	ProvideBufLen     int
+	Wantlist          []*cid.Cid
+	Peers             []string
+	BlocksReceived    uint64
+	DataReceived      uint64
+	BlocksSent        uint64
+	DataSent          uint64
+	DupBlksReceived   uint64
+	DupDataReceived   uint64
+	HavesReceived     uint64
+	DontHavesReceived uint64
+	WantsRejected     uint64
+	BlocksRejected    uint64
 }
 
 func (bs *Bitswap) Stat() (*Stat, error) {
@@ -30,6 +34,10 @@ func (bs *Bitswap) Stat() (*Stat, error) {
 	st.BlocksSent = c.blocksSent
 	st.DataSent = c.dataSent
 	st.DataReceived = c.dataRecvd
+	st.HavesReceived = c.havesRecvd
+	st.DontHavesReceived = c.dontHavesRecvd
+	st.WantsRejected = c.wantsRejected
+	st.BlocksRejected = c.blocksRejected
 	bs.counterLk.Unlock()
 
 	peers := bs.engine.Peers()
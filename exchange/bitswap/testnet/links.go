@@ -0,0 +1,148 @@
+package bitswap
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	bsmsg "github.com/ipfs/go-ipfs/exchange/bitswap/message"
+
+	delay "gx/ipfs/QmRJVNatYJwTAHgdSM1Xef9QVQ1Ch3XHdmcrykjP5Y4soL/go-ipfs-delay"
+	mockrouting "gx/ipfs/QmXtoXbu9ReyV6Q4kDQ5CF9wXQNDY1PdHc4HhfxRR5AHB3/go-ipfs-routing/mock"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+)
+
+// LinkModel describes the link from one peer to another.
+type LinkModel struct {
+	// Latency is the time a message takes to cross the link, nil for none.
+	Latency delay.D
+
+	// Bandwidth is the number of bytes per second sent over the link, 0 for
+	// no limit. Messages wait for the previous ones to be sent.
+	Bandwidth float64
+
+	// Loss is the probability of a message being lost.
+	Loss float64
+}
+
+// LinkFunc returns the model of the link from a peer to another. It is called
+// for every message, with the network lock held.
+type LinkFunc func(from, to peer.ID) LinkModel
+
+// ChurnNetwork is a Network whose peers can leave.
+type ChurnNetwork interface {
+	Network
+
+	// ConnectPeers connects two peers of the network.
+	ConnectPeers(a, b peer.ID) error
+
+	// RemovePeer disconnects the peer from all its peers, and removes it from
+	// the network.
+	RemovePeer(peer.ID)
+}
+
+// VirtualNetworkWithLinks returns a virtual network whose messages are
+// delivered following the model of their link. seed seeds message losses.
+func VirtualNetworkWithLinks(rs mockrouting.Server, links LinkFunc, seed int64) ChurnNetwork {
+	return &network{
+		clients:       make(map[peer.ID]*receiverQueue),
+		delay:         delay.Fixed(0),
+		routingserver: rs,
+		conns:         make(map[string]struct{}),
+		links:         links,
+		linkQueues:    make(map[link]*receiverQueue),
+		linkBusy:      make(map[link]time.Time),
+		rng:           rand.New(rand.NewSource(seed)),
+	}
+}
+
+// linkDelivery returns when a message sent now over the link should be
+// delivered, or false if it is lost. n.mu must be held.
+func (n *network) linkDelivery(from, to peer.ID, m bsmsg.BitSwapMessage) (time.Time, bool) {
+	l := n.links(from, to)
+	if l.Loss > 0 && n.rng.Float64() < l.Loss {
+		return time.Time{}, false
+	}
+
+	// the message is sent once the previous ones are
+	k := link{from: from, to: to}
+	now := time.Now()
+	sent := n.linkBusy[k]
+	if sent.Before(now) {
+		sent = now
+	}
+	if l.Bandwidth > 0 {
+		size := proto.Size(m.ToProtoV1())
+		sent = sent.Add(time.Duration(float64(size) / l.Bandwidth * float64(time.Second)))
+	}
+	n.linkBusy[k] = sent
+
+	if l.Latency != nil {
+		return sent.Add(l.Latency.Get()), true
+	}
+	return sent, true
+}
+
+// linkQueue returns the queue of the messages sent over the link, so slow
+// links do not hold back messages of the others. n.mu must be held.
+func (n *network) linkQueue(from, to peer.ID, receiver *receiverQueue) *receiverQueue {
+	k := link{from: from, to: to}
+	q, ok := n.linkQueues[k]
+	if !ok {
+		q = &receiverQueue{receiver: receiver.receiver}
+		n.linkQueues[k] = q
+	}
+	return q
+}
+
+type link struct {
+	from, to peer.ID
+}
+
+// ConnectPeers implements ChurnNetwork.
+func (n *network) ConnectPeers(a, b peer.ID) error {
+	n.mu.Lock()
+	client, ok := n.clients[a]
+	n.mu.Unlock()
+	if !ok {
+		return errors.New("no such peer in network")
+	}
+	nc := &networkClient{local: a, network: n, Receiver: client.receiver}
+	return nc.ConnectTo(context.Background(), b)
+}
+
+// RemovePeer implements ChurnNetwork.
+func (n *network) RemovePeer(p peer.ID) {
+	n.mu.Lock()
+	client, ok := n.clients[p]
+	if !ok {
+		n.mu.Unlock()
+		return
+	}
+	delete(n.clients, p)
+
+	var others []*receiverQueue
+	var otherIDs []peer.ID
+	for q, other := range n.clients {
+		tag := tagForPeers(p, q)
+		if _, ok := n.conns[tag]; ok {
+			delete(n.conns, tag)
+			others = append(others, other)
+			otherIDs = append(otherIDs, q)
+		}
+	}
+	for k := range n.linkQueues {
+		if k.from == p || k.to == p {
+			delete(n.linkQueues, k)
+			delete(n.linkBusy, k)
+		}
+	}
+	n.mu.Unlock()
+
+	for i, other := range others {
+		other.receiver.PeerDisconnected(p)
+		client.receiver.PeerDisconnected(otherIDs[i])
+	}
+}
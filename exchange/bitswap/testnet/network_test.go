@@ -4,6 +4,7 @@ import (
 	"context"
 	"sync"
 	"testing"
+	"time"
 
 	bsmsg "github.com/ipfs/go-ipfs/exchange/bitswap/message"
 	bsnet "github.com/ipfs/go-ipfs/exchange/bitswap/network"
@@ -96,3 +97,96 @@ func (lam *lambdaImpl) PeerConnected(p peer.ID) {
 func (lam *lambdaImpl) PeerDisconnected(peer.ID) {
 	// TODO
 }
+
+func TestLinkModel(t *testing.T) {
+	var lossy peer.ID
+	net := VirtualNetworkWithLinks(mockrouting.NewServer(), func(from, to peer.ID) LinkModel {
+		if from == lossy {
+			return LinkModel{Loss: 1}
+		}
+		// 100 bytes per second
+		return LinkModel{Latency: delay.Fixed(10 * time.Millisecond), Bandwidth: 100}
+	}, 1)
+
+	senderID := testutil.RandIdentityOrFatal(t)
+	lossyID := testutil.RandIdentityOrFatal(t)
+	lossy = lossyID.ID()
+	receiverID := testutil.RandIdentityOrFatal(t)
+	sender := net.Adapter(senderID)
+	lossySender := net.Adapter(lossyID)
+	receiver := net.Adapter(receiverID)
+
+	received := make(chan peer.ID, 2)
+	receiver.SetDelegate(lambda(func(ctx context.Context, from peer.ID, m bsmsg.BitSwapMessage) {
+		received <- from
+	}))
+
+	msg := bsmsg.New(false)
+	msg.AddBlock(blocks.NewBlock(make([]byte, 50)))
+	start := time.Now()
+	if err := lossySender.SendMessage(context.Background(), receiverID.ID(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.SendMessage(context.Background(), receiverID.ID(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case from := <-received:
+		if from != senderID.ID() {
+			t.Fatal("message of the lossy link delivered")
+		}
+		// half a second to send 50 bytes
+		if el := time.Since(start); el < 500*time.Millisecond {
+			t.Fatal("bandwidth not respected, message delivered after", el)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not delivered")
+	}
+
+	select {
+	case <-received:
+		t.Fatal("message of the lossy link delivered")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRemovePeer(t *testing.T) {
+	net := VirtualNetworkWithLinks(mockrouting.NewServer(), func(from, to peer.ID) LinkModel {
+		return LinkModel{}
+	}, 1)
+
+	aID := testutil.RandIdentityOrFatal(t)
+	bID := testutil.RandIdentityOrFatal(t)
+	a := net.Adapter(aID)
+	b := net.Adapter(bID)
+
+	disconnected := make(chan peer.ID, 2)
+	a.SetDelegate(&disconnectRecorder{disconnected})
+	b.SetDelegate(&disconnectRecorder{disconnected})
+	if err := a.ConnectTo(context.Background(), bID.ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	net.RemovePeer(bID.ID())
+	if net.HasPeer(bID.ID()) {
+		t.Fatal("peer not removed")
+	}
+	if len(disconnected) != 2 {
+		t.Fatal("peers were not told about the disconnection")
+	}
+	if err := a.SendMessage(context.Background(), bID.ID(), bsmsg.New(false)); err == nil {
+		t.Fatal("sent a message to a removed peer")
+	}
+}
+
+type disconnectRecorder struct {
+	disconnected chan peer.ID
+}
+
+func (r *disconnectRecorder) ReceiveMessage(context.Context, peer.ID, bsmsg.BitSwapMessage) {}
+func (r *disconnectRecorder) ReceiveError(error)                                            {}
+func (r *disconnectRecorder) PeerConnected(peer.ID)                                         {}
+func (r *disconnectRecorder) PeerDisconnected(p peer.ID) {
+	r.disconnected <- p
+}
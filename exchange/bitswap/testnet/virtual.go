@@ -3,6 +3,7 @@ package bitswap
 import (
 	"context"
 	"errors"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -36,6 +37,13 @@ type network struct {
 	routingserver mockrouting.Server
 	delay         delay.D
 	conns         map[string]struct{}
+
+	// links models the links between peers, nil for a fixed delay. The
+	// messages of each link are queued separately.
+	links      LinkFunc
+	linkQueues map[link]*receiverQueue
+	linkBusy   map[link]time.Time
+	rng        *rand.Rand
 }
 
 type message struct {
@@ -94,6 +102,20 @@ func (n *network) SendMessage(
 	// nb: terminate the context since the context wouldn't actually be passed
 	// over the network in a real scenario
 
+	if n.links != nil {
+		shouldSend, ok := n.linkDelivery(from, to, mes)
+		if !ok {
+			log.Debugf("lost message from %s to %s", from, to)
+			return nil
+		}
+		n.linkQueue(from, to, receiver).enqueue(&message{
+			from:       from,
+			msg:        mes,
+			shouldSend: shouldSend,
+		})
+		return nil
+	}
+
 	msg := &message{
 		from:       from,
 		msg:        mes,
@@ -152,6 +174,12 @@ func (nc *networkClient) FindProvidersAsync(ctx context.Context, k *cid.Cid, max
 	return out
 }
 
+// SupportsHave returns true, all peers of the virtual network run this
+// bitswap implementation.
+func (nc *networkClient) SupportsHave(p peer.ID) bool {
+	return true
+}
+
 func (nc *networkClient) ConnectionManager() ifconnmgr.ConnManager {
 	return &ifconnmgr.NullConnMgr{}
 }
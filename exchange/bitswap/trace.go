@@ -0,0 +1,185 @@
+package bitswap
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+	cid "gx/ipfs/QmcZfnkapfECQGcLZaf9B79NRg7cRa9EnZh4LSbkCzwNvY/go-cid"
+)
+
+// traceBufferSize is the number of events kept by the tracer. Older events
+// are overwritten, so tracing can stay on.
+const traceBufferSize = 8192
+
+// Types of trace events.
+const (
+	TraceWant      = "want"       // block wanted from Peers, or broadcast
+	TraceWantHave  = "want-have"  // Peers asked whether they have the block
+	TraceCancel    = "cancel"     // want cancelled
+	TraceHave      = "have"       // Peers answered they have the block
+	TraceDontHave  = "dont-have"  // Peers answered they don't have the block
+	TraceBlock     = "block"      // block received from Peers
+	TraceDuplicate = "duplicate"  // block received from Peers, we had it already
+	TraceSent      = "block-sent" // block sent to Peers
+)
+
+// TraceEvent is something that happened to a block in bitswap.
+type TraceEvent struct {
+	Seq     uint64 // increases with every recorded event
+	Time    time.Time
+	Type    string
+	Cid     string
+	Peers   []string `json:",omitempty"` // none for a broadcast
+	Session uint64   `json:",omitempty"`
+	Size    int      `json:",omitempty"`
+}
+
+type traceSub struct {
+	key string // cid to follow, "" for all
+	ch  chan TraceEvent
+}
+
+// tracer records trace events in a ring buffer, and passes them to
+// subscribers.
+type tracer struct {
+	lk     sync.Mutex
+	events []traceEntry
+	next   int
+	seq    uint64
+	subs   map[*traceSub]struct{}
+}
+
+type traceEntry struct {
+	key string
+	ev  TraceEvent
+}
+
+func newTracer(size int) *tracer {
+	return &tracer{
+		events: make([]traceEntry, 0, size),
+		subs:   make(map[*traceSub]struct{}),
+	}
+}
+
+// record adds an event for each of the cids.
+func (t *tracer) record(typ string, ks []*cid.Cid, peers []peer.ID, ses uint64, size int) {
+	if t == nil || len(ks) == 0 {
+		return
+	}
+
+	var ps []string
+	if len(peers) > 0 {
+		ps = make([]string, len(peers))
+		for i, p := range peers {
+			ps[i] = p.Pretty()
+		}
+	}
+
+	now := time.Now()
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	for _, c := range ks {
+		t.seq++
+		e := traceEntry{
+			key: c.KeyString(),
+			ev: TraceEvent{
+				Seq:     t.seq,
+				Time:    now,
+				Type:    typ,
+				Cid:     c.String(),
+				Peers:   ps,
+				Session: ses,
+				Size:    size,
+			},
+		}
+
+		if len(t.events) < cap(t.events) {
+			t.events = append(t.events, e)
+		} else {
+			t.events[t.next] = e
+		}
+		t.next = (t.next + 1) % cap(t.events)
+
+		for s := range t.subs {
+			if s.key != "" && s.key != e.key {
+				continue
+			}
+			select {
+			case s.ch <- e.ev:
+			default:
+				// slow subscribers miss events rather than slowing bitswap
+			}
+		}
+	}
+}
+
+// get returns the recorded events of the cid, oldest first.
+func (t *tracer) get(c *cid.Cid) []TraceEvent {
+	key := c.KeyString()
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	var out []TraceEvent
+	start := 0
+	if len(t.events) == cap(t.events) {
+		start = t.next
+	}
+	for i := 0; i < len(t.events); i++ {
+		e := t.events[(start+i)%len(t.events)]
+		if e.key == key {
+			out = append(out, e.ev)
+		}
+	}
+	return out
+}
+
+// subscribe returns the events of the cid, or all events if c is nil, until
+// the context is cancelled.
+func (t *tracer) subscribe(ctx context.Context, c *cid.Cid) <-chan TraceEvent {
+	s := &traceSub{ch: make(chan TraceEvent, 64)}
+	if c != nil {
+		s.key = c.KeyString()
+	}
+
+	t.lk.Lock()
+	t.subs[s] = struct{}{}
+	t.lk.Unlock()
+
+	out := make(chan TraceEvent)
+	go func() {
+		defer close(out)
+		defer func() {
+			t.lk.Lock()
+			delete(t.subs, s)
+			t.lk.Unlock()
+		}()
+		for {
+			select {
+			case ev := <-s.ch:
+				select {
+				case out <- ev:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Trace returns the recorded events of the block, oldest first.
+func (bs *Bitswap) Trace(c *cid.Cid) []TraceEvent {
+	return bs.tracer.get(c)
+}
+
+// TraceEvents returns the events of the block as they happen, or of all
+// blocks if c is nil, until the context is cancelled. Events are dropped if
+// the channel is not read fast enough. Events recorded between subscribing
+// and a call to Trace are returned by both, use Seq to tell them apart.
+func (bs *Bitswap) TraceEvents(ctx context.Context, c *cid.Cid) <-chan TraceEvent {
+	return bs.tracer.subscribe(ctx, c)
+}
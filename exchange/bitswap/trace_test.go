@@ -0,0 +1,111 @@
+package bitswap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	blocksutil "github.com/ipfs/go-ipfs/blocks/blocksutil"
+
+	tu "gx/ipfs/QmVvkK7s5imCiq3JVbL3pGfnhcCnf3LrFJPF4GE2sAoGZf/go-testutil"
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+	cid "gx/ipfs/QmcZfnkapfECQGcLZaf9B79NRg7cRa9EnZh4LSbkCzwNvY/go-cid"
+)
+
+func TestTracerRingBuffer(t *testing.T) {
+	tr := newTracer(4)
+	bgen := blocksutil.NewBlockGenerator()
+	a := bgen.Next().Cid()
+	b := bgen.Next().Cid()
+	p := tu.RandPeerIDFatal(t)
+
+	tr.record(TraceWant, []*cid.Cid{a}, nil, 1, 0)
+	tr.record(TraceWantHave, []*cid.Cid{a, b}, []peer.ID{p}, 1, 0)
+	tr.record(TraceHave, []*cid.Cid{a}, []peer.ID{p}, 0, 0)
+
+	evs := tr.get(a)
+	if len(evs) != 3 || evs[0].Type != TraceWant || evs[2].Type != TraceHave {
+		t.Fatalf("unexpected events: %+v", evs)
+	}
+	if len(evs[1].Peers) != 1 || evs[1].Peers[0] != p.Pretty() {
+		t.Fatalf("unexpected peers: %+v", evs[1])
+	}
+
+	// the oldest events are overwritten
+	tr.record(TraceBlock, []*cid.Cid{a, b}, []peer.ID{p}, 0, 10)
+	evs = tr.get(a)
+	if len(evs) != 3 || evs[0].Type != TraceWantHave || evs[2].Type != TraceBlock {
+		t.Fatalf("unexpected events after wrapping: %+v", evs)
+	}
+	if evs := tr.get(b); len(evs) != 2 {
+		t.Fatalf("unexpected events for b: %+v", evs)
+	}
+	for i := 1; i < len(evs); i++ {
+		if evs[i].Seq <= evs[i-1].Seq {
+			t.Fatalf("sequence numbers do not increase: %+v", evs)
+		}
+	}
+}
+
+func TestTracerSubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tr := newTracer(16)
+	bgen := blocksutil.NewBlockGenerator()
+	a := bgen.Next().Cid()
+	b := bgen.Next().Cid()
+
+	evs := tr.subscribe(ctx, a)
+	tr.record(TraceWant, []*cid.Cid{b}, nil, 1, 0)
+	tr.record(TraceWant, []*cid.Cid{a}, nil, 1, 0)
+
+	select {
+	case ev := <-evs:
+		if ev.Cid != a.String() {
+			t.Fatal("got event of another block", ev)
+		}
+		if past := tr.get(a); len(past) != 1 || past[0].Seq != ev.Seq {
+			t.Fatalf("live event %+v does not match the recorded one %+v", ev, past)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+
+	cancel()
+	for range evs {
+	}
+}
+
+func TestTraceFetch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	vnet := getVirtualNetwork()
+	sesgen := NewTestSessionGenerator(vnet)
+	defer sesgen.Close()
+	bgen := blocksutil.NewBlockGenerator()
+
+	inst := sesgen.Instances(2)
+	blk := bgen.Next()
+	if err := inst[0].Blockstore().Put(blk); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := inst[1].Exchange.GetBlock(ctx, blk.Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	var want, block bool
+	for _, ev := range inst[1].Exchange.Trace(blk.Cid()) {
+		switch ev.Type {
+		case TraceWant:
+			want = true
+		case TraceBlock:
+			block = len(ev.Peers) == 1 && ev.Peers[0] == inst[0].Peer.Pretty()
+		}
+	}
+	if !want || !block {
+		t.Fatal("trace misses the want or the received block")
+	}
+}
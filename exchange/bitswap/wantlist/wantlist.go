@@ -114,6 +114,19 @@ func (w *ThreadSafe) Remove(c *cid.Cid, ses uint64) bool {
 	return false
 }
 
+// SetPriority changes the priority of the given cid. It returns true if the
+// cid is in the wantlist and its priority changed.
+func (w *ThreadSafe) SetPriority(c *cid.Cid, priority int) bool {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+	e, ok := w.set[c.KeyString()]
+	if !ok || e.Priority == priority {
+		return false
+	}
+	e.Priority = priority
+	return true
+}
+
 // Contains returns true if the given cid is in the wantlist tracked by one or
 // more sessions
 func (w *ThreadSafe) Contains(k *cid.Cid) (*Entry, bool) {
@@ -102,3 +102,21 @@ func TestSesRefWantlist(t *testing.T) {
 	}
 	assertNotHasCid(t, wl, testcids[0])
 }
+
+func TestSetPriority(t *testing.T) {
+	wl := NewThreadSafe()
+
+	if wl.SetPriority(testcids[0], 3) {
+		t.Fatal("set the priority of a cid not in the wantlist")
+	}
+	wl.Add(testcids[0], 5, 1)
+	if wl.SetPriority(testcids[0], 5) {
+		t.Fatal("priority did not change")
+	}
+	if !wl.SetPriority(testcids[0], 3) {
+		t.Fatal("should have changed the priority")
+	}
+	if e, _ := wl.Contains(testcids[0]); e.Priority != 3 {
+		t.Fatal("wrong priority", e.Priority)
+	}
+}
@@ -2,6 +2,7 @@ package bitswap
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
 
@@ -13,18 +14,32 @@ import (
 	metrics "gx/ipfs/QmRg1gKTHzc3CZXSKzem8aR4E3TubFhbgXwfVuWnSK5CC5/go-metrics-interface"
 	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
 	cid "gx/ipfs/QmcZfnkapfECQGcLZaf9B79NRg7cRa9EnZh4LSbkCzwNvY/go-cid"
+	blocks "gx/ipfs/Qmej7nf81hi2x2tvjRBF3mcp74sQyuDH4VMYDGd1YtXjb2/go-block-format"
 )
 
+// maxQueuedMessages is the number of full messages of blocks that may wait to
+// be sent to a peer before senders are blocked.
+const maxQueuedMessages = 4
+
+var errQueueClosed = errors.New("message queue closed")
+
 type WantManager struct {
 	// sync channels for Run loop
 	incoming     chan *wantSet
 	connectEvent chan peerStatus     // notification channel for peers connecting/disconnecting
 	peerReqs     chan chan []peer.ID // channel to request connected peers on
 
-	// synchronized by Run loop, only touch inside there
-	peers map[peer.ID]*msgQueue
-	wl    *wantlist.ThreadSafe
-	bcwl  *wantlist.ThreadSafe
+	// synchronized by Run loop, only touch inside there. peersLk is held
+	// when modifying peers, to let SendBlocks look up queues.
+	peers   map[peer.ID]*msgQueue
+	peersLk sync.RWMutex
+	wl      *wantlist.ThreadSafe
+	bcwl    *wantlist.ThreadSafe
+
+	// maxMessageSize is the budget of the blocks packed into one message
+	maxMessageSize int
+
+	trace *tracer
 
 	network bsnet.BitSwapNetwork
 	ctx     context.Context
@@ -46,17 +61,18 @@ func NewWantManager(ctx context.Context, network bsnet.BitSwapNetwork) *WantMana
 	sentHistogram := metrics.NewCtx(ctx, "sent_all_blocks_bytes", "Histogram of blocks sent by"+
 		" this bitswap").Histogram(metricsBuckets)
 	return &WantManager{
-		incoming:      make(chan *wantSet, 10),
-		connectEvent:  make(chan peerStatus, 10),
-		peerReqs:      make(chan chan []peer.ID),
-		peers:         make(map[peer.ID]*msgQueue),
-		wl:            wantlist.NewThreadSafe(),
-		bcwl:          wantlist.NewThreadSafe(),
-		network:       network,
-		ctx:           ctx,
-		cancel:        cancel,
-		wantlistGauge: wantlistGauge,
-		sentHistogram: sentHistogram,
+		incoming:       make(chan *wantSet, 10),
+		connectEvent:   make(chan peerStatus, 10),
+		peerReqs:       make(chan chan []peer.ID),
+		peers:          make(map[peer.ID]*msgQueue),
+		maxMessageSize: engine.DefaultMaxMessageSize,
+		wl:             wantlist.NewThreadSafe(),
+		bcwl:           wantlist.NewThreadSafe(),
+		network:        network,
+		ctx:            ctx,
+		cancel:         cancel,
+		wantlistGauge:  wantlistGauge,
+		sentHistogram:  sentHistogram,
 	}
 }
 
@@ -67,6 +83,14 @@ type msgQueue struct {
 	out     bsmsg.BitSwapMessage
 	network bsnet.BitSwapNetwork
 	wl      *wantlist.ThreadSafe
+	haves   *wantlist.ThreadSafe // want-have entries sent to the peer
+
+	// blocks waiting to be sent, queued is their total size. sent is closed
+	// and replaced whenever some of them are sent.
+	blocks         []blocks.Block
+	queued         int
+	sent           chan struct{}
+	maxMessageSize int
 
 	sender bsnet.MessageSender
 
@@ -79,26 +103,98 @@ type msgQueue struct {
 // WantBlocks adds the given cids to the wantlist, tracked by the given session
 func (pm *WantManager) WantBlocks(ctx context.Context, ks []*cid.Cid, peers []peer.ID, ses uint64) {
 	log.Infof("want blocks: %s", ks)
-	pm.addEntries(ctx, ks, peers, false, ses)
+	pm.addEntries(ctx, ks, nil, peers, false, bsmsg.WantBlock, ses)
+}
+
+// WantBlocksWithPriority is like WantBlocks, with the priority of each cid.
+// Peers send the blocks of higher priority first.
+func (pm *WantManager) WantBlocksWithPriority(ctx context.Context, ks []*cid.Cid, prios []int, peers []peer.ID, ses uint64) {
+	log.Infof("want blocks: %s", ks)
+	pm.addEntries(ctx, ks, prios, peers, false, bsmsg.WantBlock, ses)
+}
+
+// WantHaves asks the given peers whether they have the given cids. Peers
+// speaking bitswap 1.2.0 answer with HAVE or DONT_HAVE, older peers treat
+// the entries as regular wants. Unlike WantBlocks, the cids are not added to
+// our wantlist.
+func (pm *WantManager) WantHaves(ctx context.Context, ks []*cid.Cid, peers []peer.ID, ses uint64) {
+	log.Infof("want haves: %s", ks)
+	pm.addEntries(ctx, ks, nil, peers, false, bsmsg.WantHave, ses)
+}
+
+// WantHavesWithPriority is like WantHaves, with the priority of each cid.
+func (pm *WantManager) WantHavesWithPriority(ctx context.Context, ks []*cid.Cid, prios []int, peers []peer.ID, ses uint64) {
+	log.Infof("want haves: %s", ks)
+	pm.addEntries(ctx, ks, prios, peers, false, bsmsg.WantHave, ses)
+}
+
+// Reprioritize changes the priority of cids already in the wantlist, and
+// sends the new priorities to the peers they were asked from.
+func (pm *WantManager) Reprioritize(ctx context.Context, ks []*cid.Cid, prios []int, ses uint64) {
+	entries := make([]*bsmsg.Entry, 0, len(ks))
+	for i, k := range ks {
+		entries = append(entries, &bsmsg.Entry{
+			Entry:    wantlist.NewRefEntry(k, prios[i]),
+			WantType: bsmsg.WantBlock,
+		})
+	}
+	select {
+	case pm.incoming <- &wantSet{entries: entries, from: ses, reprioritize: true}:
+	case <-pm.ctx.Done():
+	case <-ctx.Done():
+	}
 }
 
 // CancelWants removes the given cids from the wantlist, tracked by the given session
 func (pm *WantManager) CancelWants(ctx context.Context, ks []*cid.Cid, peers []peer.ID, ses uint64) {
-	pm.addEntries(context.Background(), ks, peers, true, ses)
+	pm.addEntries(context.Background(), ks, nil, peers, true, bsmsg.WantBlock, ses)
+}
+
+// SendPresences tells p which of the cids it asked for we have.
+func (pm *WantManager) SendPresences(ctx context.Context, p peer.ID, haves, dontHaves []*cid.Cid) {
+	select {
+	case pm.incoming <- &wantSet{targets: []peer.ID{p}, haves: haves, dontHaves: dontHaves}:
+	case <-pm.ctx.Done():
+	case <-ctx.Done():
+	}
 }
 
 type wantSet struct {
 	entries []*bsmsg.Entry
 	targets []peer.ID
 	from    uint64
+
+	// block presences to send to the targets
+	haves     []*cid.Cid
+	dontHaves []*cid.Cid
+
+	// reprioritize only changes the priority of wanted entries
+	reprioritize bool
 }
 
-func (pm *WantManager) addEntries(ctx context.Context, ks []*cid.Cid, targets []peer.ID, cancel bool, ses uint64) {
+// addEntries sends wantlist changes for the cids. The cids get the given
+// priorities, or decreasing priorities in order if prios is nil.
+func (pm *WantManager) addEntries(ctx context.Context, ks []*cid.Cid, prios []int, targets []peer.ID, cancel bool, wantType bsmsg.WantType, ses uint64) {
+	switch {
+	case cancel:
+		pm.trace.record(TraceCancel, ks, targets, ses, 0)
+	case wantType == bsmsg.WantHave:
+		pm.trace.record(TraceWantHave, ks, targets, ses, 0)
+	default:
+		pm.trace.record(TraceWant, ks, targets, ses, 0)
+	}
+
 	entries := make([]*bsmsg.Entry, 0, len(ks))
 	for i, k := range ks {
+		prio := kMaxPriority - i
+		if prios != nil {
+			prio = prios[i]
+		}
 		entries = append(entries, &bsmsg.Entry{
-			Cancel: cancel,
-			Entry:  wantlist.NewRefEntry(k, kMaxPriority-i),
+			Cancel:       cancel,
+			Entry:        wantlist.NewRefEntry(k, prio),
+			WantType:     wantType,
+			SendDontHave: !cancel && len(targets) > 0,
 		})
 	}
 	select {
@@ -114,19 +210,37 @@ func (pm *WantManager) ConnectedPeers() []peer.ID {
 	return <-resp
 }
 
-func (pm *WantManager) SendBlock(ctx context.Context, env *engine.Envelope) {
-	// Blocks need to be sent synchronously to maintain proper backpressure
-	// throughout the network stack
+// SendBlocks queues the blocks of the envelope for sending to its peer,
+// packed with the pending wantlist changes. It blocks while the queue of the
+// peer is full, to maintain proper backpressure throughout the network stack.
+func (pm *WantManager) SendBlocks(ctx context.Context, env *engine.Envelope) {
 	defer env.Sent()
 
-	pm.sentHistogram.Observe(float64(len(env.Block.RawData())))
+	for _, b := range env.Blocks {
+		pm.sentHistogram.Observe(float64(len(b.RawData())))
+	}
+	log.Infof("Sending %d blocks to %s", len(env.Blocks), env.Peer)
 
+	pm.peersLk.RLock()
+	mq, ok := pm.peers[env.Peer]
+	pm.peersLk.RUnlock()
+	if ok {
+		err := mq.addBlocks(ctx, env.Blocks)
+		if err != errQueueClosed {
+			if err != nil {
+				log.Infof("sendblocks error: %s", err)
+			}
+			return
+		}
+	}
+
+	// not a partner (anymore), send the blocks directly
 	msg := bsmsg.New(false)
-	msg.AddBlock(env.Block)
-	log.Infof("Sending block %s to %s", env.Block, env.Peer)
-	err := pm.network.SendMessage(ctx, env.Peer, msg)
-	if err != nil {
-		log.Infof("sendblock error: %s", err)
+	for _, b := range env.Blocks {
+		msg.AddBlock(b)
+	}
+	if err := pm.network.SendMessage(ctx, env.Peer, msg); err != nil {
+		log.Infof("sendblocks error: %s", err)
 	}
 }
 
@@ -143,14 +257,17 @@ func (pm *WantManager) startPeerHandler(p peer.ID) *msgQueue {
 	fullwantlist := bsmsg.New(true)
 	for _, e := range pm.bcwl.Entries() {
 		for k := range e.SesTrk {
-			mq.wl.AddEntry(e, k)
+			// not sharing the entry, its priority is changed per peer
+			mq.wl.Add(e.Cid, e.Priority, k)
 		}
 		fullwantlist.AddEntry(e.Cid, e.Priority)
 	}
 	mq.out = fullwantlist
 	mq.work <- struct{}{}
 
+	pm.peersLk.Lock()
 	pm.peers[p] = mq
+	pm.peersLk.Unlock()
 	go mq.runQueue(pm.ctx)
 	return mq
 }
@@ -168,7 +285,9 @@ func (pm *WantManager) stopPeerHandler(p peer.ID) {
 	}
 
 	close(pq.done)
+	pm.peersLk.Lock()
 	delete(pm.peers, p)
+	pm.peersLk.Unlock()
 }
 
 func (mq *msgQueue) runQueue(ctx context.Context) {
@@ -191,15 +310,36 @@ func (mq *msgQueue) runQueue(ctx context.Context) {
 }
 
 func (mq *msgQueue) doWork(ctx context.Context) {
-	// grab outgoing message
+	// grab outgoing message, and the blocks fitting in it
 	mq.outlk.Lock()
 	wlm := mq.out
+	mq.out = nil
+	var blks []blocks.Block
+	size := 0
+	for len(mq.blocks) > 0 {
+		b := mq.blocks[0]
+		if len(blks) > 0 && size+len(b.RawData()) > mq.maxMessageSize {
+			break
+		}
+		blks = append(blks, b)
+		size += len(b.RawData())
+		mq.blocks[0] = nil
+		mq.blocks = mq.blocks[1:]
+	}
+	mq.outlk.Unlock()
+
+	if len(blks) > 0 {
+		if wlm == nil {
+			wlm = bsmsg.New(false)
+		}
+		for _, b := range blks {
+			wlm.AddBlock(b)
+		}
+		defer mq.blocksSent(size)
+	}
 	if wlm == nil || wlm.Empty() {
-		mq.outlk.Unlock()
 		return
 	}
-	mq.out = nil
-	mq.outlk.Unlock()
 
 	// NB: only open a stream if we actually have data to send
 	if mq.sender == nil {
@@ -293,12 +433,20 @@ func (pm *WantManager) Run() {
 	for {
 		select {
 		case ws := <-pm.incoming:
+			if ws.reprioritize {
+				pm.reprioritize(ws.entries)
+				continue
+			}
 
 			// is this a broadcast or not?
 			brdc := len(ws.targets) == 0
 
 			// add changes to our wantlist
 			for _, e := range ws.entries {
+				if e.WantType == bsmsg.WantHave {
+					// only asking, not wanting (yet)
+					continue
+				}
 				if e.Cancel {
 					if brdc {
 						pm.bcwl.Remove(e.Cid, ws.from)
@@ -330,6 +478,7 @@ func (pm *WantManager) Run() {
 						continue
 					}
 					p.addMessage(ws.entries, ws.from)
+					p.addPresences(ws.haves, ws.dontHaves)
 				}
 			}
 
@@ -351,14 +500,79 @@ func (pm *WantManager) Run() {
 	}
 }
 
+// reprioritize updates the priorities of the entries in our wantlist and in
+// the wantlists sent to peers.
+func (pm *WantManager) reprioritize(entries []*bsmsg.Entry) {
+	for _, p := range pm.peers {
+		p.reprioritize(entries)
+	}
+	for _, e := range entries {
+		pm.wl.SetPriority(e.Cid, e.Priority)
+		pm.bcwl.SetPriority(e.Cid, e.Priority)
+	}
+}
+
 func (wm *WantManager) newMsgQueue(p peer.ID) *msgQueue {
 	return &msgQueue{
-		done:    make(chan struct{}),
-		work:    make(chan struct{}, 1),
-		wl:      wantlist.NewThreadSafe(),
-		network: wm.network,
-		p:       p,
-		refcnt:  1,
+		done:           make(chan struct{}),
+		work:           make(chan struct{}, 1),
+		sent:           make(chan struct{}),
+		wl:             wantlist.NewThreadSafe(),
+		haves:          wantlist.NewThreadSafe(),
+		network:        wm.network,
+		maxMessageSize: wm.maxMessageSize,
+		p:              p,
+		refcnt:         1,
+	}
+}
+
+// addBlocks queues blocks for sending. It waits while the blocks already
+// queued would not fit in maxQueuedMessages messages with the new ones.
+func (mq *msgQueue) addBlocks(ctx context.Context, blks []blocks.Block) error {
+	size := 0
+	for _, b := range blks {
+		size += len(b.RawData())
+	}
+
+	mq.outlk.Lock()
+	for mq.queued > 0 && mq.queued+size > maxQueuedMessages*mq.maxMessageSize {
+		sent := mq.sent
+		mq.outlk.Unlock()
+		select {
+		case <-sent:
+		case <-mq.done:
+			return errQueueClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		mq.outlk.Lock()
+	}
+	mq.blocks = append(mq.blocks, blks...)
+	mq.queued += size
+	mq.outlk.Unlock()
+
+	select {
+	case mq.work <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// blocksSent releases the room taken by sent blocks, and schedules sending
+// the remaining ones.
+func (mq *msgQueue) blocksSent(size int) {
+	mq.outlk.Lock()
+	mq.queued -= size
+	close(mq.sent)
+	mq.sent = make(chan struct{})
+	more := len(mq.blocks) > 0
+	mq.outlk.Unlock()
+
+	if more {
+		select {
+		case mq.work <- struct{}{}:
+		default:
+		}
 	}
 }
 
@@ -385,16 +599,71 @@ func (mq *msgQueue) addMessage(entries []*bsmsg.Entry, ses uint64) {
 	// otherwise, combine the one we are holding with the
 	// one passed in
 	for _, e := range entries {
-		if e.Cancel {
-			if mq.wl.Remove(e.Cid, ses) {
+		switch {
+		case e.Cancel:
+			// a cancel revokes both block and have wants
+			removedHave := mq.haves.Remove(e.Cid, ses)
+			if mq.wl.Remove(e.Cid, ses) || removedHave {
 				work = true
 				mq.out.Cancel(e.Cid)
 			}
-		} else {
+		case e.WantType == bsmsg.WantHave:
+			if mq.haves.Add(e.Cid, e.Priority, ses) {
+				work = true
+				mq.out.AddEntryType(e.Cid, e.Priority, bsmsg.WantHave, e.SendDontHave)
+			}
+		default:
 			if mq.wl.Add(e.Cid, e.Priority, ses) {
 				work = true
-				mq.out.AddEntry(e.Cid, e.Priority)
+				mq.out.AddEntryType(e.Cid, e.Priority, bsmsg.WantBlock, e.SendDontHave)
 			}
 		}
 	}
 }
+
+// reprioritize sends the new priority of the wants the peer was sent.
+func (mq *msgQueue) reprioritize(entries []*bsmsg.Entry) {
+	var work bool
+	mq.outlk.Lock()
+	for _, e := range entries {
+		if !mq.wl.SetPriority(e.Cid, e.Priority) {
+			continue
+		}
+		if mq.out == nil {
+			mq.out = bsmsg.New(false)
+		}
+		mq.out.AddEntry(e.Cid, e.Priority)
+		work = true
+	}
+	mq.outlk.Unlock()
+
+	if work {
+		select {
+		case mq.work <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (mq *msgQueue) addPresences(haves, dontHaves []*cid.Cid) {
+	if len(haves) == 0 && len(dontHaves) == 0 {
+		return
+	}
+
+	mq.outlk.Lock()
+	if mq.out == nil {
+		mq.out = bsmsg.New(false)
+	}
+	for _, c := range haves {
+		mq.out.AddHave(c)
+	}
+	for _, c := range dontHaves {
+		mq.out.AddDontHave(c)
+	}
+	mq.outlk.Unlock()
+
+	select {
+	case mq.work <- struct{}{}:
+	default:
+	}
+}
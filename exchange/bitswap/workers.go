@@ -63,20 +63,25 @@ func (bs *Bitswap) taskWorker(ctx context.Context, id int) {
 					return logging.LoggableMap{
 						"ID":     id,
 						"Target": envelope.Peer.Pretty(),
-						"Block":  envelope.Block.Cid().String(),
+						"Blocks": len(envelope.Blocks),
 					}
 				}))
 
 				// update the BS ledger to reflect sent message
 				// TODO: Should only track *useful* messages in ledger
 				outgoing := bsmsg.New(false)
-				outgoing.AddBlock(envelope.Block)
+				size := 0
+				for _, b := range envelope.Blocks {
+					outgoing.AddBlock(b)
+					size += len(b.RawData())
+					bs.tracer.record(TraceSent, []*cid.Cid{b.Cid()}, []peer.ID{envelope.Peer}, 0, len(b.RawData()))
+				}
 				bs.engine.MessageSent(envelope.Peer, outgoing)
 
-				bs.wm.SendBlock(ctx, envelope)
+				bs.wm.SendBlocks(ctx, envelope)
 				bs.counterLk.Lock()
-				bs.counters.blocksSent++
-				bs.counters.dataSent += uint64(len(envelope.Block.RawData()))
+				bs.counters.blocksSent += uint64(len(envelope.Blocks))
+				bs.counters.dataSent += uint64(size)
 				bs.counterLk.Unlock()
 			case <-ctx.Done():
 				return
@@ -231,6 +236,9 @@ func (bs *Bitswap) providerQueryManager(ctx context.Context) {
 				providers := bs.network.FindProvidersAsync(child, e.Cid, maxProvidersPerRequest)
 				wg := &sync.WaitGroup{}
 				for p := range providers {
+					if !bs.engine.Allowed(p) {
+						continue
+					}
 					wg.Add(1)
 					go func(p peer.ID) {
 						defer wg.Done()
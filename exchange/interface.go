@@ -30,9 +30,24 @@ type Fetcher interface {
 	GetBlocks(context.Context, []*cid.Cid) (<-chan blocks.Block, error)
 }
 
+// PriorityFetcher is a Fetcher which fetches blocks by priority, higher
+// first.
+type PriorityFetcher interface {
+	Fetcher
+
+	// GetBlocksWithPriority is like GetBlocks, with the priority of each key.
+	GetBlocksWithPriority(context.Context, []*cid.Cid, []int) (<-chan blocks.Block, error)
+
+	// Reprioritize changes the priorities of blocks being fetched.
+	Reprioritize([]*cid.Cid, []int)
+}
+
 // SessionExchange is an exchange.Interface which supports
 // sessions.
 type SessionExchange interface {
 	Interface
-	NewSession(context.Context) Interface
+
+	// NewSessionFetcher creates a session whose lifetime is bounded by the
+	// given context, fetching the blocks of related requests.
+	NewSessionFetcher(context.Context) Fetcher
 }
@@ -0,0 +1,350 @@
+package reprovide
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-ipfs/thirdparty/verifcid"
+
+	kb "gx/ipfs/QmTH6VLu3WXfbH3nuLdmscgPWuiPZv3GMJ2YCdzBS5z91T/go-libp2p-kbucket"
+	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
+	dsq "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore/query"
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+	cid "gx/ipfs/QmcZfnkapfECQGcLZaf9B79NRg7cRa9EnZh4LSbkCzwNvY/go-cid"
+)
+
+// BatchProvider is a content routing system which announces keys to peers
+// it looked up once for many keys.
+type BatchProvider interface {
+	// ClosestPeers returns the peers closest to the key in the keyspace.
+	ClosestPeers(ctx context.Context, key string) ([]peer.ID, error)
+
+	// ProvideTo announces the keys to the peers. It fails if none of the
+	// peers got them.
+	ProvideTo(ctx context.Context, peers []peer.ID, keys []*cid.Cid) error
+}
+
+// BatchOptions configures a batched reprovider.
+type BatchOptions struct {
+	// BatchSize is the number of keys sorted by keyspace position and
+	// announced together.
+	BatchSize int
+
+	// Workers is the number of parts of a batch announced concurrently.
+	Workers int
+}
+
+// DefaultBatchOptions are the options of the batched reprovider.
+var DefaultBatchOptions = BatchOptions{
+	BatchSize: 4096,
+	Workers:   8,
+}
+
+var (
+	roundKey       = ds.NewKey("/reprovider/round")
+	providedPrefix = ds.NewKey("/reprovider/provided")
+)
+
+func providedKey(c *cid.Cid) ds.Key {
+	return providedPrefix.ChildString(c.String())
+}
+
+// roundRecord is the progress of the reprovider kept across restarts.
+type roundRecord struct {
+	// Start of the round in progress, zero between rounds.
+	Start time.Time
+
+	LastEnd      time.Time
+	LastDuration time.Duration
+	LastKeys     uint64
+}
+
+// NewBatchReprovider creates a Reprovider announcing keys in batches through
+// bp. The time each key was last provided and the progress of rounds are
+// kept in dstore, so rounds interrupted by a restart are resumed.
+func NewBatchReprovider(ctx context.Context, bp BatchProvider, keyProvider KeyChanFunc, dstore ds.Batching, opts BatchOptions) *Reprovider {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchOptions.BatchSize
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultBatchOptions.Workers
+	}
+
+	rp := &Reprovider{
+		ctx:     ctx,
+		trigger: make(chan doneFunc),
+
+		keyProvider: keyProvider,
+		batch:       bp,
+		batchOpts:   opts,
+		dstore:      dstore,
+	}
+	rp.status.st.Batched = true
+	if rec, err := rp.loadRound(); err != nil {
+		log.Errorf("loading reprovider progress: %s", err)
+	} else {
+		rp.status.st.RoundStart = rec.Start
+		rp.status.st.LastRoundEnd = rec.LastEnd
+		rp.status.st.LastRoundDuration = rec.LastDuration
+		rp.status.st.LastRoundKeys = rec.LastKeys
+	}
+	return rp
+}
+
+func (rp *Reprovider) loadRound() (roundRecord, error) {
+	var rec roundRecord
+	v, err := rp.dstore.Get(roundKey)
+	switch err {
+	case nil:
+		b, ok := v.([]byte)
+		if !ok {
+			return rec, fmt.Errorf("reprovider round record is a %T, not bytes", v)
+		}
+		err = json.Unmarshal(b, &rec)
+	case ds.ErrNotFound:
+		err = nil
+	}
+	return rec, err
+}
+
+func (rp *Reprovider) saveRound(rec roundRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return rp.dstore.Put(roundKey, b)
+}
+
+// providedSince returns whether the key was provided after t.
+func (rp *Reprovider) providedSince(c *cid.Cid, t time.Time) (bool, error) {
+	v, err := rp.dstore.Get(providedKey(c))
+	if err == ds.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	pt, ok := providedTime(v)
+	if !ok {
+		return false, fmt.Errorf("invalid provide time of %s", c)
+	}
+	return pt.After(t), nil
+}
+
+// providedTime decodes a provide time from the datastore.
+func providedTime(v interface{}) (time.Time, bool) {
+	b, ok := v.([]byte)
+	if !ok {
+		return time.Time{}, false
+	}
+	ns, n := binary.Varint(b)
+	if n <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ns), true
+}
+
+// pruneProvided deletes the provide times older than t. Called at the end
+// of a round started at t, it deletes the times of the keys which are not
+// provided anymore.
+func (rp *Reprovider) pruneProvided(t time.Time) error {
+	res, err := rp.dstore.Query(dsq.Query{Prefix: providedPrefix.String()})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	b, err := rp.dstore.Batch()
+	if err != nil {
+		return err
+	}
+	for e := range res.Next() {
+		if e.Error != nil {
+			return e.Error
+		}
+		if pt, ok := providedTime(e.Value); ok && !pt.Before(t) {
+			continue
+		}
+		if err := b.Delete(ds.RawKey(e.Key)); err != nil {
+			return err
+		}
+	}
+	return b.Commit()
+}
+
+func (rp *Reprovider) reprovideBatched(keychan <-chan *cid.Cid) error {
+	rec, err := rp.loadRound()
+	if err != nil {
+		return err
+	}
+	resume := rec.Start
+	if resume.IsZero() {
+		rec.Start = time.Now()
+		if err := rp.saveRound(rec); err != nil {
+			return err
+		}
+	} else {
+		log.Infof("resuming reprovider round started at %s", resume)
+	}
+	rp.status.start(rec.Start)
+
+	batch := make([]*cid.Cid, 0, rp.batchOpts.BatchSize)
+	for c := range keychan {
+		if err := verifcid.ValidateCid(c); err != nil {
+			log.Errorf("insecure hash in reprovider, %s (%s)", c, err)
+			continue
+		}
+		if !resume.IsZero() {
+			provided, err := rp.providedSince(c, resume)
+			if err != nil {
+				log.Warningf("reprovider progress of %s: %s", c, err)
+			}
+			if provided {
+				rp.status.add(0, 1, 0, 0)
+				continue
+			}
+		}
+
+		batch = append(batch, c)
+		if len(batch) == cap(batch) {
+			rp.provideBatch(batch)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		rp.provideBatch(batch)
+	}
+
+	if err := rp.ctx.Err(); err != nil {
+		return err
+	}
+
+	// keys of this round were provided after its start, or skipped having
+	// been provided after it when resuming
+	if err := rp.pruneProvided(rec.Start); err != nil {
+		log.Errorf("pruning reprovider progress: %s", err)
+	}
+
+	st := rp.status.get()
+	rec.LastEnd = time.Now()
+	rec.LastDuration = rec.LastEnd.Sub(rec.Start)
+	rec.LastKeys = st.Provided + st.Skipped + st.Failed
+	rec.Start = time.Time{}
+	return rp.saveRound(rec)
+}
+
+// sortKeys sorts the keys by keyspace position, and returns their IDs.
+func sortKeys(keys []*cid.Cid) []kb.ID {
+	ids := make(map[*cid.Cid]kb.ID, len(keys))
+	for _, c := range keys {
+		ids[c] = kb.ConvertKey(c.KeyString())
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(ids[keys[i]], ids[keys[j]]) < 0
+	})
+
+	out := make([]kb.ID, len(keys))
+	for i, c := range keys {
+		out[i] = ids[c]
+	}
+	return out
+}
+
+// spanBits returns the length of the keyspace prefix the keys announced to
+// the peers closest to id must share with it. The peers hold all the peers
+// sharing a longer prefix with id than the farthest of them, so they are the
+// closest peers of the keys in that span too.
+func spanBits(id kb.ID, peers []peer.ID) int {
+	bits := len(id) * 8
+	for _, p := range peers {
+		if cpl := kb.CommonPrefixLen(id, kb.ConvertPeerID(p)); cpl < bits {
+			bits = cpl
+		}
+	}
+	return bits + 1
+}
+
+// provideBatch announces a batch of keys, split into parts announced
+// concurrently.
+func (rp *Reprovider) provideBatch(keys []*cid.Cid) {
+	ids := sortKeys(keys)
+
+	var wg sync.WaitGroup
+	size := (len(keys) + rp.batchOpts.Workers - 1) / rp.batchOpts.Workers
+	for start := 0; start < len(keys); start += size {
+		end := start + size
+		if end > len(keys) {
+			end = len(keys)
+		}
+		wg.Add(1)
+		go func(keys []*cid.Cid, ids []kb.ID) {
+			defer wg.Done()
+			rp.provideSorted(keys, ids)
+		}(keys[start:end], ids[start:end])
+	}
+	wg.Wait()
+}
+
+// provideSorted announces keys sorted by keyspace position. The peers
+// closest to the first key are looked up, and the keys in the span of these
+// peers are announced to them, before going on with the next keys.
+func (rp *Reprovider) provideSorted(keys []*cid.Cid, ids []kb.ID) {
+	for len(keys) > 0 && rp.ctx.Err() == nil {
+		peers, err := rp.batch.ClosestPeers(rp.ctx, keys[0].KeyString())
+		if err == nil && len(peers) == 0 {
+			err = errors.New("no peers close to the keys")
+		}
+
+		// without peers the span is unknown, and the remaining keys fail
+		n := len(keys)
+		if err == nil {
+			bits := spanBits(ids[0], peers)
+			n = 1
+			for n < len(keys) && kb.CommonPrefixLen(ids[0], ids[n]) >= bits {
+				n++
+			}
+			err = rp.batch.ProvideTo(rp.ctx, peers, keys[:n])
+		}
+		rp.regionDone(keys[:n], err)
+		keys, ids = keys[n:], ids[n:]
+	}
+}
+
+// regionDone records the outcome of announcing the keys of a lookup.
+func (rp *Reprovider) regionDone(keys []*cid.Cid, err error) {
+	n := uint64(len(keys))
+	if err != nil {
+		log.Debugf("failed to provide %d keys: %s", n, err)
+		rp.status.add(0, 0, n, 1)
+		rp.status.setError(err)
+		return
+	}
+
+	if err := rp.markProvided(keys, time.Now()); err != nil {
+		log.Errorf("saving reprovider progress: %s", err)
+	}
+	rp.status.add(n, 0, 0, 1)
+}
+
+// markProvided records the time the keys were provided.
+func (rp *Reprovider) markProvided(keys []*cid.Cid, t time.Time) error {
+	b, err := rp.dstore.Batch()
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, binary.MaxVarintLen64)
+	v := buf[:binary.PutVarint(buf, t.UnixNano())]
+	for _, c := range keys {
+		if err := b.Put(providedKey(c), v); err != nil {
+			return err
+		}
+	}
+	return b.Commit()
+}
@@ -0,0 +1,223 @@
+package reprovide
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	kb "gx/ipfs/QmTH6VLu3WXfbH3nuLdmscgPWuiPZv3GMJ2YCdzBS5z91T/go-libp2p-kbucket"
+	testutil "gx/ipfs/QmVvkK7s5imCiq3JVbL3pGfnhcCnf3LrFJPF4GE2sAoGZf/go-testutil"
+	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
+	dssync "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore/sync"
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+	cid "gx/ipfs/QmcZfnkapfECQGcLZaf9B79NRg7cRa9EnZh4LSbkCzwNvY/go-cid"
+	blocks "gx/ipfs/Qmej7nf81hi2x2tvjRBF3mcp74sQyuDH4VMYDGd1YtXjb2/go-block-format"
+)
+
+// fakeBatchProvider is a network of peers, which looks up the k closest
+// peers of keys.
+type fakeBatchProvider struct {
+	peers []peer.ID
+	k     int
+
+	lk       sync.Mutex
+	lookups  int
+	provided map[string]int
+	// providedTo lists the peers each key was provided to
+	providedTo map[string][]peer.ID
+}
+
+func (f *fakeBatchProvider) ClosestPeers(ctx context.Context, key string) ([]peer.ID, error) {
+	f.lk.Lock()
+	f.lookups++
+	f.lk.Unlock()
+	return kb.SortClosestPeers(f.peers, kb.ConvertKey(key))[:f.k], nil
+}
+
+func (f *fakeBatchProvider) ProvideTo(ctx context.Context, peers []peer.ID, keys []*cid.Cid) error {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	for _, c := range keys {
+		f.provided[c.KeyString()]++
+		f.providedTo[c.KeyString()] = peers
+	}
+	return nil
+}
+
+func testKeys(n int) []*cid.Cid {
+	keys := make([]*cid.Cid, n)
+	for i := range keys {
+		keys[i] = blocks.NewBlock([]byte(fmt.Sprintf("block %d", i))).Cid()
+	}
+	return keys
+}
+
+func sliceProvider(keys []*cid.Cid) KeyChanFunc {
+	return func(ctx context.Context) (<-chan *cid.Cid, error) {
+		out := make(chan *cid.Cid)
+		go func() {
+			defer close(out)
+			for _, c := range keys {
+				select {
+				case out <- c:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return out, nil
+	}
+}
+
+func newTestBatchReprovider(t *testing.T, dstore ds.Batching, keys []*cid.Cid) (*Reprovider, *fakeBatchProvider) {
+	fp := &fakeBatchProvider{
+		k:          8,
+		provided:   make(map[string]int),
+		providedTo: make(map[string][]peer.ID),
+	}
+	for i := 0; i < 100; i++ {
+		fp.peers = append(fp.peers, testutil.RandPeerIDFatal(t))
+	}
+	opts := BatchOptions{BatchSize: 200, Workers: 4}
+	return NewBatchReprovider(context.Background(), fp, sliceProvider(keys), dstore, opts), fp
+}
+
+func TestSortKeys(t *testing.T) {
+	keys := testKeys(300)
+	ids := sortKeys(keys)
+	for i, c := range keys {
+		if !bytes.Equal(ids[i], kb.ConvertKey(c.KeyString())) {
+			t.Fatal("IDs do not match the keys")
+		}
+		if i > 0 && bytes.Compare(ids[i-1], ids[i]) > 0 {
+			t.Fatal("keys not sorted by keyspace position")
+		}
+	}
+}
+
+func TestBatchReprovide(t *testing.T) {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	keys := testKeys(500)
+	rp, fp := newTestBatchReprovider(t, dstore, keys)
+
+	start := time.Now()
+	if err := rp.Reprovide(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range keys {
+		if fp.provided[c.KeyString()] != 1 {
+			t.Fatalf("key %s provided %d times", c, fp.provided[c.KeyString()])
+		}
+		if provided, err := rp.providedSince(c, start); err != nil || !provided {
+			t.Fatalf("provide time of %s not recorded: %v", c, err)
+		}
+
+		// no peer closer to the key than the farthest of the peers it was
+		// provided to was left out
+		id := kb.ConvertKey(c.KeyString())
+		to := fp.providedTo[c.KeyString()]
+		bits := spanBits(id, to) - 1
+		for _, p := range fp.peers {
+			if kb.CommonPrefixLen(id, kb.ConvertPeerID(p)) <= bits {
+				continue
+			}
+			found := false
+			for _, q := range to {
+				found = found || p == q
+			}
+			if !found {
+				t.Fatalf("key %s not provided to the close peer %s", c, p)
+			}
+		}
+	}
+	if fp.lookups > len(keys)/3 {
+		t.Fatalf("expected lookups to be shared by keys, got %d", fp.lookups)
+	}
+
+	st := rp.Status()
+	if st.Running || st.Provided != 500 || st.LastRoundKeys != 500 || st.Lookups != uint64(fp.lookups) {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+
+	rec, err := rp.loadRound()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Start.IsZero() || rec.LastKeys != 500 {
+		t.Fatalf("unexpected round record: %+v", rec)
+	}
+}
+
+func TestBatchReprovideResume(t *testing.T) {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	keys := testKeys(400)
+	rp, _ := newTestBatchReprovider(t, dstore, keys)
+
+	// a round interrupted after providing half of the keys
+	if err := rp.saveRound(roundRecord{Start: time.Now().Add(-time.Hour), LastKeys: 400}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rp.markProvided(keys[:200], time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	rp, fp := newTestBatchReprovider(t, dstore, keys)
+	if st := rp.Status(); st.LastRoundKeys != 400 {
+		t.Fatalf("progress not loaded: %+v", st)
+	}
+	if err := rp.Reprovide(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range keys {
+		n := fp.provided[c.KeyString()]
+		if (i < 200 && n != 0) || (i >= 200 && n != 1) {
+			t.Fatalf("key %d provided %d times", i, n)
+		}
+	}
+	if st := rp.Status(); st.Skipped != 200 || st.Provided != 200 {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+}
+
+func TestBatchReprovidePrune(t *testing.T) {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	keys := testKeys(100)
+	rp, _ := newTestBatchReprovider(t, dstore, keys)
+	if err := rp.Reprovide(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the times of the keys which are not provided anymore are deleted
+	rp, _ = newTestBatchReprovider(t, dstore, keys[:50])
+	if err := rp.Reprovide(); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range keys {
+		_, err := dstore.Get(providedKey(c))
+		if i < 50 && err != nil {
+			t.Fatalf("key %d: %s", i, err)
+		}
+		if i >= 50 && err != ds.ErrNotFound {
+			t.Fatalf("expected the time of key %d to be deleted, got %v", i, err)
+		}
+	}
+
+	// invalid values are reported instead of panicking
+	if err := dstore.Put(roundKey, "invalid"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rp.loadRound(); err == nil {
+		t.Fatal("expected an invalid round record to fail")
+	}
+	if err := dstore.Put(providedKey(keys[0]), "invalid"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rp.providedSince(keys[0], time.Time{}); err == nil {
+		t.Fatal("expected an invalid provide time to fail")
+	}
+}
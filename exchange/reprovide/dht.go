@@ -0,0 +1,93 @@
+package reprovide
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	p2phost "gx/ipfs/QmNmJZL7FQySMtE2BQuLMuZg2EB2CLEunJJUSVSc9YnnbV/go-libp2p-host"
+	pstore "gx/ipfs/QmXauCuJzmzapetmC6W4TuDJLL1yFFrVzSHoWv8YdbmnxH/go-libp2p-peerstore"
+	dht "gx/ipfs/QmY1y2M1aCcVhy8UuTbZJBvuFbegZm47f9cDAdgxiehQfx/go-libp2p-kad-dht"
+	dhtpb "gx/ipfs/QmY1y2M1aCcVhy8UuTbZJBvuFbegZm47f9cDAdgxiehQfx/go-libp2p-kad-dht/pb"
+	ggio "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/io"
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+	cid "gx/ipfs/QmcZfnkapfECQGcLZaf9B79NRg7cRa9EnZh4LSbkCzwNvY/go-cid"
+)
+
+type dhtProvider struct {
+	host p2phost.Host
+	dht  *dht.IpfsDHT
+}
+
+// NewDHTProvider returns a BatchProvider announcing keys to DHT peers. The
+// provider records of a region are sent over a single stream to each peer.
+func NewDHTProvider(h p2phost.Host, d *dht.IpfsDHT) BatchProvider {
+	return &dhtProvider{host: h, dht: d}
+}
+
+func (p *dhtProvider) ClosestPeers(ctx context.Context, key string) ([]peer.ID, error) {
+	ch, err := p.dht.GetClosestPeers(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var out []peer.ID
+	for pid := range ch {
+		out = append(out, pid)
+	}
+	return out, ctx.Err()
+}
+
+func (p *dhtProvider) ProvideTo(ctx context.Context, peers []peer.ID, keys []*cid.Cid) error {
+	// record the keys locally, as routing.Provide does
+	for _, c := range keys {
+		if err := p.dht.Provide(ctx, c, false); err != nil {
+			return err
+		}
+	}
+
+	self := pstore.PeerInfo{ID: p.host.ID(), Addrs: p.host.Addrs()}
+	if len(self.Addrs) == 0 {
+		return errors.New("no known addresses for self, cannot provide")
+	}
+	provs := dhtpb.RawPeerInfosToPBPeers([]pstore.PeerInfo{self})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(peers))
+	for _, pid := range peers {
+		wg.Add(1)
+		go func(pid peer.ID) {
+			defer wg.Done()
+			errs <- p.sendProviders(ctx, pid, keys, provs)
+		}(pid)
+	}
+	wg.Wait()
+	close(errs)
+
+	var last error
+	for err := range errs {
+		if err == nil {
+			return nil
+		}
+		last = err
+	}
+	return last
+}
+
+func (p *dhtProvider) sendProviders(ctx context.Context, pid peer.ID, keys []*cid.Cid, provs []*dhtpb.Message_Peer) error {
+	s, err := p.host.NewStream(ctx, pid, dht.ProtocolDHT, dht.ProtocolDHTOld)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	w := ggio.NewDelimitedWriter(s)
+	for _, c := range keys {
+		mes := dhtpb.NewMessage(dhtpb.Message_ADD_PROVIDER, c.KeyString(), 0)
+		mes.ProviderPeers = provs
+		if err := w.WriteMsg(mes); err != nil {
+			s.Reset()
+			return err
+		}
+	}
+	return nil
+}
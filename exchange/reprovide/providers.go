@@ -3,7 +3,10 @@ package reprovide
 import (
 	"context"
 
+	bserv "github.com/ipfs/go-ipfs/blockservice"
+	offline "github.com/ipfs/go-ipfs/exchange/offline"
 	merkledag "github.com/ipfs/go-ipfs/merkledag"
+	mfs "github.com/ipfs/go-ipfs/mfs"
 	pin "github.com/ipfs/go-ipfs/pin"
 
 	blocks "gx/ipfs/QmaG4DZ4JaqEfvPWt5nPPgoTzhc1tr1T3f4Nu9Jpdm8ymY/go-ipfs-blockstore"
@@ -43,6 +46,101 @@ func NewPinnedProvider(pinning pin.Pinner, dag ipld.DAGService, onlyRoots bool)
 	}
 }
 
+// NewMFSProvider returns provider supplying the keys reachable from the MFS
+// root which are stored locally
+func NewMFSProvider(root *mfs.Root, bstore blocks.Blockstore) KeyChanFunc {
+	// only walk the local part of the DAG, MFS may link to content we do not
+	// have
+	dag := merkledag.NewDAGService(bserv.New(bstore, offline.Exchange(bstore)))
+	getLinks := func(ctx context.Context, c *cid.Cid) ([]*ipld.Link, error) {
+		links, err := merkledag.GetLinksWithDAG(dag)(ctx, c)
+		if err == ipld.ErrNotFound {
+			return nil, nil
+		}
+		return links, err
+	}
+
+	return func(ctx context.Context) (<-chan *cid.Cid, error) {
+		nd, err := root.GetValue().GetNode()
+		if err != nil {
+			return nil, err
+		}
+
+		set := newStreamingSet()
+		go func() {
+			defer close(set.new)
+			err := merkledag.EnumerateChildren(ctx, getLinks, nd.Cid(), set.add)
+			if err != nil {
+				log.Errorf("reprovide mfs: %s", err)
+			}
+		}()
+
+		outCh := make(chan *cid.Cid)
+		go func() {
+			defer close(outCh)
+			for c := range set.new {
+				if has, err := bstore.Has(c); err != nil || !has {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					// unblock the walk
+					for range set.new {
+					}
+					return
+				case outCh <- c:
+				}
+			}
+		}()
+
+		return outCh, nil
+	}
+}
+
+// NewUnionProvider returns provider supplying the keys of all the given
+// providers, once
+func NewUnionProvider(providers ...KeyChanFunc) KeyChanFunc {
+	if len(providers) == 1 {
+		return providers[0]
+	}
+
+	return func(ctx context.Context) (<-chan *cid.Cid, error) {
+		ctx, cancel := context.WithCancel(ctx)
+
+		chans := make([]<-chan *cid.Cid, 0, len(providers))
+		for _, p := range providers {
+			ch, err := p(ctx)
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			chans = append(chans, ch)
+		}
+
+		outCh := make(chan *cid.Cid)
+		go func() {
+			defer cancel()
+			defer close(outCh)
+
+			seen := cid.NewSet()
+			for _, ch := range chans {
+				for c := range ch {
+					if !seen.Visit(c) {
+						continue
+					}
+					select {
+					case <-ctx.Done():
+						return
+					case outCh <- c:
+					}
+				}
+			}
+		}()
+
+		return outCh, nil
+	}
+}
+
 func pinSet(ctx context.Context, pinning pin.Pinner, dag ipld.DAGService, onlyRoots bool) (*streamingSet, error) {
 	set := newStreamingSet()
 
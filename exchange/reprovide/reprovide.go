@@ -10,6 +10,7 @@ import (
 	backoff "gx/ipfs/QmPJUtEJsm5YLUWhF6imvyCH8KZXRJa9Wup7FDMwTy5Ufz/backoff"
 	logging "gx/ipfs/QmRb5jh8z2E8hMGN2tkvs1yHynUanqnZ3UeKwgN1i9P1F8/go-log"
 	routing "gx/ipfs/QmTiWLZ6Fo5j4KcTVutZJ5KWRRJrbxzmxA4td8NfEdrPh7/go-libp2p-routing"
+	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
 	cid "gx/ipfs/QmcZfnkapfECQGcLZaf9B79NRg7cRa9EnZh4LSbkCzwNvY/go-cid"
 )
 
@@ -27,6 +28,13 @@ type Reprovider struct {
 	rsys routing.ContentRouting
 
 	keyProvider KeyChanFunc
+
+	// batched reproviding, nil to provide keys one by one
+	batch     BatchProvider
+	batchOpts BatchOptions
+	dstore    ds.Batching
+
+	status roundStatus
 }
 
 // NewReprovider creates new Reprovider instance.
@@ -84,6 +92,23 @@ func (rp *Reprovider) Reprovide() error {
 	if err != nil {
 		return fmt.Errorf("failed to get key chan: %s", err)
 	}
+
+	if rp.batch != nil {
+		err = rp.reprovideBatched(keychan)
+	} else {
+		rp.status.start(time.Now())
+		err = rp.reprovide(keychan)
+	}
+	rp.status.finish(err)
+	return err
+}
+
+// Status returns the progress of the reprovider.
+func (rp *Reprovider) Status() Status {
+	return rp.status.get()
+}
+
+func (rp *Reprovider) reprovide(keychan <-chan *cid.Cid) error {
 	for c := range keychan {
 		// hash security
 		if err := verifcid.ValidateCid(c); err != nil {
@@ -103,8 +128,10 @@ func (rp *Reprovider) Reprovide() error {
 		err := backoff.Retry(op, backoff.NewExponentialBackOff())
 		if err != nil {
 			log.Debugf("Providing failed after number of retries: %s", err)
+			rp.status.add(0, 0, 1, 0)
 			return err
 		}
+		rp.status.add(1, 0, 0, 0)
 	}
 	return nil
 }
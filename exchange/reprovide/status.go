@@ -0,0 +1,107 @@
+package reprovide
+
+import (
+	"sync"
+	"time"
+)
+
+// Status reports the progress of the reprovider.
+type Status struct {
+	// Running is true while a round of reproviding is in progress.
+	Running bool
+	// Batched is true if keys are announced in batches.
+	Batched bool
+
+	// RoundStart is when the current or last round started. A round
+	// interrupted by a restart is resumed, keeping its start.
+	RoundStart time.Time
+
+	// Counts of keys of the current or last round.
+	Provided uint64
+	Skipped  uint64 // provided before the round was resumed
+	Failed   uint64
+
+	// Lookups is the number of closest peers lookups of the round.
+	Lookups uint64
+
+	// Rate is the number of keys provided per second in the round.
+	Rate float64
+
+	// Backlog estimates the keys left to provide in the current round,
+	// from the size of the last complete round.
+	Backlog uint64
+
+	// Last complete round.
+	LastRoundEnd      time.Time
+	LastRoundDuration time.Duration
+	LastRoundKeys     uint64
+
+	LastError string
+}
+
+// roundStatus tracks the progress of the reprovider.
+type roundStatus struct {
+	lk sync.Mutex
+	st Status
+}
+
+func (s *roundStatus) start(t time.Time) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.st.Running = true
+	s.st.RoundStart = t
+	s.st.Provided = 0
+	s.st.Skipped = 0
+	s.st.Failed = 0
+	s.st.Lookups = 0
+	s.st.LastError = ""
+}
+
+func (s *roundStatus) add(provided, skipped, failed, lookups uint64) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.st.Provided += provided
+	s.st.Skipped += skipped
+	s.st.Failed += failed
+	s.st.Lookups += lookups
+}
+
+func (s *roundStatus) setError(err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.st.LastError = err.Error()
+}
+
+// finish ends the round. A round ended by an error is not complete.
+func (s *roundStatus) finish(err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.st.Running = false
+	if err != nil {
+		s.st.LastError = err.Error()
+		return
+	}
+	s.st.LastRoundEnd = time.Now()
+	s.st.LastRoundDuration = s.st.LastRoundEnd.Sub(s.st.RoundStart)
+	s.st.LastRoundKeys = s.st.Provided + s.st.Skipped + s.st.Failed
+}
+
+func (s *roundStatus) get() Status {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	st := s.st
+	if !st.RoundStart.IsZero() {
+		end := time.Now()
+		if !st.Running && !st.LastRoundEnd.IsZero() {
+			end = st.LastRoundEnd
+		}
+		if d := end.Sub(st.RoundStart).Seconds(); d > 0 {
+			st.Rate = float64(st.Provided) / d
+		}
+	}
+	if done := st.Provided + st.Skipped + st.Failed; st.Running && st.LastRoundKeys > done {
+		st.Backlog = st.LastRoundKeys - done
+	}
+	return st
+}
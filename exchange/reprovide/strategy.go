@@ -0,0 +1,117 @@
+package reprovide
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	mfs "github.com/ipfs/go-ipfs/mfs"
+	pin "github.com/ipfs/go-ipfs/pin"
+
+	blocks "gx/ipfs/QmaG4DZ4JaqEfvPWt5nPPgoTzhc1tr1T3f4Nu9Jpdm8ymY/go-ipfs-blockstore"
+	ipld "gx/ipfs/Qme5bWv7wtjUNGsK2BNGVUFPKiuxWrsqrtvYwCLRw8YFES/go-ipld-format"
+)
+
+// StrategyEnv is the content of the node strategies pick keys from.
+type StrategyEnv struct {
+	Blockstore blocks.Blockstore
+	Pinning    pin.Pinner
+	DAG        ipld.DAGService
+	FilesRoot  *mfs.Root
+}
+
+// StrategyFunc returns the key provider of a strategy.
+type StrategyFunc func(env StrategyEnv) (KeyChanFunc, error)
+
+// Strategies maps names of strategies to their key providers. Strategies
+// are composed by joining their names with '+', which announces the keys of
+// all of them.
+type Strategies struct {
+	lk sync.RWMutex
+	m  map[string]StrategyFunc
+}
+
+// NewStrategies returns an empty set of strategies.
+func NewStrategies() *Strategies {
+	return &Strategies{m: make(map[string]StrategyFunc)}
+}
+
+// DefaultStrategies holds the built-in strategies and the ones registered by
+// plugins:
+//   - "all" announces all stored data
+//   - "pinned" announces pinned data
+//   - "roots" announces directly pinned keys and root keys of recursive pins
+//   - "mfs" announces the stored data reachable from the MFS root
+var DefaultStrategies = NewStrategies()
+
+func init() {
+	DefaultStrategies.Register("all", func(env StrategyEnv) (KeyChanFunc, error) {
+		return NewBlockstoreProvider(env.Blockstore), nil
+	})
+	DefaultStrategies.Register("pinned", func(env StrategyEnv) (KeyChanFunc, error) {
+		return NewPinnedProvider(env.Pinning, env.DAG, false), nil
+	})
+	DefaultStrategies.Register("roots", func(env StrategyEnv) (KeyChanFunc, error) {
+		return NewPinnedProvider(env.Pinning, env.DAG, true), nil
+	})
+	DefaultStrategies.Register("mfs", func(env StrategyEnv) (KeyChanFunc, error) {
+		if env.FilesRoot == nil {
+			return nil, errors.New("mfs strategy needs the files root")
+		}
+		return NewMFSProvider(env.FilesRoot, env.Blockstore), nil
+	})
+}
+
+// Register adds a strategy.
+func (s *Strategies) Register(name string, f StrategyFunc) error {
+	if name == "" || strings.Contains(name, "+") {
+		return fmt.Errorf("invalid reprovider strategy name '%s'", name)
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if _, ok := s.m[name]; ok {
+		return fmt.Errorf("reprovider strategy '%s' already registered", name)
+	}
+	s.m[name] = f
+	return nil
+}
+
+// Names returns the names of the strategies, sorted.
+func (s *Strategies) Names() []string {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	out := make([]string, 0, len(s.m))
+	for name := range s.m {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// KeyProvider returns the key provider of the strategy, which may be a
+// composition of strategies like "pinned+mfs". The empty strategy is "all".
+func (s *Strategies) KeyProvider(strategy string, env StrategyEnv) (KeyChanFunc, error) {
+	if strategy == "" {
+		strategy = "all"
+	}
+
+	var providers []KeyChanFunc
+	for _, name := range strings.Split(strategy, "+") {
+		s.lk.RLock()
+		f, ok := s.m[name]
+		s.lk.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown reprovider strategy '%s'", name)
+		}
+
+		kp, err := f(env)
+		if err != nil {
+			return nil, fmt.Errorf("reprovider strategy '%s': %s", name, err)
+		}
+		providers = append(providers, kp)
+	}
+	return NewUnionProvider(providers...), nil
+}
@@ -0,0 +1,154 @@
+package reprovide_test
+
+import (
+	"context"
+	"testing"
+
+	bserv "github.com/ipfs/go-ipfs/blockservice"
+	offline "github.com/ipfs/go-ipfs/exchange/offline"
+	merkledag "github.com/ipfs/go-ipfs/merkledag"
+	mfs "github.com/ipfs/go-ipfs/mfs"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+
+	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
+	dssync "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore/sync"
+	blockstore "gx/ipfs/QmaG4DZ4JaqEfvPWt5nPPgoTzhc1tr1T3f4Nu9Jpdm8ymY/go-ipfs-blockstore"
+	cid "gx/ipfs/QmcZfnkapfECQGcLZaf9B79NRg7cRa9EnZh4LSbkCzwNvY/go-cid"
+	blocks "gx/ipfs/Qmej7nf81hi2x2tvjRBF3mcp74sQyuDH4VMYDGd1YtXjb2/go-block-format"
+
+	. "github.com/ipfs/go-ipfs/exchange/reprovide"
+)
+
+func keysProvider(keys ...*cid.Cid) KeyChanFunc {
+	return func(ctx context.Context) (<-chan *cid.Cid, error) {
+		out := make(chan *cid.Cid, len(keys))
+		for _, c := range keys {
+			out <- c
+		}
+		close(out)
+		return out, nil
+	}
+}
+
+func collectKeys(t *testing.T, kp KeyChanFunc) map[string]int {
+	ch, err := kp(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]int)
+	for c := range ch {
+		out[c.KeyString()]++
+	}
+	return out
+}
+
+func TestUnionProvider(t *testing.T) {
+	a := blocks.NewBlock([]byte("a")).Cid()
+	b := blocks.NewBlock([]byte("b")).Cid()
+	c := blocks.NewBlock([]byte("c")).Cid()
+
+	keys := collectKeys(t, NewUnionProvider(keysProvider(a, b), keysProvider(b, c)))
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 keys, got %d", len(keys))
+	}
+	for k, n := range keys {
+		if n != 1 {
+			t.Fatalf("key %s provided %d times", k, n)
+		}
+	}
+}
+
+func TestStrategies(t *testing.T) {
+	a := blocks.NewBlock([]byte("a")).Cid()
+	b := blocks.NewBlock([]byte("b")).Cid()
+
+	s := NewStrategies()
+	for name, c := range map[string]*cid.Cid{"a": a, "b": b} {
+		c := c
+		err := s.Register(name, func(StrategyEnv) (KeyChanFunc, error) {
+			return keysProvider(c), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Register("a", nil); err == nil {
+		t.Fatal("expected registering a strategy twice to fail")
+	}
+	if err := s.Register("a+b", nil); err == nil {
+		t.Fatal("expected registering a composed name to fail")
+	}
+
+	kp, err := s.KeyProvider("a+b", StrategyEnv{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := collectKeys(t, kp); len(keys) != 2 {
+		t.Fatalf("expected the keys of both strategies, got %d", len(keys))
+	}
+
+	if _, err := s.KeyProvider("a+c", StrategyEnv{}); err == nil {
+		t.Fatal("expected unknown strategy to fail")
+	}
+
+	names := DefaultStrategies.Names()
+	for _, n := range []string{"all", "mfs", "pinned", "roots"} {
+		found := false
+		for _, name := range names {
+			found = found || name == n
+		}
+		if !found {
+			t.Fatalf("built-in strategy %s missing from %v", n, names)
+		}
+	}
+}
+
+func TestMFSProvider(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bstore := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	dag := merkledag.NewDAGService(bserv.New(bstore, offline.Exchange(bstore)))
+
+	root, err := mfs.NewRoot(ctx, dag, ft.EmptyDirNode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := merkledag.NodeWithData(ft.FilePBData([]byte("stored"), 6))
+	if err := dag.Add(ctx, stored); err != nil {
+		t.Fatal(err)
+	}
+	missing := merkledag.NodeWithData(ft.FilePBData([]byte("missing"), 7))
+
+	if err := mfs.PutNode(root, "/stored", stored); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.PutNode(root, "/missing", missing); err != nil {
+		t.Fatal(err)
+	}
+
+	kp, err := DefaultStrategies.KeyProvider("mfs", StrategyEnv{Blockstore: bstore, FilesRoot: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := collectKeys(t, kp)
+
+	rootNd, err := root.GetValue().GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys[rootNd.Cid().KeyString()] != 1 || keys[stored.Cid().KeyString()] != 1 {
+		t.Fatal("expected the root and the stored file to be provided")
+	}
+	if keys[missing.Cid().KeyString()] != 0 {
+		t.Fatal("provided a key which is not stored")
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+
+	if _, err := DefaultStrategies.KeyProvider("mfs", StrategyEnv{Blockstore: bstore}); err == nil {
+		t.Fatal("expected mfs strategy without files root to fail")
+	}
+}
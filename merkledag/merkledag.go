@@ -152,6 +152,18 @@ func (sg *sesGetter) GetMany(ctx context.Context, keys []*cid.Cid) <-chan *ipld.
 	return getNodesFromBG(ctx, sg.bs, keys)
 }
 
+// GetManyWithPriority is like GetMany, fetching the nodes of higher priority
+// first.
+func (sg *sesGetter) GetManyWithPriority(ctx context.Context, keys []*cid.Cid, prios []int) <-chan *ipld.NodeOption {
+	keys, prios = dedupKeysPriority(keys, prios)
+	return decodeBlocks(ctx, sg.bs.GetBlocksWithPriority(ctx, keys, prios), len(keys))
+}
+
+// Reprioritize changes the priorities of nodes being fetched.
+func (sg *sesGetter) Reprioritize(keys []*cid.Cid, prios []int) {
+	sg.bs.Reprioritize(keys, prios)
+}
+
 // Session returns a NodeGetter using a new session for block fetches.
 func (n *dagService) Session(ctx context.Context) ipld.NodeGetter {
 	return &sesGetter{bserv.NewSession(ctx, n.Blocks)}
@@ -212,20 +224,43 @@ func dedupKeys(keys []*cid.Cid) []*cid.Cid {
 	return set.Keys()
 }
 
+// dedupKeysPriority removes the duplicate keys, keeping their highest
+// priority.
+func dedupKeysPriority(keys []*cid.Cid, prios []int) ([]*cid.Cid, []int) {
+	index := make(map[string]int, len(keys))
+	outk := make([]*cid.Cid, 0, len(keys))
+	outp := make([]int, 0, len(keys))
+	for i, c := range keys {
+		if j, ok := index[c.KeyString()]; ok {
+			if prios[i] > outp[j] {
+				outp[j] = prios[i]
+			}
+			continue
+		}
+		index[c.KeyString()] = len(outk)
+		outk = append(outk, c)
+		outp = append(outp, prios[i])
+	}
+	return outk, outp
+}
+
 func getNodesFromBG(ctx context.Context, bs bserv.BlockGetter, keys []*cid.Cid) <-chan *ipld.NodeOption {
 	keys = dedupKeys(keys)
+	return decodeBlocks(ctx, bs.GetBlocks(ctx, keys), len(keys))
+}
 
-	out := make(chan *ipld.NodeOption, len(keys))
-	blocks := bs.GetBlocks(ctx, keys)
+// decodeBlocks decodes the n blocks coming from the channel into nodes.
+func decodeBlocks(ctx context.Context, blks <-chan blocks.Block, n int) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption, n)
 	var count int
 
 	go func() {
 		defer close(out)
 		for {
 			select {
-			case b, ok := <-blocks:
+			case b, ok := <-blks:
 				if !ok {
-					if count != len(keys) {
+					if count != n {
 						out <- &ipld.NodeOption{Err: fmt.Errorf("failed to fetch all nodes")}
 					}
 					return
@@ -401,4 +436,5 @@ func EnumerateChildrenAsync(ctx context.Context, getLinks GetLinks, c *cid.Cid,
 var _ ipld.LinkGetter = &dagService{}
 var _ ipld.NodeGetter = &dagService{}
 var _ ipld.NodeGetter = &sesGetter{}
+var _ PriorityGetter = &sesGetter{}
 var _ ipld.DAGService = &dagService{}
@@ -3,6 +3,7 @@ package merkledag
 import (
 	"context"
 
+	cid "gx/ipfs/QmcZfnkapfECQGcLZaf9B79NRg7cRa9EnZh4LSbkCzwNvY/go-cid"
 	ipld "gx/ipfs/Qme5bWv7wtjUNGsK2BNGVUFPKiuxWrsqrtvYwCLRw8YFES/go-ipld-format"
 )
 
@@ -19,3 +20,64 @@ func NewSession(ctx context.Context, g ipld.NodeGetter) ipld.NodeGetter {
 	}
 	return g
 }
+
+// PriorityGetter is a NodeGetter which fetches nodes by priority, higher
+// first. Sessions returned by the DAGService are PriorityGetters.
+type PriorityGetter interface {
+	ipld.NodeGetter
+
+	// GetManyWithPriority is like GetMany, with the priority of each key.
+	GetManyWithPriority(ctx context.Context, keys []*cid.Cid, prios []int) <-chan *ipld.NodeOption
+
+	// Reprioritize changes the priorities of nodes being fetched.
+	Reprioritize(keys []*cid.Cid, prios []int)
+}
+
+// GetNodesWithPriority is like ipld.GetNodes, fetching the nodes by priority
+// if the NodeGetter is a PriorityGetter.
+func GetNodesWithPriority(ctx context.Context, ng ipld.NodeGetter, keys []*cid.Cid, prios []int) []*ipld.NodePromise {
+	pg, ok := ng.(PriorityGetter)
+	if !ok {
+		return ipld.GetNodes(ctx, ng, keys)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	promises := make([]*ipld.NodePromise, len(keys))
+	for i := range keys {
+		promises[i] = ipld.NewNodePromise(ctx)
+	}
+
+	go func() {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		nodes := pg.GetManyWithPriority(ctx, keys, prios)
+		for count := 0; count < len(keys); {
+			select {
+			case opt, ok := <-nodes:
+				if !ok {
+					for _, p := range promises {
+						p.Fail(ipld.ErrNotFound)
+					}
+					return
+				}
+				if opt.Err != nil {
+					for _, p := range promises {
+						p.Fail(opt.Err)
+					}
+					return
+				}
+
+				for _, i := range FindLinks(keys, opt.Node.Cid(), 0) {
+					count++
+					promises[i].Send(opt.Node)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return promises
+}
@@ -6,6 +6,7 @@ import (
 	context "context"
 
 	opts "github.com/ipfs/go-ipfs/namesys/opts"
+	pb "github.com/ipfs/go-ipfs/namesys/pb"
 	path "github.com/ipfs/go-ipfs/path"
 )
 
@@ -53,3 +54,133 @@ func resolve(ctx context.Context, r resolver, name string, options *opts.Resolve
 		}
 	}
 }
+
+// onceResult is an answer of a lookup of a name without recursion.
+type onceResult struct {
+	value path.Path
+	err   error
+
+	// entry and data are the record of the value, when it comes from one.
+	entry *pb.IpnsEntry
+	data  []byte
+}
+
+// asyncResolver is a resolver able to answer progressively better values.
+type asyncResolver interface {
+	// resolveOnceAsync looks up a name once (without recursion), sending
+	// better values as they are found. The channel is closed when done.
+	resolveOnceAsync(ctx context.Context, name string, options *opts.ResolveOpts) <-chan onceResult
+}
+
+// resolveOnceAsync looks up a name once with the resolver, asynchronously
+// when the resolver supports it.
+func resolveOnceAsync(ctx context.Context, r resolver, name string, options *opts.ResolveOpts) <-chan onceResult {
+	if ar, ok := r.(asyncResolver); ok {
+		return ar.resolveOnceAsync(ctx, name, options)
+	}
+
+	out := make(chan onceResult, 1)
+	go func() {
+		defer close(out)
+		p, err := r.resolveOnce(ctx, name, options)
+		out <- onceResult{value: p, err: err}
+	}()
+	return out
+}
+
+// resolveAsync is a helper for implementing Resolver.ResolveAsync using
+// resolveOnceAsync. Every answer of the resolver is resolved recursively and
+// sent unless it is the same as the previous one. An error is only sent when
+// there is no answer. The context must be canceled when stopping to read the
+// channel before it is closed.
+func resolveAsync(ctx context.Context, r resolver, name string, options *opts.ResolveOpts, prefixes ...string) <-chan Result {
+	out := make(chan Result)
+	go func() {
+		defer close(out)
+
+		var last path.Path
+		send := func(res Result) bool {
+			if res.Err == nil && res.Path == last {
+				return true
+			}
+			select {
+			case out <- res:
+				if res.Err == nil {
+					last = res.Path
+				}
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		resolveAsyncDepth(ctx, r, name, options, options.Depth, prefixes, send)
+	}()
+	return out
+}
+
+// resolveAsyncDepth sends the answers for the name, resolved recursively up
+// to the depth. It returns false when sending was canceled.
+func resolveAsyncDepth(ctx context.Context, r resolver, name string, options *opts.ResolveOpts, depth uint, prefixes []string, send func(Result) bool) bool {
+	answered := false
+	var lastErr error
+	for res := range resolveOnceAsync(ctx, r, name, options) {
+		if res.err != nil {
+			lastErr = res.err
+			continue
+		}
+		p := res.value
+		log.Debugf("resolved %s to %s", name, p.String())
+		answered = true
+
+		if strings.HasPrefix(p.String(), "/ipfs/") {
+			// we've bottomed out with an IPFS path
+			if !send(Result{Path: p}) {
+				return false
+			}
+			continue
+		}
+
+		if depth == 1 {
+			if !send(Result{Path: p, Err: ErrResolveRecursion}) {
+				return false
+			}
+			continue
+		}
+
+		next := ""
+		matched := false
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(p.String(), prefix) {
+				matched = true
+				next = p.String()
+				if len(prefixes) == 1 {
+					next = strings.TrimPrefix(p.String(), prefix)
+				}
+				break
+			}
+		}
+
+		if !matched {
+			if !send(Result{Path: p}) {
+				return false
+			}
+			continue
+		}
+
+		ndepth := depth
+		if depth > 1 {
+			ndepth--
+		}
+		if !resolveAsyncDepth(ctx, r, next, options, ndepth, prefixes, send) {
+			return false
+		}
+	}
+
+	if !answered {
+		if lastErr == nil {
+			lastErr = ErrResolveFailed
+		}
+		return send(Result{Err: lastErr})
+	}
+	return true
+}
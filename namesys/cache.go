@@ -0,0 +1,293 @@
+package namesys
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	path "github.com/ipfs/go-ipfs/path"
+
+	lru "gx/ipfs/QmVYxfoJQiZijTgPNHCHgHELvQpbsJNTg6Crmc3dQkj3yy/golang-lru"
+	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
+	dsq "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore/query"
+)
+
+// DefaultRevalidateTimeout is the time limit of the background resolutions
+// refreshing stale entries of the cache.
+const DefaultRevalidateTimeout = time.Minute
+
+// cachePrefix is the datastore namespace of the persisted cache entries.
+var cachePrefix = ds.NewKey("/namesys/cache")
+
+type cacheEntry struct {
+	val path.Path
+	// eol is the end of the TTL of the record, after which the entry is
+	// only served stale.
+	eol time.Time
+	// recordEOL is the end of validity of the record, after which the entry
+	// is never served.
+	recordEOL time.Time
+}
+
+// storedCacheEntry is a cache entry as persisted in the datastore.
+type storedCacheEntry struct {
+	Value     path.Path
+	Expires   time.Time
+	RecordEOL time.Time
+}
+
+// resolveCache is the lru cache of resolved names. Entries are persisted in
+// the datastore, when there is one, loaded back on start and deleted from it
+// when evicted.
+type resolveCache struct {
+	lru    *lru.Cache
+	dstore ds.Datastore
+
+	mu sync.Mutex
+	// revalidated holds the names served stale while they are refreshed.
+	revalidated map[string]bool
+}
+
+// newResolveCache returns a cache of the given size, nil when the size is
+// not positive. dstore may be nil for a memory only cache.
+func newResolveCache(size int, dstore ds.Datastore) *resolveCache {
+	if size <= 0 {
+		return nil
+	}
+
+	c := &resolveCache{
+		dstore:      dstore,
+		revalidated: make(map[string]bool),
+	}
+	c.lru, _ = lru.NewWithEvict(size, c.evicted)
+	if dstore != nil {
+		if err := c.load(); err != nil {
+			log.Errorf("could not load the resolve cache: %s", err)
+		}
+	}
+	return c
+}
+
+// load adds the entries of the datastore to the cache, dropping the entries
+// which cannot be served anymore.
+func (c *resolveCache) load() error {
+	res, err := c.dstore.Query(dsq.Query{Prefix: cachePrefix.String()})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	now := time.Now()
+	for e := range res.Next() {
+		if e.Error != nil {
+			return e.Error
+		}
+		k := ds.RawKey(e.Key)
+
+		var se storedCacheEntry
+		data, ok := e.Value.([]byte)
+		if !ok || json.Unmarshal(data, &se) != nil {
+			log.Warningf("dropping invalid resolve cache entry %s", k)
+			c.dstore.Delete(k)
+			continue
+		}
+		if !now.Before(se.Expires) && !now.Before(se.RecordEOL) {
+			c.dstore.Delete(k)
+			continue
+		}
+		c.lru.Add(k.BaseNamespace(), cacheEntry{
+			val:       se.Value,
+			eol:       se.Expires,
+			recordEOL: se.RecordEOL,
+		})
+	}
+	return nil
+}
+
+// get returns the cached value of the name. Values past their TTL are only
+// returned, as stale, for the revalidated names.
+func (c *resolveCache) get(name string) (val path.Path, stale bool, ok bool) {
+	if c == nil {
+		return "", false, false
+	}
+
+	ientry, ok := c.lru.Get(name)
+	if !ok {
+		return "", false, false
+	}
+
+	entry, ok := ientry.(cacheEntry)
+	if !ok {
+		// should never happen, purely for sanity
+		log.Panicf("unexpected type %T in cache for %q.", ientry, name)
+	}
+
+	now := time.Now()
+	if c.servable(name, entry, now) {
+		return entry.val, !now.Before(entry.eol), true
+	}
+
+	c.remove(name)
+	return "", false, false
+}
+
+func (c *resolveCache) set(name string, entry cacheEntry) {
+	if c == nil {
+		return
+	}
+
+	c.lru.Add(name, entry)
+	if c.dstore == nil {
+		return
+	}
+
+	data, err := json.Marshal(&storedCacheEntry{
+		Value:     entry.val,
+		Expires:   entry.eol,
+		RecordEOL: entry.recordEOL,
+	})
+	if err != nil {
+		log.Errorf("could not encode the cache entry of %s: %s", name, err)
+		return
+	}
+	if err := c.dstore.Put(cachePrefix.ChildString(name), data); err != nil {
+		log.Errorf("could not persist the cache entry of %s: %s", name, err)
+	}
+}
+
+func (c *resolveCache) remove(name string) {
+	c.lru.Remove(name)
+}
+
+// evicted deletes the persisted entry of a name removed from the lru.
+func (c *resolveCache) evicted(key interface{}, value interface{}) {
+	if c.dstore == nil {
+		return
+	}
+	name := key.(string)
+	err := c.dstore.Delete(cachePrefix.ChildString(name))
+	if err != nil && err != ds.ErrNotFound {
+		log.Errorf("could not delete the cache entry of %s: %s", name, err)
+	}
+}
+
+// flush removes all the entries. The persisted entries are all in memory,
+// so they are deleted as they are evicted.
+func (c *resolveCache) flush() error {
+	c.lru.Purge()
+	return nil
+}
+
+// servable tells whether the entry of the name may still be served, fresh
+// or stale.
+func (c *resolveCache) servable(name string, entry cacheEntry, now time.Time) bool {
+	return now.Before(entry.eol) || c.isRevalidated(name) && now.Before(entry.recordEOL)
+}
+
+func (c *resolveCache) isRevalidated(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.revalidated[name]
+}
+
+func (c *resolveCache) setRevalidated(names []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revalidated = make(map[string]bool, len(names))
+	for _, name := range names {
+		c.revalidated[strings.TrimPrefix(name, "/ipns/")] = true
+	}
+}
+
+// CacheEntry is an entry of the resolve cache of the name system.
+type CacheEntry struct {
+	Name  string
+	Value path.Path
+	// Expires is the end of the TTL of the record.
+	Expires time.Time
+	// EOL is the end of validity of the record.
+	EOL time.Time
+	// Revalidated tells whether the entry is served stale past its TTL
+	// while the name is resolved again in the background.
+	Revalidated bool
+}
+
+// cacheOf returns the resolve cache of the name system.
+func cacheOf(ns NameSystem) (*resolveCache, error) {
+	mpns, ok := ns.(*mpns)
+	if !ok {
+		return nil, errors.New("unexpected NameSystem; not an mpns instance")
+	}
+	rr, ok := mpns.resolvers["dht"].(*routingResolver)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type %T as DHT resolver", mpns.resolvers["dht"])
+	}
+	return rr.cache, nil
+}
+
+// CacheEntries lists the entries of the resolve cache of the name system
+// which may still be served, sorted by name. Listing them does not change
+// their recency in the cache.
+func CacheEntries(ns NameSystem) ([]CacheEntry, error) {
+	c, err := cacheOf(ns)
+	if err != nil || c == nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var out []CacheEntry
+	for _, k := range c.lru.Keys() {
+		ientry, ok := c.lru.Peek(k)
+		if !ok {
+			continue
+		}
+		entry := ientry.(cacheEntry)
+		name := k.(string)
+		if !c.servable(name, entry, now) {
+			continue
+		}
+		out = append(out, CacheEntry{
+			Name:        name,
+			Value:       entry.val,
+			Expires:     entry.eol,
+			EOL:         entry.recordEOL,
+			Revalidated: c.isRevalidated(name),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// FlushCache removes the names from the resolve cache of the name system,
+// or all the entries when no name is given.
+func FlushCache(ns NameSystem, names ...string) error {
+	c, err := cacheOf(ns)
+	if err != nil || c == nil {
+		return err
+	}
+
+	if len(names) == 0 {
+		return c.flush()
+	}
+	for _, name := range names {
+		c.remove(strings.TrimPrefix(name, "/ipns/"))
+	}
+	return nil
+}
+
+// SetRevalidatedNames sets the names which are served stale from the resolve
+// cache of the name system, once the TTL of their record is over, while they
+// are resolved again in the background. Stale values are never served past
+// the end of validity of the record.
+func SetRevalidatedNames(ns NameSystem, names []string) error {
+	c, err := cacheOf(ns)
+	if err != nil || c == nil {
+		return err
+	}
+	c.setRevalidated(names)
+	return nil
+}
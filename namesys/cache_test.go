@@ -0,0 +1,134 @@
+package namesys
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	path "github.com/ipfs/go-ipfs/path"
+
+	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
+	dssync "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore/sync"
+	offroute "gx/ipfs/QmXtoXbu9ReyV6Q4kDQ5CF9wXQNDY1PdHc4HhfxRR5AHB3/go-ipfs-routing/offline"
+)
+
+func TestResolveCachePersisted(t *testing.T) {
+	dstore := ds.NewMapDatastore()
+	now := time.Now()
+
+	c := newResolveCache(10, dstore)
+	c.set("fresh", cacheEntry{val: "/ipfs/fresh", eol: now.Add(time.Hour), recordEOL: now.Add(2 * time.Hour)})
+	c.set("stale", cacheEntry{val: "/ipfs/stale", eol: now.Add(-time.Minute), recordEOL: now.Add(time.Hour)})
+	c.set("expired", cacheEntry{val: "/ipfs/expired", eol: now.Add(-time.Hour), recordEOL: now.Add(-time.Minute)})
+
+	// entries are loaded back, except those which cannot be served anymore
+	c = newResolveCache(10, dstore)
+	if val, stale, ok := c.get("fresh"); !ok || stale || val != "/ipfs/fresh" {
+		t.Fatalf("unexpected fresh entry: %s %t %t", val, stale, ok)
+	}
+	if _, err := dstore.Get(cachePrefix.ChildString("expired")); err != ds.ErrNotFound {
+		t.Fatalf("expected the expired entry to be dropped, got %v", err)
+	}
+
+	// stale entries are only served for revalidated names
+	c.setRevalidated([]string{"/ipns/stale"})
+	if val, stale, ok := c.get("stale"); !ok || !stale || val != "/ipfs/stale" {
+		t.Fatalf("unexpected stale entry: %s %t %t", val, stale, ok)
+	}
+	c.setRevalidated(nil)
+	if _, _, ok := c.get("stale"); ok {
+		t.Fatal("expected stale entry not to be served")
+	}
+
+	if err := c.flush(); err != nil {
+		t.Fatal(err)
+	}
+	c = newResolveCache(10, dstore)
+	if c.lru.Len() != 0 {
+		t.Fatalf("expected an empty cache after flush, got %d entries", c.lru.Len())
+	}
+}
+
+func TestResolveCacheRevalidate(t *testing.T) {
+	ctx := context.Background()
+	priv, id, _, _ := genKeys(t)
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := offroute.NewOfflineRouter(dstore, priv)
+
+	p := path.Path("/ipfs/QmfM2r8seH2GiRaC4esTjeraXEachRt8ZsSeGaWTPLyMoG")
+	if err := NewRoutingPublisher(r, dstore).Publish(ctx, priv, p); err != nil {
+		t.Fatal(err)
+	}
+
+	ns := NewNameSystem(r, dstore, 10)
+	if err := SetRevalidatedNames(ns, []string{id.Pretty()}); err != nil {
+		t.Fatal(err)
+	}
+	rr := ns.(*mpns).resolvers["dht"].(*routingResolver)
+	rr.cache.set(id.Pretty(), cacheEntry{
+		val:       "/ipfs/QmOld",
+		eol:       time.Now().Add(-time.Minute),
+		recordEOL: time.Now().Add(time.Hour),
+	})
+
+	// the stale value is served while the name is resolved again
+	got, err := rr.resolveOnce(ctx, id.Pretty(), nil)
+	if err != nil || got != "/ipfs/QmOld" {
+		t.Fatalf("expected the stale value, got %s %v", got, err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		entries, err := CacheEntries(ns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) == 1 && entries[0].Value == p {
+			if !entries[0].Revalidated {
+				t.Fatal("expected the entry to be revalidated")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the revalidation")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := FlushCache(ns, "/ipns/"+id.Pretty()); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := CacheEntries(ns); len(entries) != 0 {
+		t.Fatalf("expected no entries after flush, got %d", len(entries))
+	}
+}
+
+func TestResolveCacheEviction(t *testing.T) {
+	priv, _, _, _ := genKeys(t)
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := offroute.NewOfflineRouter(dstore, priv)
+	now := time.Now()
+
+	ns := NewNameSystem(r, dstore, 2)
+	c := ns.(*mpns).resolvers["dht"].(*routingResolver).cache
+	c.set("expired", cacheEntry{val: "/ipfs/expired", eol: now.Add(-time.Minute), recordEOL: now.Add(time.Hour)})
+	c.set("fresh", cacheEntry{val: "/ipfs/fresh", eol: now.Add(time.Hour), recordEOL: now.Add(2 * time.Hour)})
+
+	// entries which cannot be served are not listed
+	entries, err := CacheEntries(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "fresh" {
+		t.Fatalf("expected only the fresh entry, got %v", entries)
+	}
+
+	// evicted entries are deleted from the datastore
+	c.set("other", cacheEntry{val: "/ipfs/other", eol: now.Add(time.Hour), recordEOL: now.Add(2 * time.Hour)})
+	if _, err := dstore.Get(cachePrefix.ChildString("expired")); err != ds.ErrNotFound {
+		t.Fatalf("expected the evicted entry to be deleted, got %v", err)
+	}
+	if _, err := dstore.Get(cachePrefix.ChildString("fresh")); err != nil {
+		t.Fatal(err)
+	}
+}
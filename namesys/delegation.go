@@ -0,0 +1,432 @@
+package namesys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	pb "github.com/ipfs/go-ipfs/namesys/pb"
+	path "github.com/ipfs/go-ipfs/path"
+
+	cbor "gx/ipfs/QmNRz7BDWfdFNVLt7AVvmRefkrURD25EeoipcXqo6yoXU1/go-ipld-cbor"
+	u "gx/ipfs/QmNiJuT8Ja3hMVpBHXv3Q6dwmperaQ6JjLtpMQgMCD7xvx/go-ipfs-util"
+	routing "gx/ipfs/QmTiWLZ6Fo5j4KcTVutZJ5KWRRJrbxzmxA4td8NfEdrPh7/go-libp2p-routing"
+	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+// DefaultDelegationLifetime is the validity of delegations. The owner of a
+// name renews its delegation whenever it publishes or republishes the name.
+const DefaultDelegationLifetime = 30 * 24 * time.Hour
+
+// Keys of the signed data of delegations.
+const (
+	delegationData      = "Data"
+	delegationSignature = "Signature"
+	delegationWriters   = "Writers"
+	delegationSequence  = "Sequence"
+	delegationValidity  = "Validity"
+)
+
+// ErrNotDelegated should be returned when an ipns record is signed by a key
+// which is not a delegated writer of the name
+var ErrNotDelegated = errors.New("key is not a delegated writer of the name")
+
+// ErrExpiredDelegation should be returned when the delegation carried by an
+// ipns record is too old
+var ErrExpiredDelegation = errors.New("expired delegation")
+
+// ErrNoRecord is returned when a name has no record to carry a delegation.
+var ErrNoRecord = errors.New("the name has no record; publish it first")
+
+// Delegation lists the keys, other than the key of the name, allowed to
+// sign the records of a name. It is signed by the owner of the name, and
+// carried by the v2 records of the name. Records carrying the delegation
+// with the highest sequence number win over the others, so that removed
+// writers cannot override the name.
+type Delegation struct {
+	Writers  []ci.PubKey
+	Sequence uint64
+	EOL      time.Time
+}
+
+// HasWriter returns whether the key is a writer of the delegation.
+func (d *Delegation) HasWriter(pk ci.PubKey) bool {
+	for _, w := range d.Writers {
+		if w.Equals(pk) {
+			return true
+		}
+	}
+	return false
+}
+
+// update returns the next delegation, with the writers added and removed.
+func (d *Delegation) update(add, remove []ci.PubKey) *Delegation {
+	nd := &Delegation{
+		Sequence: d.Sequence + 1,
+		EOL:      time.Now().Add(DefaultDelegationLifetime),
+	}
+	for _, w := range append(d.Writers, add...) {
+		if nd.HasWriter(w) {
+			continue
+		}
+		removed := false
+		for _, r := range remove {
+			if r.Equals(w) {
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			nd.Writers = append(nd.Writers, w)
+		}
+	}
+	return nd
+}
+
+func delegationDataForSig(data []byte) []byte {
+	return append([]byte("ipns-delegation:"), data...)
+}
+
+// signDelegation returns the delegation signed by the owner of the name.
+func signDelegation(owner ci.PrivKey, d *Delegation) ([]byte, error) {
+	writers := make([][]byte, 0, len(d.Writers))
+	for _, w := range d.Writers {
+		b, err := w.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, b)
+	}
+
+	data, err := cbor.DumpObject(map[string]interface{}{
+		delegationWriters:  writers,
+		delegationSequence: d.Sequence,
+		delegationValidity: []byte(u.FormatRFC3339(d.EOL)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sig, err := owner.Sign(delegationDataForSig(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return cbor.DumpObject(map[string]interface{}{
+		delegationData:      data,
+		delegationSignature: sig,
+	})
+}
+
+// decodeDelegation parses a signed delegation, without verifying it.
+func decodeDelegation(signed []byte) (*Delegation, []byte, []byte, error) {
+	var outer map[string]interface{}
+	if err := cbor.DecodeInto(signed, &outer); err != nil {
+		return nil, nil, nil, ErrBadRecord
+	}
+	data, _ := outer[delegationData].([]byte)
+	sig, _ := outer[delegationSignature].([]byte)
+
+	var fields map[string]interface{}
+	if err := cbor.DecodeInto(data, &fields); err != nil {
+		return nil, nil, nil, ErrBadRecord
+	}
+
+	d := new(Delegation)
+	d.Sequence, _ = toUint64(fields[delegationSequence])
+	validity, _ := fields[delegationValidity].([]byte)
+	eol, err := u.ParseRFC3339(string(validity))
+	if err != nil {
+		return nil, nil, nil, ErrBadRecord
+	}
+	d.EOL = eol
+
+	writers, _ := fields[delegationWriters].([]interface{})
+	for _, w := range writers {
+		b, _ := w.([]byte)
+		pk, err := ci.UnmarshalPublicKey(b)
+		if err != nil {
+			return nil, nil, nil, ErrBadRecord
+		}
+		d.Writers = append(d.Writers, pk)
+	}
+	return d, data, sig, nil
+}
+
+// openDelegation verifies the signature of the delegation by the owner of
+// the name, and returns it. It does not check its EOL.
+func openDelegation(owner ci.PubKey, signed []byte) (*Delegation, error) {
+	d, data, sig, err := decodeDelegation(signed)
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := owner.Verify(delegationDataForSig(data), sig); err != nil || !ok {
+		return nil, ErrSignature
+	}
+	return d, nil
+}
+
+// entryDelegation returns the signed delegation carried by the record, or
+// nil if it carries none.
+func entryDelegation(e *pb.IpnsEntry) ([]byte, error) {
+	if e == nil || !isV2(e) {
+		return nil, nil
+	}
+	data, err := entryData(e)
+	if err != nil {
+		return nil, ErrBadRecord
+	}
+	signed, _ := data[dataDelegation].([]byte)
+	return signed, nil
+}
+
+// entryDelegationSeq returns the sequence number of the delegation carried
+// by the record, or zero. The record must have been validated.
+func entryDelegationSeq(e *pb.IpnsEntry) uint64 {
+	signed, err := entryDelegation(e)
+	if err != nil || signed == nil {
+		return 0
+	}
+	d, _, _, err := decodeDelegation(signed)
+	if err != nil {
+		return 0
+	}
+	return d.Sequence
+}
+
+// verifyDelegation checks the delegation carried by the record of the
+// owner, and returns the public key the record must be signed with: the one
+// of the owner, or the one of the delegated writer which signed it.
+func verifyDelegation(owner ci.PubKey, e *pb.IpnsEntry) (ci.PubKey, error) {
+	if !isV2(e) {
+		return owner, nil
+	}
+	data, err := entryData(e)
+	if err != nil {
+		return nil, ErrBadRecord
+	}
+
+	signed, _ := data[dataDelegation].([]byte)
+	writer, _ := data[dataWriter].([]byte)
+	if signed == nil {
+		if writer != nil {
+			return nil, ErrNotDelegated
+		}
+		return owner, nil
+	}
+
+	d, err := openDelegation(owner, signed)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(d.EOL) {
+		return nil, ErrExpiredDelegation
+	}
+	if writer == nil {
+		return owner, nil
+	}
+
+	pk, err := ci.UnmarshalPublicKey(writer)
+	if err != nil {
+		return nil, ErrBadRecord
+	}
+	if !d.HasWriter(pk) {
+		return nil, ErrNotDelegated
+	}
+	return pk, nil
+}
+
+// verifyRecord checks the signature of the record of the owner, signed by
+// the owner or by a delegated writer of the name.
+func verifyRecord(owner ci.PubKey, e *pb.IpnsEntry) error {
+	signer, err := verifyDelegation(owner, e)
+	if err != nil {
+		return err
+	}
+	return verifyEntry(signer, e)
+}
+
+// renewDelegation returns the fields carrying the delegation of the
+// previous record of the owner, with a new EOL, or nil if it carries none.
+func renewDelegation(owner ci.PrivKey, prev *pb.IpnsEntry) (map[string]interface{}, error) {
+	signed, err := entryDelegation(prev)
+	if err != nil || signed == nil {
+		return nil, err
+	}
+	d, err := openDelegation(owner.GetPublic(), signed)
+	if err != nil {
+		return nil, err
+	}
+
+	d.EOL = time.Now().Add(DefaultDelegationLifetime)
+	signed, err = signDelegation(owner, d)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{dataDelegation: signed}, nil
+}
+
+// namePubKey returns the public key of the name of the record.
+func namePubKey(ctx context.Context, r routing.ValueStore, id peer.ID, e *pb.IpnsEntry) (ci.PubKey, error) {
+	if pk := id.ExtractPublicKey(); pk != nil {
+		return pk, nil
+	}
+	if len(e.GetPubKey()) > 0 {
+		pk, err := ci.UnmarshalPublicKey(e.GetPubKey())
+		if err != nil {
+			return nil, ErrBadRecord
+		}
+		if !id.MatchesPublicKey(pk) {
+			return nil, ErrPublicKeyMismatch
+		}
+		return pk, nil
+	}
+	return routing.GetPublicKey(r, ctx, []byte(id))
+}
+
+// latestEntry returns the record of the name in the routing system.
+func latestEntry(ctx context.Context, r routing.ValueStore, id peer.ID) (*pb.IpnsEntry, error) {
+	_, ipnskey := IpnsKeysForID(id)
+	val, err := r.GetValue(ctx, ipnskey)
+	if err != nil {
+		if err == routing.ErrNotFound {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	e := new(pb.IpnsEntry)
+	if err := proto.Unmarshal(val, e); err != nil {
+		return nil, ErrBadRecord
+	}
+	return e, nil
+}
+
+// LookupDelegation returns the delegation of the name carried by its record
+// in the routing system. Names without a delegation have an empty one.
+func LookupDelegation(ctx context.Context, r routing.ValueStore, id peer.ID) (*Delegation, error) {
+	e, err := latestEntry(ctx, r, id)
+	if err != nil {
+		return nil, err
+	}
+	signed, err := entryDelegation(e)
+	if err != nil || signed == nil {
+		return &Delegation{}, err
+	}
+
+	owner, err := namePubKey(ctx, r, id, e)
+	if err != nil {
+		return nil, err
+	}
+	return openDelegation(owner, signed)
+}
+
+// UpdateDelegation adds and removes writers of the delegation of the name of
+// the owner key, and publishes the last record of the name again, carrying
+// the new delegation.
+func UpdateDelegation(ctx context.Context, owner ci.PrivKey, add, remove []ci.PubKey, r routing.ValueStore, dstore ds.Datastore) (*Delegation, error) {
+	id, err := peer.IDFromPrivateKey(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	_, ipnskey := IpnsKeysForID(id)
+	prev, err := NewRoutingPublisher(r, dstore).getPreviousEntry(ctx, ipnskey)
+	if err != nil {
+		return nil, err
+	}
+	if prev == nil {
+		return nil, ErrNoRecord
+	}
+
+	d := new(Delegation)
+	signed, err := entryDelegation(prev)
+	if err != nil {
+		return nil, err
+	}
+	if signed != nil {
+		d, err = openDelegation(owner.GetPublic(), signed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	d = d.update(add, remove)
+	signed, err = signDelegation(owner, d)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{dataDelegation: signed}
+	eol := time.Now().Add(DefaultRecordTTL)
+	err = putRecordToRouting(ctx, owner, path.Path(prev.GetValue()), prev.GetSequence()+1, eol, fields, r, id)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// PublishDelegated publishes a record of the name signed by a delegated
+// writer of the name. Its sequence number follows the one of the record of
+// the name in the routing system, which carries the delegation.
+func PublishDelegated(ctx context.Context, writer ci.PrivKey, id peer.ID, value path.Path, eol time.Time, r routing.ValueStore) error {
+	prev, err := latestEntry(ctx, r, id)
+	if err != nil {
+		return err
+	}
+	owner, err := namePubKey(ctx, r, id, prev)
+	if err != nil {
+		return err
+	}
+
+	signed, err := entryDelegation(prev)
+	if err != nil {
+		return err
+	}
+	if signed == nil {
+		return ErrNotDelegated
+	}
+	d, err := openDelegation(owner, signed)
+	if err != nil {
+		return err
+	}
+	if time.Now().After(d.EOL) {
+		return ErrExpiredDelegation
+	}
+	if !d.HasWriter(writer.GetPublic()) {
+		return ErrNotDelegated
+	}
+
+	wb, err := writer.GetPublic().Bytes()
+	if err != nil {
+		return err
+	}
+	fields := map[string]interface{}{
+		dataDelegation: signed,
+		dataWriter:     wb,
+	}
+
+	ttl, _ := checkCtxTTL(ctx)
+	entry, err := createEntryV2(writer, value, prev.GetSequence()+1, eol, ttl, fields)
+	if err != nil {
+		return err
+	}
+
+	// the record carries the key of the name, not the one of the writer
+	entry.PubKey = nil
+	if id.ExtractPublicKey() == nil {
+		entry.PubKey, err = owner.Bytes()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, ipnskey := IpnsKeysForID(id)
+	if err := PublishEntry(ctx, r, ipnskey, entry); err != nil {
+		return fmt.Errorf("could not publish the record of %s: %s", id.Pretty(), err)
+	}
+	return nil
+}
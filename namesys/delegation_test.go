@@ -0,0 +1,144 @@
+package namesys
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/ipfs/go-ipfs/namesys/pb"
+	path "github.com/ipfs/go-ipfs/path"
+
+	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
+	dssync "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore/sync"
+	pstore "gx/ipfs/QmXauCuJzmzapetmC6W4TuDJLL1yFFrVzSHoWv8YdbmnxH/go-libp2p-peerstore"
+	offroute "gx/ipfs/QmXtoXbu9ReyV6Q4kDQ5CF9wXQNDY1PdHc4HhfxRR5AHB3/go-ipfs-routing/offline"
+	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+func TestDelegatedWriters(t *testing.T) {
+	ctx := context.Background()
+	owner, id, _, _ := genKeys(t)
+	writer, _, _, _ := genKeys(t)
+	other, _, _, _ := genKeys(t)
+	emptyKbook := pstore.NewPeerstore()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	r := offroute.NewOfflineRouter(dstore, owner)
+
+	latest := func() *pb.IpnsEntry {
+		e, err := latestEntry(ctx, r, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := validateEntry(t, emptyKbook, id, e); err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	_, err := UpdateDelegation(ctx, owner, []ci.PubKey{writer.GetPublic()}, nil, r, dstore)
+	if err != ErrNoRecord {
+		t.Fatalf("expected %s, got %v", ErrNoRecord, err)
+	}
+
+	p1 := path.Path("/ipfs/QmfM2r8seH2GiRaC4esTjeraXEachRt8ZsSeGaWTPLyMoG")
+	if err := NewRoutingPublisher(r, dstore).Publish(ctx, owner, p1); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := UpdateDelegation(ctx, owner, []ci.PubKey{writer.GetPublic()}, nil, r, dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Sequence != 1 || !d.HasWriter(writer.GetPublic()) {
+		t.Fatalf("unexpected delegation: %d %v", d.Sequence, d.Writers)
+	}
+	if e := latest(); path.Path(e.GetValue()) != p1 || e.GetSequence() != 2 {
+		t.Fatalf("expected the record to be published again, got %s %d", e.GetValue(), e.GetSequence())
+	}
+
+	// the writer publishes the name
+	p2 := path.Path("/ipfs/QmP1DfoUjiWH2ZBo1PBH6FupdBucbDepx3HpWmEY6JMUpY")
+	eol := time.Now().Add(time.Hour)
+	if err := PublishDelegated(ctx, writer, id, p2, eol, r); err != nil {
+		t.Fatal(err)
+	}
+	delegated := latest()
+	if path.Path(delegated.GetValue()) != p2 || delegated.GetSequence() != 3 {
+		t.Fatalf("unexpected delegated record: %s %d", delegated.GetValue(), delegated.GetSequence())
+	}
+
+	if err := PublishDelegated(ctx, other, id, p2, eol, r); err != ErrNotDelegated {
+		t.Fatalf("expected %s, got %v", ErrNotDelegated, err)
+	}
+
+	// keys outside the delegation cannot sign records carrying it
+	signed, err := entryDelegation(delegated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ob, err := other.GetPublic().Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := createEntryV2(other, p2, 10, eol, 0, map[string]interface{}{
+		dataDelegation: signed,
+		dataWriter:     ob,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged.PubKey = delegated.PubKey
+	if err := validateEntry(t, emptyKbook, id, forged); err != ErrNotDelegated {
+		t.Fatalf("expected %s, got %v", ErrNotDelegated, err)
+	}
+
+	// the owner keeps the delegation when publishing
+	p3 := path.Path("/ipfs/QmP1wMAqk6aZYRZirbaAwmrNeqFRgQrwBt3orUtvSa1UYD")
+	if err := NewRoutingPublisher(r, dstore).Publish(ctx, owner, p3); err != nil {
+		t.Fatal(err)
+	}
+	if e := latest(); e.GetSequence() != 4 || entryDelegationSeq(e) != 1 {
+		t.Fatalf("unexpected owner record: %d %d", e.GetSequence(), entryDelegationSeq(e))
+	}
+
+	// removed writers cannot override the name anymore
+	d, err = UpdateDelegation(ctx, owner, nil, []ci.PubKey{writer.GetPublic()}, r, dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Sequence != 2 || len(d.Writers) != 0 {
+		t.Fatalf("unexpected delegation: %d %v", d.Sequence, d.Writers)
+	}
+	if err := PublishDelegated(ctx, writer, id, p2, eol, r); err != ErrNotDelegated {
+		t.Fatalf("expected %s, got %v", ErrNotDelegated, err)
+	}
+
+	wb, err := writer.GetPublic().Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := createEntryV2(writer, p2, 100, eol, 0, map[string]interface{}{
+		dataDelegation: signed,
+		dataWriter:     wb,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.PubKey = delegated.PubKey
+	if err := validateEntry(t, emptyKbook, id, stale); err != nil {
+		t.Fatal(err)
+	}
+	cur := latest()
+	if err := AssertSelected(cur, stale, cur); err != nil {
+		t.Fatal(err)
+	}
+
+	looked, err := LookupDelegation(ctx, r, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if looked.Sequence != 2 || len(looked.Writers) != 0 {
+		t.Fatalf("unexpected delegation: %d %v", looked.Sequence, looked.Writers)
+	}
+}
@@ -3,32 +3,141 @@ package namesys
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
 	"strings"
+	"sync"
+	"time"
 
 	opts "github.com/ipfs/go-ipfs/namesys/opts"
 	path "github.com/ipfs/go-ipfs/path"
+
+	lru "gx/ipfs/QmVYxfoJQiZijTgPNHCHgHELvQpbsJNTg6Crmc3dQkj3yy/golang-lru"
 	isd "gx/ipfs/QmZmmuAXgX73UQmX1jRKjTGmjzq24Jinqkq8vzkBtno4uX/go-is-domain"
 )
 
 type LookupTXTFunc func(name string) (txt []string, err error)
 
+// dnsCacheSize is the limit of the number of names in the cache of the TXT
+// records looked up by the DNS resolver.
+const dnsCacheSize = 256
+
 // DNSResolver implements a Resolver on DNS domains
 type DNSResolver struct {
 	lookupTXT LookupTXTFunc
-	// TODO: maybe some sort of caching?
-	// cache would need a timeout
+
+	mu sync.RWMutex
+	// transports holds the transports of domain suffixes, the empty suffix
+	// matching all names.
+	transports map[string]DNSTransport
+
+	// cache holds the TXT records by name, until their TTL is over.
+	cache *lru.Cache
+}
+
+type dnsCacheEntry struct {
+	txt []string
+	eol time.Time
 }
 
 // NewDNSResolver constructs a name resolver using DNS TXT records.
 func NewDNSResolver() Resolver {
-	return &DNSResolver{lookupTXT: net.LookupTXT}
+	return newDNSResolver()
+}
+
+// NewDNSResolverWithTransports constructs a name resolver using DNS TXT
+// records looked up with the transport of the longest matching domain
+// suffix, or the resolver of the system for names matching no suffix.
+func NewDNSResolverWithTransports(transports map[string]DNSTransport) Resolver {
+	r := newDNSResolver().(*DNSResolver)
+	r.setTransports(transports)
+	return r
 }
 
 // newDNSResolver constructs a name resolver using DNS TXT records,
 // returning a resolver instead of NewDNSResolver's Resolver.
 func newDNSResolver() resolver {
-	return &DNSResolver{lookupTXT: net.LookupTXT}
+	cache, _ := lru.New(dnsCacheSize)
+	return &DNSResolver{
+		lookupTXT: net.LookupTXT,
+		cache:     cache,
+	}
+}
+
+// SetDNSTransports sets the transports used by the DNS resolver of the name
+// system by domain suffix. Names matching no suffix are looked up with the
+// resolver of the system.
+func SetDNSTransports(ns NameSystem, transports map[string]DNSTransport) error {
+	mpns, ok := ns.(*mpns)
+	if !ok {
+		return errors.New("unexpected NameSystem; not an mpns instance")
+	}
+	r, ok := mpns.resolvers["dns"].(*DNSResolver)
+	if !ok {
+		return fmt.Errorf("unexpected type %T as DNS resolver", mpns.resolvers["dns"])
+	}
+	r.setTransports(transports)
+	return nil
+}
+
+func (r *DNSResolver) setTransports(transports map[string]DNSTransport) {
+	normalized := make(map[string]DNSTransport, len(transports))
+	for suffix, t := range transports {
+		normalized[normalizeDomain(suffix)] = t
+	}
+
+	r.mu.Lock()
+	r.transports = normalized
+	r.mu.Unlock()
+	if r.cache != nil {
+		r.cache.Purge()
+	}
+}
+
+// transportFor returns the transport of the longest domain suffix of the
+// name.
+func (r *DNSResolver) transportFor(name string) DNSTransport {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	name = normalizeDomain(name)
+	var best DNSTransport
+	bestLen := -1
+	for suffix, t := range r.transports {
+		if len(suffix) <= bestLen {
+			continue
+		}
+		if suffix == "" || name == suffix || strings.HasSuffix(name, "."+suffix) {
+			best, bestLen = t, len(suffix)
+		}
+	}
+	if best == nil {
+		return &systemTransport{lookupTXT: r.lookupTXT}
+	}
+	return best
+}
+
+// lookup returns the TXT records of the name, from the cache while their
+// TTL lasts.
+func (r *DNSResolver) lookup(ctx context.Context, name string) ([]string, error) {
+	if r.cache != nil {
+		if ientry, ok := r.cache.Get(name); ok {
+			entry := ientry.(dnsCacheEntry)
+			if time.Now().Before(entry.eol) {
+				return entry.txt, nil
+			}
+			r.cache.Remove(name)
+		}
+	}
+
+	txt, ttl, err := r.transportFor(name).LookupTXT(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if r.cache != nil && ttl > 0 {
+		r.cache.Add(name, dnsCacheEntry{txt: txt, eol: time.Now().Add(ttl)})
+	}
+	return txt, nil
 }
 
 // Resolve implements Resolver.
@@ -36,6 +145,11 @@ func (r *DNSResolver) Resolve(ctx context.Context, name string, options ...opts.
 	return resolve(ctx, r, name, opts.ProcessOpts(options), "/ipns/")
 }
 
+// ResolveAsync implements Resolver.
+func (r *DNSResolver) ResolveAsync(ctx context.Context, name string, options ...opts.ResolveOpt) <-chan Result {
+	return resolveAsync(ctx, r, name, opts.ProcessOpts(options), "/ipns/")
+}
+
 type lookupRes struct {
 	path  path.Path
 	error error
@@ -54,10 +168,10 @@ func (r *DNSResolver) resolveOnce(ctx context.Context, name string, options *opt
 	log.Debugf("DNSResolver resolving %s", domain)
 
 	rootChan := make(chan lookupRes, 1)
-	go workDomain(r, domain, rootChan)
+	go workDomain(ctx, r, domain, rootChan)
 
 	subChan := make(chan lookupRes, 1)
-	go workDomain(r, "_dnslink."+domain, subChan)
+	go workDomain(ctx, r, "_dnslink."+domain, subChan)
 
 	var subRes lookupRes
 	select {
@@ -89,8 +203,8 @@ func (r *DNSResolver) resolveOnce(ctx context.Context, name string, options *opt
 	}
 }
 
-func workDomain(r *DNSResolver, name string, res chan lookupRes) {
-	txt, err := r.lookupTXT(name)
+func workDomain(ctx context.Context, r *DNSResolver, name string, res chan lookupRes) {
+	txt, err := r.lookup(ctx, name)
 
 	if err != nil {
 		// Error is != nil
@@ -1,7 +1,14 @@
 package namesys
 
 import (
+	"encoding/binary"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 
 	opts "github.com/ipfs/go-ipfs/namesys/opts"
@@ -160,3 +167,150 @@ func TestDNSResolution(t *testing.T) {
 	testResolution(t, r, "double.example.com", opts.DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
 	testResolution(t, r, "conflict.example.com", opts.DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjE", nil)
 }
+
+// dnsServer is a stand-in DNS server answering TXT queries over UDP and
+// DNS-over-HTTPS.
+type dnsServer struct {
+	records map[string][]string
+	// ttls holds the TTL of the records of names, 60s when not set.
+	ttls map[string]uint32
+
+	mu      sync.Mutex
+	queries map[string]int
+}
+
+func newDNSServer(records map[string][]string) *dnsServer {
+	return &dnsServer{
+		records: records,
+		ttls:    make(map[string]uint32),
+		queries: make(map[string]int),
+	}
+}
+
+func (s *dnsServer) count(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries[name]
+}
+
+// answer returns the response to the query message.
+func (s *dnsServer) answer(query []byte) []byte {
+	var labels []string
+	off := dnsHeaderLen
+	for off < len(query) && query[off] != 0 {
+		n := int(query[off])
+		labels = append(labels, string(query[off+1:off+1+n]))
+		off += 1 + n
+	}
+	name := strings.Join(labels, ".")
+	end := off + 5 // the zero length label, type and class
+
+	s.mu.Lock()
+	s.queries[name]++
+	s.mu.Unlock()
+
+	resp := append([]byte{}, query[:end]...)
+	txts, ok := s.records[name]
+	if !ok {
+		binary.BigEndian.PutUint16(resp[2:], dnsFlagResponse|dnsFlagRecursion|dnsRcodeNXDomain)
+		return resp
+	}
+	binary.BigEndian.PutUint16(resp[2:], dnsFlagResponse|dnsFlagRecursion)
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(txts)))
+
+	ttl, ok := s.ttls[name]
+	if !ok {
+		ttl = 60
+	}
+	for _, txt := range txts {
+		rr := []byte{0xc0, dnsHeaderLen, 0, dnsTypeTXT, 0, dnsClassINET, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(rr[6:], ttl)
+		binary.BigEndian.PutUint16(rr[10:], uint16(len(txt)+1))
+		rr = append(rr, byte(len(txt)))
+		resp = append(append(resp, rr...), txt...)
+	}
+	return resp
+}
+
+func (s *dnsServer) serveUDP(t *testing.T) (addr string, stop func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(s.answer(buf[:n]), from)
+		}
+	}()
+	return conn.LocalAddr().String(), func() { conn.Close() }
+}
+
+func (s *dnsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query, err := ioutil.ReadAll(r.Body)
+	if err != nil || r.Header.Get("Content-Type") != "application/dns-message" {
+		http.Error(w, "bad query", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/dns-message")
+	w.Write(s.answer(query))
+}
+
+func TestDNSTransports(t *testing.T) {
+	srv := newDNSServer(map[string][]string{
+		"_dnslink.udp.example.com":     {"dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD"},
+		"_dnslink.nocache.example.com": {"dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD/nocache"},
+		"doh.example.org":              {"dnslink=/ipns/udp.example.com/doh"},
+	})
+	srv.ttls["_dnslink.nocache.example.com"] = 0
+
+	addr, stop := srv.serveUDP(t)
+	defer stop()
+	hs := httptest.NewServer(srv)
+	defer hs.Close()
+
+	r := NewDNSResolverWithTransports(map[string]DNSTransport{
+		"example.com":  NewUDPDNSTransport(addr),
+		".example.org": NewDoHDNSTransport(hs.URL),
+		".":            &systemTransport{lookupTXT: newMockDNS().lookupTXT},
+	})
+
+	testResolution(t, r, "udp.example.com", opts.DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
+	testResolution(t, r, "doh.example.org", opts.DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD/doh", nil)
+	testResolution(t, r, "ipfs.example.com.other", opts.DefaultDepthLimit, "", ErrResolveFailed)
+	testResolution(t, r, "missing.example.com", opts.DefaultDepthLimit, "", ErrResolveFailed)
+
+	// answers are cached for the TTL of their records
+	testResolution(t, r, "udp.example.com", opts.DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
+	if n := srv.count("_dnslink.udp.example.com"); n != 1 {
+		t.Fatalf("expected one query for a cached name, got %d", n)
+	}
+	testResolution(t, r, "nocache.example.com", opts.DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD/nocache", nil)
+	testResolution(t, r, "nocache.example.com", opts.DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD/nocache", nil)
+	if n := srv.count("_dnslink.nocache.example.com"); n != 2 {
+		t.Fatalf("expected two queries for a name with a zero TTL, got %d", n)
+	}
+
+	// names matching no configured suffix use the fallback
+	testResolution(t, r, "ipfs.example.net", opts.DefaultDepthLimit, "", ErrResolveFailed)
+	if n := srv.count("ipfs.example.net"); n != 0 {
+		t.Fatalf("expected no query to the server, got %d", n)
+	}
+}
+
+func TestParseDNSTransport(t *testing.T) {
+	for _, s := range []string{"system", "https://cloudflare-dns.com/dns-query", "udp://127.0.0.1", "udp://[::1]:5353"} {
+		if _, err := ParseDNSTransport(s); err != nil {
+			t.Fatalf("expected %s to parse: %s", s, err)
+		}
+	}
+	for _, s := range []string{"", "tcp://127.0.0.1:53", "udp://", "127.0.0.1:53"} {
+		if _, err := ParseDNSTransport(s); err == nil {
+			t.Fatalf("expected %s not to parse", s)
+		}
+	}
+}
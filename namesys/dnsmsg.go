@@ -0,0 +1,160 @@
+package namesys
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DNS message constants, from RFC 1035.
+const (
+	dnsTypeTXT   = 16
+	dnsClassINET = 1
+
+	dnsHeaderLen = 12
+
+	dnsFlagResponse  = 1 << 15
+	dnsFlagTruncated = 1 << 9
+	dnsFlagRecursion = 1 << 8
+	dnsRcodeMask     = 0xf
+	dnsRcodeNXDomain = 3
+)
+
+var (
+	// ErrNoSuchDomain is returned by DNS transports for names which do not
+	// exist.
+	ErrNoSuchDomain = errors.New("no such domain")
+
+	errDNSTruncated = errors.New("truncated DNS response")
+	errDNSMalformed = errors.New("malformed DNS message")
+)
+
+// buildTXTQuery returns a DNS query message for the TXT records of the name,
+// asking for recursion.
+func buildTXTQuery(id uint16, name string) ([]byte, error) {
+	msg := make([]byte, dnsHeaderLen, dnsHeaderLen+len(name)+6)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], dnsFlagRecursion)
+	binary.BigEndian.PutUint16(msg[4:], 1) // one question
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid domain name %q", name)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	if len(msg)-dnsHeaderLen > 255 {
+		return nil, fmt.Errorf("domain name too long: %q", name)
+	}
+
+	msg = append(msg, 0, dnsTypeTXT, 0, dnsClassINET)
+	return msg, nil
+}
+
+// parseTXTResponse returns the TXT records in the answer of a DNS response
+// to the query of the given id, and the smallest of their TTLs. The strings
+// of a record are joined.
+func parseTXTResponse(msg []byte, id uint16) ([]string, time.Duration, error) {
+	if len(msg) < dnsHeaderLen {
+		return nil, 0, errDNSMalformed
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != id {
+		return nil, 0, errors.New("DNS response does not match the query")
+	}
+
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&dnsFlagResponse == 0 {
+		return nil, 0, errDNSMalformed
+	}
+	if flags&dnsFlagTruncated != 0 {
+		return nil, 0, errDNSTruncated
+	}
+	switch rcode := flags & dnsRcodeMask; rcode {
+	case 0:
+	case dnsRcodeNXDomain:
+		return nil, 0, ErrNoSuchDomain
+	default:
+		return nil, 0, fmt.Errorf("DNS server failed with code %d", rcode)
+	}
+
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	off := dnsHeaderLen
+	var err error
+	for i := 0; i < qdcount; i++ {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, 0, err
+		}
+		off += 4 // type and class
+	}
+
+	var txts []string
+	var ttl time.Duration
+	for i := 0; i < ancount; i++ {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, 0, err
+		}
+		if off+10 > len(msg) {
+			return nil, 0, errDNSMalformed
+		}
+		rtype := binary.BigEndian.Uint16(msg[off:])
+		rclass := binary.BigEndian.Uint16(msg[off+2:])
+		rttl := time.Duration(binary.BigEndian.Uint32(msg[off+4:])) * time.Second
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+rdlen > len(msg) {
+			return nil, 0, errDNSMalformed
+		}
+		rdata := msg[off : off+rdlen]
+		off += rdlen
+
+		// answers may hold the CNAME records leading to the TXT records
+		if rtype != dnsTypeTXT || rclass != dnsClassINET {
+			continue
+		}
+
+		var txt []byte
+		for len(rdata) > 0 {
+			n := int(rdata[0])
+			if 1+n > len(rdata) {
+				return nil, 0, errDNSMalformed
+			}
+			txt = append(txt, rdata[1:1+n]...)
+			rdata = rdata[1+n:]
+		}
+		txts = append(txts, string(txt))
+		if len(txts) == 1 || rttl < ttl {
+			ttl = rttl
+		}
+	}
+	return txts, ttl, nil
+}
+
+// skipDNSName returns the offset following the, possibly compressed, domain
+// name at the offset of the message.
+func skipDNSName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, errDNSMalformed
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			return off + 1, nil
+		case n&0xc0 == 0xc0:
+			// a pointer ends the name
+			if off+2 > len(msg) {
+				return 0, errDNSMalformed
+			}
+			return off + 2, nil
+		case n&0xc0 != 0:
+			return 0, errDNSMalformed
+		}
+		off += 1 + n
+	}
+}
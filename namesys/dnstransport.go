@@ -0,0 +1,212 @@
+package namesys
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultDNSTimeout is the time limit of DNS queries made without a deadline.
+const DefaultDNSTimeout = 10 * time.Second
+
+// maxDNSMessageSize is the size limit of DNS responses.
+const maxDNSMessageSize = 64 << 10
+
+// DNSTransport looks up the TXT records of domain names.
+type DNSTransport interface {
+	// LookupTXT returns the TXT records of the name and how long they may
+	// be cached.
+	LookupTXT(ctx context.Context, name string) (txt []string, ttl time.Duration, err error)
+}
+
+// systemTransport looks up names with the resolver of the system.
+type systemTransport struct {
+	lookupTXT LookupTXTFunc
+}
+
+// NewSystemDNSTransport returns a transport using the resolver of the
+// system. As it does not tell the TTL of the records, they are cached for
+// DefaultResolverCacheTTL.
+func NewSystemDNSTransport() DNSTransport {
+	return &systemTransport{lookupTXT: net.LookupTXT}
+}
+
+func (t *systemTransport) LookupTXT(ctx context.Context, name string) ([]string, time.Duration, error) {
+	txt, err := t.lookupTXT(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	return txt, DefaultResolverCacheTTL, nil
+}
+
+// udpTransport queries a DNS server over UDP, and over TCP when the answer
+// does not fit in a datagram.
+type udpTransport struct {
+	server string
+}
+
+// NewUDPDNSTransport returns a transport querying the DNS server at the
+// address, given as host:port. The port defaults to 53.
+func NewUDPDNSTransport(server string) DNSTransport {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &udpTransport{server: server}
+}
+
+func (t *udpTransport) LookupTXT(ctx context.Context, name string) ([]string, time.Duration, error) {
+	id := uint16(rand.Uint32())
+	query, err := buildTXTQuery(id, name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	txt, ttl, err := t.exchange(ctx, "udp", query, id)
+	if err == errDNSTruncated {
+		txt, ttl, err = t.exchange(ctx, "tcp", query, id)
+	}
+	return txt, ttl, err
+}
+
+func (t *udpTransport) exchange(ctx context.Context, network string, query []byte, id uint16) ([]string, time.Duration, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, t.server)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(DefaultDNSTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, 0, err
+	}
+
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, 0, err
+		}
+		buf := make([]byte, maxDNSMessageSize)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, 0, err
+		}
+		return parseTXTResponse(buf[:n], id)
+	}
+
+	// messages over TCP are prefixed by their length
+	msg := make([]byte, 2, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	if _, err := conn.Write(append(msg, query...)); err != nil {
+		return nil, 0, err
+	}
+	if _, err := io.ReadFull(conn, msg[:2]); err != nil {
+		return nil, 0, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, 0, err
+	}
+	return parseTXTResponse(buf, id)
+}
+
+// dohTransport queries a DNS-over-HTTPS endpoint, as specified by RFC 8484.
+type dohTransport struct {
+	url    string
+	client *http.Client
+}
+
+// NewDoHDNSTransport returns a transport querying the DNS-over-HTTPS
+// endpoint at the URL.
+func NewDoHDNSTransport(url string) DNSTransport {
+	return &dohTransport{
+		url:    url,
+		client: &http.Client{Timeout: DefaultDNSTimeout},
+	}
+}
+
+func (t *dohTransport) LookupTXT(ctx context.Context, name string) ([]string, time.Duration, error) {
+	// the id is zero for the responses to be cacheable by HTTP caches
+	query, err := buildTXTQuery(0, name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req, err := http.NewRequest("POST", t.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("DNS-over-HTTPS query to %s failed: %s", t.url, resp.Status)
+	}
+	msg, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDNSMessageSize))
+	if err != nil {
+		return nil, 0, err
+	}
+	return parseTXTResponse(msg, 0)
+}
+
+// ParseDNSTransport returns the transport described by the string:
+// "system" for the resolver of the system, an "https://" URL for a
+// DNS-over-HTTPS endpoint, or "udp://host:port" for a DNS server.
+func ParseDNSTransport(s string) (DNSTransport, error) {
+	if s == "system" {
+		return NewSystemDNSTransport(), nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS resolver %q: %s", s, err)
+	}
+	switch u.Scheme {
+	case "https":
+		return NewDoHDNSTransport(s), nil
+	case "udp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid DNS resolver %q: no server address", s)
+		}
+		return NewUDPDNSTransport(u.Host), nil
+	default:
+		return nil, fmt.Errorf("invalid DNS resolver %q: expected \"system\", an https:// or a udp:// address", s)
+	}
+}
+
+// ParseDNSTransports parses the transports of the domain suffixes, as given
+// in the config.
+func ParseDNSTransports(resolvers map[string]string) (map[string]DNSTransport, error) {
+	transports := make(map[string]DNSTransport, len(resolvers))
+	for suffix, s := range resolvers {
+		t, err := ParseDNSTransport(s)
+		if err != nil {
+			return nil, err
+		}
+		transports[suffix] = t
+	}
+	return transports, nil
+}
+
+// normalizeDomain returns the domain name in lower case, without leading or
+// trailing dots.
+func normalizeDomain(name string) string {
+	return strings.ToLower(strings.Trim(name, "."))
+}
@@ -0,0 +1,232 @@
+package namesys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	opts "github.com/ipfs/go-ipfs/namesys/opts"
+	pb "github.com/ipfs/go-ipfs/namesys/pb"
+	path "github.com/ipfs/go-ipfs/path"
+
+	routing "gx/ipfs/QmTiWLZ6Fo5j4KcTVutZJ5KWRRJrbxzmxA4td8NfEdrPh7/go-libp2p-routing"
+	record "gx/ipfs/QmUpttFinNDmNPgFwKN8sZK6BUtBmA68Y4KdSBDXa8t9sJ/go-libp2p-record"
+	pstore "gx/ipfs/QmXauCuJzmzapetmC6W4TuDJLL1yFFrVzSHoWv8YdbmnxH/go-libp2p-peerstore"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+	mh "gx/ipfs/QmZyZDi491cCNTLfAhwcaDii2Kg4pwKRkhqQzURGDvY6ua/go-multihash"
+	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+	cpb "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto/pb"
+)
+
+// ErrNotCached is the answer of the cache of the name system for names it
+// does not hold.
+var ErrNotCached = errors.New("name not in cache")
+
+// errNoValidRecord is the answer of the routing resolver when none of the
+// records received validates.
+var errNoValidRecord = errors.New("no valid record")
+
+// RecordInfo describes a record of a name received from the routing system.
+type RecordInfo struct {
+	Entry *pb.IpnsEntry
+	// From is the peer which sent the record.
+	From peer.ID
+	// Err is why the record is invalid, nil for valid records.
+	Err error
+	// Reason tells why the record was selected, or why the selected record
+	// was preferred over it.
+	Reason string
+}
+
+// ResolverAnswer is the answer of a resolver of the name system.
+type ResolverAnswer struct {
+	Resolver string
+	Value    path.Path
+	Err      error
+}
+
+// Inspection describes how the name system resolves a name.
+type Inspection struct {
+	Name peer.ID
+	// PubKey is the public key of the name, nil if it was not found.
+	PubKey ci.PubKey
+	// KeyType is the type of the public key, like "RSA" or "Ed25519".
+	KeyType string
+	// Records holds the records received from the routing system.
+	Records []RecordInfo
+	// Selected is the index of the record the routing resolver selects
+	// among Records, -1 if none.
+	Selected int
+	// Answers holds the answers of the cache, pubsub and routing resolvers.
+	Answers []ResolverAnswer
+}
+
+// Inspect gathers the records of an ipns name and the answers of the
+// resolvers of the name system, without changing their state. The public
+// key of the name is looked up in the key book, or else fetched from the
+// routing system, and used to validate the records.
+func Inspect(ctx context.Context, ns NameSystem, kbook pstore.KeyBook, name string, options ...opts.ResolveOpt) (*Inspection, error) {
+	mpns, ok := ns.(*mpns)
+	if !ok {
+		return nil, errors.New("unexpected NameSystem; not an mpns instance")
+	}
+	rr, ok := mpns.resolvers["dht"].(*routingResolver)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type %T as DHT resolver", mpns.resolvers["dht"])
+	}
+
+	key := strings.TrimPrefix(name, "/ipns/")
+	hash, err := mh.FromB58String(key)
+	if err != nil {
+		return nil, err
+	}
+	id, err := peer.IDFromBytes(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	ins := &Inspection{Name: id, Selected: -1}
+	ins.PubKey = id.ExtractPublicKey()
+	if ins.PubKey == nil {
+		ins.PubKey = kbook.PubKey(id)
+	}
+	if ins.PubKey == nil {
+		pubk, err := routing.GetPublicKey(rr.routing, ctx, hash)
+		if err != nil {
+			log.Debugf("Inspect: could not retrieve public key %s: %s", key, err)
+		} else {
+			ins.PubKey = pubk
+		}
+	}
+	if ins.PubKey != nil {
+		ins.KeyType = keyType(ins.PubKey)
+		if err := kbook.AddPubKey(id, ins.PubKey); err != nil {
+			return nil, err
+		}
+	}
+
+	cached, _, ok := rr.cache.get(key)
+	if ok {
+		ins.Answers = append(ins.Answers, ResolverAnswer{Resolver: "cache", Value: cached})
+	} else {
+		ins.Answers = append(ins.Answers, ResolverAnswer{Resolver: "cache", Err: ErrNotCached})
+	}
+
+	if psr, ok := mpns.resolvers["pubsub"].(*PubsubResolver); ok {
+		p, err := psr.storedValue("/ipns/" + key)
+		ins.Answers = append(ins.Answers, ResolverAnswer{Resolver: "pubsub", Value: p, Err: err})
+	}
+
+	answer := ResolverAnswer{Resolver: "dht"}
+	answer.Value, answer.Err = ins.inspectRecords(ctx, rr, kbook, opts.ProcessOpts(options))
+	ins.Answers = append(ins.Answers, answer)
+	return ins, nil
+}
+
+// inspectRecords fills the records of the name received from the routing
+// system and returns the value of the selected one.
+func (ins *Inspection) inspectRecords(ctx context.Context, rr *routingResolver, kbook pstore.KeyBook, options *opts.ResolveOpts) (path.Path, error) {
+	if options.DhtTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.DhtTimeout)
+		defer cancel()
+	}
+
+	_, ipnsKey := IpnsKeysForID(ins.Name)
+	vals, err := rr.getValues(ctx, ipnsKey, options)
+	if err != nil {
+		return "", err
+	}
+
+	recs := make([][]byte, len(vals))
+	entries := make([]*pb.IpnsEntry, len(vals))
+	for i, v := range vals {
+		recs[i] = v.Val
+		entry := new(pb.IpnsEntry)
+		if err := proto.Unmarshal(v.Val, entry); err == nil {
+			entries[i] = entry
+		}
+	}
+
+	// records may carry the public key the routing system did not have
+	for _, entry := range entries {
+		if ins.PubKey != nil {
+			break
+		}
+		if entry == nil || len(entry.GetPubKey()) == 0 {
+			continue
+		}
+		pubk, err := ci.UnmarshalPublicKey(entry.GetPubKey())
+		if err == nil && ins.Name.MatchesPublicKey(pubk) {
+			ins.PubKey = pubk
+			ins.KeyType = keyType(pubk)
+			if err := kbook.AddPubKey(ins.Name, pubk); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	// the record is selected among the valid ones, as by the resolver
+	validator := NewIpnsRecordValidator(kbook)
+	valid := make([]*pb.IpnsEntry, len(vals))
+	nvalid := 0
+	for i, v := range vals {
+		info := RecordInfo{Entry: entries[i], From: v.From, Err: ErrBadRecord}
+		if entries[i] != nil {
+			info.Err = validator.Func(&record.ValidationRecord{
+				Namespace: "ipns",
+				Key:       string(ins.Name),
+				Value:     v.Val,
+			})
+		}
+		if info.Err == nil {
+			valid[i] = entries[i]
+			nvalid++
+		} else {
+			info.Reason = "invalid record: " + info.Err.Error()
+		}
+		ins.Records = append(ins.Records, info)
+	}
+	if nvalid == 0 {
+		return "", errNoValidRecord
+	}
+
+	best, err := selectRecord(valid, recs)
+	if err != nil {
+		return "", err
+	}
+	ins.Selected = best
+
+	for i := range ins.Records {
+		if valid[i] == nil {
+			continue
+		}
+		if i == best {
+			if nvalid == 1 {
+				ins.Records[i].Reason = "only valid record"
+			} else {
+				ins.Records[i].Reason = fmt.Sprintf("preferred over the %d other valid records", nvalid-1)
+			}
+			continue
+		}
+		ins.Records[i].Reason = "lost to the selected record: " +
+			selectReason(entries[best], entries[i], recs[best], recs[i])
+	}
+
+	return entryPath(entries[best])
+}
+
+// keyType returns the name of the type of the key.
+func keyType(pubk ci.PubKey) string {
+	data, err := ci.MarshalPublicKey(pubk)
+	if err != nil {
+		return "unknown"
+	}
+	pbk := new(cpb.PublicKey)
+	if err := proto.Unmarshal(data, pbk); err != nil {
+		return "unknown"
+	}
+	return pbk.GetType().String()
+}
@@ -0,0 +1,132 @@
+package namesys
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/ipfs/go-ipfs/namesys/pb"
+	path "github.com/ipfs/go-ipfs/path"
+
+	routing "gx/ipfs/QmTiWLZ6Fo5j4KcTVutZJ5KWRRJrbxzmxA4td8NfEdrPh7/go-libp2p-routing"
+	testutil "gx/ipfs/QmVvkK7s5imCiq3JVbL3pGfnhcCnf3LrFJPF4GE2sAoGZf/go-testutil"
+	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
+	pstore "gx/ipfs/QmXauCuJzmzapetmC6W4TuDJLL1yFFrVzSHoWv8YdbmnxH/go-libp2p-peerstore"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+)
+
+// valuesStore answers all the values it was given to GetValues.
+type valuesStore struct {
+	vals []routing.RecvdVal
+}
+
+func (s *valuesStore) PutValue(ctx context.Context, k string, v []byte) error {
+	return nil
+}
+
+func (s *valuesStore) GetValue(ctx context.Context, k string) ([]byte, error) {
+	return nil, routing.ErrNotFound
+}
+
+func (s *valuesStore) GetValues(ctx context.Context, k string, count int) ([]routing.RecvdVal, error) {
+	return s.vals, nil
+}
+
+func TestInspect(t *testing.T) {
+	priv, id, _, _ := genKeys(t)
+	eol := time.Now().Add(time.Hour)
+
+	marshal := func(e *pb.IpnsEntry, err error) []byte {
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := proto.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	v1 := marshal(CreateRoutingEntryData(priv, path.Path("/ipfs/QmOld"), 1, eol))
+	v2 := marshal(CreateRoutingEntryDataV2(priv, path.Path("/ipfs/QmfM2r8seH2GiRaC4esTjeraXEachRt8ZsSeGaWTPLyMoG"), 2, eol, 0, nil))
+
+	// a newer record signed by another key
+	other, _, _, _ := genKeys(t)
+	forged := marshal(CreateRoutingEntryDataV2(other, path.Path("/ipfs/QmForged"), 5, eol, 0, nil))
+
+	from := testutil.RandPeerIDFatal(t)
+	store := &valuesStore{vals: []routing.RecvdVal{
+		{From: from, Val: v1},
+		{From: from, Val: v2},
+		{From: from, Val: []byte("garbage")},
+		{From: from, Val: forged},
+	}}
+	ns := NewNameSystem(store, ds.NewMapDatastore(), 10)
+
+	ins, err := Inspect(context.Background(), ns, pstore.NewPeerstore(), "/ipns/"+id.Pretty())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the key is only known from the v2 record
+	if ins.PubKey == nil || ins.KeyType != "RSA" {
+		t.Fatalf("expected the RSA key of the v2 record, got %s", ins.KeyType)
+	}
+	if len(ins.Records) != 4 || ins.Selected != 1 {
+		t.Fatalf("expected the second of 4 records to be selected, got %d of %d", ins.Selected, len(ins.Records))
+	}
+	if ins.Records[3].Err == nil || !strings.HasPrefix(ins.Records[3].Reason, "invalid record") {
+		t.Fatalf("expected the forged record to be invalid, got %v: %s", ins.Records[3].Err, ins.Records[3].Reason)
+	}
+	if ins.Records[0].Err != nil || ins.Records[1].Err != nil {
+		t.Fatalf("expected valid records, got %v and %v", ins.Records[0].Err, ins.Records[1].Err)
+	}
+	if ins.Records[2].Err != ErrBadRecord || ins.Records[2].Entry != nil {
+		t.Fatalf("expected %s, got %v", ErrBadRecord, ins.Records[2].Err)
+	}
+	if !strings.Contains(ins.Records[0].Reason, "v2 record") {
+		t.Fatalf("unexpected reason: %s", ins.Records[0].Reason)
+	}
+
+	answers := make(map[string]ResolverAnswer)
+	for _, a := range ins.Answers {
+		answers[a.Resolver] = a
+	}
+	if answers["cache"].Err != ErrNotCached {
+		t.Fatalf("expected %s, got %v", ErrNotCached, answers["cache"].Err)
+	}
+	if _, ok := answers["pubsub"]; ok {
+		t.Fatal("unexpected pubsub answer")
+	}
+	dht := answers["dht"]
+	if dht.Err != nil || dht.Value.String() != "/ipfs/QmfM2r8seH2GiRaC4esTjeraXEachRt8ZsSeGaWTPLyMoG" {
+		t.Fatalf("unexpected dht answer: %s %v", dht.Value, dht.Err)
+	}
+}
+
+func TestInspectNoValidRecord(t *testing.T) {
+	_, id, _, _ := genKeys(t)
+	other, _, _, _ := genKeys(t)
+	e, err := CreateRoutingEntryDataV2(other, path.Path("/ipfs/QmForged"), 1, time.Now().Add(time.Hour), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := proto.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := &valuesStore{vals: []routing.RecvdVal{{From: testutil.RandPeerIDFatal(t), Val: forged}}}
+	ns := NewNameSystem(store, ds.NewMapDatastore(), 10)
+	ins, err := Inspect(context.Background(), ns, pstore.NewPeerstore(), "/ipns/"+id.Pretty())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.Selected != -1 {
+		t.Fatalf("expected no record to be selected, got %d", ins.Selected)
+	}
+	dht := ins.Answers[len(ins.Answers)-1]
+	if dht.Resolver != "dht" || dht.Err != errNoValidRecord {
+		t.Fatalf("expected %s, got %s %v", errNoValidRecord, dht.Value, dht.Err)
+	}
+}
@@ -82,6 +82,19 @@ type Resolver interface {
 	// users will be fine with this default limit, but if you need to
 	// adjust the limit you can specify it as an option.
 	Resolve(ctx context.Context, name string, options ...opts.ResolveOpt) (value path.Path, err error)
+
+	// ResolveAsync performs a recursive lookup like Resolve, but sends the
+	// values as they are found, from the caches to the best of the records
+	// of the routing system, instead of waiting for the best one. The
+	// channel is closed when the lookup is done; cancel the context to stop
+	// it earlier.
+	ResolveAsync(ctx context.Context, name string, options ...opts.ResolveOpt) <-chan Result
+}
+
+// Result is a value found by Resolver.ResolveAsync.
+type Result struct {
+	Path path.Path
+	Err  error
 }
 
 // Publisher is an object capable of publishing particular names.
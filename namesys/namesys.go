@@ -8,6 +8,7 @@ import (
 	"time"
 
 	opts "github.com/ipfs/go-ipfs/namesys/opts"
+	pb "github.com/ipfs/go-ipfs/namesys/pb"
 	path "github.com/ipfs/go-ipfs/path"
 
 	p2phost "gx/ipfs/QmNmJZL7FQySMtE2BQuLMuZg2EB2CLEunJJUSVSc9YnnbV/go-libp2p-host"
@@ -34,13 +35,15 @@ type mpns struct {
 	publishers map[string]Publisher
 }
 
-// NewNameSystem will construct the IPFS naming system based on Routing
+// NewNameSystem will construct the IPFS naming system based on Routing.
+// The cache of resolved names holds up to cachesize entries and is persisted
+// in the datastore.
 func NewNameSystem(r routing.ValueStore, ds ds.Datastore, cachesize int) NameSystem {
 	return &mpns{
 		resolvers: map[string]resolver{
 			"dns":      newDNSResolver(),
 			"proquint": new(ProquintResolver),
-			"dht":      NewRoutingResolver(r, cachesize),
+			"dht":      newRoutingResolver(r, cachesize, ds),
 		},
 		publishers: map[string]Publisher{
 			"dht": NewRoutingPublisher(r, ds),
@@ -60,7 +63,7 @@ func AddPubsubNameSystem(ctx context.Context, ns NameSystem, host p2phost.Host,
 		return errors.New("unexpected IpfsRouting; not a PubKeyFetcher instance")
 	}
 
-	mpns.resolvers["pubsub"] = NewPubsubResolver(ctx, host, r, pkf, ps)
+	mpns.resolvers["pubsub"] = NewPubsubResolver(ctx, host, ds, r, pkf, ps)
 	mpns.publishers["pubsub"] = NewPubsubPublisher(ctx, host, ds, r, ps)
 	return nil
 }
@@ -99,58 +102,128 @@ func (ns *mpns) resolveOnce(ctx context.Context, name string, options *opts.Reso
 		}
 	}
 
-	// Resolver selection:
-	// 1. if it is a multihash resolve through "pubsub" (if available),
-	//    with fallback to "dht"
-	// 2. if it is a domain name, resolve through "dns"
-	// 3. otherwise resolve through the "proquint" resolver
 	key := segments[2]
-
-	_, err := mh.FromB58String(key)
-	if err == nil {
-		res, ok := ns.resolvers["pubsub"]
-		if ok {
-			p, err := res.resolveOnce(ctx, key, options)
-			if err == nil {
-				return makePath(p)
-			}
+	for _, res := range ns.resolversFor(key) {
+		p, err := res.resolveOnce(ctx, key, options)
+		if err == nil {
+			return makePath(p)
 		}
+	}
 
-		res, ok = ns.resolvers["dht"]
-		if ok {
-			p, err := res.resolveOnce(ctx, key, options)
-			if err == nil {
-				return makePath(p)
-			}
-		}
+	return "", ErrResolveFailed
+}
 
-		return "", ErrResolveFailed
+// ResolveAsync implements Resolver.
+func (ns *mpns) ResolveAsync(ctx context.Context, name string, options ...opts.ResolveOpt) <-chan Result {
+	if strings.HasPrefix(name, "/ipfs/") || !strings.HasPrefix(name, "/") {
+		out := make(chan Result, 1)
+		p, err := ns.Resolve(ctx, name, options...)
+		out <- Result{Path: p, Err: err}
+		close(out)
+		return out
 	}
 
-	if isd.IsDomain(key) {
-		res, ok := ns.resolvers["dns"]
-		if ok {
-			p, err := res.resolveOnce(ctx, key, options)
-			if err == nil {
-				return makePath(p)
-			}
-		}
+	return resolveAsync(ctx, ns, name, opts.ProcessOpts(options), "/ipns/")
+}
 
-		return "", ErrResolveFailed
+// resolveOnceAsync implements asyncResolver. All the resolvers of the name
+// are asked in turn, so the values from the pubsub resolver come before the
+// ones from the DHT. Records are compared across resolvers, and only the
+// answers from records newer than the ones already answered are sent; values
+// without a record (e.g. from the cache) are only sent before any record.
+func (ns *mpns) resolveOnceAsync(ctx context.Context, name string, options *opts.ResolveOpts) <-chan onceResult {
+	out := make(chan onceResult, 1)
+	if !strings.HasPrefix(name, "/ipns/") {
+		name = "/ipns/" + name
+	}
+	segments := strings.SplitN(name, "/", 4)
+	if len(segments) < 3 || segments[0] != "" {
+		log.Debugf("invalid name syntax for %s", name)
+		out <- onceResult{err: ErrResolveFailed}
+		close(out)
+		return out
 	}
 
-	res, ok := ns.resolvers["proquint"]
-	if ok {
-		p, err := res.resolveOnce(ctx, key, options)
-		if err == nil {
-			return makePath(p)
+	key := segments[2]
+	go func() {
+		defer close(out)
+		answered := false
+		var best *pb.IpnsEntry
+		var bestData []byte
+		for _, res := range ns.resolversFor(key) {
+			for r := range resolveOnceAsync(ctx, res, key, options) {
+				if r.err != nil {
+					continue
+				}
+				if r.entry == nil {
+					if best != nil {
+						continue
+					}
+				} else if best != nil {
+					i, err := selectRecord([]*pb.IpnsEntry{best, r.entry}, [][]byte{bestData, r.data})
+					if err != nil || i == 0 {
+						log.Debugf("dropping the answer %s for %s: not newer", r.value, key)
+						continue
+					}
+				}
+
+				p := r.value
+				if len(segments) > 3 {
+					var err error
+					p, err = path.FromSegments("", strings.TrimRight(p.String(), "/"), segments[3])
+					if err != nil {
+						continue
+					}
+				}
+
+				if r.entry != nil {
+					best, bestData = r.entry, r.data
+				}
+				answered = true
+				select {
+				case out <- onceResult{value: p}:
+				case <-ctx.Done():
+					return
+				}
+			}
 		}
 
-		return "", ErrResolveFailed
+		if !answered {
+			select {
+			case out <- onceResult{err: ErrResolveFailed}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return out
+}
+
+// resolversFor returns the resolvers of the key, in the order they are
+// tried:
+//  1. if it is a multihash resolve through "pubsub" (if available),
+//     with fallback to "dht"
+//  2. if it is a domain name, resolve through "dns"
+//  3. otherwise resolve through the "proquint" resolver
+func (ns *mpns) resolversFor(key string) []resolver {
+	var names []string
+	if _, err := mh.FromB58String(key); err == nil {
+		names = []string{"pubsub", "dht"}
+	} else if isd.IsDomain(key) {
+		names = []string{"dns"}
+	} else {
+		names = []string{"proquint"}
 	}
 
-	log.Debugf("no resolver found for %s", name)
-	return "", ErrResolveFailed
+	var out []resolver
+	for _, name := range names {
+		if res, ok := ns.resolvers[name]; ok {
+			out = append(out, res)
+		}
+	}
+	if len(out) == 0 {
+		log.Debugf("no resolver found for %s", key)
+	}
+	return out
 }
 
 // Publish implements Publisher
@@ -211,12 +284,14 @@ func (ns *mpns) addToDHTCache(key ci.PrivKey, value path.Path, eol time.Time) {
 		return
 	}
 
+	cacheTil := eol
 	if time.Now().Add(DefaultResolverCacheTTL).Before(eol) {
-		eol = time.Now().Add(DefaultResolverCacheTTL)
+		cacheTil = time.Now().Add(DefaultResolverCacheTTL)
 	}
-	rr.cache.Add(name.Pretty(), cacheEntry{
-		val: value,
-		eol: eol,
+	rr.cache.set(name.Pretty(), cacheEntry{
+		val:       value,
+		eol:       cacheTil,
+		recordEOL: eol,
 	})
 }
 
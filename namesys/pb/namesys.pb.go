@@ -58,6 +58,9 @@ type IpnsEntry struct {
 	Validity         []byte                  `protobuf:"bytes,4,opt,name=validity" json:"validity,omitempty"`
 	Sequence         *uint64                 `protobuf:"varint,5,opt,name=sequence" json:"sequence,omitempty"`
 	Ttl              *uint64                 `protobuf:"varint,6,opt,name=ttl" json:"ttl,omitempty"`
+	PubKey           []byte                  `protobuf:"bytes,7,opt,name=pubKey" json:"pubKey,omitempty"`
+	SignatureV2      []byte                  `protobuf:"bytes,8,opt,name=signatureV2" json:"signatureV2,omitempty"`
+	Data             []byte                  `protobuf:"bytes,9,opt,name=data" json:"data,omitempty"`
 	XXX_unrecognized []byte                  `json:"-"`
 }
 
@@ -107,6 +110,27 @@ func (m *IpnsEntry) GetTtl() uint64 {
 	return 0
 }
 
+func (m *IpnsEntry) GetPubKey() []byte {
+	if m != nil {
+		return m.PubKey
+	}
+	return nil
+}
+
+func (m *IpnsEntry) GetSignatureV2() []byte {
+	if m != nil {
+		return m.SignatureV2
+	}
+	return nil
+}
+
+func (m *IpnsEntry) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("namesys.pb.IpnsEntry_ValidityType", IpnsEntry_ValidityType_name, IpnsEntry_ValidityType_value)
 }
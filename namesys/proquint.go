@@ -17,6 +17,11 @@ func (r *ProquintResolver) Resolve(ctx context.Context, name string, options ...
 	return resolve(ctx, r, name, opts.ProcessOpts(options), "/ipns/")
 }
 
+// ResolveAsync implements Resolver.
+func (r *ProquintResolver) ResolveAsync(ctx context.Context, name string, options ...opts.ResolveOpt) <-chan Result {
+	return resolveAsync(ctx, r, name, opts.ProcessOpts(options), "/ipns/")
+}
+
 // resolveOnce implements resolver. Decodes the proquint string.
 func (r *ProquintResolver) resolveOnce(ctx context.Context, name string, options *opts.ResolveOpts) (path.Path, error) {
 	ok, err := proquint.IsProquint(name)
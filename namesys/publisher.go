@@ -14,8 +14,10 @@ import (
 	u "gx/ipfs/QmNiJuT8Ja3hMVpBHXv3Q6dwmperaQ6JjLtpMQgMCD7xvx/go-ipfs-util"
 	routing "gx/ipfs/QmTiWLZ6Fo5j4KcTVutZJ5KWRRJrbxzmxA4td8NfEdrPh7/go-libp2p-routing"
 	dshelp "gx/ipfs/QmTmqJGRQfuH8eKWD1FjThwPRipt1QhqJQNZ8MpzmfAAxo/go-ipfs-ds-help"
+	record "gx/ipfs/QmUpttFinNDmNPgFwKN8sZK6BUtBmA68Y4KdSBDXa8t9sJ/go-libp2p-record"
 	dhtpb "gx/ipfs/QmUpttFinNDmNPgFwKN8sZK6BUtBmA68Y4KdSBDXa8t9sJ/go-libp2p-record/pb"
 	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
+	pstore "gx/ipfs/QmXauCuJzmzapetmC6W4TuDJLL1yFFrVzSHoWv8YdbmnxH/go-libp2p-peerstore"
 	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
 	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
 	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
@@ -57,58 +59,84 @@ func (p *ipnsPublisher) PublishWithEOL(ctx context.Context, k ci.PrivKey, value
 
 	_, ipnskey := IpnsKeysForID(id)
 
-	// get previous records sequence number
-	seqnum, err := p.getPreviousSeqNo(ctx, ipnskey)
+	// get previous record
+	prev, err := p.getPreviousEntry(ctx, ipnskey)
 	if err != nil {
 		return err
 	}
 
-	// increment it
-	seqnum++
+	// keep the delegated writers of the name
+	fields, err := renewDelegation(k, prev)
+	if err != nil {
+		return err
+	}
+
+	// increment its sequence number
+	seqnum := prev.GetSequence() + 1
 
-	return PutRecordToRouting(ctx, k, value, seqnum, eol, p.routing, id)
+	return putRecordToRouting(ctx, k, value, seqnum, eol, fields, p.routing, id)
 }
 
-func (p *ipnsPublisher) getPreviousSeqNo(ctx context.Context, ipnskey string) (uint64, error) {
+// getPreviousEntry returns the last record of the key in the datastore, or
+// else in the routing system, or nil when there is none. As delegated
+// writers publish records of the name too, the record in the routing system
+// is also looked up for names with a delegation.
+func (p *ipnsPublisher) getPreviousEntry(ctx context.Context, ipnskey string) (*pb.IpnsEntry, error) {
 	prevrec, err := p.ds.Get(dshelp.NewKeyFromBinary([]byte(ipnskey)))
 	if err != nil && err != ds.ErrNotFound {
 		// None found, lets start at zero!
-		return 0, err
+		return nil, err
 	}
 	var val []byte
 	if err == nil {
 		prbytes, ok := prevrec.([]byte)
 		if !ok {
-			return 0, fmt.Errorf("unexpected type returned from datastore: %#v", prevrec)
+			return nil, fmt.Errorf("unexpected type returned from datastore: %#v", prevrec)
 		}
 		dhtrec := new(dhtpb.Record)
 		err := proto.Unmarshal(prbytes, dhtrec)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 
 		val = dhtrec.GetValue()
-	} else {
-		// try and check the dht for a record
-		ctx, cancel := context.WithTimeout(ctx, time.Second*30)
-		defer cancel()
+	}
 
-		rv, err := p.routing.GetValue(ctx, ipnskey)
+	var e *pb.IpnsEntry
+	if val != nil {
+		e = new(pb.IpnsEntry)
+		err = proto.Unmarshal(val, e)
 		if err != nil {
-			// no such record found, start at zero!
-			return 0, nil
+			return nil, err
+		}
+		if d, err := entryDelegation(e); err != nil || d == nil {
+			return e, err
 		}
-
-		val = rv
 	}
 
-	e := new(pb.IpnsEntry)
-	err = proto.Unmarshal(val, e)
+	// try and check the dht for a record
+	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+
+	rv, err := p.routing.GetValue(ctx, ipnskey)
 	if err != nil {
-		return 0, err
+		// no such record found, start at zero!
+		return e, nil
+	}
+
+	re := new(pb.IpnsEntry)
+	if err := proto.Unmarshal(rv, re); err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return re, nil
 	}
 
-	return e.GetSequence(), nil
+	i, err := selectRecord([]*pb.IpnsEntry{e, re}, [][]byte{val, rv})
+	if err != nil || i == 0 {
+		return e, nil
+	}
+	return re, nil
 }
 
 // setting the TTL on published records is an experimental feature.
@@ -125,20 +153,32 @@ func checkCtxTTL(ctx context.Context) (time.Duration, bool) {
 }
 
 func PutRecordToRouting(ctx context.Context, k ci.PrivKey, value path.Path, seqnum uint64, eol time.Time, r routing.ValueStore, id peer.ID) error {
+	return putRecordToRouting(ctx, k, value, seqnum, eol, nil, r, id)
+}
+
+// RepublishRecordToRouting puts the previous record of the key to the
+// routing system again, with a new EOL. The delegation it carries is
+// renewed.
+func RepublishRecordToRouting(ctx context.Context, k ci.PrivKey, prev *pb.IpnsEntry, eol time.Time, r routing.ValueStore, id peer.ID) error {
+	fields, err := renewDelegation(k, prev)
+	if err != nil {
+		return err
+	}
+	return putRecordToRouting(ctx, k, path.Path(prev.GetValue()), prev.GetSequence(), eol, fields, r, id)
+}
+
+func putRecordToRouting(ctx context.Context, k ci.PrivKey, value path.Path, seqnum uint64, eol time.Time, fields map[string]interface{}, r routing.ValueStore, id peer.ID) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	namekey, ipnskey := IpnsKeysForID(id)
-	entry, err := CreateRoutingEntryData(k, value, seqnum, eol)
+	// the TTL is signed, so it is set when creating the record
+	ttl, _ := checkCtxTTL(ctx)
+	entry, err := createEntryV2(k, value, seqnum, eol, ttl, fields)
 	if err != nil {
 		return err
 	}
 
-	ttl, ok := checkCtxTTL(ctx)
-	if ok {
-		entry.Ttl = proto.Uint64(uint64(ttl.Nanoseconds()))
-	}
-
 	errs := make(chan error, 2) // At most two errors (IPNS, and public key)
 
 	// Attempt to extract the public key from the ID
@@ -162,6 +202,83 @@ func PutRecordToRouting(ctx context.Context, k ci.PrivKey, value path.Path, seqn
 	return waitOnErrChan(ctx, errs)
 }
 
+// SignRecord creates the next ipns record of the key without publishing it,
+// so it can be published later by another node. Its sequence number follows
+// the one of the last record of the key in the datastore or the routing
+// system.
+func SignRecord(ctx context.Context, k ci.PrivKey, value path.Path, eol time.Time, r routing.ValueStore, dstore ds.Datastore) (*pb.IpnsEntry, error) {
+	id, err := peer.IDFromPrivateKey(k)
+	if err != nil {
+		return nil, err
+	}
+
+	_, ipnskey := IpnsKeysForID(id)
+	prev, err := NewRoutingPublisher(r, dstore).getPreviousEntry(ctx, ipnskey)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := renewDelegation(k, prev)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, _ := checkCtxTTL(ctx)
+	return createEntryV2(k, value, prev.GetSequence()+1, eol, ttl, fields)
+}
+
+// PutRecord validates an ipns record of the peer signed elsewhere and
+// publishes it to the routing system, along with the public key of the peer
+// when it cannot be extracted from the peer ID.
+func PutRecord(ctx context.Context, r routing.ValueStore, kbook pstore.KeyBook, id peer.ID, entry *pb.IpnsEntry) error {
+	namekey, ipnskey := IpnsKeysForID(id)
+
+	pubk := id.ExtractPublicKey()
+	if pubk == nil && len(entry.GetPubKey()) > 0 {
+		k, err := ci.UnmarshalPublicKey(entry.GetPubKey())
+		if err != nil {
+			return ErrBadRecord
+		}
+		pubk = k
+	}
+	if pubk == nil {
+		pubk = kbook.PubKey(id)
+	}
+	if pubk == nil {
+		k, err := routing.GetPublicKey(r, ctx, []byte(id))
+		if err != nil {
+			log.Debugf("could not retrieve public key of %s: %s", id.Pretty(), err)
+			return ErrPublicKeyNotFound
+		}
+		pubk = k
+	}
+	if len(entry.GetPubKey()) == 0 {
+		if err := kbook.AddPubKey(id, pubk); err != nil {
+			return err
+		}
+	}
+
+	data, err := proto.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = NewIpnsRecordValidator(kbook).Func(&record.ValidationRecord{
+		Namespace: "ipns",
+		Key:       string(id),
+		Value:     data,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := PublishEntry(ctx, r, ipnskey, entry); err != nil {
+		return err
+	}
+	if id.ExtractPublicKey() == nil {
+		return PublishPublicKey(ctx, r, namekey, pubk)
+	}
+	return nil
+}
+
 func waitOnErrChan(ctx context.Context, errs chan error) error {
 	select {
 	case err := <-errs:
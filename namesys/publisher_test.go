@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	pb "github.com/ipfs/go-ipfs/namesys/pb"
 	path "github.com/ipfs/go-ipfs/path"
 
 	dshelp "gx/ipfs/QmTmqJGRQfuH8eKWD1FjThwPRipt1QhqJQNZ8MpzmfAAxo/go-ipfs-ds-help"
@@ -13,7 +14,9 @@ import (
 	ma "gx/ipfs/QmWWQ2Txc2c6tqjsBpzg5Ar652cHPGNsQQp2SejkNmkUMb/go-multiaddr"
 	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
 	dssync "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore/sync"
+	pstore "gx/ipfs/QmXauCuJzmzapetmC6W4TuDJLL1yFFrVzSHoWv8YdbmnxH/go-libp2p-peerstore"
 	mockrouting "gx/ipfs/QmXtoXbu9ReyV6Q4kDQ5CF9wXQNDY1PdHc4HhfxRR5AHB3/go-ipfs-routing/mock"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
 	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
 	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
 )
@@ -113,3 +116,52 @@ func TestRSAPublisher(t *testing.T) {
 func TestEd22519Publisher(t *testing.T) {
 	testNamekeyPublisher(t, ci.Ed25519, ds.ErrNotFound, false)
 }
+
+func TestSignAndPutRecord(t *testing.T) {
+	ctx := context.Background()
+	priv, id, _, _ := genKeys(t)
+	_, ipnskey := IpnsKeysForID(id)
+	value := path.Path("/ipfs/QmfM2r8seH2GiRaC4esTjeraXEachRt8ZsSeGaWTPLyMoG")
+	eol := time.Now().Add(time.Hour)
+
+	serv := mockrouting.NewServer()
+	r := serv.ClientWithDatastore(ctx, testutil.RandIdentityOrFatal(t), dssync.MutexWrap(ds.NewMapDatastore()))
+
+	// the sequence number follows the one of the published record
+	if err := PutRecordToRouting(ctx, priv, value, 4, eol, r, id); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := SignRecord(ctx, priv, value, eol, r, dssync.MutexWrap(ds.NewMapDatastore()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.GetSequence() != 5 {
+		t.Fatalf("expected sequence 5, got %d", entry.GetSequence())
+	}
+
+	if err := PutRecord(ctx, r, pstore.NewPeerstore(), id, entry); err != nil {
+		t.Fatal(err)
+	}
+	data, err := r.GetValue(ctx, ipnskey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := new(pb.IpnsEntry)
+	if err := proto.Unmarshal(data, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.GetSequence() != 5 || string(got.GetValue()) != value.String() {
+		t.Fatalf("unexpected record: %v", got)
+	}
+
+	// records of another name are rejected
+	_, other, _, _ := genKeys(t)
+	if err := PutRecord(ctx, r, pstore.NewPeerstore(), other, entry); err != ErrPublicKeyMismatch {
+		t.Fatalf("expected %s, got %v", ErrPublicKeyMismatch, err)
+	}
+
+	entry.Value = []byte("/ipfs/QmTampered")
+	if err := PutRecord(ctx, r, pstore.NewPeerstore(), id, entry); err == nil {
+		t.Fatal("expected tampered record to be rejected")
+	}
+}
@@ -1,9 +1,12 @@
 package namesys
 
 import (
+	"bufio"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 	"sync"
 	"time"
@@ -20,9 +23,12 @@ import (
 	record "gx/ipfs/QmUpttFinNDmNPgFwKN8sZK6BUtBmA68Y4KdSBDXa8t9sJ/go-libp2p-record"
 	dhtpb "gx/ipfs/QmUpttFinNDmNPgFwKN8sZK6BUtBmA68Y4KdSBDXa8t9sJ/go-libp2p-record/pb"
 	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
+	dsq "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore/query"
 	dssync "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore/sync"
 	pstore "gx/ipfs/QmXauCuJzmzapetmC6W4TuDJLL1yFFrVzSHoWv8YdbmnxH/go-libp2p-peerstore"
+	inet "gx/ipfs/QmXfkENeeBvh3zYA51MaSdGUdBjhQ99cP5WQe8zgr6wchG/go-libp2p-net"
 	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	protocol "gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
 	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
 	mh "gx/ipfs/QmZyZDi491cCNTLfAhwcaDii2Kg4pwKRkhqQzURGDvY6ua/go-multihash"
 	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
@@ -50,10 +56,37 @@ type PubsubResolver struct {
 	pkf  routing.PubKeyFetcher
 	ps   *floodsub.PubSub
 
+	// dstore persists the subscriptions, and holds the records published
+	// by the node
+	dstore ds.Datastore
+
 	mx   sync.Mutex
 	subs map[string]*floodsub.Subscription
+
+	// recvmx serializes the updates of the stored records
+	recvmx sync.Mutex
 }
 
+// PubsubFetchProtocol is the protocol through which subscribers fetch the
+// latest record of a name from their peers when joining its topic.
+const PubsubFetchProtocol = protocol.ID("/ipns/pubsub/fetch/1.0.0")
+
+// PubsubRebroadcastInterval is the interval at which subscribers rebroadcast
+// the latest record they received for a name, for the peers which joined
+// the topic since it was published.
+var PubsubRebroadcastInterval = time.Minute * 10
+
+// pubsubFetchTimeout is the time limit of fetching a record from a peer.
+const pubsubFetchTimeout = time.Second * 10
+
+// maxPubsubRecordSize is the size limit of the records fetched from peers.
+const maxPubsubRecordSize = 10 << 10
+
+// pubsubSubsPrefix is the datastore namespace of the persisted subscriptions.
+var pubsubSubsPrefix = ds.NewKey("/namesys/pubsub/subs")
+
+var errStaleUpdate = errors.New("stale update; sequence number too small")
+
 // NewPubsubPublisher constructs a new Publisher that publishes IPNS records through pubsub.
 // The constructor interface is complicated by the need to bootstrap the pubsub topic.
 // This could be greatly simplified if the pubsub implementation handled bootstrap itself
@@ -70,16 +103,24 @@ func NewPubsubPublisher(ctx context.Context, host p2phost.Host, ds ds.Datastore,
 
 // NewPubsubResolver constructs a new Resolver that resolves IPNS records through pubsub.
 // same as above for pubsub bootstrap dependencies
-func NewPubsubResolver(ctx context.Context, host p2phost.Host, cr routing.ContentRouting, pkf routing.PubKeyFetcher, ps *floodsub.PubSub) *PubsubResolver {
-	return &PubsubResolver{
-		ctx:  ctx,
-		ds:   dssync.MutexWrap(ds.NewMapDatastore()),
-		host: host, // needed for pubsub bootstrap
-		cr:   cr,   // needed for pubsub bootstrap
-		pkf:  pkf,
-		ps:   ps,
-		subs: make(map[string]*floodsub.Subscription),
-	}
+// The subscriptions persisted in the datastore are restored, and the latest
+// records of the subscribed names are served to the peers joining their
+// topic.
+func NewPubsubResolver(ctx context.Context, host p2phost.Host, dstore ds.Datastore, cr routing.ContentRouting, pkf routing.PubKeyFetcher, ps *floodsub.PubSub) *PubsubResolver {
+	r := &PubsubResolver{
+		ctx:    ctx,
+		ds:     dssync.MutexWrap(ds.NewMapDatastore()),
+		host:   host, // needed for pubsub bootstrap
+		cr:     cr,   // needed for pubsub bootstrap
+		pkf:    pkf,
+		ps:     ps,
+		dstore: dstore,
+		subs:   make(map[string]*floodsub.Subscription),
+	}
+
+	host.SetStreamHandler(PubsubFetchProtocol, r.handleFetch)
+	go r.restoreSubscriptions()
+	return r
 }
 
 // Publish publishes an IPNS record through pubsub with default TTL
@@ -96,50 +137,56 @@ func (p *PubsubPublisher) PublishWithEOL(ctx context.Context, k ci.PrivKey, valu
 
 	_, ipnskey := IpnsKeysForID(id)
 
-	seqno, err := p.getPreviousSeqNo(ctx, ipnskey)
+	prev, err := p.getPreviousEntry(ctx, ipnskey)
+	if err != nil {
+		return err
+	}
+
+	// keep the delegated writers of the name
+	fields, err := renewDelegation(k, prev)
 	if err != nil {
 		return err
 	}
 
-	seqno++
+	seqno := prev.GetSequence() + 1
 
-	return p.publishRecord(ctx, k, value, seqno, eol, ipnskey, id)
+	return p.publishRecord(ctx, k, value, seqno, eol, fields, ipnskey, id)
 }
 
-func (p *PubsubPublisher) getPreviousSeqNo(ctx context.Context, ipnskey string) (uint64, error) {
+func (p *PubsubPublisher) getPreviousEntry(ctx context.Context, ipnskey string) (*pb.IpnsEntry, error) {
 	// the datastore is shared with the routing publisher to properly increment and persist
 	// ipns record sequence numbers.
 	prevrec, err := p.ds.Get(dshelp.NewKeyFromBinary([]byte(ipnskey)))
 	if err != nil {
 		if err == ds.ErrNotFound {
 			// None found, lets start at zero!
-			return 0, nil
+			return nil, nil
 		}
-		return 0, err
+		return nil, err
 	}
 
 	prbytes, ok := prevrec.([]byte)
 	if !ok {
-		return 0, fmt.Errorf("unexpected type returned from datastore: %#v", prevrec)
+		return nil, fmt.Errorf("unexpected type returned from datastore: %#v", prevrec)
 	}
 
 	var dsrec dhtpb.Record
 	err = proto.Unmarshal(prbytes, &dsrec)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	var entry pb.IpnsEntry
-	err = proto.Unmarshal(dsrec.GetValue(), &entry)
+	entry := new(pb.IpnsEntry)
+	err = proto.Unmarshal(dsrec.GetValue(), entry)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	return entry.GetSequence(), nil
+	return entry, nil
 }
 
-func (p *PubsubPublisher) publishRecord(ctx context.Context, k ci.PrivKey, value path.Path, seqno uint64, eol time.Time, ipnskey string, ID peer.ID) error {
-	entry, err := CreateRoutingEntryData(k, value, seqno, eol)
+func (p *PubsubPublisher) publishRecord(ctx context.Context, k ci.PrivKey, value path.Path, seqno uint64, eol time.Time, fields map[string]interface{}, ipnskey string, ID peer.ID) error {
+	entry, err := createEntryV2(k, value, seqno, eol, 0, fields)
 	if err != nil {
 		return err
 	}
@@ -190,20 +237,70 @@ func (r *PubsubResolver) Resolve(ctx context.Context, name string, options ...op
 	return resolve(ctx, r, name, opts.ProcessOpts(options), "/ipns/")
 }
 
+// ResolveAsync resolves a name through pubsub, sending the value found
+func (r *PubsubResolver) ResolveAsync(ctx context.Context, name string, options ...opts.ResolveOpt) <-chan Result {
+	return resolveAsync(ctx, r, name, opts.ProcessOpts(options), "/ipns/")
+}
+
 func (r *PubsubResolver) resolveOnce(ctx context.Context, name string, options *opts.ResolveOpts) (path.Path, error) {
 	log.Debugf("PubsubResolve: resolve '%s'", name)
 
+	// the topic is /ipns/Qmhash
+	if !strings.HasPrefix(name, "/ipns/") {
+		name = "/ipns/" + name
+	}
+
+	err := r.subscribe(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	// resolve to what we may already have in the datastore
+	return r.storedValue(name)
+}
+
+// resolveOnceAsync implements asyncResolver, answering the record with the
+// value so that it can be compared with the records of other resolvers.
+func (r *PubsubResolver) resolveOnceAsync(ctx context.Context, name string, options *opts.ResolveOpts) <-chan onceResult {
+	out := make(chan onceResult, 1)
+	go func() {
+		defer close(out)
+		log.Debugf("PubsubResolve: resolve '%s' asynchronously", name)
+
+		if !strings.HasPrefix(name, "/ipns/") {
+			name = "/ipns/" + name
+		}
+
+		if err := r.subscribe(ctx, name); err != nil {
+			out <- onceResult{err: err}
+			return
+		}
+
+		data, entry, err := r.storedEntry(name)
+		if err != nil {
+			out <- onceResult{err: err}
+			return
+		}
+		p, err := path.ParsePath(string(entry.GetValue()))
+		out <- onceResult{value: p, err: err, entry: entry, data: data}
+	}()
+	return out
+}
+
+// subscribe subscribes to the topic of the name, unless already subscribed,
+// and persists the subscription.
+func (r *PubsubResolver) subscribe(ctx context.Context, name string) error {
 	// retrieve the public key once (for verifying messages)
 	xname := strings.TrimPrefix(name, "/ipns/")
 	hash, err := mh.FromB58String(xname)
 	if err != nil {
 		log.Warningf("PubsubResolve: bad input hash: [%s]", xname)
-		return "", err
+		return err
 	}
 
 	id := peer.ID(hash)
 	if r.host.Peerstore().PrivKey(id) != nil {
-		return "", errors.New("cannot resolve own name through pubsub")
+		return errors.New("cannot resolve own name through pubsub")
 	}
 
 	pubk := id.ExtractPublicKey()
@@ -211,42 +308,94 @@ func (r *PubsubResolver) resolveOnce(ctx context.Context, name string, options *
 		pubk, err = r.pkf.GetPublicKey(ctx, id)
 		if err != nil {
 			log.Warningf("PubsubResolve: error fetching public key: %s [%s]", err.Error(), xname)
-			return "", err
+			return err
 		}
 	}
 
-	// the topic is /ipns/Qmhash
-	if !strings.HasPrefix(name, "/ipns/") {
-		name = "/ipns/" + name
-	}
-
 	r.mx.Lock()
+	defer r.mx.Unlock()
+
 	// see if we already have a pubsub subscription; if not, subscribe
-	sub, ok := r.subs[name]
-	if !ok {
-		sub, err = r.ps.Subscribe(name)
+	if _, ok := r.subs[name]; ok {
+		return nil
+	}
+
+	sub, err := r.ps.Subscribe(name)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("PubsubResolve: subscribed to %s", name)
+
+	r.subs[name] = sub
+
+	sctx, cancel := context.WithCancel(r.ctx)
+	go r.handleSubscription(sub, name, pubk, cancel)
+	go r.rebroadcast(sctx, name)
+	go func() {
+		peers := bootstrapPubsub(sctx, r.cr, r.host, name)
+		r.fetchLatest(sctx, name, pubk, peers)
+	}()
+
+	if r.dstore != nil {
+		err := r.dstore.Put(pubsubSubsPrefix.ChildString(xname), []byte{})
 		if err != nil {
-			r.mx.Unlock()
-			return "", err
+			log.Warningf("PubsubResolve: error persisting subscription to %s: %s", name, err.Error())
 		}
+	}
+	return nil
+}
 
-		log.Debugf("PubsubResolve: subscribed to %s", name)
+// restoreSubscriptions subscribes to the names persisted in the datastore.
+func (r *PubsubResolver) restoreSubscriptions() {
+	if r.dstore == nil {
+		return
+	}
 
-		r.subs[name] = sub
+	res, err := r.dstore.Query(dsq.Query{Prefix: pubsubSubsPrefix.String(), KeysOnly: true})
+	if err != nil {
+		log.Warningf("PubsubResolve: error listing persisted subscriptions: %s", err.Error())
+		return
+	}
+	defer res.Close()
 
-		ctx, cancel := context.WithCancel(r.ctx)
-		go r.handleSubscription(sub, name, pubk, cancel)
-		go bootstrapPubsub(ctx, r.cr, r.host, name)
+	for e := range res.Next() {
+		if e.Error != nil {
+			log.Warningf("PubsubResolve: error listing persisted subscriptions: %s", e.Error.Error())
+			return
+		}
+
+		name := "/ipns/" + ds.RawKey(e.Key).BaseNamespace()
+		ctx, cancel := context.WithTimeout(r.ctx, pubsubFetchTimeout)
+		err := r.subscribe(ctx, name)
+		cancel()
+		if err != nil {
+			log.Warningf("PubsubResolve: error restoring subscription to %s: %s", name, err.Error())
+		}
 	}
-	r.mx.Unlock()
+}
 
-	// resolve to what we may already have in the datastore
+// storedValue returns the value of the last record of the name received
+// through pubsub.
+func (r *PubsubResolver) storedValue(name string) (path.Path, error) {
+	_, entry, err := r.storedEntry(name)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := path.ParsePath(string(entry.GetValue()))
+	return value, err
+}
+
+// storedEntry returns the last record of the name received through pubsub,
+// unless it expired.
+func (r *PubsubResolver) storedEntry(name string) ([]byte, *pb.IpnsEntry, error) {
 	dsval, err := r.ds.Get(dshelp.NewKeyFromBinary([]byte(name)))
 	if err != nil {
 		if err == ds.ErrNotFound {
-			return "", ErrResolveFailed
+			return nil, nil, ErrResolveFailed
 		}
-		return "", err
+		return nil, nil, err
 	}
 
 	data := dsval.([]byte)
@@ -254,7 +403,7 @@ func (r *PubsubResolver) resolveOnce(ctx context.Context, name string, options *
 
 	err = proto.Unmarshal(data, entry)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 
 	// check EOL; if the entry has expired, delete from datastore and return ds.ErrNotFound
@@ -265,11 +414,10 @@ func (r *PubsubResolver) resolveOnce(ctx context.Context, name string, options *
 			log.Warningf("PubsubResolve: error deleting stale value for %s: %s", name, err.Error())
 		}
 
-		return "", ErrResolveFailed
+		return nil, nil, ErrResolveFailed
 	}
 
-	value, err := path.ParsePath(string(entry.GetValue()))
-	return value, err
+	return data, entry, nil
 }
 
 // GetSubscriptions retrieves a list of active topic subscriptions
@@ -297,6 +445,13 @@ func (r *PubsubResolver) Cancel(name string) bool {
 		delete(r.subs, name)
 	}
 
+	if r.dstore != nil {
+		err := r.dstore.Delete(pubsubSubsPrefix.ChildString(strings.TrimPrefix(name, "/ipns/")))
+		if err != nil && err != ds.ErrNotFound {
+			log.Warningf("PubsubResolve: error deleting persisted subscription to %s: %s", name, err.Error())
+		}
+	}
+
 	return ok
 }
 
@@ -313,15 +468,19 @@ func (r *PubsubResolver) handleSubscription(sub *floodsub.Subscription, name str
 			return
 		}
 
-		err = r.receive(msg, name, pubk)
-		if err != nil {
+		err = r.receive(msg.GetData(), name, pubk)
+		switch err {
+		case nil:
+		case errStaleUpdate:
+			// rebroadcasts of the record we have
+			log.Debugf("PubsubResolve: ignoring stale update for %s", name)
+		default:
 			log.Warningf("PubsubResolve: error proessing update for %s: %s", name, err.Error())
 		}
 	}
 }
 
-func (r *PubsubResolver) receive(msg *floodsub.Message, name string, pubk ci.PubKey) error {
-	data := msg.GetData()
+func (r *PubsubResolver) receive(data []byte, name string, pubk ci.PubKey) error {
 	if data == nil {
 		return errors.New("empty message")
 	}
@@ -332,9 +491,9 @@ func (r *PubsubResolver) receive(msg *floodsub.Message, name string, pubk ci.Pub
 		return err
 	}
 
-	ok, err := pubk.Verify(ipnsEntryDataForSig(entry), entry.GetSignature())
-	if err != nil || !ok {
-		return errors.New("signature verification failed")
+	err = verifyRecord(pubk, entry)
+	if err != nil {
+		return err
 	}
 
 	_, err = path.ParsePath(string(entry.GetValue()))
@@ -347,7 +506,12 @@ func (r *PubsubResolver) receive(msg *floodsub.Message, name string, pubk ci.Pub
 		return errors.New("stale update; EOL exceeded")
 	}
 
-	// check the sequence number against what we may already have in our datastore
+	r.recvmx.Lock()
+	defer r.recvmx.Unlock()
+
+	// check the record against what we may already have in our datastore;
+	// the sequence number alone does not tell v1 records stripped from v2
+	// records
 	oval, err := r.ds.Get(dshelp.NewKeyFromBinary([]byte(name)))
 	if err == nil {
 		odata := oval.([]byte)
@@ -358,8 +522,9 @@ func (r *PubsubResolver) receive(msg *floodsub.Message, name string, pubk ci.Pub
 			return err
 		}
 
-		if entry.GetSequence() <= oentry.GetSequence() {
-			return errors.New("stale update; sequence number too small")
+		i, err := selectRecord([]*pb.IpnsEntry{oentry, entry}, [][]byte{odata, data})
+		if err != nil || i != 1 {
+			return errStaleUpdate
 		}
 	}
 
@@ -368,9 +533,160 @@ func (r *PubsubResolver) receive(msg *floodsub.Message, name string, pubk ci.Pub
 	return r.ds.Put(dshelp.NewKeyFromBinary([]byte(name)), data)
 }
 
-// rendezvous with peers in the name topic through provider records
+// rebroadcast publishes the stored record of the name to its topic
+// periodically, until the context is canceled.
+func (r *PubsubResolver) rebroadcast(ctx context.Context, name string) {
+	ticker := time.NewTicker(PubsubRebroadcastInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			data, _, err := r.storedEntry(name)
+			if err != nil {
+				continue
+			}
+			log.Debugf("PubsubResolve: rebroadcast IPNS record for %s", name)
+			err = r.ps.Publish(name, data)
+			if err != nil {
+				log.Warningf("PubsubResolve: error rebroadcasting %s: %s", name, err.Error())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// latestRecord returns the latest record of the name known to the node:
+// the last one received through pubsub, or else the one it published.
+func (r *PubsubResolver) latestRecord(name string) ([]byte, error) {
+	data, _, err := r.storedEntry(name)
+	if err == nil || r.dstore == nil {
+		return data, err
+	}
+
+	hash, err := mh.FromB58String(strings.TrimPrefix(name, "/ipns/"))
+	if err != nil {
+		return nil, err
+	}
+	_, ipnskey := IpnsKeysForID(peer.ID(hash))
+	dsval, err := r.dstore.Get(dshelp.NewKeyFromBinary([]byte(ipnskey)))
+	if err != nil {
+		return nil, err
+	}
+
+	var dsrec dhtpb.Record
+	err = proto.Unmarshal(dsval.([]byte), &dsrec)
+	if err != nil {
+		return nil, err
+	}
+	return dsrec.GetValue(), nil
+}
+
+// handleFetch answers the request of a peer for the latest record of a
+// name: the name on a line, answered with the length of the record, zero
+// when unknown, followed by the record.
+func (r *PubsubResolver) handleFetch(s inet.Stream) {
+	defer s.Close()
+
+	name, err := bufio.NewReader(io.LimitReader(s, 256)).ReadString('\n')
+	if err != nil {
+		s.Reset()
+		return
+	}
+	name = strings.TrimSuffix(name, "\n")
+
+	data, err := r.latestRecord(name)
+	if err != nil {
+		data = nil
+	}
+
+	buf := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(data))
+	n := binary.PutUvarint(buf, uint64(len(data)))
+	_, err = s.Write(append(buf[:n], data...))
+	if err != nil {
+		log.Debugf("PubsubResolve: error answering fetch of %s: %s", name, err.Error())
+		s.Reset()
+	}
+}
+
+// fetchRecord asks the peer for the latest record of the name.
+func (r *PubsubResolver) fetchRecord(ctx context.Context, p peer.ID, name string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, pubsubFetchTimeout)
+	defer cancel()
+
+	s, err := r.host.NewStream(ctx, p, PubsubFetchProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	// the stream does not follow the context
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Reset()
+		case <-done:
+		}
+	}()
+
+	_, err = io.WriteString(s, name+"\n")
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(s)
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxPubsubRecordSize {
+		return nil, fmt.Errorf("record of %d bytes is too large", size)
+	}
+
+	data := make([]byte, size)
+	_, err = io.ReadFull(br, data)
+	return data, err
+}
+
+// fetchLatest asks the peers, and the peers of the topic of the name, for
+// the latest record of the name, keeping the most recent valid one.
+func (r *PubsubResolver) fetchLatest(ctx context.Context, name string, pubk ci.PubKey, peers []peer.ID) {
+	asked := make(map[peer.ID]bool)
+	wg := &sync.WaitGroup{}
+	for _, p := range append(peers, r.ps.ListPeers(name)...) {
+		if p == r.host.ID() || asked[p] {
+			continue
+		}
+		asked[p] = true
+
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer wg.Done()
+
+			data, err := r.fetchRecord(ctx, p, name)
+			if err != nil {
+				log.Debugf("PubsubResolve: error fetching %s from %s: %s", name, p, err.Error())
+				return
+			}
+			if len(data) == 0 {
+				return
+			}
+
+			err = r.receive(data, name, pubk)
+			if err != nil && err != errStaleUpdate {
+				log.Debugf("PubsubResolve: invalid record of %s from %s: %s", name, p, err.Error())
+			}
+		}(p)
+	}
+	wg.Wait()
+}
+
+// rendezvous with peers in the name topic through provider records, returns the peers it connected to
 // Note: rendezbous/boostrap should really be handled by the pubsub implementation itself!
-func bootstrapPubsub(ctx context.Context, cr routing.ContentRouting, host p2phost.Host, name string) {
+func bootstrapPubsub(ctx context.Context, cr routing.ContentRouting, host p2phost.Host, name string) []peer.ID {
 	topic := "floodsub:" + name
 	hash := u.Hash([]byte(topic))
 	rz := cid.NewCidV1(cid.Raw, hash)
@@ -397,6 +713,8 @@ func bootstrapPubsub(ctx context.Context, cr routing.ContentRouting, host p2phos
 	rzctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
+	var mx sync.Mutex
+	var peers []peer.ID
 	wg := &sync.WaitGroup{}
 	for pi := range cr.FindProvidersAsync(rzctx, rz, 10) {
 		if pi.ID == host.ID() {
@@ -419,8 +737,13 @@ func bootstrapPubsub(ctx context.Context, cr routing.ContentRouting, host p2phos
 			time.Sleep(time.Millisecond * 250)
 
 			log.Debugf("Connected to pubsub peer %s", pi.ID)
+
+			mx.Lock()
+			peers = append(peers, pi.ID)
+			mx.Unlock()
 		}(pi)
 	}
 
 	wg.Wait()
+	return peers
 }
@@ -120,7 +120,7 @@ func TestPubsubPublishSubscribe(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		res[i] = NewPubsubResolver(ctx, reshosts[i], resmrs[i], ks, fs)
+		res[i] = NewPubsubResolver(ctx, reshosts[i], ds.NewMapDatastore(), resmrs[i], ks, fs)
 		if err := reshosts[i].Connect(ctx, pubpinfo); err != nil {
 			t.Fatal(err)
 		}
@@ -179,6 +179,158 @@ func TestPubsubPublishSubscribe(t *testing.T) {
 	}
 }
 
+func TestPubsubFetchLatestOnJoin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ms := mockrouting.NewServer()
+	ks := newMockKeyStore()
+
+	// the publishing node also serves its records to the joining peers
+	pubhost := newNetHost(ctx, t)
+	pubmr := newMockRouting(ms, ks, pubhost)
+	fs, err := floodsub.NewFloodSub(ctx, pubhost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubds := ds.NewMapDatastore()
+	pub := NewPubsubPublisher(ctx, pubhost, pubds, pubmr, fs)
+	NewPubsubResolver(ctx, pubhost, pubds, pubmr, ks, fs)
+	privk := pubhost.Peerstore().PrivKey(pubhost.ID())
+	pubpinfo := pstore.PeerInfo{ID: pubhost.ID(), Addrs: pubhost.Addrs()}
+
+	name := "/ipns/" + pubhost.ID().Pretty()
+
+	val := path.Path("/ipfs/QmP1DfoUjiWH2ZBo1PBH6FupdBucbDepx3HpWmEY6JMUpY")
+	err = pub.Publish(ctx, privk, val)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the resolver joins after the record was published
+	reshost := newNetHost(ctx, t)
+	resmr := newMockRouting(ms, ks, reshost)
+	fs, err = floodsub.NewFloodSub(ctx, reshost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resds := ds.NewMapDatastore()
+	res := NewPubsubResolver(ctx, reshost, resds, resmr, ks, fs)
+	if err := reshost.Connect(ctx, pubpinfo); err != nil {
+		t.Fatal(err)
+	}
+
+	checkResolveNotFound(ctx, t, 0, res, name)
+
+	// let the bootstrap and the fetch finish
+	time.Sleep(time.Second * 2)
+	checkResolve(ctx, t, 0, res, name, val)
+
+	// the subscription is restored by a new node on the same datastore
+	_, err = resds.Get(pubsubSubsPrefix.ChildString(pubhost.ID().Pretty()))
+	if err != nil {
+		t.Fatalf("expected the subscription to be persisted: %s", err)
+	}
+	reshost = newNetHost(ctx, t)
+	resmr = newMockRouting(ms, ks, reshost)
+	fs, err = floodsub.NewFloodSub(ctx, reshost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reshost.Connect(ctx, pubpinfo); err != nil {
+		t.Fatal(err)
+	}
+	res = NewPubsubResolver(ctx, reshost, resds, resmr, ks, fs)
+
+	time.Sleep(time.Second * 2)
+	subs := res.GetSubscriptions()
+	if len(subs) != 1 || subs[0] != name {
+		t.Fatalf("expected the subscription to %s to be restored, got %v", name, subs)
+	}
+	checkResolve(ctx, t, 0, res, name, val)
+
+	// canceling the subscription forgets it
+	res.Cancel(name)
+	_, err = resds.Get(pubsubSubsPrefix.ChildString(pubhost.ID().Pretty()))
+	if err != ds.ErrNotFound {
+		t.Fatalf("expected the subscription not to be persisted, got %v", err)
+	}
+}
+
+func TestPubsubRebroadcast(t *testing.T) {
+	interval := PubsubRebroadcastInterval
+	PubsubRebroadcastInterval = time.Millisecond * 200
+	defer func() {
+		PubsubRebroadcastInterval = interval
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ms := mockrouting.NewServer()
+	ks := newMockKeyStore()
+
+	pubhost := newNetHost(ctx, t)
+	pubmr := newMockRouting(ms, ks, pubhost)
+	fs, err := floodsub.NewFloodSub(ctx, pubhost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := NewPubsubPublisher(ctx, pubhost, ds.NewMapDatastore(), pubmr, fs)
+	privk := pubhost.Peerstore().PrivKey(pubhost.ID())
+	pubpinfo := pstore.PeerInfo{ID: pubhost.ID(), Addrs: pubhost.Addrs()}
+
+	name := "/ipns/" + pubhost.ID().Pretty()
+
+	// a subscriber receives the record from the publisher
+	subhost := newNetHost(ctx, t)
+	submr := newMockRouting(ms, ks, subhost)
+	fs, err = floodsub.NewFloodSub(ctx, subhost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := NewPubsubResolver(ctx, subhost, ds.NewMapDatastore(), submr, ks, fs)
+	if err := subhost.Connect(ctx, pubpinfo); err != nil {
+		t.Fatal(err)
+	}
+	checkResolveNotFound(ctx, t, 0, sub, name)
+
+	// let the bootstrap finish
+	time.Sleep(time.Second * 1)
+
+	val := path.Path("/ipfs/QmP1DfoUjiWH2ZBo1PBH6FupdBucbDepx3HpWmEY6JMUpY")
+	err = pub.Publish(ctx, privk, val)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Second * 1)
+	checkResolve(ctx, t, 0, sub, name, val)
+
+	// the publisher leaves, and the subscriber does not answer fetches, so
+	// the late subscriber can only get the record from the rebroadcasts
+	if err := pubhost.Close(); err != nil {
+		t.Fatal(err)
+	}
+	subhost.RemoveStreamHandler(PubsubFetchProtocol)
+	subpinfo := pstore.PeerInfo{ID: subhost.ID(), Addrs: subhost.Addrs()}
+
+	latehost := newNetHost(ctx, t)
+	latemr := newMockRouting(mockrouting.NewServer(), ks, latehost)
+	fs, err = floodsub.NewFloodSub(ctx, latehost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	late := NewPubsubResolver(ctx, latehost, ds.NewMapDatastore(), latemr, ks, fs)
+	if err := latehost.Connect(ctx, subpinfo); err != nil {
+		t.Fatal(err)
+	}
+	checkResolveNotFound(ctx, t, 1, late, name)
+
+	time.Sleep(time.Second * 2)
+	checkResolve(ctx, t, 1, late, name, val)
+}
+
 func checkResolveNotFound(ctx context.Context, t *testing.T, i int, resolver Resolver, name string) {
 	_, err := resolver.Resolve(ctx, name)
 	if err != ErrResolveFailed {
@@ -0,0 +1,215 @@
+package namesys
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	pb "github.com/ipfs/go-ipfs/namesys/pb"
+	path "github.com/ipfs/go-ipfs/path"
+
+	cbor "gx/ipfs/QmNRz7BDWfdFNVLt7AVvmRefkrURD25EeoipcXqo6yoXU1/go-ipld-cbor"
+	pstore "gx/ipfs/QmXauCuJzmzapetmC6W4TuDJLL1yFFrVzSHoWv8YdbmnxH/go-libp2p-peerstore"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+// Keys of the fields of the record in the signed data of v2 records. Other
+// keys of the data are extension fields.
+const (
+	dataValue        = "Value"
+	dataValidity     = "Validity"
+	dataValidityType = "ValidityType"
+	dataSequence     = "Sequence"
+	dataTTL          = "TTL"
+
+	// fields of the records of names with delegated writers
+	dataDelegation = "Delegation"
+	dataWriter     = "Writer"
+)
+
+// ErrDataMismatch should be returned when the fields of a v2 ipns record
+// differ from its signed data
+var ErrDataMismatch = errors.New("record fields do not match the signed data")
+
+// ErrPublicKeyMismatch should be returned when the public key embedded in an
+// ipns record does not match the record key
+var ErrPublicKeyMismatch = errors.New("embedded public key does not match the record key")
+
+// ErrReservedExtension is returned when an extension field of an ipns record
+// uses the name of a field of the record.
+var ErrReservedExtension = errors.New("extension field name is reserved")
+
+func isReservedField(k string) bool {
+	switch k {
+	case dataValue, dataValidity, dataValidityType, dataSequence, dataTTL,
+		dataDelegation, dataWriter:
+		return true
+	}
+	return false
+}
+
+// CreateRoutingEntryDataV2 creates a v2 ipns record. All its fields and the
+// extension fields are signed as a canonical CBOR map. The record is also
+// signed like v1 records, so nodes not knowing v2 records still accept it.
+// The public key is embedded in the record if it cannot be extracted from
+// the peer ID. A zero ttl leaves the TTL of the record unset.
+func CreateRoutingEntryDataV2(pk ci.PrivKey, val path.Path, seq uint64, eol time.Time, ttl time.Duration, ext map[string]interface{}) (*pb.IpnsEntry, error) {
+	for k := range ext {
+		if isReservedField(k) {
+			return nil, fmt.Errorf("%s: %s", ErrReservedExtension, k)
+		}
+	}
+	return createEntryV2(pk, val, seq, eol, ttl, ext)
+}
+
+// createEntryV2 creates a v2 ipns record signing the given additional fields,
+// which may be reserved ones.
+func createEntryV2(pk ci.PrivKey, val path.Path, seq uint64, eol time.Time, ttl time.Duration, fields map[string]interface{}) (*pb.IpnsEntry, error) {
+	entry, err := CreateRoutingEntryData(pk, val, seq, eol)
+	if err != nil {
+		return nil, err
+	}
+	if ttl > 0 {
+		entry.Ttl = proto.Uint64(uint64(ttl.Nanoseconds()))
+	}
+
+	data := make(map[string]interface{}, len(fields)+5)
+	for k, v := range fields {
+		data[k] = v
+	}
+	data[dataValue] = entry.GetValue()
+	data[dataValidity] = entry.GetValidity()
+	data[dataValidityType] = uint64(entry.GetValidityType())
+	data[dataSequence] = entry.GetSequence()
+	data[dataTTL] = entry.GetTtl()
+
+	entry.Data, err = cbor.DumpObject(data)
+	if err != nil {
+		return nil, err
+	}
+	entry.SignatureV2, err = pk.Sign(ipnsEntryDataForSigV2(entry))
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := peer.IDFromPrivateKey(pk)
+	if err != nil {
+		return nil, err
+	}
+	if id.ExtractPublicKey() == nil {
+		entry.PubKey, err = pk.GetPublic().Bytes()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+func ipnsEntryDataForSigV2(e *pb.IpnsEntry) []byte {
+	return append([]byte("ipns-signature:"), e.GetData()...)
+}
+
+// isV2 returns whether the record carries signed data.
+func isV2(e *pb.IpnsEntry) bool {
+	return len(e.GetSignatureV2()) > 0
+}
+
+func entryData(e *pb.IpnsEntry) (map[string]interface{}, error) {
+	var data map[string]interface{}
+	if err := cbor.DecodeInto(e.GetData(), &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// RecordExtensions returns the extension fields of a v2 ipns record, or nil
+// for v1 records. The record must have been validated.
+func RecordExtensions(e *pb.IpnsEntry) (map[string]interface{}, error) {
+	if !isV2(e) {
+		return nil, nil
+	}
+	data, err := entryData(e)
+	if err != nil {
+		return nil, err
+	}
+	for k := range data {
+		if isReservedField(k) {
+			delete(data, k)
+		}
+	}
+	return data, nil
+}
+
+// entryPubKey returns the public key verifying the record of the peer: the
+// one embedded in the record, or else the one of the key book.
+func entryPubKey(pid peer.ID, e *pb.IpnsEntry, kbook pstore.KeyBook) (ci.PubKey, error) {
+	if len(e.GetPubKey()) == 0 {
+		pubk := kbook.PubKey(pid)
+		if pubk == nil {
+			log.Debugf("public key with hash %s not found in peer store", pid)
+			return nil, ErrPublicKeyNotFound
+		}
+		return pubk, nil
+	}
+
+	pubk, err := ci.UnmarshalPublicKey(e.GetPubKey())
+	if err != nil {
+		return nil, ErrBadRecord
+	}
+	if !pid.MatchesPublicKey(pubk) {
+		return nil, ErrPublicKeyMismatch
+	}
+	return pubk, nil
+}
+
+// verifyEntry checks the signature of the record. The fields of v2 records
+// must match their signed data.
+func verifyEntry(pubk ci.PubKey, e *pb.IpnsEntry) error {
+	if !isV2(e) {
+		if ok, err := pubk.Verify(ipnsEntryDataForSig(e), e.GetSignature()); err != nil || !ok {
+			return ErrSignature
+		}
+		return nil
+	}
+
+	if ok, err := pubk.Verify(ipnsEntryDataForSigV2(e), e.GetSignatureV2()); err != nil || !ok {
+		return ErrSignature
+	}
+
+	data, err := entryData(e)
+	if err != nil {
+		return ErrBadRecord
+	}
+
+	v, _ := data[dataValue].([]byte)
+	validity, _ := data[dataValidity].([]byte)
+	vtype, _ := toUint64(data[dataValidityType])
+	seq, _ := toUint64(data[dataSequence])
+	ttl, _ := toUint64(data[dataTTL])
+	if !bytes.Equal(v, e.GetValue()) ||
+		!bytes.Equal(validity, e.GetValidity()) ||
+		vtype != uint64(e.GetValidityType()) ||
+		seq != e.GetSequence() ||
+		ttl != e.GetTtl() {
+		return ErrDataMismatch
+	}
+	return nil
+}
+
+// toUint64 converts the integers decoded from CBOR.
+func toUint64(v interface{}) (uint64, bool) {
+	switch v := v.(type) {
+	case uint64:
+		return v, true
+	case uint:
+		return uint64(v), true
+	case int:
+		return uint64(v), v >= 0
+	case int64:
+		return uint64(v), v >= 0
+	}
+	return 0, false
+}
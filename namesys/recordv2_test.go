@@ -0,0 +1,169 @@
+package namesys
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/ipfs/go-ipfs/namesys/pb"
+	path "github.com/ipfs/go-ipfs/path"
+
+	record "gx/ipfs/QmUpttFinNDmNPgFwKN8sZK6BUtBmA68Y4KdSBDXa8t9sJ/go-libp2p-record"
+	pstore "gx/ipfs/QmXauCuJzmzapetmC6W4TuDJLL1yFFrVzSHoWv8YdbmnxH/go-libp2p-peerstore"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+)
+
+func validateEntry(t *testing.T, kbook pstore.KeyBook, id peer.ID, e *pb.IpnsEntry) error {
+	data, err := proto.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewIpnsRecordValidator(kbook).Func(&record.ValidationRecord{
+		Namespace: "ipns",
+		Key:       string(id),
+		Value:     data,
+	})
+}
+
+func TestRecordV2(t *testing.T) {
+	priv, id, _, _ := genKeys(t)
+	priv2, _, _, _ := genKeys(t)
+	emptyKbook := pstore.NewPeerstore()
+
+	p := path.Path("/ipfs/QmfM2r8seH2GiRaC4esTjeraXEachRt8ZsSeGaWTPLyMoG")
+	eol := time.Now().Add(time.Hour)
+	ext := map[string]interface{}{"app": "test"}
+
+	newEntry := func() *pb.IpnsEntry {
+		e, err := CreateRoutingEntryDataV2(priv, p, 3, eol, time.Minute, ext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	// RSA keys do not fit in the peer ID, so the record carries the key
+	e := newEntry()
+	if len(e.GetPubKey()) == 0 {
+		t.Fatal("expected the public key to be embedded")
+	}
+	if err := validateEntry(t, emptyKbook, id, e); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := RecordExtensions(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got["app"] != "test" {
+		t.Fatalf("unexpected extension fields: %v", got)
+	}
+
+	// the v1 signature still holds for nodes not knowing v2 records
+	if err := verifyEntry(priv.GetPublic(), &pb.IpnsEntry{
+		Value:        e.Value,
+		Signature:    e.Signature,
+		ValidityType: e.ValidityType,
+		Validity:     e.Validity,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// unsigned fields cannot be changed anymore
+	e = newEntry()
+	e.Sequence = proto.Uint64(4)
+	if err := validateEntry(t, emptyKbook, id, e); err != ErrDataMismatch {
+		t.Fatalf("expected %s, got %v", ErrDataMismatch, err)
+	}
+	e = newEntry()
+	e.Ttl = proto.Uint64(uint64(time.Hour))
+	if err := validateEntry(t, emptyKbook, id, e); err != ErrDataMismatch {
+		t.Fatalf("expected %s, got %v", ErrDataMismatch, err)
+	}
+	e = newEntry()
+	e.Data = append(e.Data, 0)
+	if err := validateEntry(t, emptyKbook, id, e); err != ErrSignature {
+		t.Fatalf("expected %s, got %v", ErrSignature, err)
+	}
+
+	// the embedded key must be the one of the name
+	e = newEntry()
+	e.PubKey, err = priv2.GetPublic().Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateEntry(t, emptyKbook, id, e); err != ErrPublicKeyMismatch {
+		t.Fatalf("expected %s, got %v", ErrPublicKeyMismatch, err)
+	}
+
+	_, err = CreateRoutingEntryDataV2(priv, p, 1, eol, 0, map[string]interface{}{dataSequence: 5})
+	if err == nil {
+		t.Fatal("expected reserved extension field to be rejected")
+	}
+}
+
+func TestOrderingV2(t *testing.T) {
+	ts := time.Unix(1000000, 0)
+	priv, _, _, _ := genKeys(t)
+
+	v1, err := CreateRoutingEntryData(priv, path.Path("foo"), 2, ts.Add(time.Hour*2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := CreateRoutingEntryDataV2(priv, path.Path("bar"), 2, ts.Add(time.Hour), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2next, err := CreateRoutingEntryDataV2(priv, path.Path("baz"), 2, ts.Add(time.Hour*2), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1next, err := CreateRoutingEntryData(priv, path.Path("cat"), 3, ts.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AssertSelected(v2, v1, v2); err != nil {
+		t.Fatal(err)
+	}
+	if err := AssertSelected(v2next, v1, v2, v2next); err != nil {
+		t.Fatal(err)
+	}
+	// v1 records do not override v2 records, even with a higher sequence
+	// number, as it is not signed
+	if err := AssertSelected(v2next, v1, v2, v2next, v1next); err != nil {
+		t.Fatal(err)
+	}
+	if err := AssertSelected(v1next, v1, v1next); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordV2Downgrade(t *testing.T) {
+	priv, id, _, _ := genKeys(t)
+	emptyKbook := pstore.NewPeerstore()
+
+	p := path.Path("/ipfs/QmfM2r8seH2GiRaC4esTjeraXEachRt8ZsSeGaWTPLyMoG")
+	v2, err := CreateRoutingEntryDataV2(priv, p, 3, time.Now().Add(time.Hour), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stripped of its signed data, the record is a valid v1 record whose
+	// sequence number can be raised
+	forged := &pb.IpnsEntry{
+		Value:        v2.Value,
+		Signature:    v2.Signature,
+		ValidityType: v2.ValidityType,
+		Validity:     v2.Validity,
+		Sequence:     proto.Uint64(100),
+		PubKey:       v2.PubKey,
+	}
+	if err := validateEntry(t, emptyKbook, id, forged); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AssertSelected(v2, forged, v2); err != nil {
+		t.Fatal(err)
+	}
+}
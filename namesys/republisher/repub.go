@@ -3,6 +3,8 @@ package republisher
 import (
 	"context"
 	"errors"
+	"sort"
+	"sync"
 	"time"
 
 	keystore "github.com/ipfs/go-ipfs/keystore"
@@ -10,6 +12,7 @@ import (
 	pb "github.com/ipfs/go-ipfs/namesys/pb"
 	path "github.com/ipfs/go-ipfs/path"
 
+	u "gx/ipfs/QmNiJuT8Ja3hMVpBHXv3Q6dwmperaQ6JjLtpMQgMCD7xvx/go-ipfs-util"
 	logging "gx/ipfs/QmRb5jh8z2E8hMGN2tkvs1yHynUanqnZ3UeKwgN1i9P1F8/go-log"
 	goprocess "gx/ipfs/QmSF8fPo3jgVBAy8fpdjjYqgG87dkJgUprRBHRd2tmfgpP/goprocess"
 	gpctx "gx/ipfs/QmSF8fPo3jgVBAy8fpdjjYqgG87dkJgUprRBHRd2tmfgpP/goprocess/context"
@@ -17,6 +20,7 @@ import (
 	dshelp "gx/ipfs/QmTmqJGRQfuH8eKWD1FjThwPRipt1QhqJQNZ8MpzmfAAxo/go-ipfs-ds-help"
 	recpb "gx/ipfs/QmUpttFinNDmNPgFwKN8sZK6BUtBmA68Y4KdSBDXa8t9sJ/go-libp2p-record/pb"
 	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
+	dsq "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore/query"
 	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
 	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
 	ic "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
@@ -24,6 +28,8 @@ import (
 
 var errNoEntry = errors.New("no previous entry")
 
+var errExpired = errors.New("record expired")
+
 var log = logging.Logger("ipns-repub")
 
 // DefaultRebroadcastInterval is the default interval at which we rebroadcast IPNS records
@@ -38,6 +44,27 @@ var FailureRetryInterval = time.Minute * 5
 // DefaultRecordLifetime is the default lifetime for IPNS records
 const DefaultRecordLifetime = time.Hour * 24
 
+// trackedPrefix is the datastore namespace of the names republished from
+// their stored record.
+var trackedPrefix = ds.NewKey("/namesys/republish")
+
+// SelfKeyName is the name of the identity key of the node among the keys
+// to republish.
+const SelfKeyName = "self"
+
+// Status is the state of the republishing of a name.
+type Status struct {
+	Name peer.ID
+	// Key is the name of the key of the name, empty for the names whose
+	// record was signed elsewhere.
+	Key   string
+	Value path.Path
+	// LastRepublish is the time of the last republishing attempt.
+	LastRepublish time.Time
+	// Err is why the last attempt failed, nil if it succeeded.
+	Err error
+}
+
 type Republisher struct {
 	r    routing.ValueStore
 	ds   ds.Datastore
@@ -48,6 +75,13 @@ type Republisher struct {
 
 	// how long records that are republished should be valid for
 	RecordLifetime time.Duration
+
+	// Keys holds the names of the keys to republish, with SelfKeyName for
+	// the identity key. All keys are republished when it is nil.
+	Keys []string
+
+	mu     sync.Mutex
+	status map[peer.ID]Status
 }
 
 // NewRepublisher creates a new Republisher
@@ -59,6 +93,7 @@ func NewRepublisher(r routing.ValueStore, ds ds.Datastore, self ic.PrivKey, ks k
 		ks:             ks,
 		Interval:       DefaultRebroadcastInterval,
 		RecordLifetime: DefaultRecordLifetime,
+		status:         make(map[peer.ID]Status),
 	}
 }
 
@@ -86,37 +121,91 @@ func (rp *Republisher) Run(proc goprocess.Process) {
 	}
 }
 
+// Status returns the state of the republishing of the names attempted since
+// start, sorted by name.
+func (rp *Republisher) Status() []Status {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	out := make([]Status, 0, len(rp.status))
+	for _, st := range rp.status {
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (rp *Republisher) setStatus(st Status) {
+	st.LastRepublish = time.Now()
+	rp.mu.Lock()
+	rp.status[st.Name] = st
+	rp.mu.Unlock()
+}
+
+// republishEntries republishes all the names, and returns the first error.
 func (rp *Republisher) republishEntries(p goprocess.Process) error {
 	ctx, cancel := context.WithCancel(gpctx.OnClosingContext(p))
 	defer cancel()
 
-	err := rp.republishEntry(ctx, rp.self)
+	var firstErr error
+	keys, err := rp.keys()
 	if err != nil {
 		return err
 	}
+	for name, priv := range keys {
+		err := rp.republishEntry(ctx, name, priv)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	ids, err := TrackedNames(rp.ds)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		err := rp.republishRecord(ctx, id)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
 
+	return firstErr
+}
+
+// keys returns the keys to republish by name.
+func (rp *Republisher) keys() (map[string]ic.PrivKey, error) {
+	keys := map[string]ic.PrivKey{SelfKeyName: rp.self}
 	if rp.ks != nil {
 		keyNames, err := rp.ks.List()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		for _, name := range keyNames {
 			priv, err := rp.ks.Get(name)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			err = rp.republishEntry(ctx, priv)
-			if err != nil {
-				return err
-			}
-
+			keys[name] = priv
 		}
 	}
 
-	return nil
+	if rp.Keys == nil {
+		return keys, nil
+	}
+	selected := make(map[string]ic.PrivKey, len(rp.Keys))
+	for _, name := range rp.Keys {
+		priv, ok := keys[name]
+		if !ok {
+			log.Warningf("not republishing unknown key %s", name)
+			continue
+		}
+		selected[name] = priv
+	}
+	return selected, nil
 }
 
-func (rp *Republisher) republishEntry(ctx context.Context, priv ic.PrivKey) error {
+func (rp *Republisher) republishEntry(ctx context.Context, key string, priv ic.PrivKey) error {
 	id, err := peer.IDFromPrivateKey(priv)
 	if err != nil {
 		return err
@@ -126,43 +215,113 @@ func (rp *Republisher) republishEntry(ctx context.Context, priv ic.PrivKey) erro
 
 	// Look for it locally only
 	_, ipnskey := namesys.IpnsKeysForID(id)
-	p, seq, err := rp.getLastVal(ipnskey)
+	e, err := rp.getLastEntry(ipnskey)
 	if err != nil {
 		if err == errNoEntry {
 			return nil
 		}
+		rp.setStatus(Status{Name: id, Key: key, Err: err})
 		return err
 	}
 
-	// update record with same sequence number
+	// update record with same sequence number, renewing its delegation
 	eol := time.Now().Add(rp.RecordLifetime)
-	err = namesys.PutRecordToRouting(ctx, priv, p, seq, eol, rp.r, id)
+	err = namesys.RepublishRecordToRouting(ctx, priv, e, eol, rp.r, id)
+	rp.setStatus(Status{Name: id, Key: key, Value: path.Path(e.GetValue()), Err: err})
+	return err
+}
+
+// republishRecord puts the stored record of a name signed elsewhere to the
+// routing system again. The name is not tracked anymore once its record
+// expired.
+func (rp *Republisher) republishRecord(ctx context.Context, id peer.ID) error {
+	log.Debugf("republishing stored ipns record for %s", id)
+
+	_, ipnskey := namesys.IpnsKeysForID(id)
+	e, err := rp.getLastEntry(ipnskey)
 	if err != nil {
+		rp.setStatus(Status{Name: id, Err: err})
 		return err
 	}
+	p := path.Path(e.GetValue())
 
-	return nil
+	if e.GetValidityType() == pb.IpnsEntry_EOL {
+		eol, err := u.ParseRFC3339(string(e.GetValidity()))
+		if err != nil {
+			rp.setStatus(Status{Name: id, Value: p, Err: err})
+			return err
+		}
+		if time.Now().After(eol) {
+			log.Infof("record of %s expired, not republishing it anymore", id)
+			rp.setStatus(Status{Name: id, Value: p, Err: errExpired})
+			return Untrack(rp.ds, id)
+		}
+	}
+
+	err = namesys.PublishEntry(ctx, rp.r, ipnskey, e)
+	rp.setStatus(Status{Name: id, Value: p, Err: err})
+	return err
 }
 
-func (rp *Republisher) getLastVal(k string) (path.Path, uint64, error) {
+// getLastEntry returns the record of the ipns key in the datastore.
+func (rp *Republisher) getLastEntry(k string) (*pb.IpnsEntry, error) {
 	ival, err := rp.ds.Get(dshelp.NewKeyFromBinary([]byte(k)))
 	if err != nil {
 		// not found means we dont have a previously published entry
-		return "", 0, errNoEntry
+		return nil, errNoEntry
 	}
 
 	val := ival.([]byte)
 	dhtrec := new(recpb.Record)
 	err = proto.Unmarshal(val, dhtrec)
 	if err != nil {
-		return "", 0, err
+		return nil, err
 	}
 
 	// extract published data from record
 	e := new(pb.IpnsEntry)
 	err = proto.Unmarshal(dhtrec.GetValue(), e)
 	if err != nil {
-		return "", 0, err
+		return nil, err
+	}
+	return e, nil
+}
+
+// Track adds the name to the names whose stored record is republished, for
+// records signed elsewhere.
+func Track(d ds.Datastore, id peer.ID) error {
+	return d.Put(trackedPrefix.ChildString(id.Pretty()), []byte{})
+}
+
+// Untrack removes the name from the names whose stored record is
+// republished.
+func Untrack(d ds.Datastore, id peer.ID) error {
+	err := d.Delete(trackedPrefix.ChildString(id.Pretty()))
+	if err == ds.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// TrackedNames returns the names whose stored record is republished.
+func TrackedNames(d ds.Datastore) ([]peer.ID, error) {
+	res, err := d.Query(dsq.Query{Prefix: trackedPrefix.String(), KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var ids []peer.ID
+	for e := range res.Next() {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		id, err := peer.IDB58Decode(ds.RawKey(e.Key).BaseNamespace())
+		if err != nil {
+			log.Warningf("skipping invalid tracked name %s: %s", e.Key, err)
+			continue
+		}
+		ids = append(ids, id)
 	}
-	return path.Path(e.Value), e.GetSequence(), nil
+	return ids, nil
 }
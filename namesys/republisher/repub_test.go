@@ -14,7 +14,13 @@ import (
 
 	mocknet "gx/ipfs/QmNh1kGFFdsPu79KNSaL4NUKUPb4Eiz4KHdMtFY6664RDp/go-libp2p/p2p/net/mock"
 	goprocess "gx/ipfs/QmSF8fPo3jgVBAy8fpdjjYqgG87dkJgUprRBHRd2tmfgpP/goprocess"
+	routing "gx/ipfs/QmTiWLZ6Fo5j4KcTVutZJ5KWRRJrbxzmxA4td8NfEdrPh7/go-libp2p-routing"
+	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
+	dssync "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore/sync"
 	pstore "gx/ipfs/QmXauCuJzmzapetmC6W4TuDJLL1yFFrVzSHoWv8YdbmnxH/go-libp2p-peerstore"
+	offroute "gx/ipfs/QmXtoXbu9ReyV6Q4kDQ5CF9wXQNDY1PdHc4HhfxRR5AHB3/go-ipfs-routing/offline"
+	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
+	ci "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
 )
 
 func TestRepublish(t *testing.T) {
@@ -94,6 +100,85 @@ func TestRepublish(t *testing.T) {
 	}
 }
 
+func TestRepublishImported(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	self := genKey(t)
+	r := offroute.NewOfflineRouter(dstore, self)
+
+	// records signed elsewhere and put on this node
+	p := path.FromString("/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn")
+	valid := importRecord(ctx, t, r, dstore, p, time.Now().Add(time.Hour))
+	expired := importRecord(ctx, t, r, dstore, p, time.Now().Add(-time.Hour))
+
+	repub := NewRepublisher(r, dstore, self, nil)
+	repub.Interval = 10 * time.Millisecond
+
+	proc := goprocess.Go(repub.Run)
+	defer proc.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(repub.Status()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the records to be republished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the identity key was never published
+	status := make(map[peer.ID]Status)
+	for _, st := range repub.Status() {
+		status[st.Name] = st
+	}
+	if len(status) != 2 {
+		t.Fatalf("expected the status of 2 names, got %d", len(status))
+	}
+	if st := status[valid]; st.Err != nil || st.Value != p || st.Key != "" {
+		t.Fatalf("unexpected status of the valid record: %+v", st)
+	}
+	if st := status[expired]; st.Err == nil {
+		t.Fatal("expected the expired record not to be republished")
+	}
+
+	tracked, err := TrackedNames(dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracked) != 1 || tracked[0] != valid {
+		t.Fatalf("expected only %s to be tracked, got %v", valid, tracked)
+	}
+}
+
+func genKey(t *testing.T) ci.PrivKey {
+	priv, _, err := ci.GenerateKeyPair(ci.RSA, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+func importRecord(ctx context.Context, t *testing.T, r routing.ValueStore, dstore ds.Datastore, p path.Path, eol time.Time) peer.ID {
+	priv := genKey(t)
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := namesys.CreateRoutingEntryData(priv, p, 1, eol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ipnskey := namesys.IpnsKeysForID(id)
+	if err := namesys.PublishEntry(ctx, r, ipnskey, entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := Track(dstore, id); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
 func verifyResolution(nodes []*core.IpfsNode, key string, exp path.Path) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
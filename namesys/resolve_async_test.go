@@ -0,0 +1,264 @@
+package namesys
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	opts "github.com/ipfs/go-ipfs/namesys/opts"
+	pb "github.com/ipfs/go-ipfs/namesys/pb"
+	path "github.com/ipfs/go-ipfs/path"
+
+	routing "gx/ipfs/QmTiWLZ6Fo5j4KcTVutZJ5KWRRJrbxzmxA4td8NfEdrPh7/go-libp2p-routing"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+)
+
+// stagedStore streams its first value right away, and the others once
+// released. It counts the queries it answers.
+type stagedStore struct {
+	vals    [][]byte
+	release chan struct{}
+	queries int32
+}
+
+func (s *stagedStore) PutValue(ctx context.Context, k string, v []byte) error {
+	return nil
+}
+
+func (s *stagedStore) GetValue(ctx context.Context, k string) ([]byte, error) {
+	return nil, routing.ErrNotFound
+}
+
+func (s *stagedStore) GetValues(ctx context.Context, k string, count int) ([]routing.RecvdVal, error) {
+	var out []routing.RecvdVal
+	vals, err := s.SearchValues(ctx, k, count)
+	if err != nil {
+		return nil, err
+	}
+	for v := range vals {
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (s *stagedStore) SearchValues(ctx context.Context, k string, count int) (<-chan routing.RecvdVal, error) {
+	atomic.AddInt32(&s.queries, 1)
+	out := make(chan routing.RecvdVal)
+	go func() {
+		defer close(out)
+		for i := 0; i < count && i < len(s.vals); i++ {
+			if i == 1 {
+				select {
+				case <-s.release:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case out <- routing.RecvdVal{Val: s.vals[i]}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// batchStore only answers the records of the stagedStore once the query is
+// over.
+type batchStore struct {
+	s *stagedStore
+}
+
+func (b batchStore) PutValue(ctx context.Context, k string, v []byte) error {
+	return b.s.PutValue(ctx, k, v)
+}
+
+func (b batchStore) GetValue(ctx context.Context, k string) ([]byte, error) {
+	return b.s.GetValue(ctx, k)
+}
+
+func (b batchStore) GetValues(ctx context.Context, k string, count int) ([]routing.RecvdVal, error) {
+	return b.s.GetValues(ctx, k, count)
+}
+
+func nextResult(t *testing.T, results <-chan Result) Result {
+	select {
+	case res, ok := <-results:
+		if !ok {
+			t.Fatal("results closed early")
+		}
+		return res
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a result")
+	}
+	return Result{}
+}
+
+func TestNamesysResolveAsync(t *testing.T) {
+	r := &mpns{
+		resolvers: map[string]resolver{
+			"dht": mockResolverOne(),
+			"dns": mockResolverTwo(),
+		},
+	}
+
+	results := r.ResolveAsync(context.Background(), "/ipns/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD")
+	res := nextResult(t, results)
+	if res.Err != nil || res.Path.String() != "/ipfs/Qmcqtw8FfrVSBaRmbWwHxt3AuySBhJLcvmFYi3Lbc4xnwj" {
+		t.Fatalf("unexpected result: %s %v", res.Path, res.Err)
+	}
+	if _, ok := <-results; ok {
+		t.Fatal("expected a single result")
+	}
+
+	results = r.ResolveAsync(context.Background(), "/ipns/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", opts.Depth(1))
+	res = nextResult(t, results)
+	if res.Err != ErrResolveRecursion || res.Path.String() != "/ipns/ipfs.io" {
+		t.Fatalf("unexpected result: %s %v", res.Path, res.Err)
+	}
+}
+
+func TestRoutingResolveAsync(t *testing.T) {
+	priv, id, _, _ := genKeys(t)
+	eol := time.Now().Add(time.Hour)
+
+	oldPath := path.Path("/ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy")
+	newPath := path.Path("/ipfs/QmfM2r8seH2GiRaC4esTjeraXEachRt8ZsSeGaWTPLyMoG")
+	var vals [][]byte
+	for i, p := range []path.Path{oldPath, newPath} {
+		e, err := CreateRoutingEntryDataV2(priv, p, uint64(i+1), eol, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := proto.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vals = append(vals, data)
+	}
+
+	store := &stagedStore{vals: vals, release: make(chan struct{})}
+	r := NewRoutingResolver(store, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := r.ResolveAsync(ctx, "/ipns/"+id.Pretty())
+
+	// the first record found is answered without waiting for the others
+	res := nextResult(t, results)
+	if res.Err != nil || res.Path != oldPath {
+		t.Fatalf("expected %s first, got %s %v", oldPath, res.Path, res.Err)
+	}
+
+	close(store.release)
+	res = nextResult(t, results)
+	if res.Err != nil || res.Path != newPath {
+		t.Fatalf("expected %s, got %s %v", newPath, res.Path, res.Err)
+	}
+	if _, ok := <-results; ok {
+		t.Fatal("expected no more results")
+	}
+
+	if q := atomic.LoadInt32(&store.queries); q != 1 {
+		t.Fatalf("expected a single query, got %d", q)
+	}
+
+	// the synchronous resolution waits for the best record
+	p, err := r.Resolve(ctx, "/ipns/"+id.Pretty())
+	if err != nil || p != newPath {
+		t.Fatalf("expected %s, got %s %v", newPath, p, err)
+	}
+
+	// routing systems that do not stream their answers get a single query,
+	// and only the best record is answered
+	store = &stagedStore{vals: vals, release: make(chan struct{})}
+	close(store.release)
+	r = NewRoutingResolver(batchStore{store}, 0)
+	results = r.ResolveAsync(ctx, "/ipns/"+id.Pretty())
+	res = nextResult(t, results)
+	if res.Err != nil || res.Path != newPath {
+		t.Fatalf("expected %s, got %s %v", newPath, res.Path, res.Err)
+	}
+	if _, ok := <-results; ok {
+		t.Fatal("expected no more results")
+	}
+	if q := atomic.LoadInt32(&store.queries); q != 1 {
+		t.Fatalf("expected a single query, got %d", q)
+	}
+}
+
+// recordResolver answers a record, like the pubsub resolver does.
+type recordResolver struct {
+	data []byte
+}
+
+func (r *recordResolver) resolveOnce(ctx context.Context, name string, options *opts.ResolveOpts) (path.Path, error) {
+	res := <-r.resolveOnceAsync(ctx, name, options)
+	return res.value, res.err
+}
+
+func (r *recordResolver) resolveOnceAsync(ctx context.Context, name string, options *opts.ResolveOpts) <-chan onceResult {
+	out := make(chan onceResult, 1)
+	entry := new(pb.IpnsEntry)
+	if err := proto.Unmarshal(r.data, entry); err != nil {
+		out <- onceResult{err: err}
+	} else {
+		out <- onceResult{value: path.Path(entry.GetValue()), entry: entry, data: r.data}
+	}
+	close(out)
+	return out
+}
+
+func TestNamesysResolveAsyncDropsOlderRecords(t *testing.T) {
+	priv, id, _, _ := genKeys(t)
+	eol := time.Now().Add(time.Hour)
+
+	oldPath := path.Path("/ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy")
+	newPath := path.Path("/ipfs/QmfM2r8seH2GiRaC4esTjeraXEachRt8ZsSeGaWTPLyMoG")
+	var vals [][]byte
+	for i, p := range []path.Path{oldPath, newPath} {
+		e, err := CreateRoutingEntryDataV2(priv, p, uint64(i+1), eol, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := proto.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vals = append(vals, data)
+	}
+
+	// the DHT only has the older record
+	store := &stagedStore{vals: vals[:1], release: make(chan struct{})}
+	close(store.release)
+	r := &mpns{
+		resolvers: map[string]resolver{
+			"pubsub": &recordResolver{data: vals[1]},
+			"dht":    NewRoutingResolver(store, 0),
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := r.ResolveAsync(ctx, "/ipns/"+id.Pretty())
+	res := nextResult(t, results)
+	if res.Err != nil || res.Path != newPath {
+		t.Fatalf("expected %s, got %s %v", newPath, res.Path, res.Err)
+	}
+	if res, ok := <-results; ok {
+		t.Fatalf("expected the older DHT record to be dropped, got %s %v", res.Path, res.Err)
+	}
+
+	// records newer than the pubsub one are answered
+	r.resolvers["pubsub"] = &recordResolver{data: vals[0]}
+	store.vals = vals[1:]
+	results = r.ResolveAsync(ctx, "/ipns/"+id.Pretty())
+	for _, expected := range []path.Path{oldPath, newPath} {
+		res := nextResult(t, results)
+		if res.Err != nil || res.Path != expected {
+			t.Fatalf("expected %s, got %s %v", expected, res.Path, res.Err)
+		}
+	}
+}
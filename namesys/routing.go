@@ -3,6 +3,7 @@ package namesys
 import (
 	"context"
 	"strings"
+	"sync"
 	"time"
 
 	opts "github.com/ipfs/go-ipfs/namesys/opts"
@@ -12,7 +13,7 @@ import (
 	u "gx/ipfs/QmNiJuT8Ja3hMVpBHXv3Q6dwmperaQ6JjLtpMQgMCD7xvx/go-ipfs-util"
 	logging "gx/ipfs/QmRb5jh8z2E8hMGN2tkvs1yHynUanqnZ3UeKwgN1i9P1F8/go-log"
 	routing "gx/ipfs/QmTiWLZ6Fo5j4KcTVutZJ5KWRRJrbxzmxA4td8NfEdrPh7/go-libp2p-routing"
-	lru "gx/ipfs/QmVYxfoJQiZijTgPNHCHgHELvQpbsJNTg6Crmc3dQkj3yy/golang-lru"
+	ds "gx/ipfs/QmXRKBQA4wXP7xWbFiZsR1GP4HV6wMDQ1aWFxZZ4uBcPX9/go-datastore"
 	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
 	peer "gx/ipfs/QmZoWKhxUmZ2seW4BzX6fJkNR8hh9PsGModr7q171yq2SS/go-libp2p-peer"
 	mh "gx/ipfs/QmZyZDi491cCNTLfAhwcaDii2Kg4pwKRkhqQzURGDvY6ua/go-multihash"
@@ -25,32 +26,21 @@ var log = logging.Logger("namesys")
 type routingResolver struct {
 	routing routing.ValueStore
 
-	cache *lru.Cache
+	cache *resolveCache
+
+	mu sync.Mutex
+	// revalidating holds the names being refreshed in the background.
+	revalidating map[string]bool
 }
 
+// cacheGet returns the cached value of the name, and starts refreshing it
+// in the background when it is stale.
 func (r *routingResolver) cacheGet(name string) (path.Path, bool) {
-	if r.cache == nil {
-		return "", false
-	}
-
-	ientry, ok := r.cache.Get(name)
-	if !ok {
-		return "", false
-	}
-
-	entry, ok := ientry.(cacheEntry)
-	if !ok {
-		// should never happen, purely for sanity
-		log.Panicf("unexpected type %T in cache for %q.", ientry, name)
+	val, stale, ok := r.cache.get(name)
+	if stale {
+		r.revalidate(name)
 	}
-
-	if time.Now().Before(entry.eol) {
-		return entry.val, true
-	}
-
-	r.cache.Remove(name)
-
-	return "", false
+	return val, ok
 }
 
 func (r *routingResolver) cacheSet(name string, val path.Path, rec *pb.IpnsEntry) {
@@ -69,19 +59,47 @@ func (r *routingResolver) cacheSet(name string, val path.Path, rec *pb.IpnsEntry
 
 	cacheTil := time.Now().Add(ttl)
 	eol, ok := checkEOL(rec)
-	if ok && eol.Before(cacheTil) {
+	if !ok {
+		eol = cacheTil
+	} else if eol.Before(cacheTil) {
 		cacheTil = eol
 	}
 
-	r.cache.Add(name, cacheEntry{
-		val: val,
-		eol: cacheTil,
+	r.cache.set(name, cacheEntry{
+		val:       val,
+		eol:       cacheTil,
+		recordEOL: eol,
 	})
 }
 
-type cacheEntry struct {
-	val path.Path
-	eol time.Time
+// revalidate resolves the name again in the background and caches the
+// result, unless it is already being resolved.
+func (r *routingResolver) revalidate(name string) {
+	r.mu.Lock()
+	if r.revalidating[name] {
+		r.mu.Unlock()
+		return
+	}
+	r.revalidating[name] = true
+	r.mu.Unlock()
+
+	go func() {
+		defer func() {
+			r.mu.Lock()
+			delete(r.revalidating, name)
+			r.mu.Unlock()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultRevalidateTimeout)
+		defer cancel()
+
+		p, entry, err := r.lookup(ctx, name, opts.ProcessOpts(nil))
+		if err != nil {
+			log.Debugf("RoutingResolver: could not revalidate %s: %s", name, err)
+			return
+		}
+		r.cacheSet(name, p, entry)
+	}()
 }
 
 // NewRoutingResolver constructs a name resolver using the IPFS Routing system
@@ -89,18 +107,20 @@ type cacheEntry struct {
 // cachesize is the limit of the number of entries in the lru cache. Setting it
 // to '0' will disable caching.
 func NewRoutingResolver(route routing.ValueStore, cachesize int) *routingResolver {
+	return newRoutingResolver(route, cachesize, nil)
+}
+
+// newRoutingResolver constructs a routing resolver whose cache is persisted
+// in the datastore, unless it is nil.
+func newRoutingResolver(route routing.ValueStore, cachesize int, dstore ds.Datastore) *routingResolver {
 	if route == nil {
 		panic("attempt to create resolver with nil routing system")
 	}
 
-	var cache *lru.Cache
-	if cachesize > 0 {
-		cache, _ = lru.New(cachesize)
-	}
-
 	return &routingResolver{
-		routing: route,
-		cache:   cache,
+		routing:      route,
+		cache:        newResolveCache(cachesize, dstore),
+		revalidating: make(map[string]bool),
 	}
 }
 
@@ -109,6 +129,11 @@ func (r *routingResolver) Resolve(ctx context.Context, name string, options ...o
 	return resolve(ctx, r, name, opts.ProcessOpts(options), "/ipns/")
 }
 
+// ResolveAsync implements Resolver.
+func (r *routingResolver) ResolveAsync(ctx context.Context, name string, options ...opts.ResolveOpt) <-chan Result {
+	return resolveAsync(ctx, r, name, opts.ProcessOpts(options), "/ipns/")
+}
+
 // resolveOnce implements resolver. Uses the IPFS routing system to
 // resolve SFS-like names.
 func (r *routingResolver) resolveOnce(ctx context.Context, name string, options *opts.ResolveOpts) (path.Path, error) {
@@ -118,6 +143,16 @@ func (r *routingResolver) resolveOnce(ctx context.Context, name string, options
 		return cached, nil
 	}
 
+	p, entry, err := r.lookup(ctx, name, options)
+	if err != nil {
+		return "", err
+	}
+	r.cacheSet(name, p, entry)
+	return p, nil
+}
+
+// lookup resolves the name with the routing system, without the cache.
+func (r *routingResolver) lookup(ctx context.Context, name string, options *opts.ResolveOpts) (path.Path, *pb.IpnsEntry, error) {
 	if options.DhtTimeout != 0 {
 		// Resolution must complete within the timeout
 		var cancel context.CancelFunc
@@ -125,6 +160,165 @@ func (r *routingResolver) resolveOnce(ctx context.Context, name string, options
 		defer cancel()
 	}
 
+	ipnsKey, err := r.lookupKey(ctx, name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Use the routing system to get the name.
+	// Note that the DHT will call the ipns validator when retrieving
+	// the value, which in turn verifies the ipns record signature
+	val, err := r.getValue(ctx, ipnsKey, options)
+	if err != nil {
+		log.Debugf("RoutingResolver: dht get for name %s failed: %s", name, err)
+		return "", nil, err
+	}
+
+	entry := new(pb.IpnsEntry)
+	err = proto.Unmarshal(val, entry)
+	if err != nil {
+		log.Debugf("RoutingResolver: could not unmarshal value for name %s: %s", name, err)
+		return "", nil, err
+	}
+
+	p, err := entryPath(entry)
+	if err != nil {
+		return "", nil, err
+	}
+	return p, entry, nil
+}
+
+// resolveOnceAsync implements asyncResolver. The value of the first valid
+// record found is sent, then the values of better records as they arrive.
+func (r *routingResolver) resolveOnceAsync(ctx context.Context, name string, options *opts.ResolveOpts) <-chan onceResult {
+	out := make(chan onceResult, 1)
+	go func() {
+		defer close(out)
+		log.Debugf("RoutingResolver resolving %s asynchronously", name)
+		cached, ok := r.cacheGet(name)
+		if ok {
+			out <- onceResult{value: cached}
+			return
+		}
+
+		if options.DhtTimeout != 0 {
+			// Resolution must complete within the timeout
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, options.DhtTimeout)
+			defer cancel()
+		}
+
+		ipnsKey, err := r.lookupKey(ctx, name)
+		if err != nil {
+			out <- onceResult{err: err}
+			return
+		}
+
+		var best *pb.IpnsEntry
+		var bestVal []byte
+		var bestPath path.Path
+		for res := range r.searchValues(ctx, ipnsKey, options) {
+			if res.err != nil {
+				err = res.err
+				continue
+			}
+
+			entry := new(pb.IpnsEntry)
+			if err = proto.Unmarshal(res.val, entry); err != nil {
+				log.Debugf("RoutingResolver: could not unmarshal value for name %s: %s", name, err)
+				continue
+			}
+			if best != nil {
+				i, err := selectRecord([]*pb.IpnsEntry{best, entry}, [][]byte{bestVal, res.val})
+				if err != nil || i == 0 {
+					continue
+				}
+			}
+			p, perr := entryPath(entry)
+			if perr != nil {
+				err = perr
+				continue
+			}
+			best, bestVal, bestPath = entry, res.val, p
+
+			select {
+			case out <- onceResult{value: p, entry: entry, data: res.val}:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if best == nil {
+			if err == nil {
+				err = routing.ErrNotFound
+			}
+			log.Debugf("RoutingResolver: dht get for name %s failed: %s", name, err)
+			select {
+			case out <- onceResult{err: err}:
+			case <-ctx.Done():
+			}
+			return
+		}
+		r.cacheSet(name, bestPath, best)
+	}()
+	return out
+}
+
+type searchResult struct {
+	val []byte
+	err error
+}
+
+// valueSearcher is implemented by routing systems that pass on the records of
+// a key as they are received, rather than once the lookup is over.
+type valueSearcher interface {
+	SearchValues(ctx context.Context, key string, count int) (<-chan routing.RecvdVal, error)
+}
+
+// searchValues runs a single query for DhtRecordCount records. If the routing
+// system streams its answers, each record is sent as it arrives, otherwise the
+// best record is sent once the query ends.
+func (r *routingResolver) searchValues(ctx context.Context, ipnsKey string, options *opts.ResolveOpts) <-chan searchResult {
+	out := make(chan searchResult, 1)
+	vs, ok := r.routing.(valueSearcher)
+	if !ok {
+		go func() {
+			defer close(out)
+			val, err := r.getValue(ctx, ipnsKey, options)
+			out <- searchResult{val: val, err: err}
+		}()
+		return out
+	}
+
+	go func() {
+		defer close(out)
+		vals, err := vs.SearchValues(ctx, ipnsKey, int(options.DhtRecordCount))
+		if err != nil {
+			out <- searchResult{err: err}
+			return
+		}
+		found := false
+		for v := range vals {
+			if v.Val == nil {
+				continue
+			}
+			found = true
+			select {
+			case out <- searchResult{val: v.Val}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if !found {
+			out <- searchResult{err: routing.ErrNotFound}
+		}
+	}()
+	return out
+}
+
+// lookupKey returns the routing key of the records of the name, after
+// fetching the public key of the name.
+func (r *routingResolver) lookupKey(ctx context.Context, name string) (string, error) {
 	name = strings.TrimPrefix(name, "/ipns/")
 	hash, err := mh.FromB58String(name)
 	if err != nil {
@@ -137,11 +331,11 @@ func (r *routingResolver) resolveOnce(ctx context.Context, name string, options
 	// We retrieve the public key here to make certain that it's in the peer
 	// store before calling GetValue() on the DHT - the DHT will call the
 	// ipns validator, which in turn will get the public key from the peer
-	// store to verify the record signature
+	// store to verify the record signature. Records embedding the public key
+	// are verified without it.
 	_, err = routing.GetPublicKey(r.routing, ctx, hash)
 	if err != nil {
 		log.Debugf("RoutingResolver: could not retrieve public key %s: %s\n", name, err)
-		return "", err
 	}
 
 	pid, err := peer.IDFromBytes(hash)
@@ -150,61 +344,56 @@ func (r *routingResolver) resolveOnce(ctx context.Context, name string, options
 		return "", err
 	}
 
-	// Use the routing system to get the name.
-	// Note that the DHT will call the ipns validator when retrieving
-	// the value, which in turn verifies the ipns record signature
 	_, ipnsKey := IpnsKeysForID(pid)
-	val, err := r.getValue(ctx, ipnsKey, options)
-	if err != nil {
-		log.Debugf("RoutingResolver: dht get for name %s failed: %s", name, err)
-		return "", err
-	}
-
-	entry := new(pb.IpnsEntry)
-	err = proto.Unmarshal(val, entry)
-	if err != nil {
-		log.Debugf("RoutingResolver: could not unmarshal value for name %s: %s", name, err)
-		return "", err
-	}
+	return ipnsKey, nil
+}
 
+// entryPath returns the path the record points to.
+func entryPath(entry *pb.IpnsEntry) (path.Path, error) {
 	// check for old style record:
 	valh, err := mh.Cast(entry.GetValue())
 	if err != nil {
 		// Not a multihash, probably a new record
-		p, err := path.ParsePath(string(entry.GetValue()))
-		if err != nil {
-			return "", err
-		}
-
-		r.cacheSet(name, p, entry)
-		return p, nil
-	} else {
-		// Its an old style multihash record
-		log.Debugf("encountered CIDv0 ipns entry: %s", valh)
-		p := path.FromCid(cid.NewCidV0(valh))
-		r.cacheSet(name, p, entry)
-		return p, nil
+		return path.ParsePath(string(entry.GetValue()))
 	}
+
+	// Its an old style multihash record
+	log.Debugf("encountered CIDv0 ipns entry: %s", valh)
+	return path.FromCid(cid.NewCidV0(valh)), nil
 }
 
-func (r *routingResolver) getValue(ctx context.Context, ipnsKey string, options *opts.ResolveOpts) ([]byte, error) {
+// getValues returns the records of the key received from the routing system.
+func (r *routingResolver) getValues(ctx context.Context, ipnsKey string, options *opts.ResolveOpts) ([]routing.RecvdVal, error) {
 	// Get specified number of values from the DHT
 	vals, err := r.routing.GetValues(ctx, ipnsKey, int(options.DhtRecordCount))
 	if err != nil {
 		return nil, err
 	}
 
-	// Select the best value
-	recs := make([][]byte, 0, len(vals))
+	out := make([]routing.RecvdVal, 0, len(vals))
 	for _, v := range vals {
 		if v.Val != nil {
-			recs = append(recs, v.Val)
+			out = append(out, v)
 		}
 	}
 
-	if len(recs) == 0 {
+	if len(out) == 0 {
 		return nil, routing.ErrNotFound
 	}
+	return out, nil
+}
+
+func (r *routingResolver) getValue(ctx context.Context, ipnsKey string, options *opts.ResolveOpts) ([]byte, error) {
+	vals, err := r.getValues(ctx, ipnsKey, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Select the best value
+	recs := make([][]byte, len(vals))
+	for i, v := range vals {
+		recs[i] = v.Val
+	}
 
 	i, err := IpnsSelectorFunc(ipnsKey, recs)
 	if err != nil {
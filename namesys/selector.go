@@ -3,6 +3,7 @@ package namesys
 import (
 	"bytes"
 	"errors"
+	"fmt"
 
 	pb "github.com/ipfs/go-ipfs/namesys/pb"
 
@@ -10,8 +11,9 @@ import (
 	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
 )
 
-// IpnsSelectorFunc selects the best record by checking which has the highest
-// sequence number and latest EOL
+// IpnsSelectorFunc selects the best record by checking which is a v2 record,
+// carries the newest delegation, has the highest sequence number, and has
+// the latest EOL
 func IpnsSelectorFunc(k string, vals [][]byte) (int, error) {
 	var recs []*pb.IpnsEntry
 	for _, v := range vals {
@@ -28,11 +30,28 @@ func IpnsSelectorFunc(k string, vals [][]byte) (int, error) {
 }
 
 func selectRecord(recs []*pb.IpnsEntry, vals [][]byte) (int, error) {
-	var bestSeq uint64
+	var bestSeq, bestDelegation uint64
 	besti := -1
 
+	// v2 records carry a v1 signature too, which does not cover their
+	// sequence number: stripped of their signed data, they could be given
+	// any sequence number. So v1 records never override v2 records.
+	hasV2 := false
+	for _, r := range recs {
+		if r != nil && isV2(r) {
+			hasV2 = true
+			break
+		}
+	}
+
 	for i, r := range recs {
-		if r == nil || r.GetSequence() < bestSeq {
+		if r == nil || hasV2 && !isV2(r) {
+			continue
+		}
+		// records carrying an older delegation may be signed by removed
+		// writers
+		delegation := entryDelegationSeq(r)
+		if delegation < bestDelegation || delegation == bestDelegation && r.GetSequence() < bestSeq {
 			continue
 		}
 		rt, err := u.ParseRFC3339(string(r.GetValidity()))
@@ -41,8 +60,9 @@ func selectRecord(recs []*pb.IpnsEntry, vals [][]byte) (int, error) {
 			continue
 		}
 
-		if besti == -1 || r.GetSequence() > bestSeq {
+		if besti == -1 || delegation > bestDelegation || r.GetSequence() > bestSeq {
 			bestSeq = r.GetSequence()
+			bestDelegation = delegation
 			besti = i
 		} else if r.GetSequence() == bestSeq {
 			bestt, _ := u.ParseRFC3339(string(recs[besti].GetValidity()))
@@ -61,3 +81,32 @@ func selectRecord(recs []*pb.IpnsEntry, vals [][]byte) (int, error) {
 
 	return besti, nil
 }
+
+// selectReason tells why selectRecord prefers the record a over the record b.
+func selectReason(a, b *pb.IpnsEntry, va, vb []byte) string {
+	if b == nil {
+		return "the other record could not be parsed"
+	}
+	if isV2(a) != isV2(b) {
+		return "v2 record, whose sequence number is signed; v1 records cannot override it"
+	}
+	if ad, bd := entryDelegationSeq(a), entryDelegationSeq(b); ad != bd {
+		return fmt.Sprintf("newer delegation (%d > %d)", ad, bd)
+	}
+	if a.GetSequence() != b.GetSequence() {
+		return fmt.Sprintf("higher sequence number (%d > %d)", a.GetSequence(), b.GetSequence())
+	}
+
+	bt, err := u.ParseRFC3339(string(b.GetValidity()))
+	if err != nil {
+		return "the EOL of the other record could not be parsed"
+	}
+	at, _ := u.ParseRFC3339(string(a.GetValidity()))
+	if at.After(bt) {
+		return fmt.Sprintf("later EOL (%s > %s)", a.GetValidity(), b.GetValidity())
+	}
+	if bytes.Equal(va, vb) {
+		return "same record"
+	}
+	return "greater record bytes"
+}
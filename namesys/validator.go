@@ -42,10 +42,13 @@ var ErrKeyFormat = errors.New("record key could not be parsed into peer ID")
 var ErrPublicKeyNotFound = errors.New("public key not found in peer store")
 
 // NewIpnsRecordValidator returns a ValidChecker for IPNS records.
-// The validator function will get a public key from the KeyBook
-// to verify the record's signature. Note that the public key must
-// already have been fetched from the network and put into the KeyBook
-// by the caller.
+// The validator function will get a public key from the record, or else
+// from the KeyBook to verify the record's signature. Note that the public
+// key of records not embedding it must already have been fetched from the
+// network and put into the KeyBook by the caller. Both v1 records and v2
+// records, whose signed data must match their fields, are accepted. v2
+// records may be signed by a writer listed in the delegation of the name
+// they carry, signed by the owner of the name.
 func NewIpnsRecordValidator(kbook pstore.KeyBook) *record.ValidChecker {
 	// ValidateIpnsRecord implements ValidatorFunc and verifies that the
 	// given record's value is an IpnsEntry, that the entry has been correctly
@@ -68,16 +71,16 @@ func NewIpnsRecordValidator(kbook pstore.KeyBook) *record.ValidChecker {
 			log.Debugf("failed to parse ipns record key %s into peer ID", r.Key)
 			return ErrKeyFormat
 		}
-		pubk := kbook.PubKey(pid)
-		if pubk == nil {
-			log.Debugf("public key with hash %s not found in peer store", pid)
-			return ErrPublicKeyNotFound
+		pubk, err := entryPubKey(pid, entry, kbook)
+		if err != nil {
+			return err
 		}
 
-		// Check the ipns record signature with the public key
-		if ok, err := pubk.Verify(ipnsEntryDataForSig(entry), entry.GetSignature()); err != nil || !ok {
-			log.Debugf("failed to verify signature for ipns record %s", r.Key)
-			return ErrSignature
+		// Check the ipns record signature with the public key, or the
+		// one of the delegated writer which signed it
+		if err := verifyRecord(pubk, entry); err != nil {
+			log.Debugf("failed to verify ipns record %s: %s", r.Key, err)
+			return err
 		}
 
 		// Check that record has not expired
@@ -2,6 +2,7 @@ package loader
 
 import (
 	"github.com/ipfs/go-ipfs/core/coredag"
+	"github.com/ipfs/go-ipfs/exchange/reprovide"
 	"github.com/ipfs/go-ipfs/plugin"
 	"gx/ipfs/QmWLWmRVSiagqP15jczsGME1qpob6HDbtbHAY2he9W5iUo/opentracing-go"
 
@@ -32,6 +33,11 @@ func run(plugins []plugin.Plugin) error {
 			if err != nil {
 				return err
 			}
+		case plugin.PluginReprovider:
+			err := pl.RegisterStrategies(reprovide.DefaultStrategies)
+			if err != nil {
+				return err
+			}
 		default:
 			panic(pl)
 		}
@@ -0,0 +1,13 @@
+package plugin
+
+import (
+	"github.com/ipfs/go-ipfs/exchange/reprovide"
+)
+
+// PluginReprovider is an interface that can be implemented to add
+// reprovider strategies, usable in the Reprovider.Strategy config by name
+type PluginReprovider interface {
+	Plugin
+
+	RegisterStrategies(strategies *reprovide.Strategies) error
+}
@@ -1,5 +1,17 @@
 package config
 
 type API struct {
-	HTTPHeaders map[string][]string // HTTP headers to return with the API.
+	HTTPHeaders   map[string][]string // HTTP headers to return with the API.
+	RateLimit     RateLimit
+	Authorization APIAuthorization
+}
+
+// APIAuthorization configures bearer token authentication for the HTTP API.
+type APIAuthorization struct {
+	// Enabled requires every API request to carry a valid token.
+	Enabled bool
+	// AllowLoopback lets requests from the loopback interface through without
+	// a token, so that the local command line client keeps working. It is off
+	// by default since it trusts every local process.
+	AllowLoopback bool
 }
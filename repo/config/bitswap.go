@@ -0,0 +1,23 @@
+package config
+
+// Bitswap configures how the node serves blocks to other peers.
+type Bitswap struct {
+	// Strategy decides which peer is sent blocks next: "fair" (the default),
+	// "reciprocity" or "allowlist".
+	Strategy string
+
+	// PriorityPeers are served before all other peers by the allowlist
+	// strategy, and limited by PrioritySendRate instead of PeerSendRate.
+	PriorityPeers []string
+
+	// AllowedPeers, when not empty, are the only peers blocks are exchanged
+	// with. Other peers may still connect for the DHT or pubsub.
+	AllowedPeers []string
+
+	PeerSendRate     int64 // bytes per second sent to each peer, 0 for no limit
+	PeerSendBurst    int64 // bytes sent to a peer in a burst, defaults to PeerSendRate
+	PrioritySendRate int64 // bytes per second sent to each priority peer, 0 for no limit
+
+	MaxMessageSize int // budget of the blocks packed into one message, in bytes
+	MaxBlockSize   int // size of the largest block accepted from peers, in bytes
+}
@@ -21,9 +21,12 @@ type Config struct {
 	Discovery Discovery // local node's discovery mechanisms
 	Routing   Routing   // local node's routing settings
 	Ipns      Ipns      // Ipns settings
+	DNS       DNS       // DNSLink resolution settings
 	Bootstrap []string  // local nodes's bootstrap peer addresses
 	Gateway   Gateway   // local node's gateway server options
 	API       API       // local node's API settings
+	WebDAV    WebDAV    // local node's WebDAV share settings
+	Bitswap   Bitswap   // block exchange settings
 	Swarm     SwarmConfig
 
 	Reprovider   Reprovider
@@ -0,0 +1,9 @@
+package config
+
+// DNS configures the resolution of DNSLink names.
+type DNS struct {
+	// Resolvers maps domain suffixes to the resolver of their names:
+	// "system", an "https://" DNS-over-HTTPS endpoint or a "udp://host:port"
+	// DNS server. The "." suffix matches all names.
+	Resolvers map[string]string
+}
@@ -59,6 +59,10 @@ func Init(out io.Writer, nBitsForKeypair int) (*Config, error) {
 			ResolveCacheSize: 128,
 		},
 
+		DNS: DNS{
+			Resolvers: map[string]string{},
+		},
+
 		Gateway: Gateway{
 			RootRedirect: "",
 			Writable:     false,
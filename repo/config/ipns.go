@@ -4,5 +4,13 @@ type Ipns struct {
 	RepublishPeriod string
 	RecordLifetime  string
 
+	// RepublishKeys lists the names of the keys to republish, "self" being
+	// the identity key. All keys are republished when unset.
+	RepublishKeys []string `json:",omitempty"`
+
 	ResolveCacheSize int
+
+	// ResolveCacheRevalidate lists the names served stale from the resolve
+	// cache while they are resolved again in the background.
+	ResolveCacheRevalidate []string `json:",omitempty"`
 }
@@ -0,0 +1,15 @@
+package config
+
+// RateLimit contains per-client limits enforced by the HTTP servers.
+// A zero value for any field disables that limit.
+type RateLimit struct {
+	RequestsPerSecond float64 // sustained request rate allowed per client IP
+	RequestBurst      int     // number of requests a client may make in a burst
+	MaxConcurrent     int     // maximum number of in-flight requests per client IP
+	BytesPerSecond    int64   // response bandwidth allowed per client IP
+}
+
+// Enabled returns whether any limit is configured.
+func (rl RateLimit) Enabled() bool {
+	return rl.RequestsPerSecond > 0 || rl.MaxConcurrent > 0 || rl.BytesPerSecond > 0
+}
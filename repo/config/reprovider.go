@@ -3,4 +3,7 @@ package config
 type Reprovider struct {
 	Interval string // Time period to reprovide locally stored objects to the network
 	Strategy string // Which keys to announce
+
+	Batched   bool // Announce keys in batches sharing DHT lookups
+	BatchSize int  // Number of keys sorted and announced together
 }
@@ -0,0 +1,11 @@
+package config
+
+// WebDAV contains options for the WebDAV share of the node's files (MFS),
+// served on the API address.
+type WebDAV struct {
+	Enabled  bool
+	ReadOnly bool // refuse all modifications
+	// ExposeIPFS adds a read-only 'ipfs' collection at the root of the share
+	// through which any /ipfs/ path can be browsed.
+	ExposeIPFS bool
+}
@@ -32,6 +32,14 @@ test_expect_success "resolve output looks good" '
   test_cmp expected2 output
 '
 
+test_expect_success "'ipfs name resolve --stream' succeeds" '
+  ipfs name resolve --stream "$PEERID" >output
+'
+
+test_expect_success "resolve --stream output looks good" '
+  test_cmp expected2 output
+'
+
 # now test with a path
 
 test_expect_success "'ipfs name publish' succeeds" '
@@ -91,6 +99,118 @@ test_expect_success "publish an explicit node ID as key name looks good" '
 '
 
 
+# test signing records offline and putting them
+
+test_expect_success "'ipfs name publish --offline' succeeds" '
+  ipfs name publish --offline --output=record.bin "/ipfs/$HASH_WELCOME_DOCS" >publish_out
+'
+
+test_expect_success "offline publish output looks good" '
+  echo "Signed record of ${PEERID}: /ipfs/$HASH_WELCOME_DOCS, written to record.bin" >expected_offline &&
+  test_cmp expected_offline publish_out &&
+  test -s record.bin
+'
+
+test_expect_success "'ipfs name publish --output' fails without --offline" '
+  test_expect_code 1 ipfs name publish --output=other.bin "/ipfs/$HASH_WELCOME_DOCS"
+'
+
+test_expect_success "'ipfs name put' succeeds" '
+  ipfs name put "$PEERID" record.bin >put_out
+'
+
+test_expect_success "put output looks good" '
+  echo "Published to ${PEERID}: /ipfs/$HASH_WELCOME_DOCS" >expected_put &&
+  test_cmp expected_put put_out
+'
+
+test_expect_success "'ipfs name put' fails for the record of another name" '
+  test_expect_code 1 ipfs name put "$NEWID" record.bin
+'
+
+test_expect_success "'ipfs name get --raw' returns the record" '
+  ipfs name get --raw "$PEERID" >record_out &&
+  test_cmp record.bin record_out
+'
+
+test_expect_success "'ipfs name get' shows the record fields" '
+  ipfs name get "$PEERID" >get_out &&
+  grep "Value: /ipfs/$HASH_WELCOME_DOCS" get_out &&
+  grep "Validity Type: EOL" get_out &&
+  grep "Version: 2" get_out
+'
+
+test_expect_success "'ipfs name resolve' returns the put record" '
+  ipfs name resolve "$PEERID" >output &&
+  printf "/ipfs/%s\n" "$HASH_WELCOME_DOCS" >expected_resolve &&
+  test_cmp expected_resolve output
+'
+
+test_expect_success "'ipfs name inspect' succeeds" '
+  ipfs name inspect "$PEERID" >inspect_out
+'
+
+test_expect_success "inspect output looks good" '
+  grep "Name: $PEERID" inspect_out &&
+  grep "Key Type: RSA" inspect_out &&
+  grep "dht: /ipfs/$HASH_WELCOME_DOCS" inspect_out &&
+  grep "Records: 1" inspect_out &&
+  grep "(selected): only valid record" inspect_out &&
+  grep "Validation: ok" inspect_out
+'
+
+# test the resolve cache
+
+test_expect_success "'ipfs name cache ls' lists the resolved name" '
+  ipfs name cache ls >cache_out &&
+  grep "^${PEERID}: /ipfs/$HASH_WELCOME_DOCS (expires in" cache_out
+'
+
+test_expect_success "'ipfs name cache flush' succeeds" '
+  ipfs name cache flush "$PEERID"
+'
+
+test_expect_success "'ipfs name cache ls' is empty after flush" '
+  ipfs name cache ls >cache_out &&
+  test_must_be_empty cache_out
+'
+
+# test delegated writers
+
+test_expect_success "'ipfs name delegate add' succeeds" '
+  WRITERID=`ipfs key gen --type=ed25519 writer` &&
+  ipfs name delegate add "$WRITERID" >delegate_out &&
+  echo "$WRITERID" >expected_delegate &&
+  test_cmp expected_delegate delegate_out
+'
+
+test_expect_success "'ipfs name delegate ls' lists the writer" '
+  ipfs name delegate ls >delegate_out &&
+  test_cmp expected_delegate delegate_out
+'
+
+test_expect_success "'ipfs name delegate publish' succeeds" '
+  ipfs name delegate publish --key=writer --resolve=false "$PEERID" "/ipfs/$HASH_WELCOME_DOCS/readme" >publish_out &&
+  echo "Published to $PEERID: /ipfs/$HASH_WELCOME_DOCS/readme" >expected_publish &&
+  test_cmp expected_publish publish_out
+'
+
+test_expect_success "'ipfs name resolve' returns the delegated record" '
+  ipfs name resolve "$PEERID" >output &&
+  printf "/ipfs/%s/readme\n" "$HASH_WELCOME_DOCS" >expected_resolve &&
+  test_cmp expected_resolve output
+'
+
+test_expect_success "'ipfs name delegate rm' succeeds" '
+  ipfs name delegate rm writer >delegate_out &&
+  test_must_be_empty delegate_out
+'
+
+test_expect_success "removed writers cannot publish" '
+  test_expect_code 1 ipfs name delegate publish --key=writer --resolve=false "$PEERID" "/ipfs/$HASH_WELCOME_DOCS" 2>publish_err &&
+  grep "key is not a delegated writer of the name" publish_err
+'
+
 # test publishing nothing
 
 test_expect_success "'ipfs name publish' fails" '
@@ -10,9 +10,15 @@ test_description="Test HTTP Gateway (Writable)"
 
 test_init_ipfs
 
+test_expect_success "create a token for writing to the gateway" '
+  TOKEN=$(ipfs api token create --scopes=files,name gateway-writer) &&
+  AUTH="Authorization: Bearer $TOKEN" &&
+  READ_TOKEN=$(ipfs api token create --scopes=read gateway-reader)
+'
+
 test_launch_ipfs_daemon --writable
 test_expect_success "ipfs daemon --writable overrides config" '
-  curl -v -X POST http://$GWAY_ADDR/ipfs/ 2> outfile &&
+  curl -v -H "$AUTH" -X POST http://$GWAY_ADDR/ipfs/ 2> outfile &&
   grep "HTTP/1.1 201 Created" outfile &&
   grep "Location: /ipfs/QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH" outfile
 '
@@ -21,7 +27,7 @@ test_kill_ipfs_daemon
 test_config_ipfs_gateway_writable
 test_launch_ipfs_daemon --writable=false
 test_expect_success "ipfs daemon --writable=false overrides Writable=true config" '
-  curl -v -X POST http://$GWAY_ADDR/ipfs/ 2> outfile &&
+  curl -v -H "$AUTH" -X POST http://$GWAY_ADDR/ipfs/ 2> outfile &&
   grep "HTTP/1.1 405 Method Not Allowed" outfile
 '
 test_kill_ipfs_daemon
@@ -39,10 +45,20 @@ test_expect_success "HTTP gateway gives access to sample file" '
   grep "Hello and Welcome to IPFS!" welcome
 '
 
+test_expect_success "HTTP POST without a token is rejected" '
+  curl -svX POST --data-binary "hello" "http://localhost:$port/ipfs/" 2>curl_noauth.out &&
+  grep "HTTP/1.1 401 Unauthorized" curl_noauth.out
+'
+
+test_expect_success "HTTP POST with a read-only token is rejected" '
+  curl -svX POST -H "Authorization: Bearer $READ_TOKEN" --data-binary "hello" "http://localhost:$port/ipfs/" 2>curl_readonly.out &&
+  grep "HTTP/1.1 403 Forbidden" curl_readonly.out
+'
+
 test_expect_success "HTTP POST file gives Hash" '
   echo "$RANDOM" >infile &&
   URL="http://localhost:$port/ipfs/" &&
-  curl -svX POST --data-binary @infile "$URL" 2>curl_post.out &&
+  curl -svX POST -H "$AUTH" --data-binary @infile "$URL" 2>curl_post.out &&
   grep "HTTP/1.1 201 Created" curl_post.out &&
   LOCATION=$(grep Location curl_post.out) &&
   HASH=$(echo $LOCATION | cut -d":" -f2- |tr -d " \n\r")
@@ -57,7 +73,7 @@ test_expect_success "We can HTTP GET file just created" '
 test_expect_success "HTTP PUT empty directory" '
   URL="http://localhost:$port/ipfs/$HASH_EMPTY_DIR/" &&
   echo "PUT $URL" &&
-  curl -svX PUT "$URL" 2>curl_putEmpty.out &&
+  curl -svX PUT -H "$AUTH" "$URL" 2>curl_putEmpty.out &&
   cat curl_putEmpty.out &&
   grep "Ipfs-Hash: $HASH_EMPTY_DIR" curl_putEmpty.out &&
   grep "Location: /ipfs/$HASH_EMPTY_DIR" curl_putEmpty.out &&
@@ -74,7 +90,7 @@ test_expect_success "HTTP PUT file to construct a hierarchy" '
   echo "$RANDOM" >infile &&
   URL="http://localhost:$port/ipfs/$HASH_EMPTY_DIR/test.txt" &&
   echo "PUT $URL" &&
-  curl -svX PUT --data-binary @infile "$URL" 2>curl_put.out &&
+  curl -svX PUT -H "$AUTH" --data-binary @infile "$URL" 2>curl_put.out &&
   grep "HTTP/1.1 201 Created" curl_put.out &&
   LOCATION=$(grep Location curl_put.out) &&
   HASH=$(expr "$LOCATION" : "< Location: /ipfs/\(.*\)/test.txt")
@@ -91,7 +107,7 @@ test_expect_success "HTTP PUT file to append to existing hierarchy" '
   echo "$RANDOM" >infile2 &&
   URL="http://localhost:$port/ipfs/$HASH/test/test.txt" &&
   echo "PUT $URL" &&
-  curl -svX PUT --data-binary @infile2 "$URL" 2>curl_putAgain.out &&
+  curl -svX PUT -H "$AUTH" --data-binary @infile2 "$URL" 2>curl_putAgain.out &&
   grep "HTTP/1.1 201 Created" curl_putAgain.out &&
   LOCATION=$(grep Location curl_putAgain.out) &&
   HASH=$(expr "$LOCATION" : "< Location: /ipfs/\(.*\)/test/test.txt")
@@ -105,6 +121,45 @@ test_expect_success "We can HTTP GET file just updated" '
   test_cmp infile2 outfile2
 '
 
+test_expect_success "HTTP POST multipart upload creates a directory" '
+  echo "a" >a.txt &&
+  echo "b" >b.txt &&
+  curl -svX POST -H "$AUTH" -F "file=@a.txt;filename=a.txt" -F "file=@b.txt;filename=sub/b.txt" \
+    "http://localhost:$port/ipfs/" 2>curl_multipart.out &&
+  grep "HTTP/1.1 201 Created" curl_multipart.out &&
+  DIR_HASH=$(grep "Ipfs-Hash" curl_multipart.out | cut -d":" -f2- | tr -d " \n\r") &&
+  ipfs cat "$DIR_HASH/sub/b.txt" >outfile &&
+  test_cmp b.txt outfile
+'
+
+test_expect_success "HTTP PUT writes to MFS" '
+  curl -svX PUT -H "$AUTH" --data-binary @infile "http://localhost:$port/mfs/uploads/file.txt" 2>curl_mfs.out &&
+  grep "HTTP/1.1 201 Created" curl_mfs.out &&
+  ipfs files read /uploads/file.txt >outfile &&
+  test_cmp infile outfile
+'
+
+test_expect_success "HTTP DELETE removes from MFS" '
+  curl -svX DELETE -H "$AUTH" "http://localhost:$port/mfs/uploads/file.txt" 2>curl_mfs_rm.out &&
+  grep "HTTP/1.1 201 Created" curl_mfs_rm.out &&
+  test_must_fail ipfs files stat /uploads/file.txt
+'
+
+test_expect_success "HTTP PUT to an owned IPNS name republishes it" '
+  PEERID=$(ipfs config Identity.PeerID) &&
+  curl -svX PUT -H "$AUTH" --data-binary @infile "http://localhost:$port/ipns/$PEERID/file.txt" 2>curl_ipns.out &&
+  grep "HTTP/1.1 201 Created" curl_ipns.out &&
+  grep "Ipns-Name: $PEERID" curl_ipns.out &&
+  grep "Ipns-Record: " curl_ipns.out &&
+  ipfs cat "/ipns/$PEERID/file.txt" >outfile &&
+  test_cmp infile outfile
+'
+
+test_expect_success "HTTP PUT to a foreign IPNS name is rejected" '
+  curl -svX PUT -H "$AUTH" --data-binary @infile "http://localhost:$port/ipns/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/file.txt" 2>curl_ipns_foreign.out &&
+  grep "HTTP/1.1 403 Forbidden" curl_ipns_foreign.out
+'
+
 test_kill_ipfs_daemon
 
 test_done
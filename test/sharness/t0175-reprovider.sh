@@ -125,6 +125,39 @@ test_expect_success 'stop peer 1' '
   iptb stop 1
 '
 
+# Test 'pinned+mfs' strategy
+init_strategy 'pinned+mfs'
+
+test_expect_success 'prepare test files' '
+  echo foo > f1 &&
+  echo bar > f2 &&
+  echo baz > f3
+'
+
+test_expect_success 'add test objects' '
+  HASH_FOO=$(ipfsi 0 add -q --local --pin=false f1) &&
+  HASH_BAR=$(ipfsi 0 add -q --local --pin=false f2) &&
+  HASH_BAZ=$(ipfsi 0 add -q --local f3)
+'
+
+test_expect_success 'copy test object to mfs' '
+  ipfsi 0 files cp /ipfs/$HASH_BAR /bar
+'
+
+findprovs_empty '$HASH_FOO'
+findprovs_empty '$HASH_BAR'
+findprovs_empty '$HASH_BAZ'
+
+reprovide
+
+findprovs_empty '$HASH_FOO'
+findprovs_expect '$HASH_BAR' '$PEERID_0'
+findprovs_expect '$HASH_BAZ' '$PEERID_0'
+
+test_expect_success 'stop peer 1' '
+  iptb stop 1
+'
+
 # Test reprovider working with ticking disabled
 test_expect_success 'init iptb' '
   iptb init -f -n $NUM_NODES --bootstrap=none --port=0
@@ -149,5 +182,42 @@ findprovs_empty '$HASH_0'
 reprovide
 findprovs_expect '$HASH_0' '$PEERID_0'
 
+test_expect_success 'stop peer 1' '
+  iptb stop 1
+'
+
+# Test batched reproviding
+test_expect_success 'init iptb' '
+  iptb init -f -n $NUM_NODES --bootstrap=none --port=0
+'
+
+test_expect_success 'peer ids' '
+  PEERID_0=$(iptb get id 0) &&
+  PEERID_1=$(iptb get id 1)
+'
+
+test_expect_success 'enable batched reproviding' '
+  ipfsi 0 config --bool Reprovider.Batched true
+'
+
+startup_cluster ${NUM_NODES}
+
+test_expect_success 'add test object' '
+  HASH_0=$(echo "foo" | ipfsi 0 add -q --local)
+'
+
+findprovs_empty '$HASH_0'
+reprovide
+findprovs_expect '$HASH_0' '$PEERID_0'
+
+test_expect_success 'reprovide --status succeeds' '
+  ipfsi 0 bitswap reprovide --status > status_out
+'
+
+test_expect_success 'reprovide --status output looks good' '
+  grep "Reprovider (batched)" status_out &&
+  grep "Provided:" status_out &&
+  grep "Last complete round:" status_out
+'
 
 test_done
@@ -25,6 +25,10 @@ bitswap status
   data sent: 0
   dup blocks received: 0
   dup data received: 0 B
+  haves received: 0
+  dont-haves received: 0
+  wants rejected: 0
+  blocks rejected: 0
   wantlist [0 keys]
   partners [0]
 EOF
@@ -63,6 +67,10 @@ bitswap status
   data sent: 0
   dup blocks received: 0
   dup data received: 0 B
+  haves received: 0
+  dont-haves received: 0
+  wants rejected: 0
+  blocks rejected: 0
   wantlist [0 keys]
   partners [0]
 EOF
@@ -77,6 +85,36 @@ test_expect_success "'ipfs bitswap wantlist -p' output looks good" '
   test_cmp wantlist_out wantlist_p_out
 '
 
+test_expect_success "'ipfs bitswap ledger' shows the ledger of a peer" '
+  ipfs bitswap ledger "$PEERID" >ledger_out &&
+  grep "Ledger for $PEERID" ledger_out
+'
+
+test_expect_success "'ipfs bitswap ledger' needs a peer or --all" '
+  test_must_fail ipfs bitswap ledger
+'
+
+test_expect_success "'ipfs bitswap ledger --all' succeeds" '
+  ipfs bitswap ledger --all >ledger_all_out
+'
+
+test_expect_success "'ipfs bitswap sessions' succeeds" '
+  ipfs bitswap sessions >sessions_out
+'
+
+test_expect_success "'ipfs bitswap trace' succeeds for a local block" '
+  HASH=$(echo "trace me" | ipfs block put) &&
+  ipfs bitswap trace "$HASH" >trace_out
+'
+
+test_expect_success "'ipfs bitswap trace' output is empty for a local block" '
+  test_must_be_empty trace_out
+'
+
+test_expect_success "'ipfs bitswap trace' fails on an invalid key" '
+  test_must_fail ipfs bitswap trace not-a-cid
+'
+
 test_kill_ipfs_daemon
 
 test_done
@@ -408,8 +408,32 @@ func (ds *Shard) ForEachLink(ctx context.Context, f func(*ipld.Link) error) erro
 	})
 }
 
+// ForEachLinkAfter walks the Shard like ForEachLink, starting right after the
+// entry named 'after'. Entries are visited in hash order, so subtrees before
+// 'after' are skipped without being loaded. This allows resuming a walk of a
+// large shard from a cursor. An empty 'after' walks the whole Shard.
+func (ds *Shard) ForEachLinkAfter(ctx context.Context, after string, f func(*ipld.Link) error) error {
+	cb := func(sv *shardValue) error {
+		lnk := sv.val
+		lnk.Name = sv.key
+
+		return f(lnk)
+	}
+	if after == "" {
+		return ds.walkTrie(ctx, cb)
+	}
+
+	hv := &hashBits{b: hash([]byte(after))}
+	return ds.walkTrieAfter(ctx, hv, after, cb)
+}
+
 func (ds *Shard) walkTrie(ctx context.Context, cb func(*shardValue) error) error {
-	for idx := range ds.children {
+	return ds.walkChildren(ctx, 0, cb)
+}
+
+// walkChildren walks the children of this shard starting at index 'start'.
+func (ds *Shard) walkChildren(ctx context.Context, start int, cb func(*shardValue) error) error {
+	for idx := start; idx < len(ds.children); idx++ {
 		c, err := ds.getChild(ctx, idx)
 		if err != nil {
 			return err
@@ -432,6 +456,38 @@ func (ds *Shard) walkTrie(ctx context.Context, cb func(*shardValue) error) error
 	return nil
 }
 
+func (ds *Shard) walkTrieAfter(ctx context.Context, hv *hashBits, key string, cb func(*shardValue) error) error {
+	idx := hv.Next(ds.tableSizeLg2)
+
+	// children are ordered by their bit position, everything before the
+	// position of 'key' was visited already.
+	start := ds.indexForBitPos(idx)
+	if ds.bitfield.Bit(idx) {
+		c, err := ds.getChild(ctx, start)
+		if err != nil {
+			return err
+		}
+
+		switch c := c.(type) {
+		case *shardValue:
+			if c.key != key {
+				if err := cb(c); err != nil {
+					return err
+				}
+			}
+		case *Shard:
+			if err := c.walkTrieAfter(ctx, hv, key, cb); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected child type: %#v", c)
+		}
+		start++
+	}
+
+	return ds.walkChildren(ctx, start, cb)
+}
+
 func (ds *Shard) modifyValue(ctx context.Context, hv *hashBits, key string, val *ipld.Link) error {
 	idx := hv.Next(ds.tableSizeLg2)
 
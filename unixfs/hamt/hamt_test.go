@@ -608,3 +608,51 @@ func TestHamtBadSize(t *testing.T) {
 		t.Fatal("should have failed to construct hamt with bad size")
 	}
 }
+
+func TestForEachLinkAfter(t *testing.T) {
+	ds := mdtest.Mock()
+	_, s, err := makeDir(ds, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nd, err := s.Node()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// walk a freshly loaded shard so skipped subtrees are never loaded
+	ctx := context.Background()
+	walk := func(after string) []string {
+		s, err := NewHamtFromDag(ds, nd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		err = s.ForEachLinkAfter(ctx, after, func(l *ipld.Link) error {
+			names = append(names, l.Name)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return names
+	}
+
+	all := walk("")
+	if len(all) != 1000 {
+		t.Fatalf("expected 1000 links, got %d", len(all))
+	}
+
+	for _, i := range []int{0, 1, 254, 255, 256, 500, 998, 999} {
+		rest := walk(all[i])
+		if len(rest) != len(all)-i-1 {
+			t.Fatalf("after %q: expected %d links, got %d", all[i], len(all)-i-1, len(rest))
+		}
+		for j, name := range rest {
+			if name != all[i+1+j] {
+				t.Fatalf("after %q: link %d is %q, expected %q", all[i], j, name, all[i+1+j])
+			}
+		}
+	}
+}
@@ -14,6 +14,9 @@ import (
 	context "context"
 
 	testu "github.com/ipfs/go-ipfs/unixfs/test"
+
+	cid "gx/ipfs/QmcZfnkapfECQGcLZaf9B79NRg7cRa9EnZh4LSbkCzwNvY/go-cid"
+	ipld "gx/ipfs/Qme5bWv7wtjUNGsK2BNGVUFPKiuxWrsqrtvYwCLRw8YFES/go-ipld-format"
 )
 
 func TestBasicRead(t *testing.T) {
@@ -302,3 +305,68 @@ func readByte(t testing.TB, reader DagReader) byte {
 
 	return out[0]
 }
+
+type priorityGetter struct {
+	ipld.NodeGetter
+
+	fetched     []int
+	reprioKeys  []*cid.Cid
+	reprioPrios []int
+}
+
+func (pg *priorityGetter) GetManyWithPriority(ctx context.Context, keys []*cid.Cid, prios []int) <-chan *ipld.NodeOption {
+	pg.fetched = append(pg.fetched, prios...)
+	return pg.GetMany(ctx, keys)
+}
+
+func (pg *priorityGetter) Reprioritize(keys []*cid.Cid, prios []int) {
+	pg.reprioKeys = append(pg.reprioKeys, keys...)
+	pg.reprioPrios = append(pg.reprioPrios, prios...)
+}
+
+func TestReadPriorities(t *testing.T) {
+	dserv := testu.GetDAGServ()
+	inbuf := make([]byte, 20000)
+	rand.Read(inbuf)
+
+	node := testu.GetNode(t, dserv, inbuf, testu.UseProtoBufLeaves)
+	ctx, closer := context.WithCancel(context.Background())
+	defer closer()
+
+	pg := &priorityGetter{NodeGetter: dserv}
+	reader, err := NewDagReader(ctx, node, pg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 100)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// the block being read first, then the prefetched ones in order
+	if len(pg.fetched) != preloadSize {
+		t.Fatalf("expected %d fetched blocks, got %d", preloadSize, len(pg.fetched))
+	}
+	for i, p := range pg.fetched {
+		if p != readPriority-i {
+			t.Fatalf("block %d fetched with priority %d", i, p)
+		}
+	}
+
+	// the prefetched blocks left behind by the seek come last
+	if _, err := reader.Seek(10000, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if len(pg.reprioPrios) != preloadSize-1 {
+		t.Fatalf("expected %d reprioritized blocks, got %d", preloadSize-1, len(pg.reprioPrios))
+	}
+	for _, p := range pg.reprioPrios {
+		if p != skippedPriority {
+			t.Fatal("skipped block got priority", p)
+		}
+	}
+	if pg.fetched[preloadSize] != readPriority {
+		t.Fatal("block at the new position not fetched first")
+	}
+}
@@ -153,6 +153,28 @@ func (d *Directory) ForEachLink(ctx context.Context, f func(*ipld.Link) error) e
 	return d.shard.ForEachLink(ctx, f)
 }
 
+// ForEachLinkAfter applies the given function to the Links in the directory
+// following the one named 'after', in the same order as ForEachLink. An empty
+// 'after' visits all links. If no link named 'after' exists in a basic
+// directory, no links are visited.
+func (d *Directory) ForEachLinkAfter(ctx context.Context, after string, f func(*ipld.Link) error) error {
+	if d.shard == nil {
+		found := after == ""
+		for _, l := range d.dirnode.Links() {
+			if !found {
+				found = l.Name == after
+				continue
+			}
+			if err := f(l); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return d.shard.ForEachLinkAfter(ctx, after, f)
+}
+
 // Links returns the all the links in the directory node.
 func (d *Directory) Links(ctx context.Context) ([]*ipld.Link, error) {
 	if d.shard == nil {
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 
 	mdag "github.com/ipfs/go-ipfs/merkledag"
 	ft "github.com/ipfs/go-ipfs/unixfs"
@@ -68,6 +69,14 @@ func NewPBFileReader(ctx context.Context, n *mdag.ProtoNode, pb *ftpb.Data, serv
 
 const preloadSize = 10
 
+// Priorities of the blocks fetched by the reader, when the NodeGetter
+// supports them: the block at the read position comes first, then the
+// prefetched ones in reading order. Blocks left behind by a seek come last.
+const (
+	readPriority    = math.MaxInt32
+	skippedPriority = 1
+)
+
 func (dr *PBDagReader) preloadNextNodes(ctx context.Context) {
 	beg := dr.linkPosition
 	end := beg + preloadSize
@@ -75,11 +84,42 @@ func (dr *PBDagReader) preloadNextNodes(ctx context.Context) {
 		end = len(dr.links)
 	}
 
-	for i, p := range ipld.GetNodes(ctx, dr.serv, dr.links[beg:end]) {
+	prios := make([]int, end-beg)
+	for i := range prios {
+		prios[i] = readPriority - i
+	}
+	for i, p := range mdag.GetNodesWithPriority(ctx, dr.serv, dr.links[beg:end], prios) {
 		dr.promises[beg+i] = p
 	}
 }
 
+// reprioritize updates the priorities of the blocks still being fetched
+// after the read position moved: the ones within the preload window of the
+// new position keep being prefetched in order, the others come last.
+func (dr *PBDagReader) reprioritize() {
+	pg, ok := dr.serv.(mdag.PriorityGetter)
+	if !ok {
+		return
+	}
+
+	var keys []*cid.Cid
+	var prios []int
+	for i, p := range dr.promises {
+		if p == nil {
+			continue
+		}
+		prio := skippedPriority
+		if d := i - dr.linkPosition; d >= 0 && d < preloadSize {
+			prio = readPriority - d
+		}
+		keys = append(keys, dr.links[i])
+		prios = append(prios, prio)
+	}
+	if len(keys) > 0 {
+		pg.Reprioritize(keys, prios)
+	}
+}
+
 // precalcNextBuf follows the next link in line and loads it from the
 // DAGService, setting the next buffer to read from
 func (dr *PBDagReader) precalcNextBuf(ctx context.Context) error {
@@ -259,6 +299,7 @@ func (dr *PBDagReader) Seek(offset int64, whence int) (int64, error) {
 			// start reading links from the beginning
 			dr.linkPosition = 0
 			dr.offset = offset
+			dr.reprioritize()
 			return offset, nil
 		}
 
@@ -274,6 +315,7 @@ func (dr *PBDagReader) Seek(offset int64, whence int) (int64, error) {
 				left -= int64(pb.Blocksizes[i])
 			}
 		}
+		dr.reprioritize()
 
 		// start sub-block request
 		err := dr.precalcNextBuf(dr.ctx)